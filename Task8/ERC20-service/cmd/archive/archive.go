@@ -0,0 +1,304 @@
+package archive
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"erc20-service/cmd"
+	"erc20-service/config"
+	"erc20-service/internal/db"
+	"erc20-service/pkg/logger"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	archiveCmd = &cobra.Command{
+		Use:   "archive",
+		Short: "归档并清理过期数据",
+		Long: `按保留策略将过期的余额变动与积分计算历史按月导出为gzip压缩的JSON Lines文件，并从数据库清理
+积分计算历史在清理前汇总到 points_history_daily，保证已计算时间段仍可判断
+每个用户在保留期之前的最后一条余额变动作为期初余额锚点保留
+
+示例:
+  ./erc20-service archive
+  ./erc20-service archive --table balance_changes --dry-run`,
+		Run: runArchive,
+	}
+
+	log = logger.New("archive")
+)
+
+// 可归档的表
+const (
+	tableBalanceChanges = "balance_changes"
+	tablePointsHistory  = "points_calculation_history"
+	tableLeaderboard    = "leaderboard_snapshots"
+)
+
+func init() {
+	cmd.RootCmd.AddCommand(archiveCmd)
+	archiveCmd.Flags().String("table", "all", "归档的表: balance_changes | points_calculation_history | leaderboard_snapshots | all")
+	archiveCmd.Flags().Bool("dry-run", false, "只导出文件，不清理数据库")
+}
+
+// 积分历史归档行
+type historyArchiveRow struct {
+	ID           int64              `json:"id"`
+	ChainName    string             `json:"chain_name"`
+	UserAddress  string             `json:"user_address"`
+	PeriodStart  time.Time          `json:"period_start"`
+	PeriodEnd    time.Time          `json:"period_end"`
+	PointsAdded  string             `json:"points_added"`
+	TotalPoints  string             `json:"total_points"`
+	RuleVersion  string             `json:"rule_version"`
+	TaskID       string             `json:"task_id,omitempty"`
+	EpochID      int64              `json:"epoch_id,omitempty"`
+	Segments     []db.PointsSegment `json:"segments,omitempty"`
+	Prices       []db.PriceQuote    `json:"prices,omitempty"`
+	CalculatedAt time.Time          `json:"calculated_at"`
+}
+
+func runArchive(cmd *cobra.Command, args []string) {
+	table, _ := cmd.Flags().GetString("table")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+	// 加载配置
+	cfgPath, _ := cmd.Flags().GetString("config")
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		logger.Fatal("加载配置失败", "error", err)
+	}
+
+	// 初始化数据库
+	if err := db.Init(cfg.Database); err != nil {
+		logger.Fatal("初始化数据库失败", "error", err)
+	}
+
+	if table == "all" || table == tableBalanceChanges {
+		if err := archiveBalanceChanges(cfg.Retention, dryRun); err != nil {
+			logger.Fatal("归档余额变动失败", "error", err)
+		}
+	}
+	if table == "all" || table == tablePointsHistory {
+		if err := archivePointsHistory(cfg.Retention, dryRun); err != nil {
+			logger.Fatal("归档积分历史失败", "error", err)
+		}
+	}
+
+	if table == "all" || table == tableLeaderboard {
+		if err := pruneLeaderboardSnapshots(cfg.Retention, dryRun); err != nil {
+			logger.Fatal("清理排行榜快照失败", "error", err)
+		}
+	}
+
+	log.Info("归档完成", "table", table, "dry_run", dryRun)
+}
+
+// 清理过期排行榜快照（由排名派生，不导出文件）
+func pruneLeaderboardSnapshots(cfg config.RetentionConfig, dryRun bool) error {
+	if cfg.LeaderboardSnapshotDays <= 0 {
+		log.Info("排行榜快照未配置保留天数，跳过")
+		return nil
+	}
+	if dryRun {
+		return nil
+	}
+	pruned, err := db.PruneLeaderboardSnapshots(retentionCutoff(cfg.LeaderboardSnapshotDays))
+	if err != nil {
+		return err
+	}
+	log.Info("清理排行榜快照", "rows", pruned)
+	return nil
+}
+
+// 归档余额变动
+func archiveBalanceChanges(cfg config.RetentionConfig, dryRun bool) error {
+	if cfg.BalanceChangesDays <= 0 {
+		log.Info("余额变动未配置保留天数，跳过")
+		return nil
+	}
+	cutoff := retentionCutoff(cfg.BalanceChangesDays)
+	oldest, ok, err := db.GetOldestBalanceChangeTime()
+	if err != nil {
+		return fmt.Errorf("获取最早余额变动时间失败: %v", err)
+	}
+	if !ok || !oldest.Before(cutoff) {
+		log.Info("无过期余额变动", "cutoff", cutoff)
+		return nil
+	}
+
+	for _, p := range monthlyPartitions(oldest, cutoff) {
+		path := archivePath(cfg.ArchiveDir, tableBalanceChanges, p)
+		count, err := writeArchive(path, func(enc *json.Encoder) error {
+			return db.StreamPrunableBalanceChanges(p.Start, p.End, cutoff, func(c db.BalanceChange) error {
+				return enc.Encode(db.BalanceChangedEvent(c))
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("导出 %s 失败: %v", path, err)
+		}
+		log.Info("导出余额变动", "partition", path, "rows", count)
+
+		if dryRun {
+			continue
+		}
+		pruned, err := db.PruneBalanceChanges(p.Start, p.End, cutoff, cfg.BatchSize)
+		if err != nil {
+			return fmt.Errorf("清理余额变动失败: %v", err)
+		}
+		log.Info("清理余额变动", "start", p.Start, "end", p.End, "rows", pruned)
+	}
+	return nil
+}
+
+// 归档积分计算历史
+func archivePointsHistory(cfg config.RetentionConfig, dryRun bool) error {
+	if cfg.HistoryDays <= 0 {
+		log.Info("积分历史未配置保留天数，跳过")
+		return nil
+	}
+	cutoff := retentionCutoff(cfg.HistoryDays)
+	oldest, ok, err := db.GetOldestPointsHistoryTime()
+	if err != nil {
+		return fmt.Errorf("获取最早积分历史时间失败: %v", err)
+	}
+	if !ok || !oldest.Before(cutoff) {
+		log.Info("无过期积分历史", "cutoff", cutoff)
+		return nil
+	}
+
+	for _, p := range monthlyPartitions(oldest, cutoff) {
+		path := archivePath(cfg.ArchiveDir, tablePointsHistory, p)
+		count, err := writeArchive(path, func(enc *json.Encoder) error {
+			return db.StreamPointsHistory(p.Start, p.End, func(r db.PointsHistoryRecord) error {
+				return enc.Encode(historyArchiveRow(r))
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("导出 %s 失败: %v", path, err)
+		}
+		log.Info("导出积分历史", "partition", path, "rows", count)
+
+		if dryRun {
+			continue
+		}
+		// 按天、按用户分批汇总并清理，每批一个事务
+		var pruned int64
+		for _, day := range splitDays(p.Start, p.End) {
+			n, err := db.RollupAndPrunePointsHistory(day.Start, day.End, cfg.BatchSize)
+			if err != nil {
+				return fmt.Errorf("汇总清理积分历史失败: %v", err)
+			}
+			pruned += n
+		}
+		log.Info("汇总并清理积分历史", "start", p.Start, "end", p.End, "rows", pruned)
+	}
+	return nil
+}
+
+// 写入gzip压缩的JSON Lines文件，先写临时文件再重命名，避免留下不完整归档
+// 无数据时不生成文件；同名文件已存在时追加序号，不覆盖历史归档
+func writeArchive(path string, write func(enc *json.Encoder) error) (int, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return 0, err
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp)
+
+	gz := gzip.NewWriter(f)
+	counter := &countingWriter{w: gz}
+	enc := json.NewEncoder(counter)
+	if err := write(enc); err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := gz.Close(); err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	if counter.n == 0 {
+		return 0, nil
+	}
+	return counter.n, os.Rename(tmp, uniquePath(path))
+}
+
+// 返回不存在的文件路径，已存在时追加序号
+func uniquePath(path string) string {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return path
+	}
+	base := strings.TrimSuffix(path, ".jsonl.gz")
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s.%d.jsonl.gz", base, i)
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
+
+// 保留期截止时间（按UTC日对齐，与积分周期和日汇总的日期一致）
+func retentionCutoff(days int) time.Time {
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return today.AddDate(0, 0, -days)
+}
+
+// 按自然月划分[start, end)
+func monthlyPartitions(start, end time.Time) []db.TimePeriod {
+	var periods []db.TimePeriod
+	start = start.In(end.Location())
+	current := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, end.Location())
+	for current.Before(end) {
+		next := current.AddDate(0, 1, 0)
+		if next.After(end) {
+			next = end
+		}
+		periods = append(periods, db.TimePeriod{Start: current, End: next})
+		current = next
+	}
+	return periods
+}
+
+// 按天划分[start, end)
+func splitDays(start, end time.Time) []db.TimePeriod {
+	var periods []db.TimePeriod
+	for current := start; current.Before(end); {
+		next := current.AddDate(0, 0, 1)
+		if next.After(end) {
+			next = end
+		}
+		periods = append(periods, db.TimePeriod{Start: current, End: next})
+		current = next
+	}
+	return periods
+}
+
+// 归档文件路径：<dir>/<table>/<table>_<起始日>_<截止日>.jsonl.gz
+func archivePath(dir, table string, p db.TimePeriod) string {
+	name := fmt.Sprintf("%s_%s_%s.jsonl.gz", table, p.Start.Format("20060102"), p.End.Format("20060102"))
+	return filepath.Join(dir, table, name)
+}
+
+// countingWriter 统计写入的JSON行数（json.Encoder每次Encode写入一行）
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.n++
+	return cw.w.Write(p)
+}
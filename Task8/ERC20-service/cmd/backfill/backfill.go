@@ -4,9 +4,13 @@ import (
 	"erc20-service/cmd"
 	"erc20-service/config"
 	"erc20-service/internal/db"
+	"erc20-service/internal/epoch"
 	"erc20-service/internal/mq"
+	"erc20-service/internal/service"
 	"erc20-service/pkg/logger"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -27,7 +31,9 @@ var (
   chain_name: 链名称 (如: sepolia)
   start_time: 开始时间 (格式: 2006-01-02T15:04:05Z)
   end_time: 结束时间 (格式: 2006-01-02T15:04:05Z)
-  
+
+时间范围向外对齐到积分周期（UTC对齐到points.interval的整数倍），只为未完整覆盖的周期生成任务
+
 示例:
   ./erc20-service backfill points sepolia 2024-01-01T00:00:00Z 2024-01-03T00:00:00Z`,
 		Args: cobra.ExactArgs(3),
@@ -37,7 +43,7 @@ var (
 	backfillCheckCmd = &cobra.Command{
 		Use:   "check [chain_name]",
 		Short: "检查积分计算状态",
-		Long:  "检查指定链的积分计算状态，显示缺失的时间段",
+		Long:  "检查指定链各用户在--since时间窗口（及上次计算时间之后）内的积分周期覆盖情况，按周期ID列出缺口",
 		Args:  cobra.ExactArgs(1),
 		Run:   runBackfillCheck,
 	}
@@ -58,6 +64,10 @@ func init() {
 	backfillCmd.AddCommand(backfillPointsCmd)
 	backfillCmd.AddCommand(backfillCheckCmd)
 	backfillCmd.AddCommand(backfillScanCmd)
+
+	for _, c := range []*cobra.Command{backfillCheckCmd, backfillScanCmd} {
+		c.Flags().Duration("since", 24*time.Hour, "检查缺口的时间窗口")
+	}
 }
 
 func runBackfillPoints(cmd *cobra.Command, args []string) {
@@ -91,19 +101,12 @@ func runBackfillPoints(cmd *cobra.Command, args []string) {
 	if err := db.Init(cfg.Database); err != nil {
 		logger.Fatal("初始化数据库失败", "error", err)
 	}
+	policy := db.NewPolicy(cfg)
+	requireTimeMode(cfg, chainName)
 
-	// 初始化MQ连接
-	mqConn, err := mq.NewConnection(cfg.RabbitMQ.URL)
-	if err != nil {
-		logger.Fatal("初始化MQ连接失败", "error", err)
-	}
-	defer mqConn.Close()
-
-	// 创建积分生产者
-	pointsProducer := mq.NewPointsProducer(mqConn, cfg.RabbitMQ)
-
-	// 执行回溯计算
-	if err := backfillPointsForChain(chainName, startTime, endTime, pointsProducer, cfg.Points.Rate); err != nil {
+	// 执行回溯计算（任务写入发件箱，由守护进程中继投递）
+	clock := epoch.NewClock(time.Duration(cfg.Points.Interval) * time.Minute)
+	if err := backfillPointsForChain(policy, chainName, startTime, endTime, clock); err != nil {
 		logger.Fatal("回溯计算失败", "error", err)
 	}
 
@@ -124,19 +127,30 @@ func runBackfillCheck(cmd *cobra.Command, args []string) {
 	if err := db.Init(cfg.Database); err != nil {
 		logger.Fatal("初始化数据库失败", "error", err)
 	}
+	policy := db.NewPolicy(cfg)
+	requireTimeMode(cfg, chainName)
+	// 扫描类查询路由到只读副本，任务写入仍走主库
+	db.EnableReplicaReads()
 
 	// 检查积分计算状态
-	if err := checkPointsCalculationStatus(chainName); err != nil {
+	since, _ := cmd.Flags().GetDuration("since")
+	clock := epoch.NewClock(time.Duration(cfg.Points.Interval) * time.Minute)
+	if err := checkPointsCalculationStatus(policy, chainName, clock, since); err != nil {
 		logger.Fatal("检查失败", "error", err)
 	}
 }
 
 // 回溯计算指定链的积分
-func backfillPointsForChain(chainName string, startTime, endTime time.Time, producer *mq.PointsProducer, rate float64) error {
-	log.Info("开始回溯计算", "chain", chainName, "start", startTime, "end", endTime)
+func backfillPointsForChain(policy *db.Policy, chainName string, startTime, endTime time.Time, clock epoch.Clock) error {
+	epochs := clock.Covering(startTime, endTime)
+	if len(epochs) == 0 {
+		return nil
+	}
+	first, last := epochs[0], epochs[len(epochs)-1]
+	log.Info("开始回溯计算", "chain", chainName, "first_epoch", first.String(), "last_epoch", last.String(), "epochs", len(epochs))
 
-	// 获取链上所有用户
-	users, err := db.GetUsersByChain(chainName)
+	// 获取链上参与积分的用户（剔除排除地址）
+	users, err := db.GetEligibleUsersByChain(policy, chainName)
 	if err != nil {
 		return fmt.Errorf("获取用户列表失败: %v", err)
 	}
@@ -145,42 +159,34 @@ func backfillPointsForChain(chainName string, startTime, endTime time.Time, prod
 		log.Info("链上无用户", "chain", chainName)
 		return nil
 	}
+	seasons, err := db.ListSeasons()
+	if err != nil {
+		return fmt.Errorf("获取赛季失败: %v", err)
+	}
 
 	log.Info("找到用户", "count", len(users))
 
-	// 按小时分割时间段，避免单次计算时间过长
-	periods := splitTimeRange(startTime, endTime, time.Hour)
-
 	totalTasks := 0
-	for _, period := range periods {
-		for _, user := range users {
-			// 检查该时间段是否已经计算过
-			hasCalculated, err := db.HasPointsCalculated(chainName, user, period.Start, period.End)
-			if err != nil {
-				log.Warn("检查计算状态失败", "user", user, "period", period, "error", err)
-				continue
-			}
-
-			if hasCalculated {
-				log.Debug("时间段已计算", "user", user, "period", period)
-				continue
-			}
-
-			// 发布计算任务
-			task := mq.PointsCalculationTask{
-				ChainName:   chainName,
-				UserAddress: user,
-				PeriodStart: period.Start,
-				PeriodEnd:   period.End,
-			}
-
-			if err := producer.Publish(task); err != nil {
-				log.Warn("发布任务失败", "user", user, "period", period, "error", err)
-				continue
-			}
-
-			totalTasks++
+	for _, user := range users {
+		// 一次查询取回用户在整个范围内的已入账时间段，只为未完整覆盖的周期生成任务
+		missing, err := service.MissingEpochs(clock, chainName, user, first.ID, last.ID)
+		if err != nil {
+			log.Warn("检查周期覆盖失败", "user", user, "error", err)
+			continue
+		}
+
+		tasks := make([]any, 0, len(missing))
+		for _, e := range missing {
+			task := mq.NewEpochTask(chainName, user, e)
+			task.Season = db.SeasonAt(seasons, chainName, e.Start)
+			tasks = append(tasks, task)
 		}
+
+		// 按用户批量写入发件箱
+		if err := db.EnqueueOutbox(db.OutboxTopicPointsTask, tasks...); err != nil {
+			return fmt.Errorf("写入发件箱失败: %v", err)
+		}
+		totalTasks += len(tasks)
 	}
 
 	log.Info("回溯任务发布完成", "total_tasks", totalTasks)
@@ -188,11 +194,11 @@ func backfillPointsForChain(chainName string, startTime, endTime time.Time, prod
 }
 
 // 检查积分计算状态
-func checkPointsCalculationStatus(chainName string) error {
-	log.Info("检查积分计算状态", "chain", chainName)
+func checkPointsCalculationStatus(policy *db.Policy, chainName string, clock epoch.Clock, since time.Duration) error {
+	log.Info("检查积分计算状态", "chain", chainName, "since", since)
 
-	// 获取链上所有用户
-	users, err := db.GetUsersByChain(chainName)
+	// 获取链上参与积分的用户（剔除排除地址）
+	users, err := db.GetEligibleUsersByChain(policy, chainName)
 	if err != nil {
 		return fmt.Errorf("获取用户列表失败: %v", err)
 	}
@@ -202,27 +208,29 @@ func checkPointsCalculationStatus(chainName string) error {
 		return nil
 	}
 
-	// 检查每个用户的积分计算状态
+	// 检查每个用户的周期覆盖情况
+	now := time.Now()
+	lagging := 0
 	for _, user := range users {
-		lastCalc, err := db.GetUserLastCalculatedTime(chainName, user)
+		missing, lastCalc, err := findMissingEpochs(clock, chainName, user, now, since)
 		if err != nil {
-			log.Warn("获取用户计算时间失败", "user", user, "error", err)
+			log.Warn("检查周期覆盖失败", "user", user, "error", err)
 			continue
 		}
 
-		now := time.Now()
-		hoursSinceLastCalc := now.Sub(lastCalc).Hours()
-
-		if hoursSinceLastCalc > 2 { // 超过2小时未计算
-			log.Warn("用户积分计算滞后",
+		if len(missing) > 0 {
+			lagging++
+			log.Warn("用户积分周期缺失",
 				"user", user,
 				"last_calc", lastCalc,
-				"hours_behind", hoursSinceLastCalc)
+				"missing", len(missing),
+				"epoch_ids", formatEpochRanges(missing))
 		} else {
 			log.Info("用户积分计算正常", "user", user, "last_calc", lastCalc)
 		}
 	}
 
+	log.Info("检查完成", "chain", chainName, "users", len(users), "lagging_users", lagging)
 	return nil
 }
 
@@ -240,19 +248,15 @@ func runBackfillScan(cmd *cobra.Command, args []string) {
 	if err := db.Init(cfg.Database); err != nil {
 		logger.Fatal("初始化数据库失败", "error", err)
 	}
-
-	// 初始化MQ连接
-	mqConn, err := mq.NewConnection(cfg.RabbitMQ.URL)
-	if err != nil {
-		logger.Fatal("初始化MQ连接失败", "error", err)
-	}
-	defer mqConn.Close()
-
-	// 创建积分生产者
-	pointsProducer := mq.NewPointsProducer(mqConn, cfg.RabbitMQ)
+	policy := db.NewPolicy(cfg)
+	requireTimeMode(cfg, chainName)
+	// 扫描类查询路由到只读副本，任务写入仍走主库
+	db.EnableReplicaReads()
 
 	// 执行扫描和修复
-	if err := scanAndFixMissingPoints(chainName, pointsProducer, cfg.Points.Rate, cfg.Points.Interval); err != nil {
+	since, _ := cmd.Flags().GetDuration("since")
+	clock := epoch.NewClock(time.Duration(cfg.Points.Interval) * time.Minute)
+	if err := scanAndFixMissingPoints(policy, chainName, clock, since); err != nil {
 		logger.Fatal("扫描修复失败", "error", err)
 	}
 
@@ -260,11 +264,11 @@ func runBackfillScan(cmd *cobra.Command, args []string) {
 }
 
 // 扫描并修复积分缺失
-func scanAndFixMissingPoints(chainName string, producer *mq.PointsProducer, rate float64, intervalMinutes int) error {
-	log.Info("开始扫描积分缺失", "chain", chainName)
+func scanAndFixMissingPoints(policy *db.Policy, chainName string, clock epoch.Clock, since time.Duration) error {
+	log.Info("开始扫描积分缺失", "chain", chainName, "since", since)
 
-	// 获取链上所有用户
-	users, err := db.GetUsersByChain(chainName)
+	// 获取链上参与积分的用户（剔除排除地址）
+	users, err := db.GetEligibleUsersByChain(policy, chainName)
 	if err != nil {
 		return fmt.Errorf("获取用户列表失败: %v", err)
 	}
@@ -273,73 +277,46 @@ func scanAndFixMissingPoints(chainName string, producer *mq.PointsProducer, rate
 		log.Info("链上无用户", "chain", chainName)
 		return nil
 	}
+	seasons, err := db.ListSeasons()
+	if err != nil {
+		return fmt.Errorf("获取赛季失败: %v", err)
+	}
 
 	log.Info("找到用户", "count", len(users))
 
 	now := time.Now()
-	normalInterval := time.Duration(intervalMinutes) * time.Minute
 	fixedCount := 0
 	totalTasks := 0
 
 	for _, user := range users {
-		// 获取用户上次计算时间
-		lastCalc, err := db.GetUserLastCalculatedTime(chainName, user)
+		missing, lastCalc, err := findMissingEpochs(clock, chainName, user, now, since)
 		if err != nil {
-			log.Warn("获取用户计算时间失败", "user", user, "error", err)
+			log.Warn("检查周期覆盖失败", "user", user, "error", err)
+			continue
+		}
+		if len(missing) == 0 {
 			continue
 		}
 
-		// 检查是否需要修复
-		expectedLastCalc := now.Add(-normalInterval)
-		needsFix := lastCalc.Before(expectedLastCalc)
+		log.Info("发现用户积分缺失",
+			"user", user,
+			"last_calc", lastCalc,
+			"epoch_ids", formatEpochRanges(missing))
 
-		if needsFix {
-			log.Info("发现用户积分缺失",
-				"user", user,
-				"last_calc", lastCalc,
-				"expected", expectedLastCalc)
-
-			// 计算修复的时间范围
-			backfillEnd := now.Add(-normalInterval) // 修复到正常周期开始
-
-			// 按小时分割时间段
-			periods := splitTimeRange(lastCalc, backfillEnd, time.Hour)
-
-			userTasks := 0
-			for _, period := range periods {
-				// 检查该时间段是否已经计算过
-				hasCalculated, err := db.HasPointsCalculated(chainName, user, period.Start, period.End)
-				if err != nil {
-					log.Warn("检查计算状态失败", "user", user, "period", period, "error", err)
-					continue
-				}
-
-				if hasCalculated {
-					continue
-				}
-
-				// 发布计算任务
-				task := mq.PointsCalculationTask{
-					ChainName:   chainName,
-					UserAddress: user,
-					PeriodStart: period.Start,
-					PeriodEnd:   period.End,
-				}
-
-				if err := producer.Publish(task); err != nil {
-					log.Warn("发布修复任务失败", "user", user, "period", period, "error", err)
-					continue
-				}
-
-				userTasks++
-				totalTasks++
-			}
-
-			if userTasks > 0 {
-				fixedCount++
-				log.Info("用户修复任务发布完成", "user", user, "task_count", userTasks)
-			}
+		tasks := make([]any, 0, len(missing))
+		for _, e := range missing {
+			task := mq.NewEpochTask(chainName, user, e)
+			task.Season = db.SeasonAt(seasons, chainName, e.Start)
+			tasks = append(tasks, task)
 		}
+		if err := db.EnqueueOutbox(db.OutboxTopicPointsTask, tasks...); err != nil {
+			log.Warn("写入修复任务失败", "user", user, "error", err)
+			continue
+		}
+
+		fixedCount++
+		totalTasks += len(tasks)
+		log.Info("用户修复任务写入完成", "user", user, "task_count", len(tasks))
 	}
 
 	log.Info("扫描修复完成",
@@ -350,30 +327,44 @@ func scanAndFixMissingPoints(chainName string, producer *mq.PointsProducer, rate
 	return nil
 }
 
-// 时间段结构
-type TimePeriod struct {
-	Start time.Time
-	End   time.Time
+// 查找用户缺失的积分周期：范围从 min(now-since, 上次计算时间) 所在周期到最近一个已结束的周期
+// 最近结束的周期可能正由调度器处理，不计入缺口
+func findMissingEpochs(clock epoch.Clock, chainName, user string, now time.Time, since time.Duration) ([]epoch.Epoch, time.Time, error) {
+	lastCalc, err := db.GetUserLastCalculatedTime(chainName, user)
+	if err != nil {
+		return nil, lastCalc, err
+	}
+	from := now.Add(-since)
+	if lastCalc.Before(from) {
+		from = lastCalc
+	}
+	missing, err := service.MissingEpochs(clock, chainName, user, clock.ID(from), clock.LastCompleted(now).ID-1)
+	return missing, lastCalc, err
 }
 
-// 分割时间范围
-func splitTimeRange(start, end time.Time, duration time.Duration) []TimePeriod {
-	var periods []TimePeriod
-
-	current := start
-	for current.Before(end) {
-		periodEnd := current.Add(duration)
-		if periodEnd.After(end) {
-			periodEnd = end
+// 将缺失周期的ID压缩为连续区间，如 "100-104,108"
+func formatEpochRanges(epochs []epoch.Epoch) string {
+	var parts []string
+	for i := 0; i < len(epochs); {
+		j := i
+		for j+1 < len(epochs) && epochs[j+1].ID == epochs[j].ID+1 {
+			j++
 		}
-
-		periods = append(periods, TimePeriod{
-			Start: current,
-			End:   periodEnd,
-		})
-
-		current = periodEnd
+		if i == j {
+			parts = append(parts, strconv.FormatInt(epochs[i].ID, 10))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", epochs[i].ID, epochs[j].ID))
+		}
+		i = j + 1
 	}
+	return strings.Join(parts, ",")
+}
 
-	return periods
+// 回溯命令按时间周期检测缺口，区块模式的链由调度器按上次计算时间之后的区块周期补齐
+func requireTimeMode(cfg *config.Config, chainName string) {
+	for _, c := range cfg.Chains {
+		if c.Name == chainName && c.BlockMode() {
+			logger.Fatal("区块模式的链不支持按时间周期回溯", "chain", chainName)
+		}
+	}
 }
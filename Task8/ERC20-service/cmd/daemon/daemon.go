@@ -7,8 +7,13 @@ import (
 	"erc20-service/internal/chain"
 	"erc20-service/internal/db"
 	"erc20-service/internal/mq"
+	"erc20-service/internal/pricing"
+	"erc20-service/internal/rules"
 	"erc20-service/internal/service"
 	"erc20-service/pkg/logger"
+	"erc20-service/pkg/metrics"
+	"errors"
+	"net/http"
 	"os"
 	"os/signal"
 	"sync"
@@ -44,6 +49,8 @@ func runDaemon(cmd *cobra.Command, args []string) {
 	if err := db.Init(cfg.Database); err != nil {
 		logger.Fatal("初始化数据库失败", "error", err)
 	}
+	// 链权重、推荐奖励、女巫处理与排除地址策略，显式传入各组件
+	policy := db.NewPolicy(cfg)
 	// 2.2 初始化链状态
 	if err := db.InitChainStatus(cfg.Chains); err != nil {
 		logger.Fatal("初始化链状态失败", "error", err)
@@ -60,12 +67,22 @@ func runDaemon(cmd *cobra.Command, args []string) {
 	// 3. 创建核心服务组件
 	// 3.1 MQ生产者（发送积分计算任务）
 	pointsProducer := mq.NewPointsProducer(mqConn, cfg.RabbitMQ)
-	// 3.2 MQ消费者（处理积分计算）
-	pointsConsumer := service.NewPointsConsumer(mqConn, cfg.RabbitMQ, cfg.Points.Rate)
+	// 3.2 MQ消费者（处理积分计算，按规则引擎定价，配置价格源时按USD价值计算）
+	rulesEngine, err := rules.NewEngine(cfg.Points)
+	if err != nil {
+		logger.Fatal("加载积分规则失败", "error", err)
+	}
+	priceSource, err := pricing.New(cfg.Points.Pricing)
+	if err != nil {
+		logger.Fatal("初始化价格源失败", "error", err)
+	}
+	pointsConsumer := service.NewPointsConsumer(mqConn, cfg.RabbitMQ, rulesEngine, priceSource, cfg.Points, policy)
 	// 3.3 多链事件监听管理器
 	chainManager := chain.NewManager(cfg.Chains, abiBytes, pointsProducer)
-	// 3.4 积分计算定时调度器
-	scheduler := service.NewScheduler(cfg.Points.Interval, chainManager.GetChainNames(), pointsProducer)
+	// 3.4 积分计算定时调度器（任务写入发件箱）
+	scheduler := service.NewScheduler(cfg.Points, cfg.Chains, policy)
+	// 3.5 发件箱中继（独立MQ连接，投递积分任务与领域事件）
+	outboxRelay := service.NewOutboxRelay(cfg.RabbitMQ, cfg.Outbox)
 
 	// 4. 启动服务组件
 	var wg sync.WaitGroup
@@ -97,6 +114,25 @@ func runDaemon(cmd *cobra.Command, args []string) {
 		log.Info("积分调度器退出")
 	}()
 
+	// 4.4 启动发件箱中继
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		outboxRelay.Start(ctx)
+	}()
+
+	// 4.5 启动指标服务
+	if cfg.Metrics.Listen != "" {
+		metricsServer := &http.Server{Addr: cfg.Metrics.Listen, Handler: metricsMux()}
+		go func() {
+			log.Info("指标服务启动", "listen", cfg.Metrics.Listen)
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Error("指标服务退出", "error", err)
+			}
+		}()
+		defer metricsServer.Close()
+	}
+
 	// 5. 等待退出信号
 	log.Info("服务启动成功，等待退出信号...")
 	sigChan := make(chan os.Signal, 1)
@@ -115,3 +151,10 @@ func runDaemon(cmd *cobra.Command, args []string) {
 	wg.Wait()
 	log.Info("所有服务已关闭，退出程序")
 }
+
+// 指标路由
+func metricsMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	return mux
+}
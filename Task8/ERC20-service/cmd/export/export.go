@@ -0,0 +1,270 @@
+package export
+
+import (
+	"encoding/json"
+	"erc20-service/cmd"
+	"erc20-service/config"
+	"erc20-service/internal/db"
+	"erc20-service/internal/merkle"
+	"erc20-service/pkg/decimal"
+	"erc20-service/pkg/logger"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportCmd = &cobra.Command{
+		Use:   "export",
+		Short: "导出积分数据",
+	}
+
+	exportMerkleCmd = &cobra.Command{
+		Use:   "merkle [file]",
+		Short: "导出空投默克尔树",
+		Long: `按截止时间汇总积分流水，按兑换比例换算为代币数量，
+构建与OpenZeppelin StandardMerkleTree兼容的(address, uint256)默克尔树，输出根与各地址证明
+
+未指定--chain时按points.chain_weights汇总全部链；--verify 校验已有文件中的证明
+
+示例:
+  ./erc20-service export merkle airdrop.json --cutoff 2024-06-30T00:00:00Z --ratio 0.5
+  ./erc20-service export merkle airdrop.json --verify`,
+		Args: cobra.ExactArgs(1),
+		Run:  runExportMerkle,
+	}
+
+	log = logger.New("export-cmd")
+)
+
+func init() {
+	cmd.RootCmd.AddCommand(exportCmd)
+	exportCmd.AddCommand(exportMerkleCmd)
+	exportMerkleCmd.Flags().String("cutoff", "", "积分截止时间（RFC3339），默认当前时间")
+	exportMerkleCmd.Flags().String("chain", "", "只导出指定链，默认跨链加权汇总")
+	exportMerkleCmd.Flags().String("ratio", "", "每积分兑换的代币数量，默认airdrop.tokens_per_point")
+	exportMerkleCmd.Flags().Bool("verify", false, "校验文件中的证明与根")
+}
+
+// MerkleExport 默克尔导出文件
+type MerkleExport struct {
+	Root           string                 `json:"root"`
+	LeafEncoding   []string               `json:"leaf_encoding"`
+	Cutoff         time.Time              `json:"cutoff"`
+	Chain          string                 `json:"chain,omitempty"`
+	TokensPerPoint string                 `json:"tokens_per_point"`
+	TokenDecimals  int                    `json:"token_decimals"`
+	TotalAmount    string                 `json:"total_amount"`
+	Claims         map[string]MerkleClaim `json:"claims"` // 键为校验和地址
+}
+
+// MerkleClaim 单地址领取数据
+type MerkleClaim struct {
+	Points string   `json:"points"`
+	Amount string   `json:"amount"` // 代币最小单位
+	Proof  []string `json:"proof"`
+}
+
+func runExportMerkle(cmd *cobra.Command, args []string) {
+	verify, _ := cmd.Flags().GetBool("verify")
+	if verify {
+		if err := verifyMerkleFile(args[0]); err != nil {
+			logger.Fatal("默克尔证明校验失败", "file", args[0], "error", err)
+		}
+		return
+	}
+
+	cutoffStr, _ := cmd.Flags().GetString("cutoff")
+	chainName, _ := cmd.Flags().GetString("chain")
+	ratioStr, _ := cmd.Flags().GetString("ratio")
+
+	// 加载配置
+	cfgPath, _ := cmd.Flags().GetString("config")
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		logger.Fatal("加载配置失败", "error", err)
+	}
+
+	cutoff := time.Now()
+	if cutoffStr != "" {
+		if cutoff, err = time.Parse(time.RFC3339, cutoffStr); err != nil {
+			logger.Fatal("截止时间格式错误", "cutoff", cutoffStr, "error", err)
+		}
+	}
+	if ratioStr == "" {
+		ratioStr = cfg.Airdrop.TokensPerPoint
+	}
+	ratio, ok := new(big.Rat).SetString(ratioStr)
+	if !ok || ratio.Sign() <= 0 {
+		logger.Fatal("兑换比例必须为正数", "ratio", ratioStr)
+	}
+
+	// 初始化数据库
+	if err := db.Init(cfg.Database); err != nil {
+		logger.Fatal("初始化数据库失败", "error", err)
+	}
+
+	export, err := buildMerkleExport(db.NewPolicy(cfg), cutoff, chainName, ratio, cfg.Airdrop.TokenDecimals)
+	if err != nil {
+		logger.Fatal("构建默克尔树失败", "error", err)
+	}
+
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		logger.Fatal("序列化导出文件失败", "error", err)
+	}
+	if err := os.WriteFile(args[0], data, 0644); err != nil {
+		logger.Fatal("写入导出文件失败", "file", args[0], "error", err)
+	}
+	log.Info("默克尔树导出完成",
+		"file", args[0],
+		"root", export.Root,
+		"claims", len(export.Claims),
+		"total_amount", export.TotalAmount,
+		"cutoff", cutoff)
+}
+
+// 汇总截止时间的积分，换算代币数量并构建默克尔树
+func buildMerkleExport(policy *db.Policy, cutoff time.Time, chainName string, ratio *big.Rat, tokenDecimals int) (*MerkleExport, error) {
+	balances, err := db.GetLedgerBalancesAt(cutoff, chainName)
+	if err != nil {
+		return nil, fmt.Errorf("汇总积分失败: %v", err)
+	}
+
+	// 按地址汇总；跨链导出时乘以链权重
+	points := make(map[common.Address]decimal.Decimal)
+	for _, b := range balances {
+		if !common.IsHexAddress(b.UserAddress) {
+			log.Warn("跳过无效地址", "user", b.UserAddress)
+			continue
+		}
+		p := b.Points
+		if chainName == "" {
+			p = p.MulRat(policy.ChainWeight(b.ChainName).Rat())
+		}
+		addr := common.HexToAddress(b.UserAddress)
+		points[addr] = points[addr].Add(p)
+	}
+
+	// 代币数量 = 积分 × 比例 × 10^精度，向下取整
+	unit := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(tokenDecimals)), nil))
+	var (
+		leaves     []merkle.Leaf
+		leafPoints []decimal.Decimal
+	)
+	total := new(big.Int)
+	for addr, p := range points {
+		if p.Sign() <= 0 {
+			continue
+		}
+		amount := new(big.Rat).Mul(p.Rat(), ratio)
+		amount.Mul(amount, unit)
+		wei := new(big.Int).Quo(amount.Num(), amount.Denom())
+		if wei.Sign() == 0 {
+			continue
+		}
+		leaves = append(leaves, merkle.Leaf{Account: addr, Amount: wei})
+		leafPoints = append(leafPoints, p)
+		total.Add(total, wei)
+	}
+	if len(leaves) == 0 {
+		return nil, fmt.Errorf("截止 %s 无可兑换积分", cutoff.Format(time.RFC3339))
+	}
+
+	// 按地址排序，保证同一输入生成的文件一致
+	idx := make([]int, len(leaves))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(a, b int) bool {
+		return leaves[idx[a]].Account.Hex() < leaves[idx[b]].Account.Hex()
+	})
+	sortedLeaves := make([]merkle.Leaf, len(leaves))
+	sortedPoints := make([]decimal.Decimal, len(leaves))
+	for i, j := range idx {
+		sortedLeaves[i], sortedPoints[i] = leaves[j], leafPoints[j]
+	}
+
+	tree, err := merkle.Build(sortedLeaves)
+	if err != nil {
+		return nil, err
+	}
+	export := &MerkleExport{
+		Root:           tree.Root().Hex(),
+		LeafEncoding:   []string{"address", "uint256"},
+		Cutoff:         cutoff.UTC(),
+		Chain:          chainName,
+		TokensPerPoint: ratio.RatString(),
+		TokenDecimals:  tokenDecimals,
+		TotalAmount:    total.String(),
+		Claims:         make(map[string]MerkleClaim, len(sortedLeaves)),
+	}
+	for i, l := range sortedLeaves {
+		proof := tree.Proof(i)
+		hexProof := make([]string, len(proof))
+		for k, h := range proof {
+			hexProof[k] = h.Hex()
+		}
+		export.Claims[l.Account.Hex()] = MerkleClaim{
+			Points: sortedPoints[i].String(),
+			Amount: l.Amount.String(),
+			Proof:  hexProof,
+		}
+	}
+	return export, nil
+}
+
+// 校验导出文件：逐个校验证明，并由全部叶子重建树比对根
+func verifyMerkleFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var export MerkleExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return fmt.Errorf("解析导出文件失败: %v", err)
+	}
+	root := common.HexToHash(export.Root)
+
+	var (
+		leaves []merkle.Leaf
+		failed int
+	)
+	for addrStr, claim := range export.Claims {
+		if !common.IsHexAddress(addrStr) {
+			return fmt.Errorf("无效地址: %s", addrStr)
+		}
+		amount, ok := new(big.Int).SetString(claim.Amount, 10)
+		if !ok {
+			return fmt.Errorf("地址 %s 金额无效: %s", addrStr, claim.Amount)
+		}
+		leaf := merkle.Leaf{Account: common.HexToAddress(addrStr), Amount: amount}
+		proof := make([]common.Hash, len(claim.Proof))
+		for i, p := range claim.Proof {
+			proof[i] = common.HexToHash(p)
+		}
+		if !merkle.Verify(root, leaf, proof) {
+			failed++
+			log.Warn("证明校验失败", "user", addrStr, "amount", claim.Amount)
+		}
+		leaves = append(leaves, leaf)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d 个地址的证明校验失败", failed)
+	}
+
+	tree, err := merkle.Build(leaves)
+	if err != nil {
+		return err
+	}
+	if tree.Root() != root {
+		return fmt.Errorf("由叶子重建的根 %s 与文件中的根 %s 不一致", tree.Root().Hex(), export.Root)
+	}
+	log.Info("默克尔证明校验通过", "file", path, "root", export.Root, "claims", len(export.Claims))
+	return nil
+}
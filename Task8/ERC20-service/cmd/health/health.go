@@ -44,9 +44,11 @@ func runHealthCheck(cmd *cobra.Command, args []string) {
 	if err := db.Init(cfg.Database); err != nil {
 		logger.Fatal("初始化数据库失败", "error", err)
 	}
+	// 健康检查的统计查询路由到只读副本
+	db.EnableReplicaReads()
 
 	// 执行健康检查
-	status := performHealthCheck(cfg)
+	status := performHealthCheck(cfg, db.NewPolicy(cfg))
 
 	// 输出结果
 	if status.IsHealthy {
@@ -60,6 +62,7 @@ func runHealthCheck(cmd *cobra.Command, args []string) {
 type HealthStatus struct {
 	IsHealthy               bool                    `json:"is_healthy"`
 	DatabaseStatus          ComponentStatus         `json:"database_status"`
+	ReplicaStatuses         []db.ReplicaStatus      `json:"replica_statuses,omitempty"`
 	ChainStatuses           map[string]ChainStatus  `json:"chain_statuses"`
 	PointsCalculationStatus PointsCalculationStatus `json:"points_calculation_status"`
 	OverallScore            int                     `json:"overall_score"`
@@ -79,8 +82,15 @@ type ChainStatus struct {
 	LastProcessedTime  time.Time `json:"last_processed_time"`
 	HoursBehind        float64   `json:"hours_behind"`
 	Message            string    `json:"message"`
+	// 持有者统计（剔除排除地址）
+	Holders *db.HolderStats `json:"holders,omitempty"`
+	// 总积分与流水汇总不一致的用户（最多ledgerMismatchSample个）
+	LedgerMismatches []db.LedgerMismatch `json:"ledger_mismatches,omitempty"`
 }
 
+// 每条链列出的总积分与流水不一致用户数
+const ledgerMismatchSample = 10
+
 // PointsCalculationStatus 积分计算状态
 type PointsCalculationStatus struct {
 	IsHealthy          bool    `json:"is_healthy"`
@@ -91,7 +101,7 @@ type PointsCalculationStatus struct {
 }
 
 // 执行健康检查
-func performHealthCheck(cfg *config.Config) HealthStatus {
+func performHealthCheck(cfg *config.Config, policy *db.Policy) HealthStatus {
 	status := HealthStatus{
 		IsHealthy:     true,
 		ChainStatuses: make(map[string]ChainStatus),
@@ -103,9 +113,30 @@ func performHealthCheck(cfg *config.Config) HealthStatus {
 		status.IsHealthy = false
 	}
 
+	// 检查只读副本（副本不可用时查询回退主库，不影响整体健康）
+	status.ReplicaStatuses = db.GetReplicaStatuses()
+	for _, r := range status.ReplicaStatuses {
+		if !r.Healthy {
+			log.Warn("只读副本不可用或延迟过高", "replica", r.Name, "lag", r.Lag, "error", r.Error)
+		}
+	}
+
 	// 检查各链状态
 	for _, chain := range cfg.Chains {
 		chainStatus := checkChainStatus(chain.Name)
+		if holders, err := db.GetHolderStats(policy, chain.Name); err != nil {
+			log.Warn("统计持有者失败", "chain", chain.Name, "error", err)
+		} else {
+			chainStatus.Holders = &holders
+		}
+		// 对账：total_points 必须等于流水之和
+		if mismatches, err := db.GetLedgerMismatches(chain.Name, ledgerMismatchSample); err != nil {
+			log.Warn("核对积分流水失败", "chain", chain.Name, "error", err)
+		} else if len(mismatches) > 0 {
+			chainStatus.LedgerMismatches = mismatches
+			chainStatus.IsHealthy = false
+			chainStatus.Message = fmt.Sprintf("%s；总积分与流水汇总不一致的用户: %d（最多列出%d个）", chainStatus.Message, len(mismatches), ledgerMismatchSample)
+		}
 		status.ChainStatuses[chain.Name] = chainStatus
 		if !chainStatus.IsHealthy {
 			status.IsHealthy = false
@@ -113,7 +144,7 @@ func performHealthCheck(cfg *config.Config) HealthStatus {
 	}
 
 	// 检查积分计算状态
-	status.PointsCalculationStatus = checkPointsCalculationStatus()
+	status.PointsCalculationStatus = checkPointsCalculationStatus(policy)
 	if !status.PointsCalculationStatus.IsHealthy {
 		status.IsHealthy = false
 	}
@@ -136,10 +167,10 @@ func checkDatabase() ComponentStatus {
 	}
 
 	// 检查关键表是否存在
-	tables := []string{"chain_status", "user_balances", "balance_changes", "user_points", "points_calculation_history"}
+	tables := []string{"chain_status", "user_balances", "balance_changes", "user_points", "points_ledger", "points_calculation_history", "outbox"}
 	for _, table := range tables {
 		var count int
-		err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s LIMIT 1", table)).Scan(&count)
+		err := db.ReadQueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s LIMIT 1", table)).Scan(&count)
 		if err != nil {
 			return ComponentStatus{
 				IsHealthy: false,
@@ -168,7 +199,7 @@ func checkChainStatus(chainName string) ChainStatus {
 
 	// 获取最后处理时间（从chain_status表）
 	var lastProcessedTime time.Time
-	err = db.QueryRow(`
+	err = db.ReadQueryRow(`
 		SELECT updated_at FROM chain_status WHERE chain_name = ?
 	`, chainName).Scan(&lastProcessedTime)
 	if err != nil {
@@ -193,9 +224,9 @@ func checkChainStatus(chainName string) ChainStatus {
 }
 
 // 检查积分计算状态
-func checkPointsCalculationStatus() PointsCalculationStatus {
+func checkPointsCalculationStatus(policy *db.Policy) PointsCalculationStatus {
 	// 获取所有用户
-	users, err := db.GetUsersByChain("sepolia") // 假设检查sepolia链
+	users, err := db.GetEligibleUsersByChain(policy, "sepolia") // 假设检查sepolia链
 	if err != nil {
 		return PointsCalculationStatus{
 			IsHealthy: false,
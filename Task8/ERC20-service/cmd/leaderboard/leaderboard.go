@@ -0,0 +1,159 @@
+package leaderboard
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"erc20-service/cmd"
+	"erc20-service/config"
+	"erc20-service/internal/db"
+	"erc20-service/pkg/logger"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	leaderboardCmd = &cobra.Command{
+		Use:   "leaderboard [chain|global]",
+		Short: "查询积分排行榜",
+		Long: `分页查询链榜单或跨链总榜（global），包含与前一日快照相比的排名变化
+排名由daemon每轮调度后刷新，也可通过--refresh立即刷新
+
+示例:
+  ./erc20-service leaderboard sepolia --page 1 --page-size 20
+  ./erc20-service leaderboard global --format csv > leaderboard.csv`,
+		Args: cobra.MaximumNArgs(1),
+		Run:  runLeaderboard,
+	}
+	log = logger.New("leaderboard-cmd")
+)
+
+func init() {
+	cmd.RootCmd.AddCommand(leaderboardCmd)
+	leaderboardCmd.Flags().Int("page", 1, "页码，从1开始")
+	leaderboardCmd.Flags().Int("page-size", 50, "每页条数")
+	leaderboardCmd.Flags().String("format", "json", "输出格式: json | csv")
+	leaderboardCmd.Flags().Bool("refresh", false, "查询前重新计算排名")
+}
+
+// 分页输出结构
+type leaderboardPage struct {
+	Board       string                `json:"board"`
+	Page        int                   `json:"page"`
+	PageSize    int                   `json:"page_size"`
+	Total       int                   `json:"total"`
+	RefreshedAt *time.Time            `json:"refreshed_at"`
+	Entries     []db.LeaderboardEntry `json:"entries"`
+}
+
+func runLeaderboard(cmd *cobra.Command, args []string) {
+	page, _ := cmd.Flags().GetInt("page")
+	pageSize, _ := cmd.Flags().GetInt("page-size")
+	format, _ := cmd.Flags().GetString("format")
+	refresh, _ := cmd.Flags().GetBool("refresh")
+	if page < 1 || pageSize < 1 {
+		logger.Fatal("页码与每页条数必须大于0", "page", page, "page_size", pageSize)
+	}
+	if format != "json" && format != "csv" {
+		logger.Fatal("不支持的输出格式", "format", format)
+	}
+
+	board := db.LeaderboardGlobal
+	if len(args) > 0 {
+		board = args[0]
+	}
+
+	// 加载配置
+	cfgPath, _ := cmd.Flags().GetString("config")
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		logger.Fatal("加载配置失败", "error", err)
+	}
+	if board != db.LeaderboardGlobal && !hasChain(cfg, board) {
+		logger.Fatal("未配置的链", "chain", board)
+	}
+
+	// 初始化数据库
+	if err := db.Init(cfg.Database); err != nil {
+		logger.Fatal("初始化数据库失败", "error", err)
+	}
+
+	if !refresh {
+		// 只读查询可走只读副本
+		db.EnableReplicaReads()
+	} else {
+		n, err := db.RefreshLeaderboard(board, time.Now())
+		if err != nil {
+			logger.Fatal("刷新排行榜失败", "error", err)
+		}
+		log.Info("排行榜已刷新", "board", board, "ranked", n)
+	}
+
+	total, refreshedAt, err := db.CountLeaderboard(board)
+	if err != nil {
+		logger.Fatal("统计排行榜失败", "error", err)
+	}
+	entries, err := db.GetLeaderboard(board, (page-1)*pageSize, pageSize)
+	if err != nil {
+		logger.Fatal("查询排行榜失败", "error", err)
+	}
+
+	result := leaderboardPage{
+		Board:       board,
+		Page:        page,
+		PageSize:    pageSize,
+		Total:       total,
+		RefreshedAt: refreshedAt,
+		Entries:     entries,
+	}
+	if format == "csv" {
+		err = writeCSV(result)
+	} else {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(result)
+	}
+	if err != nil {
+		logger.Fatal("输出排行榜失败", "error", err)
+	}
+}
+
+func hasChain(cfg *config.Config, name string) bool {
+	for _, c := range cfg.Chains {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// CSV输出，排名变化为空表示新上榜
+func writeCSV(p leaderboardPage) error {
+	w := csv.NewWriter(os.Stdout)
+	if err := w.Write([]string{"rank", "user_address", "total_points", "prev_rank", "rank_change"}); err != nil {
+		return err
+	}
+	for _, e := range p.Entries {
+		if err := w.Write([]string{
+			strconv.Itoa(e.Rank),
+			e.UserAddress,
+			e.TotalPoints.String(),
+			optionalInt(e.PrevRank),
+			optionalInt(e.RankChange),
+		}); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func optionalInt(v *int) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(*v)
+}
@@ -0,0 +1,115 @@
+package points
+
+import (
+	"erc20-service/internal/db"
+	"erc20-service/pkg/logger"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	pointsExclusionsCmd = &cobra.Command{
+		Use:   "exclusions",
+		Short: "管理排除地址",
+		Long: `排除地址不参与积分计算与持有者统计（代币合约、LP池、销毁地址、跨链桥、团队金库等）
+配置文件chains[].excluded_addresses为静态列表，此处维护数据库中的列表，两者合并生效`,
+	}
+
+	pointsExclusionsAddCmd = &cobra.Command{
+		Use:   "add [chain] [address]",
+		Short: "添加排除地址",
+		Long: `添加排除地址，下一轮调度起不再为该地址生成积分任务，已入账积分不受影响
+
+示例:
+  ./erc20-service points exclusions add sepolia 0xabc... --reason "Uniswap V3 池"`,
+		Args: cobra.ExactArgs(2),
+		Run:  runExclusionsAdd,
+	}
+
+	pointsExclusionsRemoveCmd = &cobra.Command{
+		Use:   "remove [chain] [address]",
+		Short: "移除排除地址",
+		Args:  cobra.ExactArgs(2),
+		Run:   runExclusionsRemove,
+	}
+
+	pointsExclusionsListCmd = &cobra.Command{
+		Use:   "list [chain]",
+		Short: "列出排除地址",
+		Args:  cobra.MaximumNArgs(1),
+		Run:   runExclusionsList,
+	}
+)
+
+func init() {
+	pointsCmd.AddCommand(pointsExclusionsCmd)
+	pointsExclusionsCmd.AddCommand(pointsExclusionsAddCmd)
+	pointsExclusionsCmd.AddCommand(pointsExclusionsRemoveCmd)
+	pointsExclusionsCmd.AddCommand(pointsExclusionsListCmd)
+
+	pointsExclusionsAddCmd.Flags().String("reason", "", "排除原因（必填）")
+	pointsExclusionsAddCmd.Flags().String("actor", os.Getenv("USER"), "操作人，默认当前系统用户")
+	pointsExclusionsAddCmd.MarkFlagRequired("reason")
+}
+
+func runExclusionsAdd(cmd *cobra.Command, args []string) {
+	reason, _ := cmd.Flags().GetString("reason")
+	actor, _ := cmd.Flags().GetString("actor")
+	if strings.TrimSpace(reason) == "" {
+		logger.Fatal("必须填写排除原因")
+	}
+	if actor == "" {
+		logger.Fatal("无法确定操作人，请通过--actor指定")
+	}
+	initDB(cmd)
+
+	e := db.ExcludedAddress{
+		ChainName: args[0],
+		Address:   parseAddress(args[1]),
+		Reason:    reason,
+		Actor:     actor,
+	}
+	if err := db.AddExcludedAddress(e); err != nil {
+		logger.Fatal("添加排除地址失败", "error", err)
+	}
+	log.Info("排除地址已添加", "chain", e.ChainName, "address", e.Address, "reason", reason, "actor", actor)
+}
+
+func runExclusionsRemove(cmd *cobra.Command, args []string) {
+	initDB(cmd)
+
+	chainName, addr := args[0], parseAddress(args[1])
+	removed, err := db.RemoveExcludedAddress(chainName, addr)
+	if err != nil {
+		logger.Fatal("移除排除地址失败", "error", err)
+	}
+	if !removed {
+		logger.Fatal("数据库中不存在该排除地址（配置文件中的地址需修改配置移除）", "chain", chainName, "address", addr)
+	}
+	log.Info("排除地址已移除", "chain", chainName, "address", addr)
+}
+
+func runExclusionsList(cmd *cobra.Command, args []string) {
+	policy := db.NewPolicy(initDB(cmd))
+	db.EnableReplicaReads()
+
+	chainName := ""
+	if len(args) > 0 {
+		chainName = args[0]
+	}
+	list, err := db.ListExcludedAddresses(policy, chainName)
+	if err != nil {
+		logger.Fatal("查询排除地址失败", "error", err)
+	}
+	for _, e := range list {
+		log.Info("排除地址",
+			"chain", e.ChainName,
+			"address", e.Address,
+			"source", e.Source,
+			"reason", e.Reason,
+			"actor", e.Actor)
+	}
+	log.Info("排除地址汇总", "chain", chainName, "count", len(list))
+}
@@ -0,0 +1,360 @@
+package points
+
+import (
+	"encoding/json"
+	"erc20-service/config"
+	"erc20-service/internal/db"
+	"erc20-service/internal/epoch"
+	"erc20-service/internal/pricing"
+	"erc20-service/internal/rules"
+	"erc20-service/internal/service"
+	"erc20-service/pkg/decimal"
+	"erc20-service/pkg/logger"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var pointsExplainCmd = &cobra.Command{
+	Use:   "explain [chain] [address] [period]",
+	Short: "解释积分计算过程",
+	Long: `按当前规则重算用户在指定时间段的积分，逐个定价子段列出起止时间、余额、计息余额、比率、命中规则与积分，
+并与已入账的计算历史对比（开启points.persist_segments时同时列出入账时保存的明细）
+
+时间范围跨多个积分周期时逐周期计算（比率按周期计），首尾周期截断到时间范围
+
+period 支持三种格式:
+  积分周期ID:            5690880
+  时间点（所在周期）:    2024-01-01T10:03:00Z
+  时间范围:              2024-01-01T00:00:00Z/2024-01-02T00:00:00Z
+区块模式的链（chains[].points.mode: block）period 为区块周期序号，按持有区块数重算
+
+
+示例:
+  ./erc20-service points explain sepolia 0xabc... 2024-01-01T10:03:00Z
+  ./erc20-service points explain sepolia 0xabc... 5690880 --format json`,
+	Args: cobra.ExactArgs(3),
+	Run:  runPointsExplain,
+}
+
+func init() {
+	pointsCmd.AddCommand(pointsExplainCmd)
+	pointsExplainCmd.Flags().String("format", "table", "输出格式: table | json")
+}
+
+// 积分解释结果
+type explanation struct {
+	ChainName   string             `json:"chain_name"`
+	UserAddress string             `json:"user_address"`
+	EpochID     int64              `json:"epoch_id,omitempty"`
+	BlockPeriod string             `json:"block_period,omitempty"`
+	PeriodStart time.Time          `json:"period_start"`
+	PeriodEnd   time.Time          `json:"period_end"`
+	Excluded    bool               `json:"excluded"`
+	Segments    []db.PointsSegment `json:"segments"`
+	Points      string             `json:"points"`
+	RuleVersion string             `json:"rule_version"`
+	Recorded    []recordedHistory  `json:"recorded"`
+	RecordedSum string             `json:"recorded_points"`
+	Difference  string             `json:"difference"` // 已入账 - 重算
+}
+
+// 已入账的计算历史
+type recordedHistory struct {
+	PeriodStart time.Time          `json:"period_start"`
+	PeriodEnd   time.Time          `json:"period_end"`
+	EpochID     int64              `json:"epoch_id,omitempty"`
+	Points      string             `json:"points"`
+	RuleVersion string             `json:"rule_version"`
+	TaskID      string             `json:"task_id,omitempty"`
+	Segments    []db.PointsSegment `json:"segments,omitempty"`
+	Prices      []db.PriceQuote    `json:"prices,omitempty"`
+}
+
+func runPointsExplain(cmd *cobra.Command, args []string) {
+	format, _ := cmd.Flags().GetString("format")
+	if format != "table" && format != "json" {
+		logger.Fatal("不支持的输出格式", "format", format)
+	}
+
+	cfg := initDB(cmd)
+	policy := db.NewPolicy(cfg)
+	db.EnableReplicaReads()
+
+	chainName, addr := args[0], parseAddress(args[1])
+	engine, err := rules.NewEngine(cfg.Points)
+	if err != nil {
+		logger.Fatal("加载积分规则失败", "error", err)
+	}
+	prices, err := pricing.New(cfg.Points.Pricing)
+	if err != nil {
+		logger.Fatal("初始化价格源失败", "error", err)
+	}
+	excluded, err := db.IsExcludedAddress(policy, chainName, addr)
+	if err != nil {
+		logger.Fatal("检查排除地址失败", "error", err)
+	}
+
+	// 1. 按当前规则重算
+	var (
+		start, end  time.Time
+		epochID     int64
+		blockPeriod string
+		points      decimal.Decimal
+		ruleVersion string
+		segments    []db.PointsSegment
+	)
+	if chainCfg, ok := findChain(cfg, chainName); ok && chainCfg.BlockMode() {
+		p, err := parseBlockPeriod(chainCfg, args[2])
+		if err != nil {
+			logger.Fatal("区块周期无效", "period", args[2], "error", err)
+		}
+		holdings, err := service.LoadBlockPeriodHoldings(policy, chainName, addr, p)
+		if err != nil {
+			logger.Fatal("重算积分失败", "error", err)
+		}
+		if holdings.Prices, err = service.LoadPrices(prices, chainName, p.Start, p.End); err != nil {
+			logger.Fatal("重算积分失败", "error", err)
+		}
+		start, end, blockPeriod = p.Start, p.End, p.String()
+		periodEngine, err := seasonEngine(engine, chainName, p.Start)
+		if err != nil {
+			logger.Fatal("重算积分失败", "error", err)
+		}
+		points, ruleVersion, segments = service.ExplainBlockPeriodPoints(periodEngine, chainName, addr, holdings, p)
+	} else {
+		clock := epoch.NewClock(time.Duration(cfg.Points.Interval) * time.Minute)
+		start, end, epochID, err = parseExplainPeriod(clock, args[2])
+		if err != nil {
+			logger.Fatal("时间段格式错误", "period", args[2], "error", err)
+		}
+		points, ruleVersion, segments, err = explainRange(policy, engine, prices, clock, chainName, addr, start, end)
+		if err != nil {
+			logger.Fatal("重算积分失败", "error", err)
+		}
+	}
+
+	// 2. 已入账的计算历史（已归档的时间段只保留日汇总，不含明细）
+	records, err := db.GetPointsHistoryInPeriod(chainName, addr, start, end)
+	if err != nil {
+		logger.Fatal("查询计算历史失败", "error", err)
+	}
+
+	e := explanation{
+		ChainName:   chainName,
+		UserAddress: addr,
+		EpochID:     epochID,
+		BlockPeriod: blockPeriod,
+		PeriodStart: start,
+		PeriodEnd:   end,
+		Excluded:    excluded,
+		Segments:    segments,
+		Points:      points.String(),
+		RuleVersion: ruleVersion,
+	}
+	recorded := decimal.Zero()
+	for _, r := range records {
+		added, err := decimal.Parse(r.PointsAdded)
+		if err != nil {
+			logger.Fatal("解析历史积分失败", "value", r.PointsAdded, "error", err)
+		}
+		recorded = recorded.Add(added)
+		e.Recorded = append(e.Recorded, recordedHistory{
+			PeriodStart: r.PeriodStart,
+			PeriodEnd:   r.PeriodEnd,
+			EpochID:     r.EpochID,
+			Points:      r.PointsAdded,
+			RuleVersion: r.RuleVersion,
+			TaskID:      r.TaskID,
+			Segments:    r.Segments,
+			Prices:      r.Prices,
+		})
+	}
+	e.RecordedSum = recorded.String()
+	e.Difference = recorded.Sub(points).String()
+
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(e); err != nil {
+			logger.Fatal("输出失败", "error", err)
+		}
+		return
+	}
+	writeExplanation(os.Stdout, e)
+}
+
+// 解析时间段参数：周期ID、时间点（所在周期）或"开始/结束"时间范围
+func parseExplainPeriod(clock epoch.Clock, s string) (time.Time, time.Time, int64, error) {
+	if id, err := strconv.ParseInt(s, 10, 64); err == nil {
+		ep := clock.Epoch(id)
+		return ep.Start, ep.End, ep.ID, nil
+	}
+	if from, to, ok := strings.Cut(s, "/"); ok {
+		start, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return time.Time{}, time.Time{}, 0, err
+		}
+		end, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return time.Time{}, time.Time{}, 0, err
+		}
+		if !end.After(start) {
+			return time.Time{}, time.Time{}, 0, fmt.Errorf("结束时间必须晚于开始时间")
+		}
+		return start, end, 0, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, time.Time{}, 0, err
+	}
+	ep := clock.At(t)
+	return ep.Start, ep.End, ep.ID, nil
+}
+
+// 按名称查找链配置
+func findChain(cfg *config.Config, name string) (config.ChainConfig, bool) {
+	for _, c := range cfg.Chains {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return config.ChainConfig{}, false
+}
+
+// 解析区块周期序号，起止时间取监听器记录的边界区块链上时间
+func parseBlockPeriod(c config.ChainConfig, s string) (epoch.BlockPeriod, error) {
+	index, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return epoch.BlockPeriod{}, fmt.Errorf("区块模式的period应为区块周期序号")
+	}
+	p, ok := epoch.NewBlockClock(c.Points.StartBlock, c.Points.EndBlock, c.Points.BlocksPerPeriod).Period(index)
+	if !ok {
+		return p, fmt.Errorf("超出积分区块范围")
+	}
+	boundaries, err := db.GetBlockBoundaries(c.Name, p.StartBlock)
+	if err != nil {
+		return p, err
+	}
+	var startOK, endOK bool
+	p.Start, startOK = boundaries[p.StartBlock]
+	p.End, endOK = boundaries[p.EndBlock]
+	if !startOK || !endOK {
+		return p, fmt.Errorf("周期%s尚未结束或边界区块时间未记录", p)
+	}
+	return p, nil
+}
+
+// 按积分周期重算时间范围内的积分（比率按周期计，跨多个周期时逐周期计算，首尾截断到时间范围）
+func explainRange(policy *db.Policy, engine *rules.Engine, prices pricing.PriceSource, clock epoch.Clock, chainName, addr string, start, end time.Time) (decimal.Decimal, string, []db.PointsSegment, error) {
+	total := decimal.Zero()
+	var (
+		versions []string
+		segments []db.PointsSegment
+	)
+	for _, ep := range clock.Covering(start, end) {
+		ps, pe := ep.Start, ep.End
+		if ps.Before(start) {
+			ps = start
+		}
+		if pe.After(end) {
+			pe = end
+		}
+		holdings, err := service.LoadPeriodHoldings(policy, chainName, addr, ps, pe)
+		if err != nil {
+			return total, "", nil, err
+		}
+		if holdings.Prices, err = service.LoadPrices(prices, chainName, ps, pe); err != nil {
+			return total, "", nil, err
+		}
+		periodEngine, err := seasonEngine(engine, chainName, ep.Start)
+		if err != nil {
+			return total, "", nil, err
+		}
+		points, version, segs := service.ExplainPeriodPoints(periodEngine, chainName, addr, holdings, ps, pe)
+		total = total.Add(points)
+		segments = append(segments, segs...)
+		for _, v := range strings.Split(version, "+") {
+			if v != "" && (len(versions) == 0 || versions[len(versions)-1] != v) {
+				versions = append(versions, v)
+			}
+		}
+	}
+	return total, strings.Join(versions, "+"), segments, nil
+}
+
+// 以表格输出积分解释
+func writeExplanation(out io.Writer, e explanation) {
+	fmt.Fprintf(out, "链: %s  地址: %s\n", e.ChainName, e.UserAddress)
+	if e.BlockPeriod != "" {
+		fmt.Fprintf(out, "区块周期: %s ", e.BlockPeriod)
+	} else if e.EpochID != 0 {
+		fmt.Fprintf(out, "周期: #%d ", e.EpochID)
+	} else {
+		fmt.Fprint(out, "时间段: ")
+	}
+	fmt.Fprintf(out, "%s - %s\n", e.PeriodStart.UTC().Format(time.RFC3339), e.PeriodEnd.UTC().Format(time.RFC3339))
+	if e.Excluded {
+		fmt.Fprintln(out, "注意: 该地址在排除列表中，积分任务不会为其入账")
+	}
+
+	fmt.Fprintln(out, "\n按当前规则重算:")
+	writeSegments(out, e.Segments)
+	fmt.Fprintf(out, "重算积分: %s  规则版本: %s\n", e.Points, e.RuleVersion)
+
+	fmt.Fprintln(out, "\n已入账记录:")
+	if len(e.Recorded) == 0 {
+		fmt.Fprintln(out, "  （无）")
+	}
+	for _, r := range e.Recorded {
+		fmt.Fprintf(out, "  %s - %s  积分: %s  规则版本: %s  任务: %s\n",
+			r.PeriodStart.UTC().Format(time.RFC3339), r.PeriodEnd.UTC().Format(time.RFC3339),
+			r.Points, r.RuleVersion, r.TaskID)
+		for _, p := range r.Prices {
+			fmt.Fprintf(out, "    价格: %s  报价时间: %s  来源: %s\n", p.Price, p.At.UTC().Format(time.RFC3339), p.Source)
+		}
+		if len(r.Segments) > 0 {
+			writeSegments(out, r.Segments)
+		}
+	}
+	fmt.Fprintf(out, "已入账积分: %s  差异(已入账-重算): %s\n", e.RecordedSum, e.Difference)
+}
+
+// 输出积分明细表
+func writeSegments(out io.Writer, segments []db.PointsSegment) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  START\tEND\tBLOCKS\tBALANCE\tSTAKED\tWEIGHT\tPRICE\tRATE\tRULE_VERSION\tRULE\tPOINTS")
+	for _, s := range segments {
+		staked := s.Staked
+		if staked == "" {
+			staked = "0"
+		}
+		blocks := "-"
+		if s.ToBlock > 0 {
+			blocks = fmt.Sprintf("%d-%d", s.FromBlock, s.ToBlock)
+		}
+		price := s.Price
+		if price == "" {
+			price = "-"
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			s.Start.UTC().Format(time.RFC3339), s.End.UTC().Format(time.RFC3339), blocks,
+			s.Balance, staked, s.Weight, price, s.Rate, s.RuleVersion, s.Rule, s.Points)
+	}
+	w.Flush()
+}
+
+// 周期所属赛季使用的规则引擎，赛季按周期开始时间确定（与调度器一致）
+func seasonEngine(engine *rules.Engine, chainName string, periodStart time.Time) (*rules.Engine, error) {
+	season, err := db.GetSeasonAt(chainName, periodStart)
+	if err != nil {
+		return nil, fmt.Errorf("获取赛季失败: %v", err)
+	}
+	return service.SeasonEngine(engine, season)
+}
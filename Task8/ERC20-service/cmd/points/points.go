@@ -0,0 +1,286 @@
+package points
+
+import (
+	"erc20-service/cmd"
+	"erc20-service/config"
+	"erc20-service/internal/db"
+	"erc20-service/pkg/decimal"
+	"erc20-service/pkg/logger"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pointsCmd = &cobra.Command{
+		Use:   "points",
+		Short: "积分流水管理",
+		Long:  "人工补发、扣回积分并查询积分流水，所有变更以带符号流水记录，总积分由流水汇总",
+	}
+
+	pointsGrantCmd = &cobra.Command{
+		Use:   "grant [chain] [address] [amount]",
+		Short: "补发积分",
+		Long: `为用户补发积分（如故障补偿），写入grant流水；指定--season时同时计入该赛季
+
+示例:
+  ./erc20-service points grant sepolia 0xabc... 100.5 --reason "RPC故障补偿" --ref INC-1024
+  ./erc20-service points grant sepolia 0xabc... 500 --reason "S2任务奖励" --season s2`,
+		Args: cobra.ExactArgs(3),
+		Run:  runPointsAdjust(db.LedgerTypeGrant),
+	}
+
+	pointsRevokeCmd = &cobra.Command{
+		Use:   "revoke [chain] [address] [amount]",
+		Short: "扣回积分",
+		Long: `扣回用户积分（如利用漏洞获得的积分），写入revoke流水，扣回后总积分不能为负
+
+示例:
+  ./erc20-service points revoke sepolia 0xabc... 5000 --reason "重入漏洞刷分" --ref INC-1025`,
+		Args: cobra.ExactArgs(3),
+		Run:  runPointsAdjust(db.LedgerTypeRevoke),
+	}
+
+	pointsHistoryCmd = &cobra.Command{
+		Use:   "history [chain] [address]",
+		Short: "查询积分流水",
+		Args:  cobra.ExactArgs(2),
+		Run:   runPointsHistory,
+	}
+
+	pointsTotalCmd = &cobra.Command{
+		Use:   "total [address]",
+		Short: "查询跨链汇总积分",
+		Long: `查询地址在各链的积分及按chain_weights加权的跨链汇总积分
+
+示例:
+  ./erc20-service points total 0xabc...`,
+		Args: cobra.ExactArgs(1),
+		Run:  runPointsTotal,
+	}
+
+	pointsRebuildTotalsCmd = &cobra.Command{
+		Use:   "rebuild-totals",
+		Short: "按当前链权重重算跨链汇总积分",
+		Long:  "修改points.chain_weights后执行，由各链总积分重算全部地址的跨链汇总积分",
+		Args:  cobra.NoArgs,
+		Run:   runPointsRebuildTotals,
+	}
+
+	pointsExpiringCmd = &cobra.Command{
+		Use:   "expiring",
+		Short: "查询即将到期的积分",
+		Long: `按用户汇总将在指定天数内到期的积分（有效期为points.policy.expiry_days）
+指定--address时同时列出该地址的各积分批次
+
+示例:
+  ./erc20-service points expiring --within-days 30
+  ./erc20-service points expiring --chain sepolia --address 0xabc...`,
+		Args: cobra.NoArgs,
+		Run:  runPointsExpiring,
+	}
+
+	log = logger.New("points-cmd")
+)
+
+func init() {
+	cmd.RootCmd.AddCommand(pointsCmd)
+	pointsCmd.AddCommand(pointsGrantCmd)
+	pointsCmd.AddCommand(pointsRevokeCmd)
+	pointsCmd.AddCommand(pointsHistoryCmd)
+	pointsCmd.AddCommand(pointsTotalCmd)
+	pointsCmd.AddCommand(pointsRebuildTotalsCmd)
+	pointsCmd.AddCommand(pointsExpiringCmd)
+
+	for _, c := range []*cobra.Command{pointsGrantCmd, pointsRevokeCmd} {
+		c.Flags().String("reason", "", "调整原因（必填）")
+		c.Flags().String("actor", os.Getenv("USER"), "操作人，默认当前系统用户")
+		c.Flags().String("ref", "", "关联标识，如工单号或交易哈希")
+		c.Flags().String("season", "", "同时计入的赛季（按原值计入，不乘赛季倍数）")
+		c.MarkFlagRequired("reason")
+	}
+	pointsHistoryCmd.Flags().Int("limit", 50, "返回的流水条数")
+	pointsExpiringCmd.Flags().Int("within-days", 30, "查询未来多少天内到期")
+	pointsExpiringCmd.Flags().String("chain", "", "只查询指定链")
+	pointsExpiringCmd.Flags().String("address", "", "只查询指定地址")
+}
+
+// 校验地址并转换为与事件记录一致的校验和格式
+func parseAddress(s string) string {
+	if !common.IsHexAddress(s) {
+		logger.Fatal("无效的地址", "address", s)
+	}
+	return common.HexToAddress(s).Hex()
+}
+
+// 加载配置并初始化数据库
+func initDB(cmd *cobra.Command) *config.Config {
+	cfgPath, _ := cmd.Flags().GetString("config")
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		logger.Fatal("加载配置失败", "error", err)
+	}
+	if err := db.Init(cfg.Database); err != nil {
+		logger.Fatal("初始化数据库失败", "error", err)
+	}
+	return cfg
+}
+
+func runPointsAdjust(entryType string) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		reason, _ := cmd.Flags().GetString("reason")
+		actor, _ := cmd.Flags().GetString("actor")
+		ref, _ := cmd.Flags().GetString("ref")
+		season, _ := cmd.Flags().GetString("season")
+		if strings.TrimSpace(reason) == "" {
+			logger.Fatal("必须填写调整原因")
+		}
+		if actor == "" {
+			logger.Fatal("无法确定操作人，请通过--actor指定")
+		}
+
+		amount, err := decimal.Parse(args[2])
+		if err != nil || amount.Sign() <= 0 {
+			logger.Fatal("积分数量必须为正数", "amount", args[2])
+		}
+		if entryType == db.LedgerTypeRevoke {
+			amount = amount.Neg()
+		}
+
+		policy := db.NewPolicy(initDB(cmd))
+		entry := db.LedgerEntry{
+			ChainName:   args[0],
+			UserAddress: parseAddress(args[1]),
+			Amount:      amount,
+			EntryType:   entryType,
+			Reason:      reason,
+			Actor:       actor,
+			Reference:   ref,
+			Season:      season,
+		}
+		total, err := db.AdjustPoints(policy, entry)
+		if errors.Is(err, db.ErrInsufficientPoints) {
+			logger.Fatal("扣回失败：用户积分不足", "total", total.String(), "amount", amount.String())
+		}
+		if err != nil {
+			logger.Fatal("调整积分失败", "error", err)
+		}
+		log.Info("积分调整完成",
+			"chain", entry.ChainName,
+			"user", entry.UserAddress,
+			"type", entryType,
+			"amount", amount.String(),
+			"total", total.String(),
+			"season", season,
+			"actor", actor)
+	}
+}
+
+func runPointsHistory(cmd *cobra.Command, args []string) {
+	limit, _ := cmd.Flags().GetInt("limit")
+	initDB(cmd)
+
+	chainName, userAddr := args[0], parseAddress(args[1])
+	total, err := db.GetUserTotalPoints(chainName, userAddr)
+	if err != nil {
+		logger.Fatal("查询总积分失败", "error", err)
+	}
+	entries, err := db.GetLedgerEntries(chainName, userAddr, limit)
+	if err != nil {
+		logger.Fatal("查询积分流水失败", "error", err)
+	}
+	for _, e := range entries {
+		log.Info("积分流水",
+			"id", e.ID,
+			"time", e.CreatedAt,
+			"type", e.EntryType,
+			"amount", e.Amount.String(),
+			"reason", e.Reason,
+			"actor", e.Actor,
+			"ref", e.Reference,
+			"season", e.Season)
+	}
+	seasonPoints, err := db.GetUserSeasonPoints(chainName, userAddr)
+	if err != nil {
+		logger.Fatal("查询赛季积分失败", "error", err)
+	}
+	for id, p := range seasonPoints {
+		log.Info("赛季积分", "season", id, "points", p.String())
+	}
+	log.Info("积分汇总", "chain", chainName, "user", userAddr, "total", total.String(), "entries", len(entries))
+}
+
+func runPointsTotal(cmd *cobra.Command, args []string) {
+	policy := db.NewPolicy(initDB(cmd))
+
+	agg, err := db.GetAggregatedPoints(policy, parseAddress(args[0]))
+	if err != nil {
+		logger.Fatal("查询跨链积分失败", "error", err)
+	}
+	for _, c := range agg.Chains {
+		log.Info("链积分", "chain", c.ChainName, "points", c.TotalPoints.String(), "weight", c.Weight.String())
+	}
+	log.Info("跨链汇总积分", "user", agg.UserAddress, "total", agg.TotalPoints.String(), "chains", len(agg.Chains))
+}
+
+func runPointsRebuildTotals(cmd *cobra.Command, args []string) {
+	policy := db.NewPolicy(initDB(cmd))
+
+	n, err := db.RebuildAggregatedPoints(policy)
+	if err != nil {
+		logger.Fatal("重算跨链汇总积分失败", "error", err)
+	}
+	log.Info("跨链汇总积分重算完成", "users", n)
+}
+
+func runPointsExpiring(cmd *cobra.Command, args []string) {
+	withinDays, _ := cmd.Flags().GetInt("within-days")
+	chainName, _ := cmd.Flags().GetString("chain")
+	addrFlag, _ := cmd.Flags().GetString("address")
+	cfg := initDB(cmd)
+	db.EnableReplicaReads()
+
+	expiryDays := cfg.Points.Policy.ExpiryDays
+	if expiryDays <= 0 {
+		logger.Fatal("未启用积分到期（points.policy.expiry_days为0）")
+	}
+	userAddr := ""
+	if addrFlag != "" {
+		userAddr = parseAddress(addrFlag)
+	}
+
+	within := time.Duration(withinDays) * 24 * time.Hour
+	expiring, err := db.GetUpcomingExpirations(chainName, userAddr, expiryDays, within)
+	if err != nil {
+		logger.Fatal("查询即将到期积分失败", "error", err)
+	}
+	for _, e := range expiring {
+		log.Info("即将到期",
+			"chain", e.ChainName,
+			"user", e.UserAddress,
+			"amount", e.Amount.String(),
+			"next_expiry_at", e.NextExpiryAt,
+			"lots", e.Lots)
+	}
+
+	// 指定地址时列出批次明细
+	if userAddr != "" && chainName != "" {
+		lots, err := db.GetOpenLots(chainName, userAddr)
+		if err != nil {
+			logger.Fatal("查询积分批次失败", "error", err)
+		}
+		for _, l := range lots {
+			log.Info("积分批次",
+				"lot", l.ID,
+				"earned_at", l.EarnedAt,
+				"expires_at", l.EarnedAt.AddDate(0, 0, expiryDays),
+				"amount", l.Amount.String(),
+				"remaining", l.Remaining.String())
+		}
+	}
+	log.Info("即将到期积分汇总", "within_days", withinDays, "users", len(expiring))
+}
@@ -0,0 +1,176 @@
+package points
+
+import (
+	"encoding/csv"
+	"erc20-service/internal/db"
+	"erc20-service/pkg/logger"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pointsReferralsCmd = &cobra.Command{
+		Use:   "referrals",
+		Short: "管理推荐关系",
+		Long: `被推荐人入账持有积分时，按points.referral.levels为各级推荐人记入referral流水
+推荐关系通过此处导入，或由推荐注册合约（chains[].referral.contract_address）的签名注册事件写入；
+每个被推荐人在每条链上只能绑定一个推荐人，绑定后不可更改，自我推荐与形成环的关系被拒绝`,
+	}
+
+	pointsReferralsImportCmd = &cobra.Command{
+		Use:   "import [chain] [file]",
+		Short: "从CSV导入推荐关系",
+		Long: `从CSV导入推荐关系，每行为"被推荐人,推荐人"，首行为表头时自动跳过，#开头的行为注释
+已绑定、自我推荐或形成环的行跳过并输出原因，导入只对之后入账的积分生效
+
+示例:
+  ./erc20-service points referrals import sepolia referrals.csv`,
+		Args: cobra.ExactArgs(2),
+		Run:  runReferralsImport,
+	}
+
+	pointsReferralsAddCmd = &cobra.Command{
+		Use:   "add [chain] [referee] [referrer]",
+		Short: "绑定单个推荐关系",
+		Args:  cobra.ExactArgs(3),
+		Run:   runReferralsAdd,
+	}
+
+	pointsReferralsShowCmd = &cobra.Command{
+		Use:   "show [chain] [address]",
+		Short: "查询地址的推荐人、直接被推荐人与累计推荐奖励",
+		Args:  cobra.ExactArgs(2),
+		Run:   runReferralsShow,
+	}
+)
+
+func init() {
+	pointsCmd.AddCommand(pointsReferralsCmd)
+	pointsReferralsCmd.AddCommand(pointsReferralsImportCmd)
+	pointsReferralsCmd.AddCommand(pointsReferralsAddCmd)
+	pointsReferralsCmd.AddCommand(pointsReferralsShowCmd)
+
+	for _, c := range []*cobra.Command{pointsReferralsImportCmd, pointsReferralsAddCmd} {
+		c.Flags().String("actor", os.Getenv("USER"), "操作人，默认当前系统用户")
+	}
+}
+
+func runReferralsImport(cmd *cobra.Command, args []string) {
+	actor, _ := cmd.Flags().GetString("actor")
+	if actor == "" {
+		logger.Fatal("无法确定操作人，请通过--actor指定")
+	}
+	initDB(cmd)
+
+	f, err := os.Open(args[1])
+	if err != nil {
+		logger.Fatal("打开文件失败", "error", err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	chainName := args[0]
+	var imported, skipped int
+	for line := 1; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger.Fatal("解析CSV失败", "error", err)
+		}
+		if len(record) < 2 {
+			log.Warn("列数不足，跳过", "line", line)
+			skipped++
+			continue
+		}
+		referee, referrer := strings.TrimSpace(record[0]), strings.TrimSpace(record[1])
+		if !common.IsHexAddress(referee) || !common.IsHexAddress(referrer) {
+			if line > 1 {
+				log.Warn("地址无效，跳过", "line", line, "referee", referee, "referrer", referrer)
+				skipped++
+			}
+			continue // 首行为表头
+		}
+
+		err = db.CreateReferral(db.Referral{
+			ChainName: chainName,
+			Referee:   common.HexToAddress(referee).Hex(),
+			Referrer:  common.HexToAddress(referrer).Hex(),
+			Source:    db.ReferralSourceImport,
+			Actor:     actor,
+		})
+		if isReferralRejected(err) {
+			log.Warn("推荐关系被拒绝，跳过", "line", line, "referee", referee, "referrer", referrer, "reason", err)
+			skipped++
+			continue
+		}
+		if err != nil {
+			logger.Fatal("写入推荐关系失败", "line", line, "error", err)
+		}
+		imported++
+	}
+	log.Info("推荐关系导入完成", "chain", chainName, "imported", imported, "skipped", skipped, "actor", actor)
+}
+
+func runReferralsAdd(cmd *cobra.Command, args []string) {
+	actor, _ := cmd.Flags().GetString("actor")
+	if actor == "" {
+		logger.Fatal("无法确定操作人，请通过--actor指定")
+	}
+	initDB(cmd)
+
+	r := db.Referral{
+		ChainName: args[0],
+		Referee:   parseAddress(args[1]),
+		Referrer:  parseAddress(args[2]),
+		Source:    db.ReferralSourceImport,
+		Actor:     actor,
+	}
+	if err := db.CreateReferral(r); err != nil {
+		logger.Fatal("绑定推荐关系失败", "error", err)
+	}
+	log.Info("推荐关系已绑定", "chain", r.ChainName, "referee", r.Referee, "referrer", r.Referrer, "actor", actor)
+}
+
+func runReferralsShow(cmd *cobra.Command, args []string) {
+	initDB(cmd)
+	db.EnableReplicaReads()
+
+	chainName, addr := args[0], parseAddress(args[1])
+	if r, ok, err := db.GetReferrer(chainName, addr); err != nil {
+		logger.Fatal("查询推荐人失败", "error", err)
+	} else if ok {
+		log.Info("推荐人", "referrer", r.Referrer, "source", r.Source, "tx", r.TxHash, "created_at", r.CreatedAt)
+	} else {
+		log.Info("未绑定推荐人")
+	}
+
+	referees, err := db.ListReferees(chainName, addr)
+	if err != nil {
+		logger.Fatal("查询被推荐人失败", "error", err)
+	}
+	for _, r := range referees {
+		log.Info("被推荐人", "referee", r.Referee, "source", r.Source, "created_at", r.CreatedAt)
+	}
+
+	stats, err := db.GetReferralStats(chainName, addr)
+	if err != nil {
+		logger.Fatal("查询推荐奖励失败", "error", err)
+	}
+	log.Info("推荐汇总", "chain", chainName, "address", addr, "referees", stats.Referees, "rewards", stats.Rewards.String())
+}
+
+// 推荐关系因业务规则被拒绝（而非数据库错误）
+func isReferralRejected(err error) bool {
+	return errors.Is(err, db.ErrSelfReferral) || errors.Is(err, db.ErrReferralCycle) || errors.Is(err, db.ErrReferralExists)
+}
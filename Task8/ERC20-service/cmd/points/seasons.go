@@ -0,0 +1,234 @@
+package points
+
+import (
+	"encoding/json"
+	"erc20-service/internal/db"
+	"erc20-service/internal/rules"
+	"erc20-service/pkg/decimal"
+	"erc20-service/pkg/logger"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	pointsSeasonsCmd = &cobra.Command{
+		Use:   "seasons",
+		Short: "管理积分赛季",
+		Long: `赛季积分与终身积分分开累计：开始时刻落在赛季[start, end)内的积分周期，
+其持有积分与推荐奖励 × 赛季倍数计入赛季积分，终身积分（user_points）不受影响；
+赛季可绑定规则集版本，赛季内的周期固定按该规则集计算；
+同一条链上的赛季时间不能重叠，赛季结算后迟到的积分任务只计入终身积分`,
+	}
+
+	pointsSeasonsOpenCmd = &cobra.Command{
+		Use:   "open [id]",
+		Short: "创建赛季",
+		Long: `创建赛季，未指定--end时赛季持续进行，之后通过close设置结束时间
+开始时间可以早于当前时间，但已入账的周期不会补记赛季积分
+
+示例:
+  ./erc20-service points seasons open s2 --name "Season 2" --start 2026-07-01T00:00:00Z --multiplier 1.5
+  ./erc20-service points seasons open s2-base --start 2026-07-01T00:00:00Z --end 2026-10-01T00:00:00Z --chains base
+  ./erc20-service points seasons open s3 --start 2026-10-01T00:00:00Z --rule-set v3`,
+		Args: cobra.ExactArgs(1),
+		Run:  runSeasonsOpen,
+	}
+
+	pointsSeasonsCloseCmd = &cobra.Command{
+		Use:   "close [id]",
+		Short: "设置赛季结束时间",
+		Long: `设置赛季结束时间，默认为当前时间；结束时间之后开始的周期不再计入该赛季
+
+示例:
+  ./erc20-service points seasons close s1 --end 2026-07-01T00:00:00Z`,
+		Args: cobra.ExactArgs(1),
+		Run:  runSeasonsClose,
+	}
+
+	pointsSeasonsFinalizeCmd = &cobra.Command{
+		Use:   "finalize [id]",
+		Short: "结算赛季并生成最终快照",
+		Long: `按链排名写入赛季最终快照并将赛季标记为已结算，结算后赛季积分不再变化
+结算前应确认赛季内的积分周期已全部入账（可用 backfill check 检查）
+
+示例:
+  ./erc20-service points seasons finalize s1`,
+		Args: cobra.ExactArgs(1),
+		Run:  runSeasonsFinalize,
+	}
+
+	pointsSeasonsListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "列出全部赛季",
+		Args:  cobra.NoArgs,
+		Run:   runSeasonsList,
+	}
+
+	pointsSeasonsStandingsCmd = &cobra.Command{
+		Use:   "standings [id] [chain]",
+		Short: "查询赛季链上排名（JSON）",
+		Long:  "已结算的赛季读取最终快照，进行中的赛季按当前赛季积分实时排名",
+		Args:  cobra.ExactArgs(2),
+		Run:   runSeasonsStandings,
+	}
+)
+
+func init() {
+	pointsCmd.AddCommand(pointsSeasonsCmd)
+	pointsSeasonsCmd.AddCommand(pointsSeasonsOpenCmd)
+	pointsSeasonsCmd.AddCommand(pointsSeasonsCloseCmd)
+	pointsSeasonsCmd.AddCommand(pointsSeasonsFinalizeCmd)
+	pointsSeasonsCmd.AddCommand(pointsSeasonsListCmd)
+	pointsSeasonsCmd.AddCommand(pointsSeasonsStandingsCmd)
+
+	pointsSeasonsOpenCmd.Flags().String("name", "", "赛季名称")
+	pointsSeasonsOpenCmd.Flags().String("start", "", "开始时间（RFC3339，必填）")
+	pointsSeasonsOpenCmd.Flags().String("end", "", "结束时间（RFC3339），为空表示尚未结束")
+	pointsSeasonsOpenCmd.Flags().StringSlice("chains", nil, "参与的链，为空表示所有链")
+	pointsSeasonsOpenCmd.Flags().String("multiplier", "1", "持有积分与推荐奖励计入赛季时的倍数")
+	pointsSeasonsOpenCmd.Flags().String("rule-set", "", "赛季内周期固定使用的规则集版本（points.rule_sets中的version），为空表示按生效时间选择")
+	pointsSeasonsOpenCmd.MarkFlagRequired("start")
+	pointsSeasonsCloseCmd.Flags().String("end", "", "结束时间（RFC3339），默认当前时间")
+	pointsSeasonsStandingsCmd.Flags().Int("limit", 100, "返回的排名条数")
+}
+
+func runSeasonsOpen(cmd *cobra.Command, args []string) {
+	id := args[0]
+	if !db.ValidSeasonID(id) {
+		logger.Fatal("赛季ID只能包含字母、数字、连字符与下划线，且不超过32个字符", "id", id)
+	}
+	name, _ := cmd.Flags().GetString("name")
+	startStr, _ := cmd.Flags().GetString("start")
+	endStr, _ := cmd.Flags().GetString("end")
+	chains, _ := cmd.Flags().GetStringSlice("chains")
+	multStr, _ := cmd.Flags().GetString("multiplier")
+	ruleSet, _ := cmd.Flags().GetString("rule-set")
+
+	s := db.Season{ID: id, Name: name, Start: parseSeasonTime("start", startStr)}
+	if endStr != "" {
+		s.End = parseSeasonTime("end", endStr)
+	}
+	mult, err := decimal.Parse(multStr)
+	if err != nil || mult.Sign() <= 0 {
+		logger.Fatal("赛季倍数必须为正数", "multiplier", multStr)
+	}
+	s.Multiplier = mult
+
+	cfg := initDB(cmd)
+	for _, c := range chains {
+		c = strings.TrimSpace(c)
+		if _, ok := findChain(cfg, c); !ok {
+			logger.Fatal("未配置的链", "chain", c)
+		}
+		s.Chains = append(s.Chains, c)
+	}
+	if ruleSet != "" {
+		engine, err := rules.NewEngine(cfg.Points)
+		if err != nil {
+			logger.Fatal("加载积分规则失败", "error", err)
+		}
+		if _, err := engine.Pinned(ruleSet); err != nil {
+			logger.Fatal("规则集无效", "rule_set", ruleSet, "error", err)
+		}
+		s.RuleSet = ruleSet
+	}
+
+	if err := db.OpenSeason(s); err != nil {
+		logger.Fatal("创建赛季失败", "error", err)
+	}
+	log.Info("赛季已创建",
+		"id", s.ID,
+		"name", s.Name,
+		"start", s.Start,
+		"end", s.End,
+		"chains", s.Chains,
+		"multiplier", s.Multiplier.String(),
+		"rule_set", s.RuleSet)
+}
+
+func runSeasonsClose(cmd *cobra.Command, args []string) {
+	endStr, _ := cmd.Flags().GetString("end")
+	end := time.Now().UTC().Truncate(time.Second)
+	if endStr != "" {
+		end = parseSeasonTime("end", endStr)
+	}
+	initDB(cmd)
+
+	if err := db.CloseSeason(args[0], end); err != nil {
+		logger.Fatal("设置赛季结束时间失败", "error", err)
+	}
+	log.Info("赛季已设置结束时间", "id", args[0], "end", end)
+}
+
+func runSeasonsFinalize(cmd *cobra.Command, args []string) {
+	initDB(cmd)
+
+	s, err := db.GetSeason(args[0])
+	if err != nil {
+		logger.Fatal("查询赛季失败", "error", err)
+	}
+	if !s.End.IsZero() && time.Now().Before(s.End) {
+		logger.Fatal("赛季尚未结束，不能结算", "id", s.ID, "end", s.End)
+	}
+	n, err := db.FinalizeSeason(s.ID)
+	if err != nil {
+		logger.Fatal("结算赛季失败", "error", err)
+	}
+	log.Info("赛季已结算", "id", s.ID, "snapshot_rows", n)
+}
+
+func runSeasonsList(cmd *cobra.Command, args []string) {
+	initDB(cmd)
+
+	seasons, err := db.ListSeasons()
+	if err != nil {
+		logger.Fatal("查询赛季失败", "error", err)
+	}
+	for _, s := range seasons {
+		log.Info("赛季",
+			"id", s.ID,
+			"name", s.Name,
+			"status", s.Status,
+			"start", s.Start,
+			"end", s.End,
+			"chains", s.Chains,
+			"multiplier", s.Multiplier.String(),
+			"rule_set", s.RuleSet)
+	}
+	log.Info("赛季汇总", "seasons", len(seasons))
+}
+
+func runSeasonsStandings(cmd *cobra.Command, args []string) {
+	limit, _ := cmd.Flags().GetInt("limit")
+	initDB(cmd)
+	db.EnableReplicaReads()
+
+	s, err := db.GetSeason(args[0])
+	if err != nil {
+		logger.Fatal("查询赛季失败", "error", err)
+	}
+	standings, err := db.GetSeasonStandings(s, args[1], limit)
+	if err != nil {
+		logger.Fatal("查询赛季排名失败", "error", err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(struct {
+		Season    db.Season           `json:"season"`
+		Chain     string              `json:"chain"`
+		Standings []db.SeasonStanding `json:"standings"`
+	}{s, args[1], standings}); err != nil {
+		logger.Fatal("输出赛季排名失败", "error", err)
+	}
+}
+
+func parseSeasonTime(flag, s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		logger.Fatal("时间格式无效，应为RFC3339", "flag", flag, "value", s)
+	}
+	return t.UTC()
+}
@@ -0,0 +1,288 @@
+package points
+
+import (
+	"encoding/csv"
+	"erc20-service/config"
+	"erc20-service/internal/db"
+	"erc20-service/internal/epoch"
+	"erc20-service/internal/pricing"
+	"erc20-service/internal/rules"
+	"erc20-service/internal/service"
+	"erc20-service/pkg/decimal"
+	"erc20-service/pkg/logger"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var pointsSimulateCmd = &cobra.Command{
+	Use:   "simulate [chain] [start_time] [end_time]",
+	Short: "按候选规则模拟积分",
+	Long: `按候选积分规则重放时间范围内的余额变动，与实际入账积分对比，不写入任何数据
+时间范围按--interval分钟的积分周期（UTC对齐）切分，首尾周期截断到时间范围，与调度器的周期划分一致
+
+候选规则文件与配置中的points段结构相同（rate、rule_sets、pricing），未指定时使用当前配置
+
+示例:
+  ./erc20-service points simulate sepolia 2024-01-01T00:00:00Z 2024-01-08T00:00:00Z --rules candidate.yaml --output diff.csv
+  ./erc20-service points simulate sepolia 2024-01-01T00:00:00Z 2024-01-02T00:00:00Z --rate 0.08`,
+	Args: cobra.ExactArgs(3),
+	Run:  runPointsSimulate,
+}
+
+func init() {
+	pointsCmd.AddCommand(pointsSimulateCmd)
+	pointsSimulateCmd.Flags().String("rules", "", "候选规则文件（YAML，结构同points配置段）")
+	pointsSimulateCmd.Flags().Float64("rate", 0, "覆盖候选规则的基础比率")
+	pointsSimulateCmd.Flags().Int("interval", 0, "计算周期（分钟），默认points.interval")
+	pointsSimulateCmd.Flags().String("output", "", "CSV输出文件，为空时只打印日志")
+}
+
+// 单用户模拟结果
+type simulationResult struct {
+	user      string
+	actual    decimal.Decimal
+	simulated decimal.Decimal
+}
+
+func runPointsSimulate(cmd *cobra.Command, args []string) {
+	rulesPath, _ := cmd.Flags().GetString("rules")
+	rate, _ := cmd.Flags().GetFloat64("rate")
+	interval, _ := cmd.Flags().GetInt("interval")
+	output, _ := cmd.Flags().GetString("output")
+
+	chainName := args[0]
+	start, err := time.Parse(time.RFC3339, args[1])
+	if err != nil {
+		logger.Fatal("开始时间格式错误", "error", err)
+	}
+	end, err := time.Parse(time.RFC3339, args[2])
+	if err != nil {
+		logger.Fatal("结束时间格式错误", "error", err)
+	}
+	if !end.After(start) {
+		logger.Fatal("结束时间必须晚于开始时间")
+	}
+
+	cfg := initDB(cmd)
+	policy := db.NewPolicy(cfg)
+	db.EnableReplicaReads()
+
+	candidate, err := loadCandidateRules(cfg.Points, rulesPath, rate)
+	if err != nil {
+		logger.Fatal("加载候选规则失败", "error", err)
+	}
+	engine, err := rules.NewEngine(candidate)
+	if err != nil {
+		logger.Fatal("候选规则无效", "error", err)
+	}
+	if interval <= 0 {
+		interval = cfg.Points.Interval
+	}
+
+	prices, err := pricing.New(candidate.Pricing)
+	if err != nil {
+		logger.Fatal("初始化价格源失败", "error", err)
+	}
+
+	results, err := simulate(policy, engine, prices, chainName, start, end, time.Duration(interval)*time.Minute)
+	if err != nil {
+		logger.Fatal("模拟失败", "error", err)
+	}
+
+	totalActual, totalSimulated := decimal.Zero(), decimal.Zero()
+	for _, r := range results {
+		totalActual = totalActual.Add(r.actual)
+		totalSimulated = totalSimulated.Add(r.simulated)
+		if output == "" {
+			log.Info("用户积分对比",
+				"user", r.user,
+				"actual", r.actual.String(),
+				"simulated", r.simulated.String(),
+				"delta", r.simulated.Sub(r.actual).String(),
+				"delta_pct", deltaPercent(r.actual, r.simulated))
+		}
+	}
+	if output != "" {
+		if err := writeSimulationCSV(output, results); err != nil {
+			logger.Fatal("写入CSV失败", "file", output, "error", err)
+		}
+	}
+	log.Info("模拟完成（未写入任何数据）",
+		"chain", chainName,
+		"users", len(results),
+		"actual", totalActual.String(),
+		"simulated", totalSimulated.String(),
+		"delta", totalSimulated.Sub(totalActual).String(),
+		"delta_pct", deltaPercent(totalActual, totalSimulated),
+		"output", output)
+}
+
+// 候选规则：默认沿用当前配置，可由规则文件整体替换，再按--rate覆盖基础比率
+func loadCandidateRules(current config.PointsConfig, path string, rate float64) (config.PointsConfig, error) {
+	candidate := current
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return candidate, err
+		}
+		candidate = config.PointsConfig{}
+		if err := yaml.Unmarshal(data, &candidate); err != nil {
+			return candidate, fmt.Errorf("解析规则文件失败: %v", err)
+		}
+		if candidate.Rate == 0 {
+			candidate.Rate = current.Rate
+		}
+	}
+	if rate > 0 {
+		candidate.Rate = rate
+	}
+	return candidate, nil
+}
+
+// 逐用户重放余额与质押变动：每个用户只查询一次期初持仓与变动，按周期在内存中切分计算
+// 候选规则配置了价格源时，整个时间范围的报价只查询一次
+func simulate(policy *db.Policy, engine *rules.Engine, prices pricing.PriceSource, chainName string, start, end time.Time, interval time.Duration) ([]simulationResult, error) {
+	users, err := db.GetEligibleUsersByChain(policy, chainName)
+	if err != nil {
+		return nil, fmt.Errorf("获取用户列表失败: %v", err)
+	}
+	quotes, err := service.LoadPrices(prices, chainName, start, end)
+	if err != nil {
+		return nil, err
+	}
+	// 女巫处理沿用当前配置，与实际入账一致：只处理地址被检测到之后开始的周期
+	penalties, err := db.GetSybilPenalties(policy, chainName)
+	if err != nil {
+		return nil, fmt.Errorf("获取女巫聚类失败: %v", err)
+	}
+	awarded, err := db.GetAwardedPointsByUser(chainName, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("获取实际入账积分失败: %v", err)
+	}
+
+	results := make([]simulationResult, 0, len(users))
+	for _, user := range users {
+		opening, err := db.GetBalanceAt(chainName, user, start)
+		if err != nil {
+			return nil, fmt.Errorf("获取期初余额失败: %v", err)
+		}
+		changes, err := db.GetBalanceChangesInPeriod(chainName, user, start, end)
+		if err != nil {
+			return nil, fmt.Errorf("获取余额变动失败: %v", err)
+		}
+		openingStake, err := db.GetStakeAt(chainName, user, start)
+		if err != nil {
+			return nil, fmt.Errorf("获取期初质押持仓失败: %v", err)
+		}
+		stakeChanges, err := db.GetStakeChangesInPeriod(chainName, user, start, end)
+		if err != nil {
+			return nil, fmt.Errorf("获取质押变动失败: %v", err)
+		}
+
+		simulated := decimal.Zero()
+		balance := opening
+		stake := openingStake
+		next, nextStake := 0, 0 // 下一个未计入期初持仓的变动
+		for _, e := range epoch.NewClock(interval).Covering(start, end) {
+			ps, pe := e.Start, e.End
+			if ps.Before(start) {
+				ps = start
+			}
+			if pe.After(end) {
+				pe = end
+			}
+			// 期初余额：周期开始前最后一次变动后的余额
+			for next < len(changes) && changes[next].EventTime.Before(ps) {
+				balance = changes[next].BalanceAfter
+				next++
+			}
+			for nextStake < len(stakeChanges) && stakeChanges[nextStake].EventTime.Before(ps) {
+				stake = db.StakePosition{Staked: stakeChanges[nextStake].StakedAfter, Pending: stakeChanges[nextStake].PendingAfter}
+				nextStake++
+			}
+			// 周期内变动（含边界，与消费者查询一致）
+			last := next
+			for last < len(changes) && !changes[last].EventTime.After(pe) {
+				last++
+			}
+			lastStake := nextStake
+			for lastStake < len(stakeChanges) && !stakeChanges[lastStake].EventTime.After(pe) {
+				lastStake++
+			}
+			points, _ := service.CalculatePeriodPoints(engine, chainName, user, service.PeriodHoldings{
+				OpeningBalance: balance,
+				Changes:        changes[next:last],
+				OpeningStake:   stake,
+				StakeChanges:   stakeChanges[nextStake:lastStake],
+				Prices:         quotes,
+				Sybil:          penalties[db.AddrKey(user)].ActiveAt(ps),
+			}, ps, pe)
+			simulated = simulated.Add(points)
+		}
+
+		actual := awarded[user]
+		if actual.IsZero() && simulated.IsZero() {
+			continue
+		}
+		results = append(results, simulationResult{user: user, actual: actual, simulated: simulated})
+	}
+
+	// 按差额绝对值降序，影响最大的用户在前
+	sort.Slice(results, func(i, j int) bool {
+		di := results[i].simulated.Sub(results[i].actual)
+		dj := results[j].simulated.Sub(results[j].actual)
+		if di.Sign() < 0 {
+			di = di.Neg()
+		}
+		if dj.Sign() < 0 {
+			dj = dj.Neg()
+		}
+		return di.Cmp(dj) > 0
+	})
+	return results, nil
+}
+
+// 差额百分比，实际为0时为空
+func deltaPercent(actual, simulated decimal.Decimal) string {
+	if actual.IsZero() {
+		return ""
+	}
+	pct := new(big.Rat).Quo(simulated.Sub(actual).Rat(), actual.Rat())
+	pct.Mul(pct, big.NewRat(100, 1))
+	return pct.FloatString(2)
+}
+
+func writeSimulationCSV(path string, results []simulationResult) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if err := w.Write([]string{"user_address", "actual_points", "simulated_points", "delta", "delta_pct"}); err != nil {
+		return err
+	}
+	for _, r := range results {
+		if err := w.Write([]string{
+			r.user,
+			r.actual.String(),
+			r.simulated.String(),
+			r.simulated.Sub(r.actual).String(),
+			deltaPercent(r.actual, r.simulated),
+		}); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
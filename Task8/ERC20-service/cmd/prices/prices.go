@@ -0,0 +1,97 @@
+package prices
+
+import (
+	"erc20-service/cmd"
+	"erc20-service/config"
+	"erc20-service/internal/pricing"
+	"erc20-service/pkg/logger"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	pricesCmd = &cobra.Command{
+		Use:   "prices",
+		Short: "代币价格源工具",
+		Long:  "查询配置的价格源报价，或以HTTP接口提供价格文件中的报价（供points.pricing.source为http时使用的本地价格服务）",
+	}
+
+	pricesServeCmd = &cobra.Command{
+		Use:   "serve [file]",
+		Short: "以HTTP接口提供价格文件中的报价",
+		Long: `载入价格文件（.csv或.json）并在 GET <path>?chain=&from=&to= 上提供报价，
+协议与 points.pricing.source: http 一致，可作为外部价格服务的本地替身
+
+示例:
+  ./erc20-service prices serve prices.csv --listen 127.0.0.1:8088`,
+		Args: cobra.ExactArgs(1),
+		Run:  runPricesServe,
+	}
+
+	pricesQuoteCmd = &cobra.Command{
+		Use:   "quote [chain] [time]",
+		Short: "查询配置的价格源在某一时刻的报价",
+		Long: `按points.pricing配置查询链在指定时刻生效的报价，并校验报价是否超过max_age_minutes
+
+示例:
+  ./erc20-service prices quote sepolia 2026-07-01T12:00:00Z`,
+		Args: cobra.ExactArgs(2),
+		Run:  runPricesQuote,
+	}
+
+	log = logger.New("prices")
+)
+
+func init() {
+	cmd.RootCmd.AddCommand(pricesCmd)
+	pricesCmd.AddCommand(pricesServeCmd)
+	pricesCmd.AddCommand(pricesQuoteCmd)
+
+	pricesServeCmd.Flags().String("listen", "127.0.0.1:8088", "监听地址")
+	pricesServeCmd.Flags().String("path", "/prices", "报价接口路径")
+}
+
+func runPricesServe(cmd *cobra.Command, args []string) {
+	listen, _ := cmd.Flags().GetString("listen")
+	path, _ := cmd.Flags().GetString("path")
+
+	src, err := pricing.LoadFile(args[0])
+	if err != nil {
+		logger.Fatal("载入价格文件失败", "error", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, pricing.Handler(src))
+
+	log.Info("价格服务启动", "listen", listen, "path", path, "file", args[0], "chains", src.Chains())
+	if err := http.ListenAndServe(listen, mux); err != nil {
+		logger.Fatal("价格服务退出", "error", err)
+	}
+}
+
+func runPricesQuote(cmd *cobra.Command, args []string) {
+	cfgPath, _ := cmd.Flags().GetString("config")
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		logger.Fatal("加载配置失败", "error", err)
+	}
+	at, err := time.Parse(time.RFC3339, args[1])
+	if err != nil {
+		logger.Fatal("时间格式错误", "error", err)
+	}
+
+	src, err := pricing.New(cfg.Points.Pricing)
+	if err != nil {
+		logger.Fatal("初始化价格源失败", "error", err)
+	}
+	if src == nil {
+		logger.Fatal("未配置价格源（points.pricing.source为空）")
+	}
+	quotes, err := src.Quotes(args[0], at, at.Add(time.Second))
+	if err != nil {
+		logger.Fatal("查询报价失败", "error", err)
+	}
+	q := quotes[0]
+	log.Info("报价", "chain", q.Chain, "time", q.At, "price", q.PriceString(), "source", q.Source, "age", at.Sub(q.At))
+}
@@ -0,0 +1,186 @@
+package sybil
+
+import (
+	"encoding/json"
+	"erc20-service/cmd"
+	"erc20-service/config"
+	"erc20-service/internal/db"
+	"erc20-service/internal/service"
+	"erc20-service/pkg/logger"
+	"os"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/spf13/cobra"
+)
+
+var (
+	sybilCmd = &cobra.Command{
+		Use:   "sybil",
+		Short: "女巫地址聚类检测",
+		Long: `在转账图上按共同资金来源与同步操作把地址聚类并打分（0-1），结果写入sybil_clusters/sybil_addresses
+分数达到points.sybil.threshold的聚类成员按points.sybil.action降低积分权重或不计积分`,
+	}
+
+	sybilAnalyzeCmd = &cobra.Command{
+		Use:   "analyze [chain]",
+		Short: "分析转账图并替换链上的聚类结果",
+		Long: `分析链上最近window_days天的转账，以本次结果整体替换该链此前的聚类；
+--dry-run 只输出结果不写入，可用于调整检测参数
+
+示例:
+  ./erc20-service sybil analyze sepolia --dry-run`,
+		Args: cobra.ExactArgs(1),
+		Run:  runSybilAnalyze,
+	}
+
+	sybilClustersCmd = &cobra.Command{
+		Use:   "clusters [chain]",
+		Short: "列出链上的聚类",
+		Args:  cobra.ExactArgs(1),
+		Run:   runSybilClusters,
+	}
+
+	sybilShowCmd = &cobra.Command{
+		Use:   "show [chain] [address|cluster_id]",
+		Short: "查看地址所在的聚类或聚类的全部成员",
+		Long: `参数为地址时输出其所在聚类及按当前配置的积分处理，为聚类ID时输出聚类成员
+
+示例:
+  ./erc20-service sybil show sepolia 0xabc...
+  ./erc20-service sybil show sepolia 1a2b3c4d5e6f7a8b`,
+		Args: cobra.ExactArgs(2),
+		Run:  runSybilShow,
+	}
+
+	log = logger.New("sybil-cmd")
+)
+
+func init() {
+	cmd.RootCmd.AddCommand(sybilCmd)
+	sybilCmd.AddCommand(sybilAnalyzeCmd)
+	sybilCmd.AddCommand(sybilClustersCmd)
+	sybilCmd.AddCommand(sybilShowCmd)
+
+	sybilAnalyzeCmd.Flags().Bool("dry-run", false, "只输出结果，不写入数据库")
+	sybilClustersCmd.Flags().Float64("min-score", 0, "最低聚类分数")
+	sybilClustersCmd.Flags().Int("limit", 100, "返回的聚类数")
+}
+
+// 加载配置并初始化数据库
+func initDB(cmd *cobra.Command) *config.Config {
+	cfgPath, _ := cmd.Flags().GetString("config")
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		logger.Fatal("加载配置失败", "error", err)
+	}
+	if err := db.Init(cfg.Database); err != nil {
+		logger.Fatal("初始化数据库失败", "error", err)
+	}
+	return cfg
+}
+
+func runSybilAnalyze(cmd *cobra.Command, args []string) {
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	cfg := initDB(cmd)
+	policy := db.NewPolicy(cfg)
+	// 转账图读取可走只读副本，结果写入仍走主库
+	db.EnableReplicaReads()
+
+	now := time.Now()
+	clusters, transfers, err := service.DetectSybil(policy, args[0], cfg.Points.Sybil, now)
+	if err != nil {
+		logger.Fatal("女巫聚类分析失败", "error", err)
+	}
+	for _, c := range clusters {
+		log.Info("聚类",
+			"cluster", c.ID,
+			"score", c.Score,
+			"size", len(c.Members),
+			"funders", c.Funders,
+			"funded", c.Funded,
+			"lockstep", c.Lockstep,
+			"internal", c.Internal)
+	}
+	if !dryRun {
+		if err := db.SaveSybilClusters(policy, args[0], clusters, now); err != nil {
+			logger.Fatal("保存聚类结果失败", "error", err)
+		}
+	}
+	log.Info("女巫聚类分析完成",
+		"chain", args[0],
+		"transfers", transfers,
+		"clusters", len(clusters),
+		"flagged", service.CountFlagged(clusters, cfg.Points.Sybil.Threshold),
+		"threshold", cfg.Points.Sybil.Threshold,
+		"action", cfg.Points.Sybil.Action,
+		"dry_run", dryRun)
+}
+
+func runSybilClusters(cmd *cobra.Command, args []string) {
+	minScore, _ := cmd.Flags().GetFloat64("min-score")
+	limit, _ := cmd.Flags().GetInt("limit")
+	initDB(cmd)
+	db.EnableReplicaReads()
+
+	clusters, err := db.ListSybilClusters(args[0], minScore, limit)
+	if err != nil {
+		logger.Fatal("查询聚类失败", "error", err)
+	}
+	writeJSON(clusters)
+}
+
+func runSybilShow(cmd *cobra.Command, args []string) {
+	policy := db.NewPolicy(initDB(cmd))
+	db.EnableReplicaReads()
+	chainName, clusterID := args[0], args[1]
+
+	var result struct {
+		Address string            `json:"address,omitempty"`
+		Penalty string            `json:"penalty,omitempty"` // 按当前配置的积分处理
+		Cluster *db.SybilCluster  `json:"cluster"`
+		Members []db.SybilAddress `json:"members,omitempty"`
+		Member  *db.SybilAddress  `json:"member,omitempty"`
+	}
+	if common.IsHexAddress(args[1]) {
+		result.Address = common.HexToAddress(args[1]).Hex()
+		m, ok, err := db.GetSybilAddress(chainName, result.Address)
+		if err != nil {
+			logger.Fatal("查询地址聚类失败", "error", err)
+		}
+		if !ok {
+			log.Info("地址不在任何聚类中", "chain", chainName, "address", result.Address)
+			return
+		}
+		result.Member = &m
+		clusterID = m.ClusterID
+		penalty, err := db.GetSybilPenalty(policy, chainName, result.Address, time.Now())
+		if err != nil {
+			logger.Fatal("查询积分处理失败", "error", err)
+		}
+		if penalty != nil {
+			result.Penalty = penalty.String()
+		}
+	}
+
+	c, members, ok, err := db.GetSybilCluster(chainName, clusterID)
+	if err != nil {
+		logger.Fatal("查询聚类失败", "error", err)
+	}
+	if !ok {
+		logger.Fatal("聚类不存在", "chain", chainName, "cluster", clusterID)
+	}
+	result.Cluster = &c
+	if result.Member == nil {
+		result.Members = members
+	}
+	writeJSON(result)
+}
+
+func writeJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		logger.Fatal("输出结果失败", "error", err)
+	}
+}
@@ -1,8 +1,10 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 	"gopkg.in/yaml.v3"
@@ -10,10 +12,14 @@ import (
 
 // Config 应用全局配置
 type Config struct {
-	Database DatabaseConfig `yaml:"database"`
-	RabbitMQ RabbitMQConfig `yaml:"rabbitmq"`
-	Chains   []ChainConfig  `yaml:"chains"`
-	Points   PointsConfig   `yaml:"points"`
+	Database  DatabaseConfig  `yaml:"database"`
+	RabbitMQ  RabbitMQConfig  `yaml:"rabbitmq"`
+	Chains    []ChainConfig   `yaml:"chains"`
+	Points    PointsConfig    `yaml:"points"`
+	Outbox    OutboxConfig    `yaml:"outbox"`
+	Retention RetentionConfig `yaml:"retention"`
+	Metrics   MetricsConfig   `yaml:"metrics"`
+	Airdrop   AirdropConfig   `yaml:"airdrop"`
 }
 
 // DatabaseConfig 数据库配置
@@ -24,14 +30,52 @@ type DatabaseConfig struct {
 	Password string `yaml:"password"`
 	DBName   string `yaml:"dbname"`
 	SSLMode  string `yaml:"sslmode"`
+
+	QueryLog QueryLogConfig `yaml:"query_log"`
+
+	Replicas             []ReplicaConfig `yaml:"replicas"`                // 只读副本，可为空
+	MaxReplicaLagSeconds int             `yaml:"max_replica_lag_seconds"` // 副本可接受的最大复制延迟（秒），超过时回退主库，默认30
+}
+
+// ReplicaConfig 只读副本配置，未填写的连接参数沿用主库
+type ReplicaConfig struct {
+	Name     string `yaml:"name"`
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	DBName   string `yaml:"dbname"`
+}
+
+// QueryLogConfig SQL观测配置
+type QueryLogConfig struct {
+	SlowThresholdMs int     `yaml:"slow_threshold_ms"` // 慢查询阈值（毫秒），超过时WARN输出，默认200
+	SampleRate      float64 `yaml:"sample_rate"`       // 普通语句INFO采样比例（0-1），默认0
+	Redact          string  `yaml:"redact"`            // 参数脱敏策略: none | mask | hide，默认mask
+}
+
+// MetricsConfig 指标暴露配置
+type MetricsConfig struct {
+	Listen string `yaml:"listen"` // Prometheus指标监听地址，如 :9100，为空时不启动
+}
+
+// AirdropConfig 积分兑换空投配置
+type AirdropConfig struct {
+	TokensPerPoint string `yaml:"tokens_per_point"` // 每积分兑换的代币数量（十进制字符串，避免浮点误差）
+	TokenDecimals  int    `yaml:"token_decimals"`   // 代币精度，默认18
 }
 
 // RabbitMQConfig MQ配置
 type RabbitMQConfig struct {
-	URL        string `yaml:"url"`
-	Exchange   string `yaml:"exchange"`
-	Queue      string `yaml:"queue"`
-	RoutingKey string `yaml:"routing_key"`
+	URL             string `yaml:"url"`
+	Exchange        string `yaml:"exchange"`
+	Queue           string `yaml:"queue"`
+	RoutingKey      string `yaml:"routing_key"`
+	EventRoutingKey string `yaml:"event_routing_key"` // 领域事件路由键
+	EventQueue      string `yaml:"event_queue"`       // 领域事件队列，默认points_events_queue
+	// 延迟重试队列：报价缺失或过期的任务在此等待retry_delay_seconds后回到任务队列，默认<queue>_retry
+	RetryQueue        string `yaml:"retry_queue"`
+	RetryDelaySeconds int    `yaml:"retry_delay_seconds"` // 延迟重试等待时间（秒），默认60
 }
 
 // ChainConfig 区块链网络配置
@@ -42,12 +86,158 @@ type ChainConfig struct {
 	ContractAddress string `yaml:"contract_address"`
 	StartBlock      int64  `yaml:"start_block"`
 	BlockDelay      int    `yaml:"block_delay"` // 区块确认延迟，固定为6
+	// 质押合约（可选），质押在合约中的代币仍归属质押者计算积分
+	Staking StakingConfig `yaml:"staking"`
+	// 不参与积分与持有者统计的地址（代币合约、LP池、销毁地址、跨链桥、团队金库等）
+	ExcludedAddresses []string `yaml:"excluded_addresses"`
+	// 链的积分周期划分方式，默认按时间
+	Points ChainPointsConfig `yaml:"points"`
+	// 推荐注册合约（可选），监听被推荐人签名的推荐关系注册事件
+	Referral ReferralContractConfig `yaml:"referral"`
+}
+
+// ReferralContractConfig 推荐注册合约配置
+type ReferralContractConfig struct {
+	ContractAddress string `yaml:"contract_address"` // 推荐注册合约地址，为空表示只通过命令行导入推荐关系
+}
+
+// GlobalBoard 跨链总榜名称，与各链榜单共用命名空间，不能用作链名
+const GlobalBoard = "global"
+
+// 积分周期划分方式
+const (
+	PointsModeTime  = "time"  // 按UTC对齐的时间周期（points.interval）
+	PointsModeBlock = "block" // 按区块区间，余额按持有区块数加权
+)
+
+// ChainPointsConfig 单条链的积分周期配置
+// 区块模式下周期为 [start_block + k×blocks_per_period, start_block + (k+1)×blocks_per_period)，
+// 最后一个周期截断到end_block
+type ChainPointsConfig struct {
+	Mode            string `yaml:"mode"`              // time（默认）| block
+	BlocksPerPeriod uint64 `yaml:"blocks_per_period"` // 每个周期的区块数
+	StartBlock      uint64 `yaml:"start_block"`       // 积分起始区块（含），默认链的start_block
+	EndBlock        uint64 `yaml:"end_block"`         // 积分结束区块（不含），0表示不限
+}
+
+// BlockMode 是否按区块区间计算积分
+func (c ChainConfig) BlockMode() bool {
+	return c.Points.Mode == PointsModeBlock
+}
+
+// StakingConfig 质押合约配置（StakeSystem）
+type StakingConfig struct {
+	ContractAddress string   `yaml:"contract_address"` // 质押合约地址，为空表示不追踪质押
+	PoolIDs         []uint64 `yaml:"pool_ids"`         // 质押本代币的资金池ID，为空表示全部资金池
 }
 
 // PointsConfig 积分计算配置
 type PointsConfig struct {
-	Rate     float64 `yaml:"rate"`     // 积分比率，默认0.05
-	Interval int     `yaml:"interval"` // 计算间隔（分钟），默认60
+	Rate     float64         `yaml:"rate"`      // 积分比率，默认0.05
+	Interval int             `yaml:"interval"`  // 计算间隔（分钟），默认60
+	RuleSets []RuleSetConfig `yaml:"rule_sets"` // 版本化积分规则，为空时仅按rate计算
+	// 跨链汇总积分时各链的权重，未配置的链权重为1
+	ChainWeights map[string]float64 `yaml:"chain_weights"`
+	Policy       PointsPolicyConfig `yaml:"policy"` // 积分到期与衰减策略
+	// 质押锁定期间的积分倍数，默认1，规则集可单独覆盖
+	StakingMultiplier float64           `yaml:"staking_multiplier"`
+	Batch             PointsBatchConfig `yaml:"batch"` // 分片批量计算
+	// 是否随计算历史保存每个定价子段的积分明细（points explain 展示入账时的明细）
+	PersistSegments bool           `yaml:"persist_segments"`
+	Referral        ReferralConfig `yaml:"referral"` // 推荐奖励
+	Pricing         PricingConfig  `yaml:"pricing"`  // 按持仓USD价值计算积分
+	Sybil           SybilConfig    `yaml:"sybil"`    // 女巫地址聚类检测
+}
+
+// SybilConfig 女巫地址聚类检测：在转账图上按共同资金来源与同步操作聚类打分，
+// 分数达到threshold的聚类成员按action降低积分权重或不计积分
+type SybilConfig struct {
+	Action            string  `yaml:"action"`              // 处理方式: 空（只检测）| downweight | exclude
+	Threshold         float64 `yaml:"threshold"`           // 处理的最低聚类分数（0-1），默认0.7
+	Weight            float64 `yaml:"weight"`              // downweight时的积分倍数，默认0.2
+	WindowDays        int     `yaml:"window_days"`         // 分析最近多少天的转账，默认30
+	MinFanout         int     `yaml:"min_fanout"`          // 共同资金来源至少资助的地址数，默认3
+	LockstepMinutes   int     `yaml:"lockstep_minutes"`    // 同步操作的时间窗口（分钟），默认10
+	LockstepMinShared int     `yaml:"lockstep_min_shared"` // 同步操作至少共同出现的窗口数，默认3
+	MaxBucketSize     int     `yaml:"max_bucket_size"`     // 单个时间窗口参与地址超过该数时不计入同步操作，默认50
+	MinClusterSize    int     `yaml:"min_cluster_size"`    // 最小聚类规模，默认3
+	IntervalHours     int     `yaml:"interval_hours"`      // daemon自动分析间隔（小时），0表示只通过CLI分析
+}
+
+// PricingConfig 价格源配置：启用后积分 = 计息余额(代币) × 子段开始时刻的USD价格 × 比率 × 时长占比，
+// 价格按链区分，使用的报价随计算历史保存
+type PricingConfig struct {
+	Source         string `yaml:"source"`          // 价格源: 空（按代币数量计算）| file | http
+	File           string `yaml:"file"`            // 价格文件（.csv或.json）
+	URL            string `yaml:"url"`             // HTTP价格服务地址
+	TimeoutSeconds int    `yaml:"timeout_seconds"` // HTTP请求超时（秒），默认5
+	MaxAgeMinutes  int    `yaml:"max_age_minutes"` // 报价可使用的最长时间（分钟），超过时任务失败并重试，默认60
+}
+
+// ReferralConfig 推荐奖励：被推荐人每次入账持有积分时，按层级比例为上级推荐人记入推荐奖励流水
+type ReferralConfig struct {
+	// 各级推荐人的分成比例，如[0.1, 0.05]表示直接推荐人10%、推荐人的推荐人5%，为空表示不发放
+	Levels []float64 `yaml:"levels"`
+}
+
+// PointsBatchConfig 分片批量计算：每条任务覆盖一条链一个周期内的一个用户分片，
+// 由消费者按集合查询与批量写入完成整个分片的计算
+type PointsBatchConfig struct {
+	Enabled   bool `yaml:"enabled"`    // 是否启用，默认按用户逐条生成任务
+	Shards    int  `yaml:"shards"`     // 每条链的分片数，默认16
+	ChunkSize int  `yaml:"chunk_size"` // 单个写事务入账的条数，默认500
+}
+
+// PointsPolicyConfig 积分到期与衰减策略，由调度器以流水形式执行
+type PointsPolicyConfig struct {
+	ExpiryDays   int     `yaml:"expiry_days"`   // 积分有效期（天），按批次获得时间计算，0表示永不到期
+	DecayPercent float64 `yaml:"decay_percent"` // 不活跃账户每月衰减的百分比，0表示不衰减
+	InactiveDays int     `yaml:"inactive_days"` // 超过该天数未获得积分视为不活跃，默认30
+}
+
+// RuleSetConfig 积分规则集，按生效时间选择，同一时刻只有一个规则集生效
+type RuleSetConfig struct {
+	Version            string             `yaml:"version"`             // 规则集版本，写入积分计算历史
+	EffectiveFrom      time.Time          `yaml:"effective_from"`      // 生效时间
+	BaseRate           *float64           `yaml:"base_rate"`           // 基础比率，未配置时使用points.rate；配置为0表示不计积分
+	ChainRates         map[string]float64 `yaml:"chain_rates"`         // 按链覆盖基础比率
+	Tiers              []TierConfig       `yaml:"tiers"`               // 余额分级倍数
+	AddressMultipliers map[string]float64 `yaml:"address_multipliers"` // 合作方地址倍数
+	Campaigns          []CampaignConfig   `yaml:"campaigns"`           // 限时活动加成
+	StakingMultiplier  *float64           `yaml:"staking_multiplier"`  // 质押锁定期间的倍数，未配置时使用points.staking_multiplier；配置为0表示质押余额不计息
+}
+
+// TierConfig 余额分级：余额（代币单位）不低于MinBalance时适用Multiplier，取满足条件的最高档
+type TierConfig struct {
+	MinBalance float64 `yaml:"min_balance"`
+	Multiplier float64 `yaml:"multiplier"`
+}
+
+// CampaignConfig 限时活动：[Start, End) 内按Multiplier加成，Chains为空表示所有链
+type CampaignConfig struct {
+	Name       string    `yaml:"name"`
+	Start      time.Time `yaml:"start"`
+	End        time.Time `yaml:"end"`
+	Multiplier float64   `yaml:"multiplier"`
+	Chains     []string  `yaml:"chains"`
+}
+
+// OutboxConfig 事务性发件箱中继配置
+type OutboxConfig struct {
+	PollInterval   int `yaml:"poll_interval"`   // 轮询间隔（秒），默认2
+	BatchSize      int `yaml:"batch_size"`      // 单次投递条数，默认200
+	MaxAttempts    int `yaml:"max_attempts"`    // 最大重试次数，超过后进入死信，0表示无限重试
+	RetentionHours int `yaml:"retention_hours"` // 已投递消息保留时长（小时），默认72
+}
+
+// RetentionConfig 数据保留与归档配置
+type RetentionConfig struct {
+	BalanceChangesDays int    `yaml:"balance_changes_days"` // 余额变动明细保留天数，0表示永久保留
+	HistoryDays        int    `yaml:"history_days"`         // 积分计算历史明细保留天数，0表示永久保留
+	ArchiveDir         string `yaml:"archive_dir"`          // 归档文件目录，默认archive
+	BatchSize          int    `yaml:"batch_size"`           // 单批删除行数，默认5000
+	// 排行榜每日快照保留天数，0表示永久保留
+	LeaderboardSnapshotDays int `yaml:"leaderboard_snapshot_days"`
 }
 
 // Load 加载配置文件
@@ -84,13 +274,163 @@ func Load(path string) (*Config, error) {
 		cfg.Chains[i].BlockDelay = 6
 	}
 
+	// 校验各链积分周期配置
+	for i := range cfg.Chains {
+		c := &cfg.Chains[i]
+		if c.Name == GlobalBoard {
+			return nil, fmt.Errorf("链名 %s 为跨链总榜保留，不能用作链名", GlobalBoard)
+		}
+		switch c.Points.Mode {
+		case "":
+			c.Points.Mode = PointsModeTime
+		case PointsModeTime:
+		case PointsModeBlock:
+			if c.Points.BlocksPerPeriod == 0 {
+				return nil, fmt.Errorf("链 %s 区块模式需要配置 points.blocks_per_period", c.Name)
+			}
+			if c.Points.StartBlock == 0 {
+				c.Points.StartBlock = uint64(c.StartBlock)
+			}
+			if c.Points.EndBlock != 0 && c.Points.EndBlock <= c.Points.StartBlock {
+				return nil, fmt.Errorf("链 %s 的 points.end_block 必须大于 start_block", c.Name)
+			}
+		default:
+			return nil, fmt.Errorf("链 %s 的积分模式无效: %s", c.Name, c.Points.Mode)
+		}
+	}
+
 	// 设置默认值
 	if cfg.Points.Rate == 0 {
 		cfg.Points.Rate = 0.05
 	}
+	if cfg.Points.StakingMultiplier == 0 {
+		cfg.Points.StakingMultiplier = 1
+	}
+	for i, pct := range cfg.Points.Referral.Levels {
+		if pct <= 0 || pct > 1 {
+			return nil, fmt.Errorf("points.referral.levels[%d] 必须在(0, 1]之间: %v", i, pct)
+		}
+	}
 	if cfg.Points.Interval == 0 {
 		cfg.Points.Interval = 60
 	}
+	switch cfg.Points.Pricing.Source {
+	case "":
+	case "file":
+		if cfg.Points.Pricing.File == "" {
+			return nil, fmt.Errorf("points.pricing.source为file时需要配置file")
+		}
+	case "http":
+		if cfg.Points.Pricing.URL == "" {
+			return nil, fmt.Errorf("points.pricing.source为http时需要配置url")
+		}
+	default:
+		return nil, fmt.Errorf("points.pricing.source无效: %s", cfg.Points.Pricing.Source)
+	}
+	if cfg.Points.Pricing.TimeoutSeconds == 0 {
+		cfg.Points.Pricing.TimeoutSeconds = 5
+	}
+	if cfg.Points.Pricing.MaxAgeMinutes == 0 {
+		cfg.Points.Pricing.MaxAgeMinutes = 60
+	}
+	switch cfg.Points.Sybil.Action {
+	case "", "downweight", "exclude":
+	default:
+		return nil, fmt.Errorf("points.sybil.action无效: %s", cfg.Points.Sybil.Action)
+	}
+	if cfg.Points.Sybil.Threshold == 0 {
+		cfg.Points.Sybil.Threshold = 0.7
+	}
+	if cfg.Points.Sybil.Weight == 0 {
+		cfg.Points.Sybil.Weight = 0.2
+	}
+	if cfg.Points.Sybil.Threshold < 0 || cfg.Points.Sybil.Threshold > 1 {
+		return nil, fmt.Errorf("points.sybil.threshold 必须在[0, 1]之间: %v", cfg.Points.Sybil.Threshold)
+	}
+	if cfg.Points.Sybil.Weight < 0 || cfg.Points.Sybil.Weight >= 1 {
+		return nil, fmt.Errorf("points.sybil.weight 必须在[0, 1)之间: %v", cfg.Points.Sybil.Weight)
+	}
+	if cfg.Points.Sybil.WindowDays == 0 {
+		cfg.Points.Sybil.WindowDays = 30
+	}
+	if cfg.Points.Sybil.MinFanout == 0 {
+		cfg.Points.Sybil.MinFanout = 3
+	}
+	if cfg.Points.Sybil.LockstepMinutes == 0 {
+		cfg.Points.Sybil.LockstepMinutes = 10
+	}
+	if cfg.Points.Sybil.LockstepMinShared == 0 {
+		cfg.Points.Sybil.LockstepMinShared = 3
+	}
+	if cfg.Points.Sybil.MaxBucketSize == 0 {
+		cfg.Points.Sybil.MaxBucketSize = 50
+	}
+	if cfg.Points.Sybil.MinClusterSize == 0 {
+		cfg.Points.Sybil.MinClusterSize = 3
+	}
+	if cfg.Database.MaxReplicaLagSeconds == 0 {
+		cfg.Database.MaxReplicaLagSeconds = 30
+	}
+	for i := range cfg.Database.Replicas {
+		r := &cfg.Database.Replicas[i]
+		if r.Port == 0 {
+			r.Port = cfg.Database.Port
+		}
+		if r.User == "" {
+			r.User = cfg.Database.User
+		}
+		if r.Password == "" {
+			r.Password = cfg.Database.Password
+		}
+		if r.DBName == "" {
+			r.DBName = cfg.Database.DBName
+		}
+	}
+	if cfg.Database.QueryLog.SlowThresholdMs == 0 {
+		cfg.Database.QueryLog.SlowThresholdMs = 200
+	}
+	if cfg.Database.QueryLog.Redact == "" {
+		cfg.Database.QueryLog.Redact = "mask"
+	}
+	if cfg.RabbitMQ.EventRoutingKey == "" {
+		cfg.RabbitMQ.EventRoutingKey = "points.events"
+	}
+	if cfg.RabbitMQ.EventQueue == "" {
+		cfg.RabbitMQ.EventQueue = "points_events_queue"
+	}
+	if cfg.RabbitMQ.RetryQueue == "" {
+		cfg.RabbitMQ.RetryQueue = cfg.RabbitMQ.Queue + "_retry"
+	}
+	if cfg.RabbitMQ.RetryDelaySeconds <= 0 {
+		cfg.RabbitMQ.RetryDelaySeconds = 60
+	}
+	if cfg.Outbox.PollInterval == 0 {
+		cfg.Outbox.PollInterval = 2
+	}
+	if cfg.Outbox.BatchSize == 0 {
+		cfg.Outbox.BatchSize = 200
+	}
+	if cfg.Outbox.RetentionHours == 0 {
+		cfg.Outbox.RetentionHours = 72
+	}
+	if cfg.Retention.ArchiveDir == "" {
+		cfg.Retention.ArchiveDir = "archive"
+	}
+	if cfg.Retention.BatchSize == 0 {
+		cfg.Retention.BatchSize = 5000
+	}
+	if cfg.Points.Batch.Shards <= 0 {
+		cfg.Points.Batch.Shards = 16
+	}
+	if cfg.Points.Batch.ChunkSize <= 0 {
+		cfg.Points.Batch.ChunkSize = 500
+	}
+	if cfg.Points.Policy.InactiveDays == 0 {
+		cfg.Points.Policy.InactiveDays = 30
+	}
+	if cfg.Airdrop.TokenDecimals == 0 {
+		cfg.Airdrop.TokenDecimals = 18
+	}
 
 	return &cfg, nil
 }
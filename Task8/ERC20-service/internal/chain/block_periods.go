@@ -0,0 +1,39 @@
+package chain
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"erc20-service/internal/db"
+)
+
+// 记录区块周期边界区块的链上时间（仅区块模式）
+// 在更新最后处理区块之前调用，保证已处理区块内的边界都有链上时间；首次运行时补齐积分起始区块以来的全部边界
+func (l *Listener) recordBlockBoundaries(ctx context.Context, to uint64) error {
+	if !l.chainCfg.BlockMode() {
+		return nil
+	}
+	from := l.chainCfg.Points.StartBlock
+	latest, ok, err := db.GetLatestBlockBoundary(l.chainCfg.Name)
+	if err != nil {
+		return fmt.Errorf("获取区块周期边界失败: %v", err)
+	}
+	if ok && latest+1 > from {
+		from = latest + 1
+	}
+
+	for _, block := range l.blockClock.Boundaries(from, to) {
+		header, err := l.client.HeaderByNumber(ctx, new(big.Int).SetUint64(block))
+		if err != nil {
+			return fmt.Errorf("获取区块头失败: block=%d: %v", block, err)
+		}
+		blockTime := time.Unix(int64(header.Time), 0).UTC()
+		if err := db.RecordBlockBoundary(l.chainCfg.Name, block, blockTime); err != nil {
+			return fmt.Errorf("记录区块周期边界失败: %v", err)
+		}
+		l.log.Debug("记录区块周期边界", "block", block, "time", blockTime)
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 
 	"erc20-service/config"
 	"erc20-service/internal/db"
+	"erc20-service/internal/epoch"
 	mq "erc20-service/internal/mq"
 	"erc20-service/pkg/logger"
 	"log/slog"
@@ -25,6 +26,10 @@ type Listener struct {
 	client       *ethclient.Client
 	contractABI  abi.ABI
 	contractAddr common.Address
+	stakingAddr  common.Address   // 质押合约，未配置时为零地址
+	stakingPools map[uint64]bool  // 追踪的资金池，为空表示全部
+	referralAddr common.Address   // 推荐注册合约，未配置时为零地址
+	blockClock   epoch.BlockClock // 区块模式的积分周期划分
 	producer     *mq.PointsProducer
 	lastBlock    int64
 	log          *slog.Logger
@@ -44,11 +49,34 @@ func NewListener(cfg config.ChainConfig, contractABI abi.ABI, producer *mq.Point
 		lastBlock = uint64(cfg.StartBlock)
 	}
 
+	stakingPools := make(map[uint64]bool)
+	for _, pid := range cfg.Staking.PoolIDs {
+		stakingPools[pid] = true
+	}
+	var stakingAddr common.Address
+	if cfg.Staking.ContractAddress != "" {
+		if !common.IsHexAddress(cfg.Staking.ContractAddress) {
+			return nil, fmt.Errorf("质押合约地址无效: %s", cfg.Staking.ContractAddress)
+		}
+		stakingAddr = common.HexToAddress(cfg.Staking.ContractAddress)
+	}
+	var referralAddr common.Address
+	if cfg.Referral.ContractAddress != "" {
+		if !common.IsHexAddress(cfg.Referral.ContractAddress) {
+			return nil, fmt.Errorf("推荐注册合约地址无效: %s", cfg.Referral.ContractAddress)
+		}
+		referralAddr = common.HexToAddress(cfg.Referral.ContractAddress)
+	}
+
 	return &Listener{
 		chainCfg:     cfg,
 		client:       client,
 		contractABI:  contractABI,
 		contractAddr: common.HexToAddress(cfg.ContractAddress),
+		stakingAddr:  stakingAddr,
+		stakingPools: stakingPools,
+		referralAddr: referralAddr,
+		blockClock:   epoch.NewBlockClock(cfg.Points.StartBlock, cfg.Points.EndBlock, cfg.Points.BlocksPerPeriod),
 		producer:     producer,
 		lastBlock:    int64(lastBlock),
 		log:          logger.New(fmt.Sprintf("chain:%s", cfg.Name)),
@@ -59,6 +87,8 @@ func NewListener(cfg config.ChainConfig, contractABI abi.ABI, producer *mq.Point
 func (l *Listener) Start(ctx context.Context) error {
 	l.log.Info("启动监听器",
 		"contract", l.contractAddr.Hex(),
+		"staking", l.stakingEnabled(),
+		"referral", l.referralEnabled(),
 		"start_block", l.lastBlock,
 		"block_delay", l.chainCfg.BlockDelay,
 	)
@@ -99,11 +129,18 @@ func (l *Listener) processBlocks(ctx context.Context) error {
 
 	l.log.Info("开始处理区块", "from", l.lastBlock+1, "to", targetBlock)
 
-	// 过滤事件
+	// 过滤事件（代币合约、质押合约与推荐注册合约）
+	addresses := []common.Address{l.contractAddr}
+	if l.stakingEnabled() {
+		addresses = append(addresses, l.stakingAddr)
+	}
+	if l.referralEnabled() {
+		addresses = append(addresses, l.referralAddr)
+	}
 	query := ethereum.FilterQuery{
 		FromBlock: big.NewInt(l.lastBlock + 1),
 		ToBlock:   big.NewInt(targetBlock),
-		Addresses: []common.Address{l.contractAddr},
+		Addresses: addresses,
 	}
 
 	logs, err := l.client.FilterLogs(ctx, query)
@@ -118,6 +155,11 @@ func (l *Listener) processBlocks(ctx context.Context) error {
 		}
 	}
 
+	// 记录区块周期边界，之后再推进最后处理的区块
+	if err := l.recordBlockBoundaries(ctx, uint64(targetBlock)); err != nil {
+		return err
+	}
+
 	// 更新最后处理的区块
 	if err := db.UpdateLastProcessedBlock(l.chainCfg.Name, uint64(targetBlock)); err != nil {
 		return fmt.Errorf("更新区块号失败: %v", err)
@@ -130,6 +172,15 @@ func (l *Listener) processBlocks(ctx context.Context) error {
 
 // 处理单条日志
 func (l *Listener) processLog(vLog types.Log) error {
+	if len(vLog.Topics) == 0 {
+		return nil
+	}
+	if l.stakingEnabled() && vLog.Address == l.stakingAddr {
+		return l.processStakeLog(vLog)
+	}
+	if l.referralEnabled() && vLog.Address == l.referralAddr {
+		return l.processReferralLog(vLog)
+	}
 	event, err := l.contractABI.EventByID(vLog.Topics[0])
 	if err != nil {
 		return fmt.Errorf("未知事件ID: %v", err)
@@ -0,0 +1,98 @@
+package chain
+
+import (
+	"errors"
+	"fmt"
+
+	"erc20-service/internal/db"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// 推荐注册事件签名（referee、referrer 为 indexed 参数，数据区为被推荐人的签名）
+var referralRegisteredEventID = crypto.Keccak256Hash([]byte("ReferralRegistered(address,address,bytes)"))
+
+// 推荐注册事件数据区
+var referralEventData = func() abi.Arguments {
+	bytesType, _ := abi.NewType("bytes", "", nil)
+	return abi.Arguments{{Name: "signature", Type: bytesType}}
+}()
+
+// 推荐注册合约是否已配置
+func (l *Listener) referralEnabled() bool {
+	return l.referralAddr != (common.Address{})
+}
+
+// ReferralMessage 被推荐人签名的推荐注册消息（EIP-191 personal_sign），包含链ID与注册合约地址防止跨链、跨合约重放
+func ReferralMessage(chainID int, registry, referrer common.Address) string {
+	return fmt.Sprintf("ERC20 referral registration\nchain: %d\nregistry: %s\nreferrer: %s", chainID, registry.Hex(), referrer.Hex())
+}
+
+// 处理推荐注册合约日志
+// 只接受被推荐人本人签名的注册：合约可能允许任何人代为提交，签名保证推荐关系经被推荐人授权
+func (l *Listener) processReferralLog(vLog types.Log) error {
+	if vLog.Topics[0] != referralRegisteredEventID {
+		return nil
+	}
+	if len(vLog.Topics) < 3 {
+		return fmt.Errorf("推荐注册事件参数不足")
+	}
+	referee := common.HexToAddress(vLog.Topics[1].Hex())
+	referrer := common.HexToAddress(vLog.Topics[2].Hex())
+
+	values, err := referralEventData.Unpack(vLog.Data)
+	if err != nil || len(values) == 0 {
+		return fmt.Errorf("解析推荐注册事件失败: %v", err)
+	}
+	sig, _ := values[0].([]byte)
+	signer, err := recoverSigner(ReferralMessage(l.chainCfg.ChainID, l.referralAddr, referrer), sig)
+	if err != nil || signer != referee {
+		l.log.Warn("推荐注册签名无效，忽略",
+			"referee", referee.Hex(),
+			"referrer", referrer.Hex(),
+			"signer", signer.Hex(),
+			"tx", vLog.TxHash.Hex(),
+			"error", err,
+		)
+		return nil
+	}
+
+	err = db.CreateReferral(db.Referral{
+		ChainName: l.chainCfg.Name,
+		Referee:   referee.Hex(),
+		Referrer:  referrer.Hex(),
+		Source:    db.ReferralSourceChain,
+		TxHash:    vLog.TxHash.Hex(),
+	})
+	if errors.Is(err, db.ErrSelfReferral) || errors.Is(err, db.ErrReferralCycle) || errors.Is(err, db.ErrReferralExists) {
+		l.log.Info("推荐注册被拒绝", "referee", referee.Hex(), "referrer", referrer.Hex(), "reason", err, "tx", vLog.TxHash.Hex())
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("记录推荐关系失败: %v", err)
+	}
+
+	l.log.Info("处理推荐注册事件", "referee", referee.Hex(), "referrer", referrer.Hex(), "tx", vLog.TxHash.Hex())
+	return nil
+}
+
+// 从personal_sign签名恢复签名地址，v兼容0/1与27/28
+func recoverSigner(message string, sig []byte) (common.Address, error) {
+	if len(sig) != crypto.SignatureLength {
+		return common.Address{}, fmt.Errorf("签名长度无效: %d", len(sig))
+	}
+	s := make([]byte, len(sig))
+	copy(s, sig)
+	if s[crypto.RecoveryIDOffset] >= 27 {
+		s[crypto.RecoveryIDOffset] -= 27
+	}
+	pub, err := crypto.SigToPub(accounts.TextHash([]byte(message)), s)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*pub), nil
+}
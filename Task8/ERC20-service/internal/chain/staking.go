@@ -0,0 +1,85 @@
+package chain
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"erc20-service/internal/db"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// StakeSystem 事件签名（user、pid 为 indexed 参数，amount 为数据区第一个字）
+var (
+	stakedEventID           = crypto.Keccak256Hash([]byte("Staked(address,uint256,uint256)"))
+	unstakeRequestedEventID = crypto.Keccak256Hash([]byte("UnstakeRequested(address,uint256,uint256,uint256)"))
+	unstakeClaimedEventID   = crypto.Keccak256Hash([]byte("UnstakeClaimed(address,uint256,uint256,uint256)"))
+)
+
+// 质押合约是否已配置
+func (l *Listener) stakingEnabled() bool {
+	return l.stakingAddr != (common.Address{})
+}
+
+// 处理质押合约日志
+// 质押与领取解押在同一交易中还会产生代币Transfer事件（用户 ↔ 质押合约），钱包余额照常变动，
+// 这里只记录质押持仓，积分计算时将质押持仓归属回质押者
+func (l *Listener) processStakeLog(vLog types.Log) error {
+	var eventType string
+	switch vLog.Topics[0] {
+	case stakedEventID:
+		eventType = db.StakeEventStake
+	case unstakeRequestedEventID:
+		eventType = db.StakeEventUnstakeRequest
+	case unstakeClaimedEventID:
+		eventType = db.StakeEventUnstakeClaim
+	default:
+		// 资金池管理等其他事件
+		return nil
+	}
+	if len(vLog.Topics) < 3 || len(vLog.Data) < 32 {
+		return fmt.Errorf("质押事件参数不足")
+	}
+
+	user := common.HexToAddress(vLog.Topics[1].Hex())
+	pid := new(big.Int).SetBytes(vLog.Topics[2].Bytes())
+	if !pid.IsUint64() || (len(l.stakingPools) > 0 && !l.stakingPools[pid.Uint64()]) {
+		l.log.Debug("忽略未追踪资金池的质押事件", "pid", pid.String(), "tx", vLog.TxHash.Hex())
+		return nil
+	}
+	amount := new(big.Int).SetBytes(vLog.Data[:32])
+
+	change, err := db.RecordStakeChange(db.StakeChange{
+		ChainName:   l.chainCfg.Name,
+		UserAddress: user.Hex(),
+		PoolID:      pid.Uint64(),
+		EventType:   eventType,
+		Amount:      amount.String(),
+		BlockNumber: vLog.BlockNumber,
+		EventTime:   time.Now(), // 与Transfer事件一致，保证同一交易中两类记录的先后关系
+		TxHash:      vLog.TxHash.Hex(),
+		LogIndex:    vLog.Index,
+	})
+	if errors.Is(err, db.ErrStakeEventRecorded) {
+		l.log.Debug("质押事件已记录，跳过", "tx", vLog.TxHash.Hex(), "log_index", vLog.Index)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("记录质押事件失败: %v", err)
+	}
+
+	l.log.Info("处理质押事件",
+		"type", eventType,
+		"user", user.Hex(),
+		"pid", pid.Uint64(),
+		"amount", amount.String(),
+		"staked", change.StakedAfter,
+		"pending", change.PendingAfter,
+		"tx", vLog.TxHash.Hex(),
+	)
+	return nil
+}
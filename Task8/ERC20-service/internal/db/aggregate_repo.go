@@ -0,0 +1,112 @@
+package db
+
+import (
+	"database/sql"
+	"erc20-service/pkg/decimal"
+)
+
+// ChainPoints 单链积分
+type ChainPoints struct {
+	ChainName   string          `json:"chain_name"`
+	TotalPoints decimal.Decimal `json:"total_points"`
+	Weight      decimal.Decimal `json:"weight"`
+}
+
+// AggregatedPoints 地址的跨链汇总积分
+type AggregatedPoints struct {
+	UserAddress string          `json:"user_address"`
+	TotalPoints decimal.Decimal `json:"total_points"` // Σ 各链积分 × 链权重
+	Chains      []ChainPoints   `json:"chains"`
+}
+
+// 单链加权积分：链总积分 × 链权重，只对总积分舍入一次
+func weightedPoints(policy *Policy, chainName string, total decimal.Decimal) decimal.Decimal {
+	return total.MulRat(policy.ChainWeight(chainName).Rat())
+}
+
+// GetAggregatedPoints 获取地址的跨链汇总积分及各链明细
+func GetAggregatedPoints(policy *Policy, userAddr string) (AggregatedPoints, error) {
+	result := AggregatedPoints{UserAddress: userAddr}
+	err := QueryRow(
+		"SELECT total_points FROM user_points_aggregate WHERE user_address = ?", userAddr,
+	).Scan(&result.TotalPoints)
+	if err != nil && err != sql.ErrNoRows {
+		return result, err
+	}
+
+	rows, err := Query(`
+        SELECT chain_name, total_points FROM user_points
+        WHERE user_address = ?
+        ORDER BY chain_name
+    `, userAddr)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cp ChainPoints
+		if err := rows.Scan(&cp.ChainName, &cp.TotalPoints); err != nil {
+			return result, err
+		}
+		cp.Weight = policy.ChainWeight(cp.ChainName)
+		result.Chains = append(result.Chains, cp)
+	}
+	return result, rows.Err()
+}
+
+// RebuildAggregatedPoints 按当前链权重由各链总积分重算全部跨链汇总，返回地址数
+// 修改链权重后执行；与积分入账并发时以锁定的各链积分为准
+func RebuildAggregatedPoints(policy *Policy) (int, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := TxQuery(tx, `
+        SELECT chain_name, user_address, total_points FROM user_points
+        ORDER BY user_address
+        FOR UPDATE
+    `)
+	if err != nil {
+		return 0, err
+	}
+	// 按小写地址归并，同一地址在各链的大小写写法不同时汇总为一行，写入首次出现的写法
+	totals := make(map[string]decimal.Decimal)
+	var order []string
+	for rows.Next() {
+		var (
+			chainName, userAddr string
+			points              decimal.Decimal
+		)
+		if err := rows.Scan(&chainName, &userAddr, &points); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		key := AddrKey(userAddr)
+		if _, ok := totals[key]; !ok {
+			order = append(order, userAddr)
+		}
+		totals[key] = totals[key].Add(weightedPoints(policy, chainName, points))
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return 0, err
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+
+	if _, err := TxExec(tx, "DELETE FROM user_points_aggregate"); err != nil {
+		return 0, err
+	}
+	for _, userAddr := range order {
+		if _, err := TxExec(tx,
+			"INSERT INTO user_points_aggregate (user_address, total_points) VALUES (?, ?)",
+			userAddr, totals[AddrKey(userAddr)],
+		); err != nil {
+			return 0, err
+		}
+	}
+	return len(order), tx.Commit()
+}
@@ -20,7 +20,7 @@ type BalanceChange struct {
 // GetLastProcessedBlock 获取链最后处理的区块
 func GetLastProcessedBlock(chainName string) (uint64, error) {
 	var block uint64
-	err := QueryRow(
+	err := ReadQueryRow(
 		"SELECT last_processed_block FROM chain_status WHERE chain_name = ?",
 		chainName,
 	).Scan(&block)
@@ -39,7 +39,7 @@ func UpdateLastProcessedBlock(chainName string, block uint64) error {
 	return err
 }
 
-// GetUserCurrentBalance 获取用户当前余额
+// GetUserCurrentBalance 获取用户当前余额（用于计算新余额，需读己之写，始终走主库）
 func GetUserCurrentBalance(chainName, userAddr string) (string, error) {
 	var balance string
 	err := QueryRow(
@@ -85,6 +85,10 @@ func RecordBalanceChange(change BalanceChange) error {
 	if err != nil {
 		return err
 	}
+	// 写入余额变动事件，与余额更新同事务提交
+	if err := TxEnqueueOutbox(tx, OutboxTopicBalanceEvent, BalanceChangedEvent(change)); err != nil {
+		return err
+	}
 	return tx.Commit()
 }
 
@@ -97,7 +101,7 @@ func GetBalanceChangesInPeriod(chainName, userAddr string, start, end time.Time)
         WHERE chain_name = ?
           AND user_address = ?
           AND event_time BETWEEN ? AND ?
-        ORDER BY event_time ASC
+        ORDER BY event_time ASC, id ASC
     `, chainName, userAddr, start, end)
 	if err != nil {
 		return nil, err
@@ -118,9 +122,24 @@ func GetBalanceChangesInPeriod(chainName, userAddr string, start, end time.Time)
 	return changes, rows.Err()
 }
 
+// GetBalanceAt 获取用户在指定时间点的期初余额：取该时间之前最后一次变动后的余额，无变动时为"0"
+func GetBalanceAt(chainName, userAddr string, at time.Time) (string, error) {
+	var balance string
+	err := QueryRow(`
+        SELECT balance_after FROM balance_changes
+        WHERE chain_name = ? AND user_address = ? AND event_time < ?
+        ORDER BY event_time DESC, id DESC
+        LIMIT 1
+    `, chainName, userAddr, at).Scan(&balance)
+	if err == sql.ErrNoRows {
+		return "0", nil
+	}
+	return balance, err
+}
+
 // GetUsersByChain 获取链上所有用户
 func GetUsersByChain(chainName string) ([]string, error) {
-	rows, err := Query(
+	rows, err := ReadQuery(
 		"SELECT DISTINCT user_address FROM user_balances WHERE chain_name = ?",
 		chainName,
 	)
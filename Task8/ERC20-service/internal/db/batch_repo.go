@@ -0,0 +1,593 @@
+package db
+
+import (
+	"database/sql"
+	"erc20-service/pkg/decimal"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// 分片条件：CRC32(小写地址) % shards == shard，占位符依次绑定 shards、shard
+const shardCond = "MOD(CRC32(LOWER(%s)), ?) = ?"
+
+// Shard 链上的用户分片
+type Shard struct {
+	ChainName string
+	Shard     int
+	Shards    int
+}
+
+func (s Shard) cond(col string) string {
+	return fmt.Sprintf(shardCond, col)
+}
+
+// ShardUser 分片内的用户及上次计算时间（无积分记录时无效）
+type ShardUser struct {
+	UserAddress      string
+	LastCalculatedAt sql.NullTime
+}
+
+// AddrKey 批量查询结果的地址键（小写）
+func AddrKey(addr string) string {
+	return strings.ToLower(addr)
+}
+
+// GetShardUsers 获取分片内的全部用户及其上次计算时间
+func GetShardUsers(s Shard) ([]ShardUser, error) {
+	rows, err := Query(`
+        SELECT b.user_address, p.last_calculated_at
+        FROM user_balances b
+        LEFT JOIN user_points p ON p.chain_name = b.chain_name AND p.user_address = b.user_address
+        WHERE b.chain_name = ? AND `+s.cond("b.user_address"),
+		s.ChainName, s.Shards, s.Shard)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []ShardUser
+	for rows.Next() {
+		var u ShardUser
+		if err := rows.Scan(&u.UserAddress, &u.LastCalculatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// GetLaggingUsers 获取最近一次计算早于before的持有者，用于批量模式下按用户回溯
+func GetLaggingUsers(chainName string, before time.Time) ([]ShardUser, error) {
+	rows, err := ReadQuery(`
+        SELECT p.user_address, p.last_calculated_at
+        FROM user_points p
+        JOIN user_balances b ON b.chain_name = p.chain_name AND b.user_address = p.user_address
+        WHERE p.chain_name = ? AND p.last_calculated_at < ?
+    `, chainName, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []ShardUser
+	for rows.Next() {
+		var u ShardUser
+		if err := rows.Scan(&u.UserAddress, &u.LastCalculatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// GetShardOpeningBalances 获取分片内各用户在at之前最后一次变动后的余额，键为小写地址
+// at之后无变动的用户期初余额即当前余额；其余用户按索引以关联子查询逐个取at之前的最后一条变动，避免扫描全部历史
+// 不使用 JOIN LATERAL，兼容 MySQL 8.0.14 之前的版本；at之前无变动的用户期初余额为0，不返回
+func GetShardOpeningBalances(s Shard, at time.Time) (map[string]string, error) {
+	rows, err := Query(`
+        SELECT b.user_address, b.current_balance
+        FROM user_balances b
+        WHERE b.chain_name = ? AND `+s.cond("b.user_address")+`
+        AND NOT EXISTS (
+            SELECT 1 FROM balance_changes c
+            WHERE c.chain_name = b.chain_name AND c.user_address = b.user_address AND c.event_time >= ?)
+        UNION ALL
+        SELECT r.user_address, (
+            SELECT o.balance_after FROM balance_changes o
+            WHERE o.chain_name = ? AND o.user_address = r.user_address AND o.event_time < ?
+            ORDER BY o.event_time DESC, o.id DESC
+            LIMIT 1
+        )
+        FROM (
+            SELECT DISTINCT user_address FROM balance_changes
+            WHERE chain_name = ? AND event_time >= ? AND `+s.cond("user_address")+`
+        ) r`,
+		s.ChainName, s.Shards, s.Shard, at,
+		s.ChainName, at,
+		s.ChainName, at, s.Shards, s.Shard)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	balances := make(map[string]string)
+	for rows.Next() {
+		var (
+			addr    string
+			balance sql.NullString
+		)
+		if err := rows.Scan(&addr, &balance); err != nil {
+			return nil, err
+		}
+		if balance.Valid {
+			balances[AddrKey(addr)] = balance.String
+		}
+	}
+	return balances, rows.Err()
+}
+
+// GetShardBalanceChanges 获取分片内[start, end]的余额变动，按用户分组并按时间排序
+func GetShardBalanceChanges(s Shard, start, end time.Time) (map[string][]BalanceChange, error) {
+	rows, err := Query(`
+        SELECT chain_name, user_address, event_type, amount, balance_after,
+               block_number, event_time, tx_hash
+        FROM balance_changes
+        WHERE chain_name = ? AND event_time BETWEEN ? AND ? AND `+s.cond("user_address")+`
+        ORDER BY event_time ASC, id ASC`,
+		s.ChainName, start, end, s.Shards, s.Shard)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	changes := make(map[string][]BalanceChange)
+	for rows.Next() {
+		var c BalanceChange
+		if err := rows.Scan(
+			&c.ChainName, &c.UserAddress, &c.EventType,
+			&c.Amount, &c.BalanceAfter, &c.BlockNumber,
+			&c.EventTime, &c.TxHash,
+		); err != nil {
+			return nil, err
+		}
+		key := AddrKey(c.UserAddress)
+		changes[key] = append(changes[key], c)
+	}
+	return changes, rows.Err()
+}
+
+// GetShardOpeningStakes 获取分片内各用户在at之前的质押持仓，键为小写地址
+// 与 GetShardOpeningBalances 相同：at之后无变动的用户取最新持仓（user_stakes），
+// 其余用户按索引以关联子查询逐个取at之前的最后一条变动，不扫描全部质押历史；at之前无变动的用户不返回
+func GetShardOpeningStakes(s Shard, at time.Time) (map[string]StakePosition, error) {
+	rows, err := Query(`
+        SELECT u.user_address, u.staked, u.pending
+        FROM user_stakes u
+        WHERE u.chain_name = ? AND `+s.cond("u.user_address")+`
+        AND NOT EXISTS (
+            SELECT 1 FROM stake_changes c
+            WHERE c.chain_name = u.chain_name AND c.user_address = u.user_address AND c.event_time >= ?)
+        UNION ALL
+        SELECT o.user_address, o.staked_after, o.pending_after
+        FROM stake_changes o
+        JOIN (
+            SELECT (
+                SELECT l.id FROM stake_changes l
+                WHERE l.chain_name = ? AND l.user_address = r.user_address AND l.event_time < ?
+                ORDER BY l.event_time DESC, l.id DESC
+                LIMIT 1
+            ) AS id
+            FROM (
+                SELECT DISTINCT user_address FROM stake_changes
+                WHERE chain_name = ? AND event_time >= ? AND `+s.cond("user_address")+`
+            ) r
+        ) last ON last.id = o.id`,
+		s.ChainName, s.Shards, s.Shard, at,
+		s.ChainName, at,
+		s.ChainName, at, s.Shards, s.Shard)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	positions := make(map[string]StakePosition)
+	for rows.Next() {
+		var (
+			addr string
+			p    StakePosition
+		)
+		if err := rows.Scan(&addr, &p.Staked, &p.Pending); err != nil {
+			return nil, err
+		}
+		positions[AddrKey(addr)] = p
+	}
+	return positions, rows.Err()
+}
+
+// GetShardStakeChanges 获取分片内[start, end]的质押变动，按用户分组并按时间排序
+func GetShardStakeChanges(s Shard, start, end time.Time) (map[string][]StakeChange, error) {
+	rows, err := Query(`
+        SELECT chain_name, user_address, pool_id, event_type, amount, staked_after, pending_after,
+               block_number, event_time, tx_hash, log_index
+        FROM stake_changes
+        WHERE chain_name = ? AND event_time BETWEEN ? AND ? AND `+s.cond("user_address")+`
+        ORDER BY event_time ASC, id ASC`,
+		s.ChainName, start, end, s.Shards, s.Shard)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	changes := make(map[string][]StakeChange)
+	for rows.Next() {
+		var c StakeChange
+		if err := rows.Scan(
+			&c.ChainName, &c.UserAddress, &c.PoolID, &c.EventType, &c.Amount,
+			&c.StakedAfter, &c.PendingAfter, &c.BlockNumber, &c.EventTime,
+			&c.TxHash, &c.LogIndex,
+		); err != nil {
+			return nil, err
+		}
+		key := AddrKey(c.UserAddress)
+		changes[key] = append(changes[key], c)
+	}
+	return changes, rows.Err()
+}
+
+// GetShardAppliedPeriods 获取分片内与[start, end)重叠的已入账时间段（含日汇总），按用户分组并按开始时间排序
+func GetShardAppliedPeriods(s Shard, start, end time.Time) (map[string][]TimePeriod, error) {
+	rows, err := Query(`
+        SELECT user_address, period_start, period_end FROM points_calculation_history
+        WHERE chain_name = ? AND period_start < ? AND period_end > ? AND `+s.cond("user_address")+`
+        UNION ALL
+        SELECT user_address, period_start, period_end FROM points_history_daily
+        WHERE chain_name = ? AND period_start < ? AND period_end > ? AND `+s.cond("user_address")+`
+        ORDER BY period_start ASC`,
+		s.ChainName, end, start, s.Shards, s.Shard,
+		s.ChainName, end, start, s.Shards, s.Shard)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	periods := make(map[string][]TimePeriod)
+	for rows.Next() {
+		var (
+			addr string
+			p    TimePeriod
+		)
+		if err := rows.Scan(&addr, &p.Start, &p.End); err != nil {
+			return nil, err
+		}
+		key := AddrKey(addr)
+		periods[key] = append(periods[key], p)
+	}
+	return periods, rows.Err()
+}
+
+// RecordEmptyPeriods 批量记录无积分可加的时间段：写入积分为0的计算历史并推进上次计算时间，不产生流水与入账事件
+// 每个已计算的积分周期都留下历史记录，缺口检测据此区分"未计算"与"计算结果为0"；按task_id幂等
+func RecordEmptyPeriods(calcs []PointsCalculation) error {
+	if len(calcs) == 0 {
+		return nil
+	}
+	chainName := calcs[0].ChainName
+	lastEnd := make(map[string]PointsCalculation, len(calcs))
+	var users []string
+	for _, c := range calcs {
+		if c.ChainName != chainName {
+			return fmt.Errorf("批量记录只支持单条链")
+		}
+		key := AddrKey(c.UserAddress)
+		prev, ok := lastEnd[key]
+		if !ok {
+			users = append(users, c.UserAddress)
+		}
+		if !ok || c.PeriodEnd.After(prev.PeriodEnd) {
+			lastEnd[key] = c
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return AddrKey(users[i]) < AddrKey(users[j]) })
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	args := make([]any, 0, len(users)*3)
+	for _, u := range users {
+		args = append(args, chainName, u, lastEnd[AddrKey(u)].PeriodEnd)
+	}
+	if _, err := TxExec(tx, `
+        INSERT INTO user_points (chain_name, user_address, total_points, last_calculated_at)
+        VALUES `+valuesList(len(users), "?, ?, 0, ?")+`
+        ON DUPLICATE KEY UPDATE last_calculated_at = GREATEST(last_calculated_at, VALUES(last_calculated_at))`,
+		args...); err != nil {
+		return err
+	}
+	totals, err := txLockUserPointsBatch(tx, chainName, users)
+	if err != nil {
+		return err
+	}
+
+	args = args[:0]
+	for _, c := range calcs {
+		var taskID any
+		if c.TaskID != "" {
+			taskID = c.TaskID
+		}
+		args = append(args, c.ChainName, c.UserAddress, c.PeriodStart, c.PeriodEnd,
+			decimal.Zero(), totals[AddrKey(c.UserAddress)], c.RuleVersion, taskID, nullEpochID(c.EpochID),
+			nullBlock(c.BlockEnd, c.BlockStart), nullBlock(c.BlockEnd, c.BlockEnd), segmentsJSON(c.Segments),
+			nullSeason(c.Season), pricesJSON(c.Prices))
+	}
+	if _, err := TxExec(tx, `
+        INSERT INTO points_calculation_history (
+            chain_name, user_address, period_start, period_end,
+            points_added, total_points, rule_version, task_id, epoch_id,
+            block_start, block_end, segments, season_id, prices
+        ) VALUES `+valuesList(len(calcs), "?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?")+`
+        ON DUPLICATE KEY UPDATE task_id = task_id`,
+		args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ApplyPointsBatch 在一个事务中批量入账同一条链的多条积分计算结果，返回实际入账与跳过（已入账或重叠）的记录
+// 与 UpdateUserPoints 语义一致：锁定用户积分行、按时间段去重，经同一个 txAppendLedgerBulk 写入流水、批次、
+// 总积分与跨链汇总，再记录计算历史并写入入账事件，各步均为多行语句
+// 批次内存在task_id冲突时返回 ErrPeriodApplied，调用方可退回逐条入账
+func ApplyPointsBatch(policy *Policy, calcs []PointsCalculation) (applied, skipped []PointsCalculation, err error) {
+	if len(calcs) == 0 {
+		return nil, nil, nil
+	}
+	chainName := calcs[0].ChainName
+	for _, c := range calcs {
+		if c.ChainName != chainName {
+			return nil, nil, fmt.Errorf("批量入账只支持单条链")
+		}
+	}
+
+	// 同一用户按时间段顺序入账，总积分逐条累加
+	sort.SliceStable(calcs, func(i, j int) bool {
+		ki, kj := AddrKey(calcs[i].UserAddress), AddrKey(calcs[j].UserAddress)
+		if ki != kj {
+			return ki < kj
+		}
+		return calcs[i].PeriodStart.Before(calcs[j].PeriodStart)
+	})
+	var (
+		users   []string
+		minFrom = calcs[0].PeriodStart
+		maxTo   = calcs[0].PeriodEnd
+	)
+	for i, c := range calcs {
+		if i == 0 || AddrKey(c.UserAddress) != AddrKey(calcs[i-1].UserAddress) {
+			users = append(users, c.UserAddress)
+		}
+		if c.PeriodStart.Before(minFrom) {
+			minFrom = c.PeriodStart
+		}
+		if c.PeriodEnd.After(maxTo) {
+			maxTo = c.PeriodEnd
+		}
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	// 1. 确保积分行存在并按地址顺序加锁
+	args := make([]any, 0, len(users)*3)
+	for _, u := range users {
+		args = append(args, chainName, u, minFrom)
+	}
+	if _, err := TxExec(tx, `
+        INSERT INTO user_points (chain_name, user_address, total_points, last_calculated_at)
+        VALUES `+valuesList(len(users), "?, ?, 0, ?")+`
+        ON DUPLICATE KEY UPDATE chain_name = chain_name`,
+		args...); err != nil {
+		return nil, nil, err
+	}
+	totals, err := txLockUserPointsBatch(tx, chainName, users)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 2. 锁内按时间段去重：与已入账时间段重叠的记录跳过
+	existing, err := txAppliedPeriodsForUsers(tx, chainName, users, minFrom, maxTo)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, c := range calcs {
+		key := AddrKey(c.UserAddress)
+		overlapped := false
+		for _, p := range existing[key] {
+			if p.Start.Before(c.PeriodEnd) && p.End.After(c.PeriodStart) {
+				overlapped = true
+				break
+			}
+		}
+		if overlapped {
+			skipped = append(skipped, c)
+			continue
+		}
+		// 同一批次内的时间段同样互斥
+		existing[key] = append(existing[key], TimePeriod{Start: c.PeriodStart, End: c.PeriodEnd})
+		applied = append(applied, c)
+	}
+	if len(applied) == 0 {
+		return nil, skipped, tx.Commit()
+	}
+
+	// 3. 累计流水、积分批次、赛季积分、总积分与跨链汇总，与 UpdateUserPoints 共用批量写入
+	entries := make([]LedgerEntry, len(applied))
+	for i, c := range applied {
+		entries[i] = LedgerEntry{
+			ChainName:   c.ChainName,
+			UserAddress: c.UserAddress,
+			Amount:      c.PointsAdded,
+			EntryType:   LedgerTypeAccrual,
+			Reason:      fmt.Sprintf("持有积分 %s - %s", c.PeriodStart.UTC().Format(time.RFC3339), c.PeriodEnd.UTC().Format(time.RFC3339)),
+			Actor:       LedgerActorSystem,
+			Reference:   c.TaskID,
+			Season:      c.Season,
+			EarnedAt:    c.PeriodEnd,
+		}
+	}
+	running, err := txAppendLedgerBulk(tx, policy, chainName, totals, entries)
+	if err != nil {
+		return nil, nil, err
+	}
+	lastEnd := make(map[string]time.Time, len(users))
+	for i := range applied {
+		// 赛季已结算时迟到的任务只计入终身积分
+		applied[i].Season = entries[i].Season
+		applied[i].TotalPoints = running[i]
+		key := AddrKey(applied[i].UserAddress)
+		if applied[i].PeriodEnd.After(lastEnd[key]) {
+			lastEnd[key] = applied[i].PeriodEnd
+		}
+	}
+
+	// 4. 上次计算时间（每个用户一行），回溯入账不回退
+	args = args[:0]
+	for _, u := range users {
+		if end, ok := lastEnd[AddrKey(u)]; ok {
+			args = append(args, chainName, u, end)
+		}
+	}
+	if _, err := TxExec(tx, `
+        INSERT INTO user_points (chain_name, user_address, total_points, last_calculated_at)
+        VALUES `+valuesList(len(args)/3, "?, ?, 0, ?")+`
+        ON DUPLICATE KEY UPDATE last_calculated_at = GREATEST(last_calculated_at, VALUES(last_calculated_at))`,
+		args...); err != nil {
+		return nil, nil, err
+	}
+
+	// 5. 计算历史，task_id 唯一约束兜底
+	args = args[:0]
+	for _, c := range applied {
+		args = append(args, c.ChainName, c.UserAddress, c.PeriodStart, c.PeriodEnd,
+			c.PointsAdded, c.TotalPoints, c.RuleVersion, c.TaskID, nullEpochID(c.EpochID),
+			nullBlock(c.BlockEnd, c.BlockStart), nullBlock(c.BlockEnd, c.BlockEnd), segmentsJSON(c.Segments),
+			nullSeason(c.Season), pricesJSON(c.Prices))
+	}
+	if _, err := TxExec(tx, `
+        INSERT INTO points_calculation_history (
+            chain_name, user_address, period_start, period_end,
+            points_added, total_points, rule_version, task_id, epoch_id,
+            block_start, block_end, segments, season_id, prices
+        ) VALUES `+valuesList(len(applied), "?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?"),
+		args...); err != nil {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrDuplicateEntry {
+			return nil, nil, ErrPeriodApplied
+		}
+		return nil, nil, err
+	}
+
+	// 6. 推荐奖励：推荐链与处理权重整批查询一次，奖励流水多行写入
+	// 推荐人可能属于其他分片，并发分片间偶发的死锁由任务重新入队重试
+	if err := txCreditReferrals(tx, policy, applied); err != nil {
+		return nil, nil, err
+	}
+
+	// 7. 入账事件，与积分更新同事务提交
+	events := make([]any, 0, len(applied))
+	for _, c := range applied {
+		events = append(events, PointsCreditedEvent{
+			ChainName:   c.ChainName,
+			UserAddress: c.UserAddress,
+			PeriodStart: c.PeriodStart,
+			PeriodEnd:   c.PeriodEnd,
+			PointsAdded: c.PointsAdded,
+			TotalPoints: c.TotalPoints,
+			RuleVersion: c.RuleVersion,
+		})
+	}
+	if err := txEnqueueOutboxBulk(tx, OutboxTopicPointsEvent, events); err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return applied, skipped, nil
+}
+
+// 批量锁定用户积分行，返回当前总积分（键为小写地址）
+func txLockUserPointsBatch(tx *sql.Tx, chainName string, users []string) (map[string]decimal.Decimal, error) {
+	args := []any{chainName}
+	for _, u := range users {
+		args = append(args, u)
+	}
+	rows, err := TxQuery(tx, `
+        SELECT user_address, total_points FROM user_points
+        WHERE chain_name = ? AND user_address IN `+placeholders(len(users))+`
+        ORDER BY user_address
+        FOR UPDATE`,
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	totals := make(map[string]decimal.Decimal, len(users))
+	for rows.Next() {
+		var (
+			addr  string
+			total decimal.Decimal
+		)
+		if err := rows.Scan(&addr, &total); err != nil {
+			return nil, err
+		}
+		totals[AddrKey(addr)] = total
+	}
+	return totals, rows.Err()
+}
+
+// 事务内查询用户在[start, end)内已入账的时间段（含日汇总）
+func txAppliedPeriodsForUsers(tx *sql.Tx, chainName string, users []string, start, end time.Time) (map[string][]TimePeriod, error) {
+	in := placeholders(len(users))
+	args := []any{chainName, end, start}
+	for _, u := range users {
+		args = append(args, u)
+	}
+	args = append(args, args...)
+	rows, err := TxQuery(tx, `
+        SELECT user_address, period_start, period_end FROM points_calculation_history
+        WHERE chain_name = ? AND period_start < ? AND period_end > ? AND user_address IN `+in+`
+        UNION ALL
+        SELECT user_address, period_start, period_end FROM points_history_daily
+        WHERE chain_name = ? AND period_start < ? AND period_end > ? AND user_address IN `+in,
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	periods := make(map[string][]TimePeriod)
+	for rows.Next() {
+		var (
+			addr string
+			p    TimePeriod
+		)
+		if err := rows.Scan(&addr, &p.Start, &p.End); err != nil {
+			return nil, err
+		}
+		key := AddrKey(addr)
+		periods[key] = append(periods[key], p)
+	}
+	return periods, rows.Err()
+}
+
+// IN 子句占位符：(?, ?, ?)
+func placeholders(n int) string {
+	return "(" + strings.TrimSuffix(strings.Repeat("?, ", n), ", ") + ")"
+}
+
+// 多行 VALUES 子句：(row), (row), ...
+func valuesList(n int, row string) string {
+	return strings.TrimSuffix(strings.Repeat("("+row+"), ", n), ", ")
+}
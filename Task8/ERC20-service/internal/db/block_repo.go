@@ -0,0 +1,189 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// RecordBlockBoundary 记录区块周期边界区块的链上时间，重复记录时保持不变
+func RecordBlockBoundary(chainName string, block uint64, blockTime time.Time) error {
+	_, err := Exec(`
+        INSERT INTO block_boundaries (chain_name, block_number, block_time)
+        VALUES (?, ?, ?)
+        ON DUPLICATE KEY UPDATE block_number = block_number
+    `, chainName, block, blockTime)
+	return err
+}
+
+// GetLatestBlockBoundary 获取链上已记录的最大边界区块，无记录时ok为false
+func GetLatestBlockBoundary(chainName string) (block uint64, ok bool, err error) {
+	var b sql.NullInt64
+	err = QueryRow("SELECT MAX(block_number) FROM block_boundaries WHERE chain_name = ?", chainName).Scan(&b)
+	if err != nil || !b.Valid {
+		return 0, false, err
+	}
+	return uint64(b.Int64), true, nil
+}
+
+// GetBlockBoundaries 获取链上不小于from的边界区块及其链上时间
+func GetBlockBoundaries(chainName string, from uint64) (map[uint64]time.Time, error) {
+	rows, err := Query(
+		"SELECT block_number, block_time FROM block_boundaries WHERE chain_name = ? AND block_number >= ?",
+		chainName, from,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	boundaries := make(map[uint64]time.Time)
+	for rows.Next() {
+		var (
+			block uint64
+			t     time.Time
+		)
+		if err := rows.Scan(&block, &t); err != nil {
+			return nil, err
+		}
+		boundaries[block] = t
+	}
+	return boundaries, rows.Err()
+}
+
+// GetBalanceAtBlock 获取用户在指定区块之前的余额：取区块号小于block的最后一次变动后的余额，无变动时为"0"
+func GetBalanceAtBlock(chainName, userAddr string, block uint64) (string, error) {
+	var balance string
+	err := QueryRow(`
+        SELECT balance_after FROM balance_changes
+        WHERE chain_name = ? AND user_address = ? AND block_number < ?
+        ORDER BY block_number DESC, id DESC
+        LIMIT 1
+    `, chainName, userAddr, block).Scan(&balance)
+	if err == sql.ErrNoRows {
+		return "0", nil
+	}
+	return balance, err
+}
+
+// GetBalanceChangesInBlocks 获取区块区间[from, to)内的余额变动，按区块号与记录顺序排序
+func GetBalanceChangesInBlocks(chainName, userAddr string, from, to uint64) ([]BalanceChange, error) {
+	rows, err := Query(`
+        SELECT chain_name, user_address, event_type, amount, balance_after,
+               block_number, event_time, tx_hash
+        FROM balance_changes
+        WHERE chain_name = ?
+          AND user_address = ?
+          AND block_number >= ? AND block_number < ?
+        ORDER BY block_number ASC, id ASC
+    `, chainName, userAddr, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var changes []BalanceChange
+	for rows.Next() {
+		var c BalanceChange
+		if err := rows.Scan(
+			&c.ChainName, &c.UserAddress, &c.EventType,
+			&c.Amount, &c.BalanceAfter, &c.BlockNumber,
+			&c.EventTime, &c.TxHash,
+		); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// GetStakeAtBlock 获取用户在指定区块之前的质押持仓
+func GetStakeAtBlock(chainName, userAddr string, block uint64) (StakePosition, error) {
+	var p StakePosition
+	err := QueryRow(`
+        SELECT staked_after, pending_after FROM stake_changes
+        WHERE chain_name = ? AND user_address = ? AND block_number < ?
+        ORDER BY block_number DESC, id DESC
+        LIMIT 1
+    `, chainName, userAddr, block).Scan(&p.Staked, &p.Pending)
+	if err == sql.ErrNoRows {
+		return StakePosition{Staked: "0", Pending: "0"}, nil
+	}
+	return p, err
+}
+
+// GetStakeChangesInBlocks 获取区块区间[from, to)内的质押变动，按区块号与记录顺序排序
+func GetStakeChangesInBlocks(chainName, userAddr string, from, to uint64) ([]StakeChange, error) {
+	rows, err := Query(`
+        SELECT chain_name, user_address, pool_id, event_type, amount, staked_after, pending_after,
+               block_number, event_time, tx_hash, log_index
+        FROM stake_changes
+        WHERE chain_name = ?
+          AND user_address = ?
+          AND block_number >= ? AND block_number < ?
+        ORDER BY block_number ASC, id ASC
+    `, chainName, userAddr, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var changes []StakeChange
+	for rows.Next() {
+		var c StakeChange
+		if err := rows.Scan(
+			&c.ChainName, &c.UserAddress, &c.PoolID, &c.EventType, &c.Amount,
+			&c.StakedAfter, &c.PendingAfter, &c.BlockNumber, &c.EventTime,
+			&c.TxHash, &c.LogIndex,
+		); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// GetFirstChangeBlocks 获取链上各用户（小写地址）首次余额变动的区块号
+func GetFirstChangeBlocks(chainName string) (map[string]uint64, error) {
+	rows, err := ReadQuery(`
+        SELECT user_address, MIN(block_number) FROM balance_changes
+        WHERE chain_name = ?
+        GROUP BY user_address
+    `, chainName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	blocks := make(map[string]uint64)
+	for rows.Next() {
+		var (
+			addr  string
+			block uint64
+		)
+		if err := rows.Scan(&addr, &block); err != nil {
+			return nil, err
+		}
+		blocks[AddrKey(addr)] = block
+	}
+	return blocks, rows.Err()
+}
+
+// GetLastCalculatedTimes 获取链上各用户（小写地址）的上次计算时间，无积分记录的用户不在结果中
+func GetLastCalculatedTimes(chainName string) (map[string]time.Time, error) {
+	rows, err := ReadQuery(
+		"SELECT user_address, last_calculated_at FROM user_points WHERE chain_name = ?",
+		chainName,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	times := make(map[string]time.Time)
+	for rows.Next() {
+		var (
+			addr string
+			t    time.Time
+		)
+		if err := rows.Scan(&addr, &t); err != nil {
+			return nil, err
+		}
+		times[AddrKey(addr)] = t
+	}
+	return times, rows.Err()
+}
@@ -0,0 +1,190 @@
+package db
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// 排除地址来源
+const (
+	ExclusionSourceConfig = "config" // 配置文件中的静态列表（含质押合约）
+	ExclusionSourceDB     = "db"     // 通过CLI维护的excluded_addresses表
+)
+
+// ExcludedAddress 不参与积分与持有者统计的地址
+type ExcludedAddress struct {
+	ChainName string    `json:"chain_name"`
+	Address   string    `json:"address"`
+	Reason    string    `json:"reason"`
+	Actor     string    `json:"actor,omitempty"`
+	Source    string    `json:"source"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+}
+
+// HolderStats 持有者统计（已剔除排除地址与零余额地址）
+type HolderStats struct {
+	ChainName    string `json:"chain_name"`
+	Holders      int    `json:"holders"`
+	TotalBalance string `json:"total_balance"` // 最小单位
+	Excluded     int    `json:"excluded"`      // 被剔除的持有地址数
+}
+
+// GetExcludedSet 获取链上全部排除地址（小写），合并策略中的静态列表与数据库
+func GetExcludedSet(policy *Policy, chainName string) (map[string]bool, error) {
+	set := make(map[string]bool)
+	for addr := range policy.staticExclusions(chainName) {
+		set[addr] = true
+	}
+
+	rows, err := Query("SELECT address FROM excluded_addresses WHERE chain_name = ?", chainName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var addr string
+		if err := rows.Scan(&addr); err != nil {
+			return nil, err
+		}
+		set[strings.ToLower(addr)] = true
+	}
+	return set, rows.Err()
+}
+
+// IsExcludedAddress 判断地址是否被排除
+func IsExcludedAddress(policy *Policy, chainName, addr string) (bool, error) {
+	if _, ok := policy.staticExclusions(chainName)[strings.ToLower(addr)]; ok {
+		return true, nil
+	}
+	var count int
+	err := QueryRow(
+		"SELECT COUNT(*) FROM excluded_addresses WHERE chain_name = ? AND address = ?",
+		chainName, addr,
+	).Scan(&count)
+	return count > 0, err
+}
+
+// GetEligibleUsersByChain 获取链上参与积分计算的用户（剔除排除地址）
+func GetEligibleUsersByChain(policy *Policy, chainName string) ([]string, error) {
+	users, err := GetUsersByChain(chainName)
+	if err != nil {
+		return nil, err
+	}
+	excluded, err := GetExcludedSet(policy, chainName)
+	if err != nil {
+		return nil, err
+	}
+	eligible := users[:0]
+	for _, u := range users {
+		if !excluded[strings.ToLower(u)] {
+			eligible = append(eligible, u)
+		}
+	}
+	return eligible, nil
+}
+
+// AddExcludedAddress 添加数据库排除地址，已存在时更新原因
+func AddExcludedAddress(e ExcludedAddress) error {
+	_, err := Exec(`
+        INSERT INTO excluded_addresses (chain_name, address, reason, actor)
+        VALUES (?, ?, ?, ?)
+        ON DUPLICATE KEY UPDATE reason = VALUES(reason), actor = VALUES(actor)
+    `, e.ChainName, e.Address, e.Reason, e.Actor)
+	return err
+}
+
+// RemoveExcludedAddress 删除数据库排除地址，返回是否存在；配置文件中的地址只能修改配置移除
+func RemoveExcludedAddress(chainName, addr string) (bool, error) {
+	res, err := Exec(
+		"DELETE FROM excluded_addresses WHERE chain_name = ? AND address = ?",
+		chainName, addr,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ListExcludedAddresses 列出排除地址（静态与数据库），chainName为空时列出全部链
+func ListExcludedAddresses(policy *Policy, chainName string) ([]ExcludedAddress, error) {
+	var list []ExcludedAddress
+	var static map[string]map[string]string
+	if policy != nil {
+		static = policy.exclusions
+	}
+	for chain, set := range static {
+		if chainName != "" && chain != chainName {
+			continue
+		}
+		for addr, reason := range set {
+			list = append(list, ExcludedAddress{ChainName: chain, Address: addr, Reason: reason, Source: ExclusionSourceConfig})
+		}
+	}
+
+	query := "SELECT chain_name, address, reason, actor, created_at FROM excluded_addresses"
+	var args []any
+	if chainName != "" {
+		query += " WHERE chain_name = ?"
+		args = append(args, chainName)
+	}
+	rows, err := ReadQuery(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := ExcludedAddress{Source: ExclusionSourceDB}
+		if err := rows.Scan(&e.ChainName, &e.Address, &e.Reason, &e.Actor, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].ChainName != list[j].ChainName {
+			return list[i].ChainName < list[j].ChainName
+		}
+		return strings.ToLower(list[i].Address) < strings.ToLower(list[j].Address)
+	})
+	return list, nil
+}
+
+// GetHolderStats 统计链上持有者数量与持有总量，剔除排除地址与零余额地址
+func GetHolderStats(policy *Policy, chainName string) (HolderStats, error) {
+	stats := HolderStats{ChainName: chainName, TotalBalance: "0"}
+	excluded, err := GetExcludedSet(policy, chainName)
+	if err != nil {
+		return stats, err
+	}
+
+	rows, err := ReadQuery(`
+        SELECT user_address, CAST(current_balance AS DECIMAL(65,0))
+        FROM user_balances
+        WHERE chain_name = ? AND CAST(current_balance AS DECIMAL(65,0)) > 0
+    `, chainName)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	total := parseWei("0")
+	for rows.Next() {
+		var addr, balance string
+		if err := rows.Scan(&addr, &balance); err != nil {
+			return stats, err
+		}
+		if excluded[strings.ToLower(addr)] {
+			stats.Excluded++
+			continue
+		}
+		stats.Holders++
+		total.Add(total, parseWei(balance))
+	}
+	stats.TotalBalance = total.String()
+	return stats, rows.Err()
+}
@@ -0,0 +1,160 @@
+package db
+
+import (
+	"database/sql"
+	"erc20-service/config"
+	"erc20-service/pkg/decimal"
+	"time"
+)
+
+// LeaderboardGlobal 跨链总榜名称，其余榜单以链名命名；配置加载时拒绝同名的链
+const LeaderboardGlobal = config.GlobalBoard
+
+// LeaderboardEntry 排行榜条目
+type LeaderboardEntry struct {
+	Rank        int             `json:"rank"`
+	UserAddress string          `json:"user_address"`
+	TotalPoints decimal.Decimal `json:"total_points"`
+	PrevRank    *int            `json:"prev_rank"`   // 前一日最终排名，新上榜为空
+	RankChange  *int            `json:"rank_change"` // 排名变化，正数表示上升
+}
+
+// RefreshLeaderboard 按当前积分更新榜单排名，返回上榜人数；同分同名次（RANK）
+// 只写入排名或积分有变化的行并删除落榜用户，每次刷新的写入量与变化量成正比；
+// 当天首次刷新时先把榜单（即前一次刷新所在日的最终排名）写入快照，快照每天只写一次
+// 在同一事务中完成，读取方不会看到中间状态；榜单行加锁使并发刷新串行执行
+func RefreshLeaderboard(board string, now time.Time) (int64, error) {
+	source := `
+        SELECT user_address, total_points,
+               RANK() OVER (ORDER BY total_points DESC) AS rank_no
+        FROM user_points WHERE chain_name = ? AND total_points > 0`
+	sourceArgs := []any{board}
+	if board == LeaderboardGlobal {
+		source = `
+        SELECT user_address, total_points,
+               RANK() OVER (ORDER BY total_points DESC) AS rank_no
+        FROM user_points_aggregate WHERE total_points > 0`
+		sourceArgs = nil
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := TxExec(tx, "INSERT IGNORE INTO leaderboard_boards (board) VALUES (?)", board); err != nil {
+		return 0, err
+	}
+	var last sql.NullTime
+	if err := TxQueryRow(tx, `
+        SELECT refreshed_at FROM leaderboard_boards WHERE board = ? FOR UPDATE
+    `, board).Scan(&last); err != nil {
+		return 0, err
+	}
+
+	// 跨日后的首次刷新：榜单仍是上次刷新的结果，作为上次刷新所在日的快照
+	if last.Valid && snapshotDay(last.Time) != snapshotDay(now) {
+		if _, err := TxExec(tx, `
+            INSERT IGNORE INTO leaderboard_snapshots (board, day, user_address, total_points, rank_no)
+            SELECT board, ?, user_address, total_points, rank_no
+            FROM leaderboard_ranks WHERE board = ?
+        `, snapshotDay(last.Time), board); err != nil {
+			return 0, err
+		}
+	}
+
+	// 删除落榜用户
+	args := append([]any{}, sourceArgs...)
+	if _, err := TxExec(tx, `
+        DELETE r FROM leaderboard_ranks r
+        LEFT JOIN (`+source+`) s ON s.user_address = r.user_address
+        WHERE r.board = ? AND s.user_address IS NULL
+    `, append(args, board)...); err != nil {
+		return 0, err
+	}
+
+	// 只写入新上榜或排名、积分有变化的用户
+	args = append([]any{board, now}, sourceArgs...)
+	if _, err := TxExec(tx, `
+        INSERT INTO leaderboard_ranks (board, user_address, total_points, rank_no, refreshed_at)
+        SELECT ?, s.user_address, s.total_points, s.rank_no, ?
+        FROM (`+source+`) s
+        LEFT JOIN leaderboard_ranks r ON r.board = ? AND r.user_address = s.user_address
+        WHERE r.id IS NULL OR r.rank_no <> s.rank_no OR r.total_points <> s.total_points
+        ON DUPLICATE KEY UPDATE
+            total_points = VALUES(total_points),
+            rank_no = VALUES(rank_no),
+            refreshed_at = VALUES(refreshed_at)
+    `, append(args, board)...); err != nil {
+		return 0, err
+	}
+
+	if _, err := TxExec(tx, "UPDATE leaderboard_boards SET refreshed_at = ? WHERE board = ?", now, board); err != nil {
+		return 0, err
+	}
+	var n int64
+	if err := TxQueryRow(tx, "SELECT COUNT(*) FROM leaderboard_ranks WHERE board = ?", board).Scan(&n); err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// GetLeaderboard 分页读取榜单，附带与前一日快照相比的排名变化
+func GetLeaderboard(board string, offset, limit int) ([]LeaderboardEntry, error) {
+	prevDay := snapshotDay(time.Now().AddDate(0, 0, -1))
+	rows, err := ReadQuery(`
+        SELECT r.rank_no, r.user_address, r.total_points, s.rank_no
+        FROM leaderboard_ranks r
+        LEFT JOIN leaderboard_snapshots s
+          ON s.board = r.board AND s.day = ? AND s.user_address = r.user_address
+        WHERE r.board = ?
+        ORDER BY r.rank_no ASC, r.user_address ASC
+        LIMIT ? OFFSET ?
+    `, prevDay, board, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []LeaderboardEntry
+	for rows.Next() {
+		var (
+			e    LeaderboardEntry
+			prev sql.NullInt64
+		)
+		if err := rows.Scan(&e.Rank, &e.UserAddress, &e.TotalPoints, &prev); err != nil {
+			return nil, err
+		}
+		if prev.Valid {
+			p := int(prev.Int64)
+			change := p - e.Rank
+			e.PrevRank, e.RankChange = &p, &change
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// CountLeaderboard 统计榜单人数与最近刷新时间
+func CountLeaderboard(board string) (count int, refreshedAt *time.Time, err error) {
+	err = ReadQueryRow(`
+        SELECT (SELECT COUNT(*) FROM leaderboard_ranks WHERE board = ?),
+               (SELECT refreshed_at FROM leaderboard_boards WHERE board = ?)
+    `, board, board).Scan(&count, &refreshedAt)
+	return count, refreshedAt, err
+}
+
+// PruneLeaderboardSnapshots 清理早于指定日期的榜单快照
+func PruneLeaderboardSnapshots(before time.Time) (int64, error) {
+	res, err := Exec("DELETE FROM leaderboard_snapshots WHERE day < ?", snapshotDay(before))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// 快照日期按UTC自然日划分
+func snapshotDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
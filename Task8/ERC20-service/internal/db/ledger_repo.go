@@ -0,0 +1,410 @@
+package db
+
+import (
+	"database/sql"
+	"erc20-service/pkg/decimal"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 积分流水类型
+const (
+	LedgerTypeOpening  = "opening"  // 迁移前的累计积分
+	LedgerTypeAccrual  = "accrual"  // 持有积分自动累计
+	LedgerTypeGrant    = "grant"    // 人工补发
+	LedgerTypeRevoke   = "revoke"   // 人工扣回
+	LedgerTypeExpire   = "expire"   // 积分批次到期
+	LedgerTypeDecay    = "decay"    // 不活跃账户按月衰减
+	LedgerTypeReferral = "referral" // 被推荐人持有积分的推荐奖励
+)
+
+// LedgerActorSystem 系统自动入账的操作人
+const LedgerActorSystem = "system"
+
+// ErrInsufficientPoints 扣回后总积分将为负
+var ErrInsufficientPoints = errors.New("用户积分不足")
+
+// LedgerEntry 积分流水，金额带符号，用户总积分为流水之和
+type LedgerEntry struct {
+	ID          int64           `json:"id"`
+	ChainName   string          `json:"chain_name"`
+	UserAddress string          `json:"user_address"`
+	Amount      decimal.Decimal `json:"amount"`
+	EntryType   string          `json:"entry_type"`
+	Reason      string          `json:"reason"`
+	Actor       string          `json:"actor"`
+	Reference   string          `json:"reference"`        // 关联标识，如任务ID、工单号、交易哈希
+	Season      string          `json:"season,omitempty"` // 计入的赛季，为空表示只计入终身积分
+	CreatedAt   time.Time       `json:"created_at"`
+	EarnedAt    time.Time       `json:"earned_at"` // 积分的获得时间（计算周期结束时间），为空时取当前时间；正数流水形成的批次也按此时间到期
+}
+
+// PointsAdjustedEvent 人工调整积分领域事件
+type PointsAdjustedEvent struct {
+	LedgerEntry
+	TotalPoints decimal.Decimal `json:"total_points"`
+}
+
+// 确保用户积分行存在并加锁，返回当前总积分；同一用户的所有积分变更经此串行化
+func txLockUserPoints(tx *sql.Tx, chainName, userAddr string, at time.Time) (decimal.Decimal, error) {
+	_, err := TxExec(tx, `
+        INSERT INTO user_points (chain_name, user_address, total_points, last_calculated_at)
+        VALUES (?, ?, 0, ?)
+        ON DUPLICATE KEY UPDATE chain_name = chain_name
+    `, chainName, userAddr, at)
+	if err != nil {
+		return decimal.Zero(), err
+	}
+	var total decimal.Decimal
+	err = TxQueryRow(tx, `
+        SELECT total_points FROM user_points
+        WHERE chain_name = ? AND user_address = ?
+        FOR UPDATE
+    `, chainName, userAddr).Scan(&total)
+	return total, err
+}
+
+// 写入一条流水并增量更新用户总积分，返回写入后的总积分，需先调用 txLockUserPoints 加锁
+func txAppendLedger(tx *sql.Tx, policy *Policy, entry LedgerEntry) (decimal.Decimal, error) {
+	var current decimal.Decimal
+	err := TxQueryRow(tx, `
+        SELECT total_points FROM user_points
+        WHERE chain_name = ? AND user_address = ?
+    `, entry.ChainName, entry.UserAddress).Scan(&current)
+	if err != nil {
+		return decimal.Zero(), err
+	}
+	totals, err := txAppendLedgerBulk(tx, policy, entry.ChainName,
+		map[string]decimal.Decimal{AddrKey(entry.UserAddress): current}, []LedgerEntry{entry})
+	if err != nil {
+		return decimal.Zero(), err
+	}
+	return totals[0], nil
+}
+
+// 批量写入同一条链的流水并增量更新总积分、赛季积分与跨链汇总，返回每条流水写入后的用户总积分
+// 涉及用户的积分行需已加锁，current 为加锁时读取的总积分（键为小写地址），按流水顺序逐条累加
+// 正数流水形成新的积分批次，负数流水在本批次的批次创建后按先进先出扣减批次余量；
+// 流水指定的赛季已结算时，entries 中对应流水的赛季被清空
+func txAppendLedgerBulk(tx *sql.Tx, policy *Policy, chainName string, current map[string]decimal.Decimal, entries []LedgerEntry) ([]decimal.Decimal, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	// 1. 赛季积分
+	var seasonDeltas []seasonDelta
+	for _, e := range entries {
+		if e.Season != "" {
+			seasonDeltas = append(seasonDeltas, seasonDelta{
+				season:     e.Season,
+				user:       e.UserAddress,
+				amount:     e.Amount,
+				multiplied: seasonMultiplied(e.EntryType),
+			})
+		}
+	}
+	credited, err := txAddSeasonPoints(tx, chainName, seasonDeltas)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. 流水，按用户累加总积分
+	var (
+		users  []string
+		before = make(map[string]decimal.Decimal)
+		totals = make([]decimal.Decimal, len(entries))
+	)
+	args := make([]any, 0, len(entries)*9)
+	now := time.Now()
+	for i := range entries {
+		e := &entries[i]
+		if e.ChainName != chainName {
+			return nil, fmt.Errorf("批量写入流水只支持单条链")
+		}
+		if !credited[e.Season] {
+			e.Season = ""
+		}
+		key := AddrKey(e.UserAddress)
+		if _, ok := before[key]; !ok {
+			before[key] = current[key]
+			users = append(users, e.UserAddress)
+		}
+		if e.EarnedAt.IsZero() {
+			e.EarnedAt = now
+		}
+		current[key] = current[key].Add(e.Amount)
+		totals[i] = current[key]
+		args = append(args, e.ChainName, e.UserAddress, e.Amount, e.EntryType, e.Reason, e.Actor, e.Reference, nullSeason(e.Season), e.EarnedAt)
+	}
+	res, err := TxExec(tx, `
+        INSERT INTO points_ledger (chain_name, user_address, amount, entry_type, reason, actor, reference, season_id, earned_at)
+        VALUES `+valuesList(len(entries), "?, ?, ?, ?, ?, ?, ?, ?, ?"),
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	firstID, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	ids, err := txLedgerIDs(tx, chainName, users, firstID, entries)
+	if err != nil {
+		return nil, err
+	}
+
+	// 3. 积分批次：先创建正数流水的批次，再按顺序扣减负数流水
+	args = args[:0]
+	var lots int
+	for i, e := range entries {
+		if e.Amount.Sign() <= 0 {
+			continue
+		}
+		args = append(args, e.ChainName, e.UserAddress, ids[i], e.EarnedAt, e.Amount, e.Amount)
+		lots++
+	}
+	if lots > 0 {
+		if _, err := TxExec(tx, `
+            INSERT INTO points_lots (chain_name, user_address, ledger_id, earned_at, amount, remaining)
+            VALUES `+valuesList(lots, "?, ?, ?, ?, ?, ?"),
+			args...); err != nil {
+			return nil, err
+		}
+	}
+	for i, e := range entries {
+		if e.Amount.Sign() < 0 {
+			if err := txConsumeLots(tx, ids[i], e.ChainName, e.UserAddress, e.Amount.Neg()); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// 4. 总积分与跨链汇总（每个用户一行），积分行已加锁，按增量更新
+	args = args[:0]
+	aggArgs := make([]any, 0, len(users)*2)
+	for _, u := range users {
+		key := AddrKey(u)
+		args = append(args, chainName, u, current[key].Sub(before[key]))
+		aggArgs = append(aggArgs, u, weightedPoints(policy, chainName, current[key]).Sub(weightedPoints(policy, chainName, before[key])))
+	}
+	if _, err := TxExec(tx, `
+        INSERT INTO user_points (chain_name, user_address, total_points, last_calculated_at)
+        VALUES `+valuesList(len(users), "?, ?, ?, CURRENT_TIMESTAMP")+`
+        ON DUPLICATE KEY UPDATE
+            total_points = total_points + VALUES(total_points),
+            updated_at = CURRENT_TIMESTAMP`,
+		args...); err != nil {
+		return nil, err
+	}
+	// 跨链汇总按加权总积分之差增量更新，汇总始终等于 Σ 各链加权总积分
+	if _, err := TxExec(tx, `
+        INSERT INTO user_points_aggregate (user_address, total_points)
+        VALUES `+valuesList(len(users), "?, ?")+`
+        ON DUPLICATE KEY UPDATE total_points = total_points + VALUES(total_points)`,
+		aggArgs...); err != nil {
+		return nil, err
+	}
+	return totals, nil
+}
+
+// 取回本次写入的流水ID（与entries顺序一致），firstID为本次首条流水ID
+// 涉及用户的积分行已加锁，其他事务不会并发写入这些用户的流水，因此id不小于firstID的流水均为本次写入
+func txLedgerIDs(tx *sql.Tx, chainName string, users []string, firstID int64, entries []LedgerEntry) ([]int64, error) {
+	args := []any{chainName, firstID}
+	for _, u := range users {
+		args = append(args, u)
+	}
+	rows, err := TxQuery(tx, `
+        SELECT id, user_address FROM points_ledger
+        WHERE chain_name = ? AND id >= ? AND user_address IN `+placeholders(len(users))+`
+        ORDER BY id`,
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	byUser := make(map[string][]int64, len(users))
+	for rows.Next() {
+		var (
+			id   int64
+			addr string
+		)
+		if err := rows.Scan(&id, &addr); err != nil {
+			return nil, err
+		}
+		byUser[AddrKey(addr)] = append(byUser[AddrKey(addr)], id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	ids := make([]int64, len(entries))
+	for i, e := range entries {
+		key := AddrKey(e.UserAddress)
+		if len(byUser[key]) == 0 {
+			return nil, fmt.Errorf("未找到 %s 的流水", e.UserAddress)
+		}
+		ids[i], byUser[key] = byUser[key][0], byUser[key][1:]
+	}
+	return ids, nil
+}
+
+// AdjustPoints 人工补发或扣回积分，返回调整后的总积分
+// 扣回金额超过当前总积分时返回 ErrInsufficientPoints
+func AdjustPoints(policy *Policy, entry LedgerEntry) (decimal.Decimal, error) {
+	switch entry.EntryType {
+	case LedgerTypeGrant:
+		if entry.Amount.Sign() <= 0 {
+			return decimal.Zero(), fmt.Errorf("补发金额必须为正")
+		}
+	case LedgerTypeRevoke:
+		if entry.Amount.Sign() >= 0 {
+			return decimal.Zero(), fmt.Errorf("扣回金额必须为负")
+		}
+	default:
+		return decimal.Zero(), fmt.Errorf("不支持的调整类型: %s", entry.EntryType)
+	}
+	if entry.Season != "" {
+		if err := CheckSeasonWritable(entry.Season); err != nil {
+			return decimal.Zero(), err
+		}
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return decimal.Zero(), err
+	}
+	defer tx.Rollback()
+
+	current, err := txLockUserPoints(tx, entry.ChainName, entry.UserAddress, time.Now())
+	if err != nil {
+		return decimal.Zero(), err
+	}
+	if current.Add(entry.Amount).Sign() < 0 {
+		return current, ErrInsufficientPoints
+	}
+
+	total, err := txAppendLedger(tx, policy, entry)
+	if err != nil {
+		return decimal.Zero(), err
+	}
+
+	entry.CreatedAt = time.Now()
+	if err := TxEnqueueOutbox(tx, OutboxTopicPointsAdjusted, PointsAdjustedEvent{
+		LedgerEntry: entry,
+		TotalPoints: total,
+	}); err != nil {
+		return decimal.Zero(), err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return decimal.Zero(), err
+	}
+	return total, nil
+}
+
+// GetLedgerEntries 获取用户积分流水，按时间倒序
+func GetLedgerEntries(chainName, userAddr string, limit int) ([]LedgerEntry, error) {
+	rows, err := Query(`
+        SELECT id, chain_name, user_address, amount, entry_type, reason, actor, reference,
+               COALESCE(season_id, ''), created_at, earned_at
+        FROM points_ledger
+        WHERE chain_name = ? AND user_address = ?
+        ORDER BY id DESC
+        LIMIT ?
+    `, chainName, userAddr, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []LedgerEntry
+	for rows.Next() {
+		var e LedgerEntry
+		if err := rows.Scan(
+			&e.ID, &e.ChainName, &e.UserAddress, &e.Amount, &e.EntryType,
+			&e.Reason, &e.Actor, &e.Reference, &e.Season, &e.CreatedAt, &e.EarnedAt,
+		); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// LedgerBalance 截至某时刻的用户积分余额
+type LedgerBalance struct {
+	ChainName   string
+	UserAddress string
+	Points      decimal.Decimal
+}
+
+// GetLedgerBalancesAt 按流水汇总截至cutoff（含）获得的各链用户积分，chainName为空时返回全部链
+// 按获得时间而不是记账时间截止：截止前的周期在截止后才补算或回填入账的积分同样计入
+func GetLedgerBalancesAt(cutoff time.Time, chainName string) ([]LedgerBalance, error) {
+	query := `
+        SELECT chain_name, user_address, SUM(amount) FROM points_ledger
+        WHERE earned_at <= ?`
+	args := []any{cutoff}
+	if chainName != "" {
+		query += " AND chain_name = ?"
+		args = append(args, chainName)
+	}
+	query += `
+        GROUP BY chain_name, user_address
+        ORDER BY chain_name, user_address`
+
+	rows, err := Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var balances []LedgerBalance
+	for rows.Next() {
+		var b LedgerBalance
+		if err := rows.Scan(&b.ChainName, &b.UserAddress, &b.Points); err != nil {
+			return nil, err
+		}
+		balances = append(balances, b)
+	}
+	return balances, rows.Err()
+}
+
+// LedgerMismatch 总积分与流水汇总不一致的用户
+type LedgerMismatch struct {
+	ChainName   string          `json:"chain_name"`
+	UserAddress string          `json:"user_address"`
+	TotalPoints decimal.Decimal `json:"total_points"`
+	LedgerSum   decimal.Decimal `json:"ledger_sum"`
+}
+
+// GetLedgerMismatches 核对链上各用户的 total_points 与 SUM(流水)，返回不一致的用户（最多limit个）
+// 流水是积分的记账依据，total_points 只是随流水在同一事务中增量维护的汇总，出现不一致说明有绕过流水的写入
+func GetLedgerMismatches(chainName string, limit int) ([]LedgerMismatch, error) {
+	rows, err := Query(`
+        SELECT p.user_address, p.total_points, COALESCE(g.total, 0)
+        FROM user_points p
+        LEFT JOIN (
+            SELECT user_address, SUM(amount) AS total FROM points_ledger
+            WHERE chain_name = ?
+            GROUP BY user_address
+        ) g ON g.user_address = p.user_address
+        WHERE p.chain_name = ? AND p.total_points <> COALESCE(g.total, 0)
+        ORDER BY p.user_address
+        LIMIT ?
+    `, chainName, chainName, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []LedgerMismatch
+	for rows.Next() {
+		m := LedgerMismatch{ChainName: chainName}
+		if err := rows.Scan(&m.UserAddress, &m.TotalPoints, &m.LedgerSum); err != nil {
+			return nil, err
+		}
+		list = append(list, m)
+	}
+	return list, rows.Err()
+}
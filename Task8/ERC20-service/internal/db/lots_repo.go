@@ -0,0 +1,285 @@
+package db
+
+import (
+	"database/sql"
+	"erc20-service/pkg/decimal"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// PointsLot 积分批次：每条正数流水形成一个批次，按获得时间先进先出扣减
+type PointsLot struct {
+	ID          int64           `json:"id"`
+	ChainName   string          `json:"chain_name"`
+	UserAddress string          `json:"user_address"`
+	LedgerID    int64           `json:"ledger_id"`
+	EarnedAt    time.Time       `json:"earned_at"`
+	Amount      decimal.Decimal `json:"amount"`
+	Remaining   decimal.Decimal `json:"remaining"`
+}
+
+// UserKey 链与用户
+type UserKey struct {
+	ChainName   string
+	UserAddress string
+}
+
+// ExpiringPoints 即将到期的积分汇总
+type ExpiringPoints struct {
+	ChainName    string          `json:"chain_name"`
+	UserAddress  string          `json:"user_address"`
+	Amount       decimal.Decimal `json:"amount"`
+	NextExpiryAt time.Time       `json:"next_expiry_at"`
+	Lots         int             `json:"lots"`
+}
+
+// 按获得时间先进先出扣减批次余量，并记录每个批次被哪条流水扣减了多少
+// 批次余量不足时（如批次追踪上线前的历史扣减）只扣减现有余量
+func txConsumeLots(tx *sql.Tx, ledgerID int64, chainName, userAddr string, amount decimal.Decimal) error {
+	rows, err := TxQuery(tx, `
+        SELECT id, remaining FROM points_lots
+        WHERE chain_name = ? AND user_address = ? AND remaining > 0
+        ORDER BY earned_at ASC, id ASC
+        FOR UPDATE
+    `, chainName, userAddr)
+	if err != nil {
+		return err
+	}
+	type usage struct {
+		lotID  int64
+		amount decimal.Decimal
+	}
+	var usages []usage
+	left := amount
+	for left.Sign() > 0 && rows.Next() {
+		var (
+			lotID     int64
+			remaining decimal.Decimal
+		)
+		if err := rows.Scan(&lotID, &remaining); err != nil {
+			rows.Close()
+			return err
+		}
+		take := remaining
+		if take.Cmp(left) > 0 {
+			take = left
+		}
+		usages = append(usages, usage{lotID: lotID, amount: take})
+		left = left.Sub(take)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	for _, u := range usages {
+		if _, err := TxExec(tx,
+			"UPDATE points_lots SET remaining = remaining - ? WHERE id = ?",
+			u.amount, u.lotID,
+		); err != nil {
+			return err
+		}
+		if _, err := TxExec(tx,
+			"INSERT INTO points_lot_usage (lot_id, ledger_id, amount) VALUES (?, ?, ?)",
+			u.lotID, ledgerID, u.amount,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetUsersWithExpiredLots 获取存在早于earnedBefore获得且未用完批次的用户
+func GetUsersWithExpiredLots(earnedBefore time.Time) ([]UserKey, error) {
+	rows, err := Query(`
+        SELECT DISTINCT chain_name, user_address FROM points_lots
+        WHERE earned_at < ? AND remaining > 0
+    `, earnedBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanUserKeys(rows)
+}
+
+// ExpirePoints 将用户早于earnedBefore获得的剩余积分记为到期流水，返回到期数量
+// 到期批次是最早的批次，先进先出扣减恰好用完这些批次
+func ExpirePoints(policy *Policy, chainName, userAddr string, earnedBefore time.Time) (decimal.Decimal, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return decimal.Zero(), err
+	}
+	defer tx.Rollback()
+
+	if _, err := txLockUserPoints(tx, chainName, userAddr, time.Now()); err != nil {
+		return decimal.Zero(), err
+	}
+	var expired decimal.Decimal
+	err = TxQueryRow(tx, `
+        SELECT COALESCE(SUM(remaining), 0) FROM points_lots
+        WHERE chain_name = ? AND user_address = ? AND earned_at < ? AND remaining > 0
+    `, chainName, userAddr, earnedBefore).Scan(&expired)
+	if err != nil {
+		return decimal.Zero(), err
+	}
+	if expired.Sign() <= 0 {
+		return decimal.Zero(), nil
+	}
+
+	if _, err := txAppendLedger(tx, policy, LedgerEntry{
+		ChainName:   chainName,
+		UserAddress: userAddr,
+		Amount:      expired.Neg(),
+		EntryType:   LedgerTypeExpire,
+		Reason:      fmt.Sprintf("%s 之前获得的积分到期", earnedBefore.UTC().Format(time.RFC3339)),
+		Actor:       LedgerActorSystem,
+	}); err != nil {
+		return decimal.Zero(), err
+	}
+	return expired, tx.Commit()
+}
+
+// GetDecayCandidates 获取需要衰减的不活跃用户：有积分、最近一次获得积分早于activeSince、本期尚未衰减
+func GetDecayCandidates(activeSince time.Time, reference string) ([]UserKey, error) {
+	rows, err := Query(`
+        SELECT p.chain_name, p.user_address FROM user_points p
+        WHERE p.total_points > 0
+        AND NOT EXISTS (
+            SELECT 1 FROM points_lots l
+            WHERE l.chain_name = p.chain_name AND l.user_address = p.user_address
+            AND l.earned_at >= ?)
+        AND NOT EXISTS (
+            SELECT 1 FROM points_ledger g
+            WHERE g.chain_name = p.chain_name AND g.user_address = p.user_address
+            AND g.reference = ?)
+    `, activeSince, reference)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanUserKeys(rows)
+}
+
+// DecayPoints 按比例衰减用户积分，reference标识衰减周期（如decay:2024-06），同一周期只衰减一次
+func DecayPoints(policy *Policy, chainName, userAddr string, percent *big.Rat, reference string) (decimal.Decimal, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return decimal.Zero(), err
+	}
+	defer tx.Rollback()
+
+	total, err := txLockUserPoints(tx, chainName, userAddr, time.Now())
+	if err != nil {
+		return decimal.Zero(), err
+	}
+	var applied int
+	err = TxQueryRow(tx, `
+        SELECT COUNT(*) FROM points_ledger
+        WHERE chain_name = ? AND user_address = ? AND reference = ?
+    `, chainName, userAddr, reference).Scan(&applied)
+	if err != nil {
+		return decimal.Zero(), err
+	}
+	if applied > 0 || total.Sign() <= 0 {
+		return decimal.Zero(), nil
+	}
+
+	decayed := total.MulRat(new(big.Rat).Quo(percent, big.NewRat(100, 1)))
+	if decayed.Sign() <= 0 {
+		return decimal.Zero(), nil
+	}
+	if _, err := txAppendLedger(tx, policy, LedgerEntry{
+		ChainName:   chainName,
+		UserAddress: userAddr,
+		Amount:      decayed.Neg(),
+		EntryType:   LedgerTypeDecay,
+		Reason:      fmt.Sprintf("不活跃账户积分衰减 %s%%", percent.FloatString(2)),
+		Actor:       LedgerActorSystem,
+		Reference:   reference,
+	}); err != nil {
+		return decimal.Zero(), err
+	}
+	return decayed, tx.Commit()
+}
+
+// GetUpcomingExpirations 按用户汇总将在[now, now+within)到期的积分，chainName、userAddr为空时不过滤
+func GetUpcomingExpirations(chainName, userAddr string, expiryDays int, within time.Duration) ([]ExpiringPoints, error) {
+	// 到期时间 = 获得时间 + 有效期，换算为获得时间区间
+	earnedFrom := time.Now().AddDate(0, 0, -expiryDays)
+	query := `
+        SELECT chain_name, user_address, SUM(remaining), MIN(earned_at), COUNT(*)
+        FROM points_lots
+        WHERE remaining > 0 AND earned_at >= ? AND earned_at < ?`
+	args := []any{earnedFrom, earnedFrom.Add(within)}
+	if chainName != "" {
+		query += " AND chain_name = ?"
+		args = append(args, chainName)
+	}
+	if userAddr != "" {
+		query += " AND user_address = ?"
+		args = append(args, userAddr)
+	}
+	query += `
+        GROUP BY chain_name, user_address
+        ORDER BY MIN(earned_at) ASC`
+
+	rows, err := ReadQuery(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []ExpiringPoints
+	for rows.Next() {
+		var (
+			e        ExpiringPoints
+			earliest time.Time
+		)
+		if err := rows.Scan(&e.ChainName, &e.UserAddress, &e.Amount, &earliest, &e.Lots); err != nil {
+			return nil, err
+		}
+		e.NextExpiryAt = earliest.AddDate(0, 0, expiryDays)
+		result = append(result, e)
+	}
+	return result, rows.Err()
+}
+
+// GetOpenLots 获取用户未用完的积分批次，按获得时间排序
+func GetOpenLots(chainName, userAddr string) ([]PointsLot, error) {
+	rows, err := ReadQuery(`
+        SELECT id, chain_name, user_address, ledger_id, earned_at, amount, remaining
+        FROM points_lots
+        WHERE chain_name = ? AND user_address = ? AND remaining > 0
+        ORDER BY earned_at ASC, id ASC
+    `, chainName, userAddr)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lots []PointsLot
+	for rows.Next() {
+		var l PointsLot
+		if err := rows.Scan(&l.ID, &l.ChainName, &l.UserAddress, &l.LedgerID, &l.EarnedAt, &l.Amount, &l.Remaining); err != nil {
+			return nil, err
+		}
+		lots = append(lots, l)
+	}
+	return lots, rows.Err()
+}
+
+func scanUserKeys(rows *Rows) ([]UserKey, error) {
+	var keys []UserKey
+	for rows.Next() {
+		var k UserKey
+		if err := rows.Scan(&k.ChainName, &k.UserAddress); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
@@ -0,0 +1,210 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"erc20-service/pkg/decimal"
+	"fmt"
+	"time"
+)
+
+// 发件箱主题
+const (
+	OutboxTopicPointsTask     = "points_task"       // 积分计算任务
+	OutboxTopicPointsBatch    = "points_batch_task" // 分片批量积分计算任务
+	OutboxTopicPointsEvent    = "points_credited"   // 积分入账领域事件
+	OutboxTopicBalanceEvent   = "balance_changed"   // 余额变动领域事件
+	OutboxTopicPointsAdjusted = "points_adjusted"   // 人工调整积分领域事件
+	outboxStatusPending       = "pending"
+	outboxStatusSent          = "sent"
+	outboxStatusDead          = "dead"
+	outboxLastErrorMaxLength  = 500
+)
+
+// OutboxMessage 发件箱消息
+type OutboxMessage struct {
+	ID       int64
+	Topic    string
+	Payload  []byte
+	Attempts int
+}
+
+// TxEnqueueOutbox 在事务中写入发件箱消息
+func TxEnqueueOutbox(tx *sql.Tx, topic string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("序列化发件箱消息失败: %v", err)
+	}
+	_, err = TxExec(tx,
+		"INSERT INTO outbox (topic, payload) VALUES (?, ?)",
+		topic, data,
+	)
+	return err
+}
+
+// 在事务中以一条多行语句写入同一主题的多条发件箱消息
+func txEnqueueOutboxBulk(tx *sql.Tx, topic string, payloads []any) error {
+	if len(payloads) == 0 {
+		return nil
+	}
+	args := make([]any, 0, len(payloads)*2)
+	for _, payload := range payloads {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("序列化发件箱消息失败: %v", err)
+		}
+		args = append(args, topic, data)
+	}
+	_, err := TxExec(tx, "INSERT INTO outbox (topic, payload) VALUES "+valuesList(len(payloads), "?, ?"), args...)
+	return err
+}
+
+// EnqueueOutbox 在独立事务中批量写入发件箱消息
+func EnqueueOutbox(topic string, payloads ...any) error {
+	if len(payloads) == 0 {
+		return nil
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, payload := range payloads {
+		if err := TxEnqueueOutbox(tx, topic, payload); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ClaimPendingOutbox 领取到期待投递的发件箱消息
+// 加锁读取时跳过其他中继已锁定的行，并将领取的消息的next_attempt_at推迟lease，
+// 多个daemon同时运行中继时同一条消息只被一个中继领取；领取后未在租约内标记结果的消息到期后可被重新领取
+func ClaimPendingOutbox(limit int, lease time.Duration) ([]OutboxMessage, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	rows, err := TxQuery(tx, `
+        SELECT id, topic, payload, attempts FROM outbox
+        WHERE status = ? AND next_attempt_at <= ?
+        ORDER BY id ASC
+        LIMIT ?
+        FOR UPDATE SKIP LOCKED
+    `, outboxStatusPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		msgs []OutboxMessage
+		ids  []any
+	)
+	for rows.Next() {
+		var m OutboxMessage
+		if err := rows.Scan(&m.ID, &m.Topic, &m.Payload, &m.Attempts); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		msgs = append(msgs, m)
+		ids = append(ids, m.ID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(msgs) == 0 {
+		return nil, nil
+	}
+
+	args := append([]any{now.Add(lease)}, ids...)
+	if _, err := TxExec(tx, "UPDATE outbox SET next_attempt_at = ? WHERE id IN "+placeholders(len(ids)), args...); err != nil {
+		return nil, err
+	}
+	return msgs, tx.Commit()
+}
+
+// ReleaseOutbox 释放已领取但未尝试投递的消息，使其立即可被重新领取
+func ReleaseOutbox(msgs []OutboxMessage) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	args := []any{time.Now(), outboxStatusPending}
+	for _, m := range msgs {
+		args = append(args, m.ID)
+	}
+	_, err := Exec("UPDATE outbox SET next_attempt_at = ? WHERE status = ? AND id IN "+placeholders(len(msgs)), args...)
+	return err
+}
+
+// MarkOutboxSent 标记发件箱消息已投递
+func MarkOutboxSent(id int64) error {
+	_, err := Exec(
+		"UPDATE outbox SET status = ?, sent_at = CURRENT_TIMESTAMP WHERE id = ?",
+		outboxStatusSent, id,
+	)
+	return err
+}
+
+// MarkOutboxFailed 记录投递失败，超过最大重试次数后进入死信状态
+func MarkOutboxFailed(id int64, attempts, maxAttempts int, nextAttempt time.Time, cause error) error {
+	status := outboxStatusPending
+	if maxAttempts > 0 && attempts >= maxAttempts {
+		status = outboxStatusDead
+	}
+	msg := cause.Error()
+	if len(msg) > outboxLastErrorMaxLength {
+		msg = msg[:outboxLastErrorMaxLength]
+	}
+	_, err := Exec(`
+        UPDATE outbox SET status = ?, attempts = ?, next_attempt_at = ?, last_error = ?
+        WHERE id = ?
+    `, status, attempts, nextAttempt, msg, id)
+	return err
+}
+
+// PurgeSentOutbox 清理早于指定时间的已投递消息
+func PurgeSentOutbox(before time.Time) (int64, error) {
+	res, err := Exec(
+		"DELETE FROM outbox WHERE status = ? AND sent_at < ?",
+		outboxStatusSent, before,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// CountOutboxBacklog 统计待投递与死信消息数量
+func CountOutboxBacklog() (pending, dead int, err error) {
+	err = QueryRow(`
+        SELECT COALESCE(SUM(status = ?), 0), COALESCE(SUM(status = ?), 0) FROM outbox
+    `, outboxStatusPending, outboxStatusDead).Scan(&pending, &dead)
+	return pending, dead, err
+}
+
+// PointsCreditedEvent 积分入账领域事件
+type PointsCreditedEvent struct {
+	ChainName   string          `json:"chain_name"`
+	UserAddress string          `json:"user_address"`
+	PeriodStart time.Time       `json:"period_start"`
+	PeriodEnd   time.Time       `json:"period_end"`
+	PointsAdded decimal.Decimal `json:"points_added"`
+	TotalPoints decimal.Decimal `json:"total_points"`
+	RuleVersion string          `json:"rule_version"`
+}
+
+// BalanceChangedEvent 余额变动领域事件
+type BalanceChangedEvent struct {
+	ChainName    string    `json:"chain_name"`
+	UserAddress  string    `json:"user_address"`
+	EventType    string    `json:"event_type"`
+	Amount       string    `json:"amount"`
+	BalanceAfter string    `json:"balance_after"`
+	BlockNumber  uint64    `json:"block_number"`
+	EventTime    time.Time `json:"event_time"`
+	TxHash       string    `json:"tx_hash"`
+}
@@ -2,24 +2,73 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
+	"erc20-service/pkg/decimal"
+	"errors"
+	"fmt"
 	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+var (
+	// ErrPeriodApplied 该任务（时间段）已入账
+	ErrPeriodApplied = errors.New("积分时间段已入账")
+	// ErrPeriodOverlap 时间段与已入账的时间段重叠
+	ErrPeriodOverlap = errors.New("积分时间段与已入账时间段重叠")
 )
 
+// MySQL 唯一键冲突错误码
+const mysqlErrDuplicateEntry = 1062
+
 // PointsCalculation 积分计算结果
 type PointsCalculation struct {
 	ChainName    string
 	UserAddress  string
 	PeriodStart  time.Time
 	PeriodEnd    time.Time
-	PointsAdded  float64
-	TotalPoints  float64
+	PointsAdded  decimal.Decimal
+	TotalPoints  decimal.Decimal
+	RuleVersion  string          // 使用的规则集版本，跨版本时以"+"连接
+	TaskID       string          // 确定性任务ID，唯一约束保证同一时间段只入账一次
+	EpochID      int64           // 所属积分周期，0表示未对齐的旧任务或区块周期
+	Season       string          // 周期开始时刻所属的赛季，为空表示不属于任何赛季
+	BlockStart   uint64          // 区块周期起始区块（含），时间周期为0
+	BlockEnd     uint64          // 区块周期结束区块（不含），时间周期为0
+	Segments     []PointsSegment // 各定价子段的积分明细，为空时不保存
+	Prices       []PriceQuote    // 按USD价值计算时使用的报价，为空表示按代币数量计算
 	CalculatedAt time.Time
 }
 
+// PointsSegment 定价子段的积分明细：持仓不变且规则不变的时间段
+type PointsSegment struct {
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	FromBlock   uint64    `json:"from_block,omitempty"` // 区块周期的子段区块区间 [FromBlock, ToBlock)
+	ToBlock     uint64    `json:"to_block,omitempty"`
+	Balance     string    `json:"balance"`          // 持有余额（最小单位），含已申请解押的代币
+	Staked      string    `json:"staked,omitempty"` // 质押锁定余额（最小单位）
+	Weight      string    `json:"weight"`           // 计息余额（代币）：持有余额 + 质押余额 × 质押倍数
+	Rate        string    `json:"rate"`             // 生效比率
+	RuleVersion string    `json:"rule_version,omitempty"`
+	Rule        string    `json:"rule"`
+	Price       string    `json:"price,omitempty"`   // 按USD价值计算时子段开始时刻生效的代币价格
+	PriceAt     time.Time `json:"price_at,omitzero"` // 报价时间
+	PriceSource string    `json:"price_source,omitempty"`
+	Points      string    `json:"points"`
+}
+
+// PriceQuote 计算使用的价格报价，随计算历史保存用于审计
+type PriceQuote struct {
+	At     time.Time `json:"time"`
+	Price  string    `json:"price"`
+	Source string    `json:"source"`
+}
+
 // GetUserLastCalculatedTime 获取用户上次积分计算时间
 func GetUserLastCalculatedTime(chainName, userAddr string) (time.Time, error) {
 	var lastTime time.Time
-	err := QueryRow(`
+	err := ReadQueryRow(`
         SELECT last_calculated_at FROM user_points 
         WHERE chain_name = ? AND user_address = ?
     `, chainName, userAddr).Scan(&lastTime)
@@ -31,86 +80,294 @@ func GetUserLastCalculatedTime(chainName, userAddr string) (time.Time, error) {
 	return lastTime, err
 }
 
-// UpdateUserPoints 更新用户积分
-func UpdateUserPoints(calc PointsCalculation) error {
+// UpdateUserPoints 入账用户积分，返回入账后的总积分
+// 在同一事务中锁定用户积分行、校验时间段未入账且不与已入账时间段重叠，再写入累计流水与历史，
+// 因此重复投递或重叠的任务不会重复计分：分别返回 ErrPeriodApplied、ErrPeriodOverlap
+func UpdateUserPoints(policy *Policy, calc PointsCalculation) (decimal.Decimal, error) {
 	tx, err := DB.Begin()
 	if err != nil {
-		return err
+		return decimal.Zero(), err
 	}
 	defer tx.Rollback()
 
-	// 更新总积分
+	// 确保积分行存在并加锁，串行化同一用户的入账
+	currentTotal, err := txLockUserPoints(tx, calc.ChainName, calc.UserAddress, calc.PeriodStart)
+	if err != nil {
+		return decimal.Zero(), err
+	}
+
+	// 校验时间段是否已入账或与已入账时间段重叠（含已归档的日汇总）
+	if calc.TaskID != "" {
+		var applied int
+		err = TxQueryRow(tx, "SELECT COUNT(*) FROM points_calculation_history WHERE task_id = ?", calc.TaskID).Scan(&applied)
+		if err != nil {
+			return decimal.Zero(), err
+		}
+		if applied > 0 {
+			return currentTotal, ErrPeriodApplied
+		}
+	}
+	var overlapping int
+	err = TxQueryRow(tx, `
+        SELECT
+            (SELECT COUNT(*) FROM points_calculation_history
+             WHERE chain_name = ? AND user_address = ?
+             AND period_start < ? AND period_end > ?)
+          + (SELECT COUNT(*) FROM points_history_daily
+             WHERE chain_name = ? AND user_address = ?
+             AND period_start < ? AND period_end > ?)
+    `, calc.ChainName, calc.UserAddress, calc.PeriodEnd, calc.PeriodStart,
+		calc.ChainName, calc.UserAddress, calc.PeriodEnd, calc.PeriodStart).Scan(&overlapping)
+	if err != nil {
+		return decimal.Zero(), err
+	}
+	if overlapping > 0 {
+		return currentTotal, ErrPeriodOverlap
+	}
+
+	// 赛季已结算时迟到的任务只计入终身积分
+	if calc.Season != "" {
+		accepting, err := txSeasonAccepting(tx, calc.Season)
+		if err != nil {
+			return decimal.Zero(), err
+		}
+		if !accepting {
+			calc.Season = ""
+		}
+	}
+
+	// 写入累计流水，总积分随流水增量更新
+	totals, err := txAppendLedgerBulk(tx, policy, calc.ChainName, map[string]decimal.Decimal{
+		AddrKey(calc.UserAddress): currentTotal,
+	}, []LedgerEntry{{
+		ChainName:   calc.ChainName,
+		UserAddress: calc.UserAddress,
+		Amount:      calc.PointsAdded,
+		EntryType:   LedgerTypeAccrual,
+		Reason:      fmt.Sprintf("持有积分 %s - %s", calc.PeriodStart.UTC().Format(time.RFC3339), calc.PeriodEnd.UTC().Format(time.RFC3339)),
+		Actor:       LedgerActorSystem,
+		Reference:   calc.TaskID,
+		Season:      calc.Season,
+		EarnedAt:    calc.PeriodEnd,
+	}})
+	if err != nil {
+		return decimal.Zero(), err
+	}
+	calc.TotalPoints = totals[0]
+
+	// 回溯入账不回退上次计算时间
 	_, err = TxExec(tx, `
-        INSERT INTO user_points (chain_name, user_address, total_points, last_calculated_at)
-        VALUES (?, ?, ?, ?)
-        ON DUPLICATE KEY UPDATE 
-            total_points = VALUES(total_points),
-            last_calculated_at = VALUES(last_calculated_at),
-            updated_at = CURRENT_TIMESTAMP
-    `,
-		calc.ChainName, calc.UserAddress, calc.TotalPoints, calc.PeriodEnd,
-	)
+        UPDATE user_points SET last_calculated_at = GREATEST(last_calculated_at, ?)
+        WHERE chain_name = ? AND user_address = ?
+    `, calc.PeriodEnd, calc.ChainName, calc.UserAddress)
 	if err != nil {
-		return err
+		return decimal.Zero(), err
 	}
 
-	// 记录计算历史
+	// 记录计算历史，task_id 唯一约束兜底
+	var taskID any
+	if calc.TaskID != "" {
+		taskID = calc.TaskID
+	}
 	_, err = TxExec(tx, `
         INSERT INTO points_calculation_history (
             chain_name, user_address, period_start, period_end,
-            points_added, total_points
-        ) VALUES (?, ?, ?, ?, ?, ?)
+            points_added, total_points, rule_version, task_id, epoch_id,
+            block_start, block_end, segments, season_id, prices
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
     `,
 		calc.ChainName, calc.UserAddress, calc.PeriodStart, calc.PeriodEnd,
-		calc.PointsAdded, calc.TotalPoints,
+		calc.PointsAdded, calc.TotalPoints, calc.RuleVersion, taskID, nullEpochID(calc.EpochID),
+		nullBlock(calc.BlockEnd, calc.BlockStart), nullBlock(calc.BlockEnd, calc.BlockEnd), segmentsJSON(calc.Segments),
+		nullSeason(calc.Season), pricesJSON(calc.Prices),
 	)
 	if err != nil {
-		return err
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrDuplicateEntry {
+			return currentTotal, ErrPeriodApplied
+		}
+		return decimal.Zero(), err
+	}
+
+	// 按层级为推荐人记入推荐奖励
+	if err := txCreditReferrals(tx, policy, []PointsCalculation{calc}); err != nil {
+		return decimal.Zero(), err
 	}
 
-	return tx.Commit()
+	// 写入积分入账事件，与积分更新同事务提交
+	if err := TxEnqueueOutbox(tx, OutboxTopicPointsEvent, PointsCreditedEvent{
+		ChainName:   calc.ChainName,
+		UserAddress: calc.UserAddress,
+		PeriodStart: calc.PeriodStart,
+		PeriodEnd:   calc.PeriodEnd,
+		PointsAdded: calc.PointsAdded,
+		TotalPoints: calc.TotalPoints,
+		RuleVersion: calc.RuleVersion,
+	}); err != nil {
+		return decimal.Zero(), err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return decimal.Zero(), err
+	}
+	return calc.TotalPoints, nil
+}
+
+// GetAppliedPeriods 获取与[start, end)重叠的已入账时间段（含已归档的日汇总），按开始时间排序
+func GetAppliedPeriods(chainName, userAddr string, start, end time.Time) ([]TimePeriod, error) {
+	rows, err := Query(`
+        SELECT period_start, period_end FROM points_calculation_history
+        WHERE chain_name = ? AND user_address = ?
+        AND period_start < ? AND period_end > ?
+        UNION ALL
+        SELECT period_start, period_end FROM points_history_daily
+        WHERE chain_name = ? AND user_address = ?
+        AND period_start < ? AND period_end > ?
+        ORDER BY period_start
+    `, chainName, userAddr, end, start, chainName, userAddr, end, start)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var periods []TimePeriod
+	for rows.Next() {
+		var p TimePeriod
+		if err := rows.Scan(&p.Start, &p.End); err != nil {
+			return nil, err
+		}
+		periods = append(periods, p)
+	}
+	return periods, rows.Err()
 }
 
 // GetUserTotalPoints 获取用户总积分
-func GetUserTotalPoints(chainName, userAddr string) (float64, error) {
-	var total float64
+func GetUserTotalPoints(chainName, userAddr string) (decimal.Decimal, error) {
+	var total decimal.Decimal
 	err := QueryRow(`
         SELECT total_points FROM user_points 
         WHERE chain_name = ? AND user_address = ?
     `, chainName, userAddr).Scan(&total)
 
 	if err == sql.ErrNoRows {
-		return 0, nil
+		return decimal.Zero(), nil
 	}
 	return total, err
 }
 
-// HasPointsCalculated 检查指定时间段是否已经计算过积分
-func HasPointsCalculated(chainName, userAddr string, start, end time.Time) (bool, error) {
-	var count int
-	err := QueryRow(`
-        SELECT COUNT(*) FROM points_calculation_history 
-        WHERE chain_name = ? AND user_address = ? 
-        AND period_start <= ? AND period_end >= ?
-    `, chainName, userAddr, end, start).Scan(&count)
+// 周期ID为0（未对齐的旧任务）时历史记录写入NULL
+func nullEpochID(id int64) any {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// 时间周期（结束区块为0）的区块列写入NULL
+func nullBlock(end, block uint64) any {
+	if end == 0 {
+		return nil
+	}
+	return block
+}
+
+// 积分明细序列化为JSON，未保存明细时写入NULL
+func segmentsJSON(segments []PointsSegment) any {
+	if len(segments) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(segments)
+	if err != nil {
+		return nil
+	}
+	return string(data)
+}
 
+// 使用的报价序列化为JSON，按代币数量计算时写入NULL
+func pricesJSON(prices []PriceQuote) any {
+	if len(prices) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(prices)
 	if err != nil {
-		return false, err
+		return nil
 	}
-	return count > 0, nil
+	return string(data)
+}
+
+// GetPointsHistoryInPeriod 获取用户与[start, end)重叠的积分计算历史（含保存的积分明细），按开始时间排序
+func GetPointsHistoryInPeriod(chainName, userAddr string, start, end time.Time) ([]PointsHistoryRecord, error) {
+	rows, err := ReadQuery(`
+        SELECT id, chain_name, user_address, period_start, period_end,
+               points_added, total_points, rule_version, COALESCE(task_id, ''), COALESCE(epoch_id, 0),
+               segments, prices, calculated_at
+        FROM points_calculation_history
+        WHERE chain_name = ? AND user_address = ?
+        AND period_start < ? AND period_end > ?
+        ORDER BY period_start
+    `, chainName, userAddr, end, start)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []PointsHistoryRecord
+	for rows.Next() {
+		r, err := scanPointsHistory(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// GetAwardedPointsByUser 按用户汇总链上在[start, end]内完整计算的时间段已入账的累计积分（含已归档的日汇总）
+func GetAwardedPointsByUser(chainName string, start, end time.Time) (map[string]decimal.Decimal, error) {
+	rows, err := ReadQuery(`
+        SELECT user_address, SUM(points_added) FROM (
+            SELECT user_address, points_added FROM points_calculation_history
+            WHERE chain_name = ? AND period_start >= ? AND period_end <= ?
+            UNION ALL
+            SELECT user_address, points_added FROM points_history_daily
+            WHERE chain_name = ? AND period_start >= ? AND period_end <= ?
+        ) awarded
+        GROUP BY user_address
+    `, chainName, start, end, chainName, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	awarded := make(map[string]decimal.Decimal)
+	for rows.Next() {
+		var (
+			user   string
+			points decimal.Decimal
+		)
+		if err := rows.Scan(&user, &points); err != nil {
+			return nil, err
+		}
+		awarded[user] = points
+	}
+	return awarded, rows.Err()
 }
 
 // GetMissingCalculationPeriods 获取缺失的积分计算时间段
 func GetMissingCalculationPeriods(chainName, userAddr string, start, end time.Time, intervalMinutes int) ([]TimePeriod, error) {
 	var periods []TimePeriod
 
-	// 获取已计算的时间段
-	rows, err := Query(`
-        SELECT period_start, period_end FROM points_calculation_history 
-        WHERE chain_name = ? AND user_address = ? 
+	// 获取已计算的时间段（含已归档的日汇总）
+	rows, err := ReadQuery(`
+        SELECT period_start, period_end FROM points_calculation_history
+        WHERE chain_name = ? AND user_address = ?
         AND period_start >= ? AND period_end <= ?
+        UNION ALL
+        SELECT period_start, period_end FROM points_history_daily
+        WHERE chain_name = ? AND user_address = ?
+        AND period_start <= ? AND period_end >= ?
         ORDER BY period_start
-    `, chainName, userAddr, start, end)
+    `, chainName, userAddr, start, end, chainName, userAddr, end, start)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"erc20-service/config"
+	"erc20-service/internal/rules"
+	"erc20-service/pkg/decimal"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Policy 积分写入与查询使用的配置策略：跨链汇总权重、推荐奖励分成、女巫聚类处理与静态排除地址
+// 由配置构造后显式传入，nil 表示链权重均为1、不发放推荐奖励、不处理女巫聚类、无静态排除地址
+type Policy struct {
+	chainWeights   map[string]decimal.Decimal
+	referralLevels []*big.Rat
+	sybil          sybilPolicy
+	exclusions     map[string]map[string]string // 链 → 小写地址 → 原因
+}
+
+// 女巫聚类处理策略，action为空时只检测不处理
+type sybilPolicy struct {
+	action    string
+	threshold float64
+	weight    *big.Rat
+}
+
+// NewPolicy 由配置构造积分策略，质押合约自动排除（其持仓已归属质押者）
+func NewPolicy(cfg *config.Config) *Policy {
+	p := &Policy{
+		chainWeights: make(map[string]decimal.Decimal, len(cfg.Points.ChainWeights)),
+		exclusions:   make(map[string]map[string]string, len(cfg.Chains)),
+	}
+	for chain, f := range cfg.Points.ChainWeights {
+		p.chainWeights[chain] = decimal.MustParse(strconv.FormatFloat(f, 'f', -1, 64))
+	}
+	for _, f := range cfg.Points.Referral.Levels {
+		p.referralLevels = append(p.referralLevels, rules.RatFromFloat(f))
+	}
+
+	sybil := cfg.Points.Sybil
+	p.sybil = sybilPolicy{action: sybil.Action, threshold: sybil.Threshold, weight: new(big.Rat)}
+	if sybil.Action == SybilActionDownweight {
+		p.sybil.weight = rules.RatFromFloat(sybil.Weight)
+	}
+
+	for _, c := range cfg.Chains {
+		set := make(map[string]string)
+		for _, addr := range c.ExcludedAddresses {
+			set[strings.ToLower(addr)] = "配置排除"
+		}
+		if c.Staking.ContractAddress != "" {
+			set[strings.ToLower(c.Staking.ContractAddress)] = "质押合约"
+		}
+		p.exclusions[c.Name] = set
+	}
+	return p
+}
+
+// ChainWeight 获取链权重，未配置的链权重为1
+func (p *Policy) ChainWeight(chainName string) decimal.Decimal {
+	if p != nil {
+		if w, ok := p.chainWeights[chainName]; ok {
+			return w
+		}
+	}
+	return decimal.FromInt(1)
+}
+
+// 推荐奖励各级分成比例
+func (p *Policy) referralRates() []*big.Rat {
+	if p == nil {
+		return nil
+	}
+	return p.referralLevels
+}
+
+// 女巫聚类处理策略，未启用时ok为false
+func (p *Policy) sybilPolicy() (threshold float64, weight *big.Rat, ok bool) {
+	if p == nil || p.sybil.action == "" {
+		return 0, nil, false
+	}
+	return p.sybil.threshold, p.sybil.weight, true
+}
+
+// 女巫聚类处理阈值，未启用处理时同样返回配置值，用于记录地址达到阈值的时间
+func (p *Policy) sybilThreshold() float64 {
+	if p == nil {
+		return 0
+	}
+	return p.sybil.threshold
+}
+
+// 链上的静态排除地址（小写地址 → 原因）
+func (p *Policy) staticExclusions(chainName string) map[string]string {
+	if p == nil {
+		return nil
+	}
+	return p.exclusions[chainName]
+}
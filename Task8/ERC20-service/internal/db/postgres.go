@@ -17,16 +17,11 @@ var (
 
 // Init 初始化数据库连接
 func Init(cfg config.DatabaseConfig) error {
-	// MySQL DSN: user:password@tcp(host:port)/dbname?parseTime=true&charset=utf8mb4&loc=Local
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true&charset=utf8mb4&loc=Local",
-		cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.DBName)
-	db, err := sql.Open("mysql", dsn)
+	setQueryLogPolicy(cfg.QueryLog)
+
+	db, err := openMySQL(cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.DBName)
 	if err != nil {
-		return fmt.Errorf("连接数据库失败: %v", err)
-	}
-	// 验证连接
-	if err := db.Ping(); err != nil {
-		return fmt.Errorf("验证连接失败: %v", err)
+		return err
 	}
 
 	// 设置连接池参数
@@ -36,9 +31,29 @@ func Init(cfg config.DatabaseConfig) error {
 
 	DB = db
 	log.Info("数据库连接成功")
+
+	// 只读副本（可选）
+	initReplicas(cfg)
 	return nil
 }
 
+// 打开并验证MySQL连接
+func openMySQL(user, password, host string, port int, dbName string) (*sql.DB, error) {
+	// MySQL DSN: user:password@tcp(host:port)/dbname?parseTime=true&charset=utf8mb4&loc=Local
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true&charset=utf8mb4&loc=Local",
+		user, password, host, port, dbName)
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("连接数据库失败: %v", err)
+	}
+	// 验证连接
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("验证连接失败: %v", err)
+	}
+	return db, nil
+}
+
 // InitChainStatus 初始化链状态
 func InitChainStatus(chains []config.ChainConfig) error {
 	tx, err := DB.Begin()
@@ -73,39 +88,104 @@ func InitChainStatus(chains []config.ChainConfig) error {
 }
 
 // ---- SQL Logging Helpers ----
+// 所有语句统一记录耗时与行数并上报指标，日志按慢查询阈值与采样率输出，参数按脱敏策略处理
 
-// Exec 执行非查询语句并记录SQL与参数
+// Exec 执行非查询语句并记录耗时与影响行数
 func Exec(query string, args ...any) (sql.Result, error) {
-	log.Info("SQL Exec", "query", query, "args", args)
-	return DB.Exec(query, args...)
+	start := time.Now()
+	res, err := DB.Exec(query, args...)
+	observeQuery("exec", query, args, start, affectedRows(res, err), err)
+	return res, err
 }
 
-// Query 执行查询并记录SQL与参数
-func Query(query string, args ...any) (*sql.Rows, error) {
-	log.Info("SQL Query", "query", query, "args", args)
-	return DB.Query(query, args...)
+// Query 执行查询，返回的Rows在Close时记录耗时与读取行数
+func Query(query string, args ...any) (*Rows, error) {
+	start := time.Now()
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		observeQuery("query", query, args, start, -1, err)
+		return nil, err
+	}
+	return &Rows{Rows: rows, op: "query", query: query, args: args, start: start}, nil
 }
 
-// QueryRow 执行单行查询并记录SQL与参数
+// QueryRow 执行单行查询并记录耗时
 func QueryRow(query string, args ...any) *sql.Row {
-	log.Info("SQL QueryRow", "query", query, "args", args)
-	return DB.QueryRow(query, args...)
+	start := time.Now()
+	row := DB.QueryRow(query, args...)
+	observeQuery("query_row", query, args, start, -1, row.Err())
+	return row
 }
 
-// TxExec 在事务中执行非查询语句并记录SQL与参数
+// TxExec 在事务中执行非查询语句并记录耗时与影响行数
 func TxExec(tx *sql.Tx, query string, args ...any) (sql.Result, error) {
-	log.Info("SQL TxExec", "query", query, "args", args)
-	return tx.Exec(query, args...)
+	start := time.Now()
+	res, err := tx.Exec(query, args...)
+	observeQuery("tx_exec", query, args, start, affectedRows(res, err), err)
+	return res, err
 }
 
-// TxQuery 在事务中执行查询并记录SQL与参数
-func TxQuery(tx *sql.Tx, query string, args ...any) (*sql.Rows, error) {
-	log.Info("SQL TxQuery", "query", query, "args", args)
-	return tx.Query(query, args...)
+// TxQuery 在事务中执行查询，返回的Rows在Close时记录耗时与读取行数
+func TxQuery(tx *sql.Tx, query string, args ...any) (*Rows, error) {
+	start := time.Now()
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		observeQuery("tx_query", query, args, start, -1, err)
+		return nil, err
+	}
+	return &Rows{Rows: rows, op: "tx_query", query: query, args: args, start: start}, nil
 }
 
-// TxQueryRow 在事务中执行单行查询并记录SQL与参数
+// TxQueryRow 在事务中执行单行查询并记录耗时
 func TxQueryRow(tx *sql.Tx, query string, args ...any) *sql.Row {
-	log.Info("SQL TxQueryRow", "query", query, "args", args)
-	return tx.QueryRow(query, args...)
+	start := time.Now()
+	row := tx.QueryRow(query, args...)
+	observeQuery("tx_query_row", query, args, start, -1, row.Err())
+	return row
+}
+
+// Rows 包装 sql.Rows，统计读取行数，Close时记录整条语句的耗时
+type Rows struct {
+	*sql.Rows
+	op     string
+	query  string
+	args   []any
+	start  time.Time
+	n      int64
+	closed bool
+}
+
+// Next 读取下一行并计数
+func (r *Rows) Next() bool {
+	ok := r.Rows.Next()
+	if ok {
+		r.n++
+	}
+	return ok
+}
+
+// Close 关闭结果集并记录观测数据（重复调用只记录一次）
+func (r *Rows) Close() error {
+	err := r.Rows.Close()
+	if !r.closed {
+		r.closed = true
+		cause := r.Rows.Err()
+		if cause == nil {
+			cause = err
+		}
+		observeQuery(r.op, r.query, r.args, r.start, r.n, cause)
+	}
+	return err
+}
+
+// 获取影响行数，失败时返回-1
+func affectedRows(res sql.Result, err error) int64 {
+	if err != nil || res == nil {
+		return -1
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return -1
+	}
+	return n
 }
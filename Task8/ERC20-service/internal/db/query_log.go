@@ -0,0 +1,149 @@
+package db
+
+import (
+	"database/sql"
+	"erc20-service/config"
+	"erc20-service/pkg/metrics"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 参数脱敏策略
+const (
+	RedactNone = "none" // 原样输出
+	RedactMask = "mask" // 地址、哈希、长数字保留首尾，其余遮盖
+	RedactHide = "hide" // 仅输出参数个数
+)
+
+// 语句观测指标
+var (
+	queryDuration = metrics.NewHistogram(
+		"db_query_duration_seconds", "数据库语句耗时", nil, "op", "statement")
+	queryRows = metrics.NewCounter(
+		"db_query_rows_total", "数据库语句读取或影响的行数", "op", "statement")
+	queryErrors = metrics.NewCounter(
+		"db_query_errors_total", "数据库语句错误次数", "op", "statement")
+	slowQueries = metrics.NewCounter(
+		"db_slow_queries_total", "慢查询次数", "op", "statement")
+)
+
+// 当前生效的日志策略
+var queryLogPolicy atomic.Pointer[config.QueryLogConfig]
+
+func init() {
+	setQueryLogPolicy(config.QueryLogConfig{
+		SlowThresholdMs: 200,
+		Redact:          RedactMask,
+	})
+}
+
+// 设置日志策略
+func setQueryLogPolicy(cfg config.QueryLogConfig) {
+	queryLogPolicy.Store(&cfg)
+}
+
+// 记录一次语句执行：上报指标，按错误/慢查询/采样输出日志
+func observeQuery(op, query string, args []any, start time.Time, rows int64, err error) {
+	elapsed := time.Since(start)
+	stmt := statementName(query)
+	queryDuration.ObserveDuration(elapsed, op, stmt)
+	if rows > 0 {
+		queryRows.Add(float64(rows), op, stmt)
+	}
+
+	policy := queryLogPolicy.Load()
+	slow := policy.SlowThresholdMs > 0 && elapsed >= time.Duration(policy.SlowThresholdMs)*time.Millisecond
+	if slow {
+		slowQueries.Inc(op, stmt)
+	}
+
+	switch {
+	case err != nil && !isNoRows(err):
+		queryErrors.Inc(op, stmt)
+		log.Warn("SQL执行失败", queryLogAttrs(policy, op, stmt, query, args, elapsed, rows, "error", err)...)
+	case slow:
+		log.Warn("SQL慢查询", queryLogAttrs(policy, op, stmt, query, args, elapsed, rows)...)
+	case policy.SampleRate > 0 && rand.Float64() < policy.SampleRate:
+		log.Info("SQL采样", queryLogAttrs(policy, op, stmt, query, args, elapsed, rows)...)
+	default:
+		log.Debug("SQL", queryLogAttrs(policy, op, stmt, query, args, elapsed, rows)...)
+	}
+}
+
+// 组装日志字段
+func queryLogAttrs(policy *config.QueryLogConfig, op, stmt, query string, args []any, elapsed time.Duration, rows int64, extra ...any) []any {
+	attrs := []any{
+		"op", op,
+		"statement", stmt,
+		"duration_ms", float64(elapsed.Microseconds()) / 1000,
+	}
+	if rows >= 0 {
+		attrs = append(attrs, "rows", rows)
+	}
+	attrs = append(attrs, "query", compactSQL(query), "args", redactArgs(policy.Redact, args))
+	return append(attrs, extra...)
+}
+
+func isNoRows(err error) bool {
+	return errors.Is(err, sql.ErrNoRows)
+}
+
+var (
+	whitespaceRe = regexp.MustCompile(`\s+`)
+	tableRe      = regexp.MustCompile(`(?i)\b(?:FROM|INTO|UPDATE|TABLE)\s+([a-zA-Z_][a-zA-Z0-9_]*)`)
+	hexRe        = regexp.MustCompile(`^0x[0-9a-fA-F]{16,}$`)
+	digitsRe     = regexp.MustCompile(`^[0-9]{8,}$`)
+)
+
+// 压缩SQL中的空白，便于单行输出
+func compactSQL(query string) string {
+	return strings.TrimSpace(whitespaceRe.ReplaceAllString(query, " "))
+}
+
+// 语句名称：动词 + 首个表名，作为指标标签，控制基数
+func statementName(query string) string {
+	q := compactSQL(query)
+	verb := strings.ToLower(strings.SplitN(q, " ", 2)[0])
+	if m := tableRe.FindStringSubmatch(q); m != nil {
+		return verb + " " + strings.ToLower(m[1])
+	}
+	return verb
+}
+
+// 按策略脱敏参数
+func redactArgs(policy string, args []any) any {
+	switch policy {
+	case RedactNone:
+		return args
+	case RedactHide:
+		return fmt.Sprintf("[%d args]", len(args))
+	}
+	out := make([]any, len(args))
+	for i, a := range args {
+		out[i] = maskValue(a)
+	}
+	return out
+}
+
+// 遮盖地址、交易哈希与长数字（金额、余额）
+func maskValue(v any) any {
+	s, ok := v.(string)
+	if !ok {
+		if b, isBytes := v.([]byte); isBytes {
+			return fmt.Sprintf("<%d bytes>", len(b))
+		}
+		return v
+	}
+	switch {
+	case hexRe.MatchString(s):
+		return s[:6] + "…" + s[len(s)-4:]
+	case digitsRe.MatchString(s):
+		return s[:2] + "…" + fmt.Sprintf("(%d digits)", len(s))
+	}
+	return s
+}
@@ -0,0 +1,305 @@
+package db
+
+import (
+	"database/sql"
+	"erc20-service/pkg/decimal"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 推荐关系来源
+const (
+	ReferralSourceImport = "import" // 通过CLI导入
+	ReferralSourceChain  = "chain"  // 推荐注册合约事件（被推荐人签名）
+)
+
+// 推荐链向上追溯的最大深度，防止异常数据导致无限追溯
+const maxReferralDepth = 1000
+
+var (
+	// ErrSelfReferral 推荐人与被推荐人为同一地址
+	ErrSelfReferral = errors.New("不能推荐自己")
+	// ErrReferralCycle 被推荐人已在推荐人的上级链中，绑定后将形成环
+	ErrReferralCycle = errors.New("推荐关系形成环")
+	// ErrReferralExists 被推荐人已绑定推荐人，推荐关系不可更改
+	ErrReferralExists = errors.New("被推荐人已绑定推荐人")
+)
+
+// Referral 推荐关系：每个被推荐人在每条链上至多一个推荐人
+type Referral struct {
+	ChainName string    `json:"chain_name"`
+	Referee   string    `json:"referee"`
+	Referrer  string    `json:"referrer"`
+	Source    string    `json:"source"`
+	Actor     string    `json:"actor,omitempty"`
+	TxHash    string    `json:"tx_hash,omitempty"` // 链上注册交易
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ReferralStats 推荐人的直接推荐人数与累计推荐奖励
+type ReferralStats struct {
+	Referees int             `json:"referees"`
+	Rewards  decimal.Decimal `json:"rewards"`
+}
+
+// CreateReferral 绑定推荐关系
+// 拒绝自我推荐、重复绑定与形成环的关系；上级链以加锁读追溯，并发绑定互为上级时由死锁检测回滚其一
+func CreateReferral(r Referral) error {
+	if strings.EqualFold(r.Referee, r.Referrer) {
+		return ErrSelfReferral
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, ok, err := txGetReferrer(tx, r.ChainName, r.Referee, true); err != nil {
+		return err
+	} else if ok {
+		return ErrReferralExists
+	}
+
+	// 被推荐人不能出现在推荐人的上级链中
+	upline := r.Referrer
+	for depth := 0; ; depth++ {
+		if depth >= maxReferralDepth {
+			return fmt.Errorf("推荐链超过最大深度 %d", maxReferralDepth)
+		}
+		next, ok, err := txGetReferrer(tx, r.ChainName, upline, true)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		if strings.EqualFold(next, r.Referee) {
+			return ErrReferralCycle
+		}
+		upline = next
+	}
+
+	_, err = TxExec(tx, `
+        INSERT INTO referrals (chain_name, referee_address, referrer_address, source, actor, tx_hash)
+        VALUES (?, ?, ?, ?, ?, ?)
+    `, r.ChainName, r.Referee, r.Referrer, r.Source, r.Actor, r.TxHash)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// 获取被推荐人的推荐人，forUpdate为true时加锁读
+func txGetReferrer(tx *sql.Tx, chainName, referee string, forUpdate bool) (string, bool, error) {
+	query := "SELECT referrer_address FROM referrals WHERE chain_name = ? AND referee_address = ?"
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+	var referrer string
+	err := TxQueryRow(tx, query, chainName, referee).Scan(&referrer)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	return referrer, err == nil, err
+}
+
+// GetReferrer 获取被推荐人的推荐关系，未绑定时ok为false
+func GetReferrer(chainName, referee string) (Referral, bool, error) {
+	r := Referral{ChainName: chainName, Referee: referee}
+	err := QueryRow(`
+        SELECT referrer_address, source, actor, tx_hash, created_at FROM referrals
+        WHERE chain_name = ? AND referee_address = ?
+    `, chainName, referee).Scan(&r.Referrer, &r.Source, &r.Actor, &r.TxHash, &r.CreatedAt)
+	if err == sql.ErrNoRows {
+		return r, false, nil
+	}
+	return r, err == nil, err
+}
+
+// ListReferees 获取推荐人的直接被推荐人，按绑定时间排序
+func ListReferees(chainName, referrer string) ([]Referral, error) {
+	rows, err := ReadQuery(`
+        SELECT referee_address, source, actor, tx_hash, created_at FROM referrals
+        WHERE chain_name = ? AND referrer_address = ?
+        ORDER BY created_at, referee_address
+    `, chainName, referrer)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []Referral
+	for rows.Next() {
+		r := Referral{ChainName: chainName, Referrer: referrer}
+		if err := rows.Scan(&r.Referee, &r.Source, &r.Actor, &r.TxHash, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
+// GetReferralStats 获取推荐人的直接推荐人数与累计获得的推荐奖励（含各级）
+func GetReferralStats(chainName, referrer string) (ReferralStats, error) {
+	var s ReferralStats
+	err := ReadQueryRow(`
+        SELECT
+            (SELECT COUNT(*) FROM referrals WHERE chain_name = ? AND referrer_address = ?),
+            (SELECT COALESCE(SUM(amount), 0) FROM points_ledger
+             WHERE chain_name = ? AND user_address = ? AND entry_type = ?)
+    `, chainName, referrer, chainName, referrer, LedgerTypeReferral).Scan(&s.Referees, &s.Rewards)
+	return s, err
+}
+
+// 按被推荐人入账的持有积分为各级推荐人记入推荐奖励流水，与持有积分同事务提交，随任务ID去重
+// 追溯遇到被推荐人自身或已出现的地址（自我推荐、环）即停止；排除地址不获得奖励，但继续向上追溯
+// 推荐链、排除地址与女巫处理权重每批只查询一次，奖励流水经 txAppendLedgerBulk 以多行语句写入；
+// 推荐人的积分行在被推荐人之后按地址顺序加锁，并发批次间偶发的死锁由任务重新入队重试
+func txCreditReferrals(tx *sql.Tx, policy *Policy, calcs []PointsCalculation) error {
+	levels := policy.referralRates()
+	if len(levels) == 0 || len(calcs) == 0 {
+		return nil
+	}
+	chainName := calcs[0].ChainName
+	var earners []string
+	for _, c := range calcs {
+		if c.PointsAdded.Sign() > 0 {
+			earners = append(earners, c.UserAddress)
+		}
+	}
+	if len(earners) == 0 {
+		return nil
+	}
+
+	referrers, err := txReferrerChains(tx, chainName, earners, len(levels))
+	if err != nil {
+		return fmt.Errorf("查询推荐人失败: %v", err)
+	}
+	if len(referrers) == 0 {
+		return nil
+	}
+	excluded, err := GetExcludedSet(policy, chainName)
+	if err != nil {
+		return err
+	}
+	// 女巫聚类：与被推荐人在同一聚类内的推荐人不发放奖励，其他达到阈值的推荐人按处理权重发放
+	addrs := append([]string{}, earners...)
+	for addr := range referrers {
+		addrs = append(addrs, referrers[addr])
+	}
+	penalties, err := getSybilPenaltiesFor(policy, chainName, addrs)
+	if err != nil {
+		return fmt.Errorf("查询女巫聚类失败: %v", err)
+	}
+
+	var (
+		entries   []LedgerEntry
+		lockUsers []string
+		locked    = make(map[string]bool)
+	)
+	for _, c := range calcs {
+		if c.PointsAdded.Sign() <= 0 {
+			continue
+		}
+		earner := penalties[AddrKey(c.UserAddress)].ActiveAt(c.PeriodStart)
+		seen := map[string]bool{AddrKey(c.UserAddress): true}
+		referee := c.UserAddress
+		for i, pct := range levels {
+			referrer, ok := referrers[AddrKey(referee)]
+			if !ok || seen[AddrKey(referrer)] {
+				break
+			}
+			seen[AddrKey(referrer)] = true
+			referee = referrer
+
+			reward := c.PointsAdded.MulRat(pct)
+			if penalty := penalties[AddrKey(referrer)].ActiveAt(c.PeriodStart); penalty != nil {
+				if earner != nil && earner.ClusterID == penalty.ClusterID {
+					continue
+				}
+				reward = reward.MulRat(penalty.Weight)
+			}
+			if excluded[AddrKey(referrer)] || reward.Sign() <= 0 {
+				continue
+			}
+			if !locked[AddrKey(referrer)] {
+				locked[AddrKey(referrer)] = true
+				lockUsers = append(lockUsers, referrer)
+			}
+			entries = append(entries, LedgerEntry{
+				ChainName:   chainName,
+				UserAddress: referrer,
+				Amount:      reward,
+				EntryType:   LedgerTypeReferral,
+				Reason:      fmt.Sprintf("%d级推荐奖励，来自 %s", i+1, c.UserAddress),
+				Actor:       LedgerActorSystem,
+				Reference:   c.TaskID,
+				Season:      c.Season,
+				EarnedAt:    c.PeriodEnd,
+			})
+		}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	// 推荐人积分行不存在时创建，再按地址顺序加锁
+	sort.Slice(lockUsers, func(i, j int) bool { return AddrKey(lockUsers[i]) < AddrKey(lockUsers[j]) })
+	args := make([]any, 0, len(lockUsers)*3)
+	for _, u := range lockUsers {
+		args = append(args, chainName, u, calcs[0].PeriodStart)
+	}
+	if _, err := TxExec(tx, `
+        INSERT INTO user_points (chain_name, user_address, total_points, last_calculated_at)
+        VALUES `+valuesList(len(lockUsers), "?, ?, 0, ?")+`
+        ON DUPLICATE KEY UPDATE chain_name = chain_name`,
+		args...); err != nil {
+		return err
+	}
+	totals, err := txLockUserPointsBatch(tx, chainName, lockUsers)
+	if err != nil {
+		return err
+	}
+	_, err = txAppendLedgerBulk(tx, policy, chainName, totals, entries)
+	return err
+}
+
+// 逐级查询被推荐人的推荐人，最多向上depth级，返回 小写被推荐人 → 推荐人
+func txReferrerChains(tx *sql.Tx, chainName string, referees []string, depth int) (map[string]string, error) {
+	referrers := make(map[string]string)
+	frontier := referees
+	for level := 0; level < depth && len(frontier) > 0; level++ {
+		args := []any{chainName}
+		for _, r := range frontier {
+			args = append(args, r)
+		}
+		rows, err := TxQuery(tx, `
+            SELECT referee_address, referrer_address FROM referrals
+            WHERE chain_name = ? AND referee_address IN `+placeholders(len(frontier)),
+			args...)
+		if err != nil {
+			return nil, err
+		}
+		var next []string
+		for rows.Next() {
+			var referee, referrer string
+			if err := rows.Scan(&referee, &referrer); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			referrers[AddrKey(referee)] = referrer
+			if _, ok := referrers[AddrKey(referrer)]; !ok {
+				next = append(next, referrer)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		frontier = next
+	}
+	return referrers, nil
+}
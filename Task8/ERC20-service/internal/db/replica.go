@@ -0,0 +1,194 @@
+package db
+
+import (
+	"database/sql"
+	"erc20-service/config"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 复制延迟检查结果缓存时长
+const replicaLagCheckInterval = 10 * time.Second
+
+// Replica 只读副本
+type Replica struct {
+	Name string
+	DB   *sql.DB
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	lag       time.Duration
+	lagErr    error
+}
+
+// ReplicaStatus 副本状态
+type ReplicaStatus struct {
+	Name    string        `json:"name"`
+	Lag     time.Duration `json:"lag"`
+	Healthy bool          `json:"healthy"`
+	Error   string        `json:"error,omitempty"`
+}
+
+var (
+	replicas      []*Replica
+	maxReplicaLag time.Duration
+	replicaReads  atomic.Bool   // 是否将只读查询路由到副本
+	replicaCursor atomic.Uint64 // 轮询游标
+)
+
+// 打开只读副本连接，单个副本失败只告警不阻断启动
+func initReplicas(cfg config.DatabaseConfig) {
+	maxReplicaLag = time.Duration(cfg.MaxReplicaLagSeconds) * time.Second
+	replicas = nil
+	for i, rc := range cfg.Replicas {
+		name := rc.Name
+		if name == "" {
+			name = fmt.Sprintf("replica-%d", i)
+		}
+		rdb, err := openMySQL(rc.User, rc.Password, rc.Host, rc.Port, rc.DBName)
+		if err != nil {
+			log.Warn("连接只读副本失败，已跳过", "replica", name, "error", err)
+			continue
+		}
+		rdb.SetMaxOpenConns(10)
+		rdb.SetMaxIdleConns(2)
+		rdb.SetConnMaxLifetime(1 * time.Hour)
+		replicas = append(replicas, &Replica{Name: name, DB: rdb})
+		log.Info("只读副本连接成功", "replica", name)
+	}
+}
+
+// EnableReplicaReads 开启只读查询路由到副本
+// 仅供查询密集且能容忍秒级延迟的命令调用（health、backfill check/scan等）；
+// 守护进程的写入链路（事件入库、积分更新）需要读己之写，始终走主库
+func EnableReplicaReads() {
+	replicaReads.Store(true)
+	if len(replicas) == 0 {
+		log.Info("未配置只读副本，只读查询使用主库")
+	}
+}
+
+// readDB 选择只读查询使用的连接：轮询延迟在阈值内的副本，均不可用时回退主库
+func readDB() (*sql.DB, string) {
+	if !replicaReads.Load() || len(replicas) == 0 {
+		return DB, "primary"
+	}
+	start := replicaCursor.Add(1)
+	for i := range replicas {
+		r := replicas[(int(start)+i)%len(replicas)]
+		if r.healthy() {
+			return r.DB, r.Name
+		}
+	}
+	return DB, "primary"
+}
+
+// 副本是否可用（延迟在阈值内）
+func (r *Replica) healthy() bool {
+	lag, err := r.Lag()
+	if err != nil {
+		return false
+	}
+	return maxReplicaLag <= 0 || lag <= maxReplicaLag
+}
+
+// Lag 获取副本复制延迟（带缓存）
+func (r *Replica) Lag() (time.Duration, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checkedAt) < replicaLagCheckInterval {
+		return r.lag, r.lagErr
+	}
+	r.lag, r.lagErr = queryReplicaLag(r.DB)
+	r.checkedAt = time.Now()
+	if r.lagErr != nil {
+		log.Warn("检查副本复制延迟失败", "replica", r.Name, "error", r.lagErr)
+	}
+	return r.lag, r.lagErr
+}
+
+// 查询MySQL副本复制延迟，兼容 SHOW REPLICA STATUS 与旧版 SHOW SLAVE STATUS
+func queryReplicaLag(rdb *sql.DB) (time.Duration, error) {
+	if lag, err := scanReplicaLag(rdb, "SHOW REPLICA STATUS"); err == nil {
+		return lag, nil
+	}
+	return scanReplicaLag(rdb, "SHOW SLAVE STATUS")
+}
+
+func scanReplicaLag(rdb *sql.DB, stmt string) (time.Duration, error) {
+	rows, err := rdb.Query(stmt)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	if !rows.Next() {
+		return 0, fmt.Errorf("实例未配置复制")
+	}
+	values := make([]sql.RawBytes, len(cols))
+	dest := make([]any, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return 0, err
+	}
+	for i, col := range cols {
+		if col != "Seconds_Behind_Source" && col != "Seconds_Behind_Master" {
+			continue
+		}
+		if values[i] == nil {
+			// 复制线程未运行
+			return 0, fmt.Errorf("复制线程未运行")
+		}
+		secs, err := strconv.ParseInt(string(values[i]), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(secs) * time.Second, nil
+	}
+	return 0, fmt.Errorf("复制状态缺少延迟字段")
+}
+
+// GetReplicaStatuses 获取所有副本状态
+func GetReplicaStatuses() []ReplicaStatus {
+	statuses := make([]ReplicaStatus, 0, len(replicas))
+	for _, r := range replicas {
+		lag, err := r.Lag()
+		s := ReplicaStatus{Name: r.Name, Lag: lag, Healthy: err == nil && (maxReplicaLag <= 0 || lag <= maxReplicaLag)}
+		if err != nil {
+			s.Error = err.Error()
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses
+}
+
+// ReadQuery 只读查询，开启副本路由时走副本
+func ReadQuery(query string, args ...any) (*Rows, error) {
+	rdb, target := readDB()
+	start := time.Now()
+	rows, err := rdb.Query(query, args...)
+	op := "read_query:" + target
+	if err != nil {
+		observeQuery(op, query, args, start, -1, err)
+		return nil, err
+	}
+	return &Rows{Rows: rows, op: op, query: query, args: args, start: start}, nil
+}
+
+// ReadQueryRow 只读单行查询，开启副本路由时走副本
+func ReadQueryRow(query string, args ...any) *sql.Row {
+	rdb, target := readDB()
+	start := time.Now()
+	row := rdb.QueryRow(query, args...)
+	observeQuery("read_query_row:"+target, query, args, start, -1, row.Err())
+	return row
+}
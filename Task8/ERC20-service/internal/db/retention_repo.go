@@ -0,0 +1,307 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"erc20-service/pkg/decimal"
+	"fmt"
+	"time"
+)
+
+// PointsHistoryRecord 积分计算历史明细
+type PointsHistoryRecord struct {
+	ID           int64
+	ChainName    string
+	UserAddress  string
+	PeriodStart  time.Time
+	PeriodEnd    time.Time
+	PointsAdded  string
+	TotalPoints  string
+	RuleVersion  string
+	TaskID       string
+	EpochID      int64
+	Segments     []PointsSegment
+	Prices       []PriceQuote
+	CalculatedAt time.Time
+}
+
+// 扫描一行积分计算历史，积分明细列为JSON
+func scanPointsHistory(rows *Rows) (PointsHistoryRecord, error) {
+	var (
+		r                PointsHistoryRecord
+		segments, prices sql.NullString
+	)
+	if err := rows.Scan(
+		&r.ID, &r.ChainName, &r.UserAddress, &r.PeriodStart, &r.PeriodEnd,
+		&r.PointsAdded, &r.TotalPoints, &r.RuleVersion, &r.TaskID, &r.EpochID,
+		&segments, &prices, &r.CalculatedAt,
+	); err != nil {
+		return r, err
+	}
+	if segments.Valid {
+		if err := json.Unmarshal([]byte(segments.String), &r.Segments); err != nil {
+			return r, fmt.Errorf("解析积分明细失败: %v", err)
+		}
+	}
+	if prices.Valid {
+		if err := json.Unmarshal([]byte(prices.String), &r.Prices); err != nil {
+			return r, fmt.Errorf("解析价格报价失败: %v", err)
+		}
+	}
+	return r, nil
+}
+
+// GetOldestBalanceChangeTime 获取最早的余额变动时间，无记录时ok为false
+func GetOldestBalanceChangeTime() (t time.Time, ok bool, err error) {
+	var oldest *time.Time
+	if err := QueryRow("SELECT MIN(event_time) FROM balance_changes").Scan(&oldest); err != nil {
+		return time.Time{}, false, err
+	}
+	if oldest == nil {
+		return time.Time{}, false, nil
+	}
+	return *oldest, true, nil
+}
+
+// GetOldestPointsHistoryTime 获取最早的积分计算历史时间，无记录时ok为false
+func GetOldestPointsHistoryTime() (t time.Time, ok bool, err error) {
+	var oldest *time.Time
+	if err := QueryRow("SELECT MIN(period_start) FROM points_calculation_history").Scan(&oldest); err != nil {
+		return time.Time{}, false, err
+	}
+	if oldest == nil {
+		return time.Time{}, false, nil
+	}
+	return *oldest, true, nil
+}
+
+// 每个用户在cutoff之前的最后一条变动，作为期初余额锚点不参与清理
+const balanceAnchorFilter = `
+    id NOT IN (
+        SELECT anchor_id FROM (
+            SELECT MAX(id) AS anchor_id FROM balance_changes
+            WHERE event_time < ?
+            GROUP BY chain_name, user_address
+        ) anchors
+    )`
+
+// StreamPrunableBalanceChanges 按时间范围[start, end)逐行读取可清理的余额变动（不含锚点）
+func StreamPrunableBalanceChanges(start, end, cutoff time.Time, fn func(BalanceChange) error) error {
+	rows, err := Query(`
+        SELECT chain_name, user_address, event_type, amount, balance_after,
+               block_number, event_time, tx_hash
+        FROM balance_changes
+        WHERE event_time >= ? AND event_time < ? AND`+balanceAnchorFilter+`
+        ORDER BY id ASC
+    `, start, end, cutoff)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c BalanceChange
+		if err := rows.Scan(
+			&c.ChainName, &c.UserAddress, &c.EventType,
+			&c.Amount, &c.BalanceAfter, &c.BlockNumber,
+			&c.EventTime, &c.TxHash,
+		); err != nil {
+			return err
+		}
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// StreamPointsHistory 按时间范围[start, end)逐行读取积分计算历史（以period_end归属）
+func StreamPointsHistory(start, end time.Time, fn func(PointsHistoryRecord) error) error {
+	rows, err := Query(`
+        SELECT id, chain_name, user_address, period_start, period_end,
+               points_added, total_points, rule_version, COALESCE(task_id, ''), COALESCE(epoch_id, 0),
+               segments, prices, calculated_at
+        FROM points_calculation_history
+        WHERE period_end >= ? AND period_end < ?
+        ORDER BY id ASC
+    `, start, end)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		r, err := scanPointsHistory(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// PruneBalanceChanges 分批删除[start, end)内的余额变动
+// 每个用户在cutoff之前的最后一条变动作为期初余额锚点保留，保证积分计算可取得期初余额
+func PruneBalanceChanges(start, end, cutoff time.Time, batchSize int) (int64, error) {
+	var total int64
+	for {
+		res, err := Exec(`
+            DELETE FROM balance_changes
+            WHERE event_time >= ? AND event_time < ? AND`+balanceAnchorFilter+`
+            LIMIT ?
+        `, start, end, cutoff, batchSize)
+		if err != nil {
+			return total, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
+
+// RollupAndPrunePointsHistory 将[start, end)内的积分历史汇总到日汇总表并删除明细，返回删除的明细行数
+// 同一用户首尾相接（或重叠）的历史合并为一行，中间有未计算的缺口时分为多行，
+// 保证汇总后缺口仍可被回溯任务补算；日期按period_end的UTC日期，不受会话时区影响
+// 按用户分批处理，每批约batchSize行明细（同一用户不拆分到两批），每批的汇总与删除在同一事务中提交，
+// 中断后重新执行只处理剩余明细，不会重复累计
+func RollupAndPrunePointsHistory(start, end time.Time, batchSize int) (int64, error) {
+	var total int64
+	for {
+		n, done, err := rollupHistoryBatch(start, end, batchSize)
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if done {
+			return total, nil
+		}
+	}
+}
+
+// 汇总并删除一批明细：按(链, 用户)排序取前batchSize行，并补齐最后一个用户的其余明细
+// done为true表示本批已包含时间范围内的全部剩余明细
+func rollupHistoryBatch(start, end time.Time, batchSize int) (int64, bool, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	// 第batchSize行所属的用户作为本批的上界，不足batchSize行时处理全部剩余明细
+	var (
+		bound               string
+		boundArgs           []any
+		done                bool
+		lastChain, lastUser string
+	)
+	err = TxQueryRow(tx, `
+        SELECT chain_name, user_address FROM points_calculation_history
+        WHERE period_end >= ? AND period_end < ?
+        ORDER BY chain_name, user_address
+        LIMIT 1 OFFSET ?
+    `, start, end, batchSize-1).Scan(&lastChain, &lastUser)
+	switch {
+	case err == sql.ErrNoRows:
+		done = true
+	case err != nil:
+		return 0, false, err
+	default:
+		bound = " AND (chain_name, user_address) <= (?, ?)"
+		boundArgs = []any{lastChain, lastUser}
+	}
+
+	runs, err := txContiguousHistoryRuns(tx, start, end, bound, boundArgs)
+	if err != nil {
+		return 0, false, err
+	}
+	for i := 0; i < len(runs); i += historyRollupChunk {
+		chunk := runs[i:min(i+historyRollupChunk, len(runs))]
+		args := make([]any, 0, len(chunk)*7)
+		for _, r := range chunk {
+			args = append(args, r.chainName, r.userAddress, r.end.UTC().Format("2006-01-02"),
+				r.start, r.end, r.points.String(), r.count)
+		}
+		_, err = TxExec(tx, `
+            INSERT INTO points_history_daily (
+                chain_name, user_address, day, period_start, period_end,
+                points_added, interval_count
+            ) VALUES `+valuesList(len(chunk), "?, ?, ?, ?, ?, ?, ?"), args...)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+
+	res, err := TxExec(tx, `
+        DELETE FROM points_calculation_history
+        WHERE period_end >= ? AND period_end < ?`+bound,
+		append([]any{start, end}, boundArgs...)...)
+	if err != nil {
+		return 0, false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, false, err
+	}
+	return n, done, tx.Commit()
+}
+
+// 单条INSERT写入的日汇总行数
+const historyRollupChunk = 500
+
+// 日汇总中的一段连续已计算时间
+type historyRun struct {
+	chainName   string
+	userAddress string
+	start, end  time.Time
+	points      decimal.Decimal
+	count       int
+}
+
+// 读取[start, end)内的积分历史，按用户合并首尾相接的时间段；bound为附加的用户范围条件
+func txContiguousHistoryRuns(tx *sql.Tx, start, end time.Time, bound string, boundArgs []any) ([]historyRun, error) {
+	rows, err := TxQuery(tx, `
+        SELECT chain_name, user_address, period_start, period_end, points_added
+        FROM points_calculation_history
+        WHERE period_end >= ? AND period_end < ?`+bound+`
+        ORDER BY chain_name, user_address, period_start
+    `, append([]any{start, end}, boundArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []historyRun
+	for rows.Next() {
+		var r historyRun
+		if err := rows.Scan(&r.chainName, &r.userAddress, &r.start, &r.end, &r.points); err != nil {
+			return nil, err
+		}
+		r.count = 1
+		// 同一UTC日内与上一段首尾相接时合并
+		if n := len(runs); n > 0 {
+			last := &runs[n-1]
+			if last.chainName == r.chainName && last.userAddress == r.userAddress &&
+				!r.start.After(last.end) && sameUTCDay(last.end, r.end) {
+				if r.end.After(last.end) {
+					last.end = r.end
+				}
+				last.points = last.points.Add(r.points)
+				last.count++
+				continue
+			}
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+func sameUTCDay(a, b time.Time) bool {
+	ay, am, ad := a.UTC().Date()
+	by, bm, bd := b.UTC().Date()
+	return ay == by && am == bm && ad == bd
+}
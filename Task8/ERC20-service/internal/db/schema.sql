@@ -7,6 +7,15 @@ CREATE TABLE IF NOT EXISTS chain_status (
     created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 
+-- 区块周期边界：积分按区块区间计算的链，由监听器记录各周期起止区块的链上时间 (MySQL)
+CREATE TABLE IF NOT EXISTS block_boundaries (
+    chain_name VARCHAR(50) NOT NULL,
+    block_number BIGINT NOT NULL,
+    block_time TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (chain_name, block_number)
+);
+
 -- 用户总余额表 (MySQL)
 CREATE TABLE IF NOT EXISTS user_balances (
     id BIGINT AUTO_INCREMENT PRIMARY KEY,
@@ -30,7 +39,63 @@ CREATE TABLE IF NOT EXISTS balance_changes (
     event_time TIMESTAMP NOT NULL,
     tx_hash VARCHAR(66) NOT NULL,
     created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-    KEY idx_chain_addr_time (chain_name, user_address, event_time)
+    KEY idx_chain_addr_time (chain_name, user_address, event_time),
+    KEY idx_chain_addr_block (chain_name, user_address, block_number),
+    KEY idx_event_time (event_time),
+    KEY idx_chain_tx (chain_name, tx_hash)
+);
+
+-- 质押变动表：StakeSystem事件，质押在合约中的代币仍归属质押者 (MySQL)
+CREATE TABLE IF NOT EXISTS stake_changes (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    chain_name VARCHAR(50) NOT NULL,
+    user_address VARCHAR(42) NOT NULL,
+    pool_id BIGINT UNSIGNED NOT NULL,
+    event_type VARCHAR(20) NOT NULL,          -- stake / unstake_request / unstake_claim
+    amount VARCHAR(100) NOT NULL,
+    staked_after VARCHAR(100) NOT NULL,       -- 变动后质押锁定余额（所有追踪资金池合计）
+    pending_after VARCHAR(100) NOT NULL,      -- 变动后已申请解押、尚未领取的余额
+    block_number BIGINT NOT NULL,
+    event_time TIMESTAMP NOT NULL,
+    tx_hash VARCHAR(66) NOT NULL,
+    log_index INT UNSIGNED NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uniq_chain_log (chain_name, tx_hash, log_index),
+    KEY idx_chain_addr_time (chain_name, user_address, event_time),
+    KEY idx_chain_addr_block (chain_name, user_address, block_number)
+);
+
+-- 用户最新质押持仓表：随质押变动在同一事务中更新 (MySQL)
+CREATE TABLE IF NOT EXISTS user_stakes (
+    chain_name VARCHAR(50) NOT NULL,
+    user_address VARCHAR(42) NOT NULL,
+    staked VARCHAR(100) NOT NULL DEFAULT '0',   -- 质押锁定余额
+    pending VARCHAR(100) NOT NULL DEFAULT '0',  -- 已申请解押、尚未领取的余额
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (chain_name, user_address)
+);
+
+-- 排除地址表：不参与积分与持有者统计，与配置中的静态列表合并生效 (MySQL)
+CREATE TABLE IF NOT EXISTS excluded_addresses (
+    chain_name VARCHAR(50) NOT NULL,
+    address VARCHAR(42) NOT NULL,
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    actor VARCHAR(64) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (chain_name, address)
+);
+
+-- 推荐关系表：被推荐人入账持有积分时按层级为上级推荐人记入推荐奖励 (MySQL)
+CREATE TABLE IF NOT EXISTS referrals (
+    chain_name VARCHAR(50) NOT NULL,
+    referee_address VARCHAR(42) NOT NULL,     -- 被推荐人，每条链至多一个推荐人
+    referrer_address VARCHAR(42) NOT NULL,
+    source VARCHAR(20) NOT NULL,              -- import/chain
+    actor VARCHAR(64) NOT NULL DEFAULT '',    -- 导入操作人，链上注册为空
+    tx_hash VARCHAR(66) NOT NULL DEFAULT '',  -- 链上注册交易
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (chain_name, referee_address),
+    KEY idx_chain_referrer (chain_name, referrer_address)
 );
 
 -- 用户总积分表 (MySQL)
@@ -54,6 +119,193 @@ CREATE TABLE IF NOT EXISTS points_calculation_history (
     period_end TIMESTAMP NOT NULL,
     points_added DECIMAL(30,6) NOT NULL,
     total_points DECIMAL(30,6) NOT NULL,
+    rule_version VARCHAR(64) NOT NULL DEFAULT '',
+    task_id CHAR(64) NULL,
+    epoch_id BIGINT NULL,                 -- 积分周期ID：floor(UTC秒 / 周期秒数)，对齐前的历史数据为NULL
+    block_start BIGINT NULL,              -- 区块周期起始区块（含），时间周期为NULL
+    block_end BIGINT NULL,                -- 区块周期结束区块（不含）
+    segments JSON NULL,                   -- 各定价子段的积分明细（points.persist_segments开启时保存）
+    season_id VARCHAR(32) NULL,           -- 周期开始时刻所属的赛季，赛季结算后迟到的任务为NULL
+    prices JSON NULL,                     -- 按USD价值计算时使用的报价，按代币数量计算时为NULL
     calculated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-    KEY idx_chain_addr_period (chain_name, user_address, period_start)
+    UNIQUE KEY uniq_task_id (task_id),
+    KEY idx_chain_addr_period (chain_name, user_address, period_start),
+    KEY idx_chain_addr_epoch (chain_name, user_address, epoch_id),
+    KEY idx_period_end (period_end)
+);
+
+-- 跨链汇总积分表：按地址汇总各链积分 × 链权重，随流水增量更新 (MySQL)
+CREATE TABLE IF NOT EXISTS user_points_aggregate (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_address VARCHAR(42) NOT NULL,
+    total_points DECIMAL(30,6) NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY uniq_user (user_address)
+);
+
+-- 排行榜刷新状态：每个榜单一行，刷新时加锁，记录最近刷新时间 (MySQL)
+CREATE TABLE IF NOT EXISTS leaderboard_boards (
+    board VARCHAR(50) PRIMARY KEY,
+    refreshed_at TIMESTAMP NULL
+);
+
+-- 排行榜排名表：每轮调度后按积分更新有变化的行并删除落榜用户，board为链名或global (MySQL)
+CREATE TABLE IF NOT EXISTS leaderboard_ranks (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    board VARCHAR(50) NOT NULL,
+    user_address VARCHAR(42) NOT NULL,
+    total_points DECIMAL(30,6) NOT NULL,
+    rank_no INT NOT NULL,
+    refreshed_at TIMESTAMP NOT NULL,          -- 该行排名或积分最近变化的时间
+    UNIQUE KEY uniq_board_user (board, user_address),
+    KEY idx_board_rank (board, rank_no)
+);
+
+-- 排行榜每日快照：每天最后一次刷新的排名，在次日首次刷新时写入一次，用于计算排名变化 (MySQL)
+CREATE TABLE IF NOT EXISTS leaderboard_snapshots (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    board VARCHAR(50) NOT NULL,
+    day DATE NOT NULL,
+    user_address VARCHAR(42) NOT NULL,
+    total_points DECIMAL(30,6) NOT NULL,
+    rank_no INT NOT NULL,
+    UNIQUE KEY uniq_board_day_user (board, day, user_address),
+    KEY idx_day (day)
+);
+
+-- 赛季表：[start_at, end_at) 内开始的积分周期计入赛季积分，同一条链上的赛季时间不重叠 (MySQL)
+CREATE TABLE IF NOT EXISTS seasons (
+    id VARCHAR(32) PRIMARY KEY,
+    name VARCHAR(100) NOT NULL DEFAULT '',
+    start_at TIMESTAMP NOT NULL,
+    end_at TIMESTAMP NULL,                    -- 为NULL表示尚未结束
+    chains JSON NULL,                         -- 参与的链，为NULL表示所有链
+    multiplier DECIMAL(20,6) NOT NULL DEFAULT 1, -- 持有积分与推荐奖励计入赛季时的倍数
+    rule_set VARCHAR(64) NULL,                -- 赛季内周期固定使用的规则集版本，为NULL表示按生效时间选择
+    status VARCHAR(20) NOT NULL,              -- open/closed/finalized
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finalized_at TIMESTAMP NULL
+);
+
+-- 赛季积分表：随带赛季标记的流水增量累加，终身积分仍在 user_points (MySQL)
+CREATE TABLE IF NOT EXISTS season_points (
+    season_id VARCHAR(32) NOT NULL,
+    chain_name VARCHAR(50) NOT NULL,
+    user_address VARCHAR(42) NOT NULL,
+    points DECIMAL(30,6) NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (season_id, chain_name, user_address),
+    KEY idx_chain_user (chain_name, user_address)
+);
+
+-- 赛季最终快照：结算时按链排名写入，结算后不再变化 (MySQL)
+CREATE TABLE IF NOT EXISTS season_snapshots (
+    season_id VARCHAR(32) NOT NULL,
+    chain_name VARCHAR(50) NOT NULL,
+    user_address VARCHAR(42) NOT NULL,
+    points DECIMAL(30,6) NOT NULL,
+    rank_no INT NOT NULL,
+    PRIMARY KEY (season_id, chain_name, user_address),
+    KEY idx_season_rank (season_id, chain_name, rank_no)
+);
+
+-- 积分流水表：带符号的积分变更记录，user_points.total_points 为流水之和 (MySQL)
+CREATE TABLE IF NOT EXISTS points_ledger (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    chain_name VARCHAR(50) NOT NULL,
+    user_address VARCHAR(42) NOT NULL,
+    amount DECIMAL(30,6) NOT NULL,            -- 正数入账，负数扣回
+    entry_type VARCHAR(20) NOT NULL,          -- opening/accrual/grant/revoke/expire/decay/referral
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    actor VARCHAR(64) NOT NULL,               -- 操作人，自动入账为system
+    reference VARCHAR(128) NOT NULL DEFAULT '', -- 关联标识：任务ID、工单号等
+    season_id VARCHAR(32) NULL,               -- 同时计入的赛季，为NULL表示只计入终身积分
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    earned_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, -- 获得时间：周期积分为周期结束时间，人工调整等为记账时间
+    KEY idx_chain_user (chain_name, user_address, id),
+    KEY idx_chain_earned (chain_name, earned_at),
+    KEY idx_reference (reference)
+);
+
+-- 积分批次表：每条正数流水形成一个批次，负数流水按获得时间先进先出扣减，用于精确到期 (MySQL)
+CREATE TABLE IF NOT EXISTS points_lots (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    chain_name VARCHAR(50) NOT NULL,
+    user_address VARCHAR(42) NOT NULL,
+    ledger_id BIGINT NOT NULL,                -- 形成批次的正数流水
+    earned_at TIMESTAMP NOT NULL,             -- 获得时间，到期时间 = 获得时间 + 有效期
+    amount DECIMAL(30,6) NOT NULL,
+    remaining DECIMAL(30,6) NOT NULL,         -- 剩余未扣减数量
+    KEY idx_chain_user_earned (chain_name, user_address, earned_at),
+    KEY idx_earned (earned_at)
+);
+
+-- 积分批次扣减记录：每条负数流水扣减了哪些批次 (MySQL)
+CREATE TABLE IF NOT EXISTS points_lot_usage (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    lot_id BIGINT NOT NULL,
+    ledger_id BIGINT NOT NULL,                -- 扣减批次的负数流水
+    amount DECIMAL(30,6) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    KEY idx_lot (lot_id),
+    KEY idx_ledger (ledger_id)
+);
+
+-- 事务性发件箱表：与状态变更同事务写入，由中继协程投递到MQ (MySQL)
+CREATE TABLE IF NOT EXISTS outbox (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    topic VARCHAR(50) NOT NULL,
+    payload JSON NOT NULL,
+    status VARCHAR(10) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_error VARCHAR(500) NOT NULL DEFAULT '',
+    sent_at TIMESTAMP NULL DEFAULT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    KEY idx_status_next (status, next_attempt_at)
+);
+
+-- 积分计算历史日汇总表：明细归档清理后仍可判断时间段是否已计算，每行为一段首尾相接的已计算时间 (MySQL)
+CREATE TABLE IF NOT EXISTS points_history_daily (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    chain_name VARCHAR(50) NOT NULL,
+    user_address VARCHAR(42) NOT NULL,
+    day DATE NOT NULL,
+    period_start TIMESTAMP NOT NULL,
+    period_end TIMESTAMP NOT NULL,
+    points_added DECIMAL(30,6) NOT NULL,
+    interval_count INT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY uniq_chain_user_start (chain_name, user_address, period_start),
+    KEY idx_chain_user_day (chain_name, user_address, day)
+);
+
+-- 女巫聚类表：sybil analyze 按链整体替换，分数0-1 (MySQL)
+CREATE TABLE IF NOT EXISTS sybil_clusters (
+    chain_name VARCHAR(50) NOT NULL,
+    cluster_id VARCHAR(16) NOT NULL,
+    score DECIMAL(6,4) NOT NULL,
+    size INT NOT NULL,
+    funders JSON NOT NULL,
+    funded DECIMAL(6,4) NOT NULL,
+    lockstep DECIMAL(6,4) NOT NULL,
+    internal DECIMAL(6,4) NOT NULL,
+    analyzed_at TIMESTAMP NOT NULL,
+    detected_at TIMESTAMP NOT NULL, -- 首次检测到该聚类的分析时间
+    PRIMARY KEY (chain_name, cluster_id),
+    KEY idx_chain_score (chain_name, score)
+);
+
+-- 女巫聚类成员表：地址为小写，积分计算按分数与当前阈值决定是否处理 (MySQL)
+CREATE TABLE IF NOT EXISTS sybil_addresses (
+    chain_name VARCHAR(50) NOT NULL,
+    address VARCHAR(42) NOT NULL,
+    cluster_id VARCHAR(16) NOT NULL,
+    score DECIMAL(6,4) NOT NULL,
+    reasons VARCHAR(100) NOT NULL DEFAULT '',
+    detected_at TIMESTAMP NOT NULL, -- 首次检测到地址在聚类中的分析时间
+    flagged_at TIMESTAMP NULL,      -- 所在聚类分数首次达到处理阈值的分析时间，只处理此后开始的周期；未达到阈值时为NULL
+    PRIMARY KEY (chain_name, address),
+    KEY idx_chain_cluster (chain_name, cluster_id)
 );
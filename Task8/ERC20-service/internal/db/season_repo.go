@@ -0,0 +1,561 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"erc20-service/pkg/decimal"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 赛季状态：open 进行中（未设置结束时间或尚未到结束时间）→ closed 已设置结束时间 → finalized 已生成最终快照
+const (
+	SeasonStatusOpen      = "open"
+	SeasonStatusClosed    = "closed"
+	SeasonStatusFinalized = "finalized"
+)
+
+var (
+	// ErrSeasonNotFound 赛季不存在
+	ErrSeasonNotFound = errors.New("赛季不存在")
+	// ErrSeasonOverlap 同一条链上的赛季时间重叠
+	ErrSeasonOverlap = errors.New("赛季时间与已有赛季重叠")
+	// ErrSeasonFinalized 赛季已生成最终快照，不再接受积分
+	ErrSeasonFinalized = errors.New("赛季已结算")
+)
+
+// Season 赛季：[Start, End) 内开始的积分周期计入赛季积分，End为零值表示尚未结束
+// 赛季积分 = 持有积分与推荐奖励 × Multiplier，人工调整按原值计入；Chains为空表示所有链
+// RuleSet 非空时赛季内的周期固定按该版本的规则集计算，为空时按规则集生效时间选择
+type Season struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	Start       time.Time       `json:"start"`
+	End         time.Time       `json:"end,omitempty"`
+	Chains      []string        `json:"chains,omitempty"`
+	Multiplier  decimal.Decimal `json:"multiplier"`
+	RuleSet     string          `json:"rule_set,omitempty"`
+	Status      string          `json:"status"`
+	CreatedAt   time.Time       `json:"created_at"`
+	FinalizedAt time.Time       `json:"finalized_at,omitempty"`
+}
+
+// Covers 时刻t是否在赛季内且赛季包含该链
+func (s Season) Covers(chainName string, t time.Time) bool {
+	if t.Before(s.Start) || (!s.End.IsZero() && !t.Before(s.End)) {
+		return false
+	}
+	return s.hasChain(chainName)
+}
+
+func (s Season) hasChain(chainName string) bool {
+	if len(s.Chains) == 0 {
+		return true
+	}
+	for _, c := range s.Chains {
+		if c == chainName {
+			return true
+		}
+	}
+	return false
+}
+
+// 两个赛季是否在同一条链上时间重叠
+func (s Season) overlaps(o Season) bool {
+	if !s.End.IsZero() && !o.Start.Before(s.End) {
+		return false
+	}
+	if !o.End.IsZero() && !s.Start.Before(o.End) {
+		return false
+	}
+	if len(s.Chains) == 0 || len(o.Chains) == 0 {
+		return true
+	}
+	for _, c := range s.Chains {
+		if o.hasChain(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// SeasonStanding 赛季积分排名
+type SeasonStanding struct {
+	SeasonID    string          `json:"season_id"`
+	ChainName   string          `json:"chain_name"`
+	UserAddress string          `json:"user_address"`
+	Points      decimal.Decimal `json:"points"`
+	Rank        int             `json:"rank"`
+}
+
+// SeasonAt 在赛季列表中查找链上时刻t所属的赛季ID，不属于任何赛季时为空
+func SeasonAt(seasons []Season, chainName string, t time.Time) string {
+	for _, s := range seasons {
+		if s.Covers(chainName, t) {
+			return s.ID
+		}
+	}
+	return ""
+}
+
+const seasonColumns = "id, name, start_at, end_at, chains, multiplier, rule_set, status, created_at, finalized_at"
+
+func scanSeason(scan func(dest ...any) error) (Season, error) {
+	var (
+		s         Season
+		end, fin  sql.NullTime
+		chainsRaw sql.NullString
+		ruleSet   sql.NullString
+	)
+	if err := scan(&s.ID, &s.Name, &s.Start, &end, &chainsRaw, &s.Multiplier, &ruleSet, &s.Status, &s.CreatedAt, &fin); err != nil {
+		return s, err
+	}
+	s.End, s.FinalizedAt, s.RuleSet = end.Time, fin.Time, ruleSet.String
+	if chainsRaw.Valid && chainsRaw.String != "" {
+		if err := json.Unmarshal([]byte(chainsRaw.String), &s.Chains); err != nil {
+			return s, fmt.Errorf("解析赛季链列表失败: %v", err)
+		}
+	}
+	return s, nil
+}
+
+// ListSeasons 获取全部赛季，按开始时间排序
+func ListSeasons() ([]Season, error) {
+	rows, err := Query("SELECT " + seasonColumns + " FROM seasons ORDER BY start_at, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var seasons []Season
+	for rows.Next() {
+		s, err := scanSeason(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		seasons = append(seasons, s)
+	}
+	return seasons, rows.Err()
+}
+
+// GetSeason 按ID获取赛季
+func GetSeason(id string) (Season, error) {
+	s, err := scanSeason(QueryRow("SELECT "+seasonColumns+" FROM seasons WHERE id = ?", id).Scan)
+	if err == sql.ErrNoRows {
+		return s, ErrSeasonNotFound
+	}
+	return s, err
+}
+
+// GetSeasonAt 查询链上时刻t所属的赛季ID，不属于任何赛季时为空
+func GetSeasonAt(chainName string, t time.Time) (string, error) {
+	rows, err := Query(`
+        SELECT `+seasonColumns+` FROM seasons
+        WHERE start_at <= ? AND (end_at IS NULL OR end_at > ?)
+    `, t, t)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var seasons []Season
+	for rows.Next() {
+		s, err := scanSeason(rows.Scan)
+		if err != nil {
+			return "", err
+		}
+		seasons = append(seasons, s)
+	}
+	return SeasonAt(seasons, chainName, t), rows.Err()
+}
+
+// OpenSeason 创建赛季，同一条链上的赛季时间不能重叠
+func OpenSeason(s Season) error {
+	if s.Multiplier.Sign() <= 0 {
+		return fmt.Errorf("赛季倍数必须为正")
+	}
+	if !s.End.IsZero() && !s.End.After(s.Start) {
+		return fmt.Errorf("赛季结束时间必须晚于开始时间")
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// 锁定赛季表，串行化重叠检查
+	rows, err := TxQuery(tx, "SELECT "+seasonColumns+" FROM seasons FOR UPDATE")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		existing, err := scanSeason(rows.Scan)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		if existing.ID == s.ID {
+			rows.Close()
+			return fmt.Errorf("赛季 %s 已存在", s.ID)
+		}
+		if existing.overlaps(s) {
+			rows.Close()
+			return fmt.Errorf("%w: %s", ErrSeasonOverlap, existing.ID)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	status := SeasonStatusOpen
+	if !s.End.IsZero() {
+		status = SeasonStatusClosed
+	}
+	_, err = TxExec(tx, `
+        INSERT INTO seasons (id, name, start_at, end_at, chains, multiplier, rule_set, status)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+    `, s.ID, s.Name, s.Start, nullTime(s.End), seasonChainsJSON(s.Chains), s.Multiplier, nullString(s.RuleSet), status)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CloseSeason 设置赛季结束时间，结束时间不能与之后的赛季重叠
+func CloseSeason(id string, end time.Time) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := TxQuery(tx, "SELECT "+seasonColumns+" FROM seasons FOR UPDATE")
+	if err != nil {
+		return err
+	}
+	var (
+		target *Season
+		others []Season
+	)
+	for rows.Next() {
+		s, err := scanSeason(rows.Scan)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		if s.ID == id {
+			target = &s
+			continue
+		}
+		others = append(others, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if target == nil {
+		return ErrSeasonNotFound
+	}
+	if target.Status == SeasonStatusFinalized {
+		return ErrSeasonFinalized
+	}
+	if !end.After(target.Start) {
+		return fmt.Errorf("赛季结束时间必须晚于开始时间 %s", target.Start.UTC().Format(time.RFC3339))
+	}
+	target.End = end
+	for _, o := range others {
+		if o.overlaps(*target) {
+			return fmt.Errorf("%w: %s", ErrSeasonOverlap, o.ID)
+		}
+	}
+
+	_, err = TxExec(tx, "UPDATE seasons SET end_at = ?, status = ? WHERE id = ?", end, SeasonStatusClosed, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// FinalizeSeason 生成赛季最终快照：按链排名写入season_snapshots并将赛季标记为已结算，返回快照行数
+// 结算后迟到的积分任务只计入终身积分，不再计入赛季积分
+func FinalizeSeason(id string) (int64, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = TxQueryRow(tx, "SELECT status FROM seasons WHERE id = ? FOR UPDATE", id).Scan(&status)
+	if err == sql.ErrNoRows {
+		return 0, ErrSeasonNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	switch status {
+	case SeasonStatusFinalized:
+		return 0, ErrSeasonFinalized
+	case SeasonStatusOpen:
+		return 0, fmt.Errorf("赛季 %s 尚未设置结束时间，请先执行 close", id)
+	}
+
+	res, err := TxExec(tx, `
+        INSERT INTO season_snapshots (season_id, chain_name, user_address, points, rank_no)
+        SELECT season_id, chain_name, user_address, points,
+               ROW_NUMBER() OVER (PARTITION BY chain_name ORDER BY points DESC, user_address)
+        FROM season_points
+        WHERE season_id = ? AND points > 0
+    `, id)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	_, err = TxExec(tx, `
+        UPDATE seasons SET status = ?, finalized_at = CURRENT_TIMESTAMP WHERE id = ?
+    `, SeasonStatusFinalized, id)
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// GetSeasonStandings 获取赛季链上排名：已结算的赛季读取最终快照，否则按当前赛季积分实时排名
+func GetSeasonStandings(season Season, chainName string, limit int) ([]SeasonStanding, error) {
+	query := `
+        SELECT season_id, chain_name, user_address, points,
+               ROW_NUMBER() OVER (ORDER BY points DESC, user_address)
+        FROM season_points
+        WHERE season_id = ? AND chain_name = ? AND points > 0
+        ORDER BY points DESC, user_address
+        LIMIT ?`
+	if season.Status == SeasonStatusFinalized {
+		query = `
+        SELECT season_id, chain_name, user_address, points, rank_no
+        FROM season_snapshots
+        WHERE season_id = ? AND chain_name = ?
+        ORDER BY rank_no
+        LIMIT ?`
+	}
+	rows, err := ReadQuery(query, season.ID, chainName, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var standings []SeasonStanding
+	for rows.Next() {
+		var s SeasonStanding
+		if err := rows.Scan(&s.SeasonID, &s.ChainName, &s.UserAddress, &s.Points, &s.Rank); err != nil {
+			return nil, err
+		}
+		standings = append(standings, s)
+	}
+	return standings, rows.Err()
+}
+
+// GetUserSeasonPoints 获取地址在各赛季的积分（键为赛季ID）
+func GetUserSeasonPoints(chainName, userAddr string) (map[string]decimal.Decimal, error) {
+	rows, err := ReadQuery(`
+        SELECT season_id, points FROM season_points
+        WHERE chain_name = ? AND user_address = ?
+    `, chainName, userAddr)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	points := make(map[string]decimal.Decimal)
+	for rows.Next() {
+		var (
+			id string
+			p  decimal.Decimal
+		)
+		if err := rows.Scan(&id, &p); err != nil {
+			return nil, err
+		}
+		points[id] = p
+	}
+	return points, rows.Err()
+}
+
+// CheckSeasonWritable 校验赛季存在且未结算
+func CheckSeasonWritable(id string) error {
+	s, err := GetSeason(id)
+	if err != nil {
+		return err
+	}
+	if s.Status == SeasonStatusFinalized {
+		return ErrSeasonFinalized
+	}
+	return nil
+}
+
+// 事务内查询赛季是否仍接受积分（存在且未结算），赛季行加共享锁
+func txSeasonAccepting(tx *sql.Tx, id string) (bool, error) {
+	var status string
+	err := TxQueryRow(tx, "SELECT status FROM seasons WHERE id = ? FOR SHARE", id).Scan(&status)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return status != SeasonStatusFinalized, nil
+}
+
+// 赛季积分增量：流水金额与是否按赛季倍数换算
+type seasonDelta struct {
+	season     string
+	user       string
+	amount     decimal.Decimal
+	multiplied bool
+}
+
+// 批量累加赛季积分（赛季行加共享锁），已结算或不存在的赛季跳过
+// 返回实际计入的赛季集合，调用方据此决定流水是否标记赛季
+func txAddSeasonPoints(tx *sql.Tx, chainName string, deltas []seasonDelta) (map[string]bool, error) {
+	if len(deltas) == 0 {
+		return nil, nil
+	}
+	var ids []string
+	seen := make(map[string]bool)
+	for _, d := range deltas {
+		if !seen[d.season] {
+			seen[d.season] = true
+			ids = append(ids, d.season)
+		}
+	}
+	sort.Strings(ids)
+
+	args := make([]any, 0, len(ids)+1)
+	args = append(args, SeasonStatusFinalized)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	rows, err := TxQuery(tx, `
+        SELECT id, multiplier FROM seasons
+        WHERE status <> ? AND id IN `+placeholders(len(ids))+`
+        FOR SHARE`, args...)
+	if err != nil {
+		return nil, err
+	}
+	multipliers := make(map[string]decimal.Decimal, len(ids))
+	for rows.Next() {
+		var (
+			id string
+			m  decimal.Decimal
+		)
+		if err := rows.Scan(&id, &m); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		multipliers[id] = m
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// 同一赛季同一用户合并为一行
+	type key struct{ season, user string }
+	var (
+		order []key
+		sums  = make(map[key]decimal.Decimal)
+		users = make(map[key]string)
+	)
+	for _, d := range deltas {
+		m, ok := multipliers[d.season]
+		if !ok {
+			continue
+		}
+		amount := d.amount
+		if d.multiplied {
+			amount = amount.MulRat(m.Rat())
+		}
+		k := key{d.season, AddrKey(d.user)}
+		if _, ok := sums[k]; !ok {
+			order = append(order, k)
+			sums[k] = decimal.Zero()
+			users[k] = d.user
+		}
+		sums[k] = sums[k].Add(amount)
+	}
+	if len(order) == 0 {
+		return nil, nil
+	}
+	sort.Slice(order, func(i, j int) bool {
+		if order[i].season != order[j].season {
+			return order[i].season < order[j].season
+		}
+		return order[i].user < order[j].user
+	})
+
+	args = args[:0]
+	for _, k := range order {
+		args = append(args, k.season, chainName, users[k], sums[k])
+	}
+	if _, err := TxExec(tx, `
+        INSERT INTO season_points (season_id, chain_name, user_address, points)
+        VALUES `+valuesList(len(order), "?, ?, ?, ?")+`
+        ON DUPLICATE KEY UPDATE points = points + VALUES(points), updated_at = CURRENT_TIMESTAMP`,
+		args...); err != nil {
+		return nil, err
+	}
+
+	credited := make(map[string]bool, len(multipliers))
+	for id := range multipliers {
+		credited[id] = true
+	}
+	return credited, nil
+}
+
+// 流水类型是否按赛季倍数换算（自动入账的持有积分与推荐奖励）
+func seasonMultiplied(entryType string) bool {
+	return entryType == LedgerTypeAccrual || entryType == LedgerTypeReferral
+}
+
+// 赛季ID为空时写入NULL
+func nullSeason(id string) any {
+	if id == "" {
+		return nil
+	}
+	return id
+}
+
+// 零值时间写入NULL
+func nullTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// 空字符串写入NULL
+func nullString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// 赛季链列表序列化为JSON，为空时写入NULL
+func seasonChainsJSON(chains []string) any {
+	if len(chains) == 0 {
+		return nil
+	}
+	data, _ := json.Marshal(chains)
+	return string(data)
+}
+
+// ValidSeasonID 赛季ID只允许字母、数字、连字符与下划线
+func ValidSeasonID(id string) bool {
+	if id == "" || len(id) > 32 {
+		return false
+	}
+	return strings.Trim(id, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_") == ""
+}
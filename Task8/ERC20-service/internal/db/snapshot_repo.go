@@ -0,0 +1,80 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// ColumnInfo 表字段信息
+type ColumnInfo struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // 数据库类型名，如 BIGINT、VARCHAR、TIMESTAMP
+}
+
+// TxStreamTable 在事务中按主键顺序逐行读取整表（可附加过滤条件），行值为驱动原始值
+func TxStreamTable(tx *sql.Tx, table, where string, args []any, fn func(cols []ColumnInfo, row []any) error) error {
+	query := "SELECT * FROM " + table
+	if where != "" {
+		query += " WHERE " + where
+	}
+	query += " ORDER BY id ASC"
+
+	rows, err := TxQuery(tx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+	cols := make([]ColumnInfo, len(types))
+	for i, t := range types {
+		cols[i] = ColumnInfo{Name: t.Name(), Type: t.DatabaseTypeName()}
+	}
+
+	for rows.Next() {
+		values := make([]any, len(cols))
+		dest := make([]any, len(cols))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		if err := fn(cols, values); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// CountTableRows 统计表行数
+func CountTableRows(table string) (int64, error) {
+	var n int64
+	err := QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n)
+	return n, err
+}
+
+// TxInsertRows 在事务中批量插入行
+func TxInsertRows(tx *sql.Tx, table string, cols []string, rows [][]any) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	placeholder := "(" + strings.TrimSuffix(strings.Repeat("?,", len(cols)), ",") + ")"
+	values := make([]string, len(rows))
+	args := make([]any, 0, len(rows)*len(cols))
+	for i, row := range rows {
+		if len(row) != len(cols) {
+			return fmt.Errorf("表 %s 第%d行字段数不匹配", table, i)
+		}
+		values[i] = placeholder
+		args = append(args, row...)
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s",
+		table, "`"+strings.Join(cols, "`,`")+"`", strings.Join(values, ","))
+	_, err := TxExec(tx, query, args...)
+	return err
+}
@@ -0,0 +1,179 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// 质押变动类型
+const (
+	StakeEventStake          = "stake"           // 质押：计入锁定余额
+	StakeEventUnstakeRequest = "unstake_request" // 申请解押：锁定余额转为待领取
+	StakeEventUnstakeClaim   = "unstake_claim"   // 领取解押：待领取余额转回钱包
+)
+
+// StakeChange 质押变动记录，StakedAfter、PendingAfter为用户在所有追踪资金池的合计
+type StakeChange struct {
+	ChainName    string
+	UserAddress  string
+	PoolID       uint64
+	EventType    string
+	Amount       string
+	StakedAfter  string
+	PendingAfter string
+	BlockNumber  uint64
+	EventTime    time.Time
+	TxHash       string
+	LogIndex     uint
+}
+
+// StakePosition 质押持仓（最小单位）
+type StakePosition struct {
+	Staked  string // 质押锁定余额
+	Pending string // 已申请解押、尚未领取的余额
+}
+
+// ErrStakeEventRecorded 同一日志已记录（重复处理区块）
+var ErrStakeEventRecorded = errors.New("质押事件已记录")
+
+// RecordStakeChange 记录质押变动：在事务内基于用户上一条记录计算变动后持仓，返回填充后的记录
+// 质押追踪上线前已存在的质押无记录，解押时持仓最低扣减到0
+func RecordStakeChange(change StakeChange) (StakeChange, error) {
+	amount, ok := new(big.Int).SetString(change.Amount, 10)
+	if !ok {
+		return change, fmt.Errorf("质押数量无效: %s", change.Amount)
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return change, err
+	}
+	defer tx.Rollback()
+
+	var stakedStr, pendingStr string
+	err = TxQueryRow(tx, `
+        SELECT staked_after, pending_after FROM stake_changes
+        WHERE chain_name = ? AND user_address = ?
+        ORDER BY event_time DESC, id DESC
+        LIMIT 1
+        FOR UPDATE
+    `, change.ChainName, change.UserAddress).Scan(&stakedStr, &pendingStr)
+	if err == sql.ErrNoRows {
+		stakedStr, pendingStr = "0", "0"
+	} else if err != nil {
+		return change, err
+	}
+	staked := parseWei(stakedStr)
+	pending := parseWei(pendingStr)
+
+	switch change.EventType {
+	case StakeEventStake:
+		staked.Add(staked, amount)
+	case StakeEventUnstakeRequest:
+		staked = subFloorZero(staked, amount)
+		pending.Add(pending, amount)
+	case StakeEventUnstakeClaim:
+		pending = subFloorZero(pending, amount)
+	default:
+		return change, fmt.Errorf("未知质押变动类型: %s", change.EventType)
+	}
+	change.StakedAfter = staked.String()
+	change.PendingAfter = pending.String()
+
+	_, err = TxExec(tx, `
+        INSERT INTO stake_changes (
+            chain_name, user_address, pool_id, event_type, amount, staked_after, pending_after,
+            block_number, event_time, tx_hash, log_index
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    `,
+		change.ChainName, change.UserAddress, change.PoolID, change.EventType, change.Amount,
+		change.StakedAfter, change.PendingAfter, change.BlockNumber, change.EventTime,
+		change.TxHash, change.LogIndex,
+	)
+	if err != nil {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrDuplicateEntry {
+			return change, ErrStakeEventRecorded
+		}
+		return change, err
+	}
+
+	// 最新持仓，批量计算按此取在周期开始后无变动的用户的期初持仓
+	_, err = TxExec(tx, `
+        INSERT INTO user_stakes (chain_name, user_address, staked, pending)
+        VALUES (?, ?, ?, ?)
+        ON DUPLICATE KEY UPDATE staked = VALUES(staked), pending = VALUES(pending)
+    `, change.ChainName, change.UserAddress, change.StakedAfter, change.PendingAfter)
+	if err != nil {
+		return change, err
+	}
+	return change, tx.Commit()
+}
+
+// GetStakeAt 获取用户在指定时间点的期初质押持仓：取该时间之前最后一次变动后的持仓
+func GetStakeAt(chainName, userAddr string, at time.Time) (StakePosition, error) {
+	var p StakePosition
+	err := QueryRow(`
+        SELECT staked_after, pending_after FROM stake_changes
+        WHERE chain_name = ? AND user_address = ? AND event_time < ?
+        ORDER BY event_time DESC, id DESC
+        LIMIT 1
+    `, chainName, userAddr, at).Scan(&p.Staked, &p.Pending)
+	if err == sql.ErrNoRows {
+		return StakePosition{Staked: "0", Pending: "0"}, nil
+	}
+	return p, err
+}
+
+// GetStakeChangesInPeriod 获取指定时间段的质押变动，边界与GetBalanceChangesInPeriod一致
+func GetStakeChangesInPeriod(chainName, userAddr string, start, end time.Time) ([]StakeChange, error) {
+	rows, err := Query(`
+        SELECT chain_name, user_address, pool_id, event_type, amount, staked_after, pending_after,
+               block_number, event_time, tx_hash, log_index
+        FROM stake_changes
+        WHERE chain_name = ?
+          AND user_address = ?
+          AND event_time BETWEEN ? AND ?
+        ORDER BY event_time ASC, id ASC
+    `, chainName, userAddr, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var changes []StakeChange
+	for rows.Next() {
+		var c StakeChange
+		if err := rows.Scan(
+			&c.ChainName, &c.UserAddress, &c.PoolID, &c.EventType, &c.Amount,
+			&c.StakedAfter, &c.PendingAfter, &c.BlockNumber, &c.EventTime,
+			&c.TxHash, &c.LogIndex,
+		); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// 解析最小单位数量，无效时为0
+func parseWei(s string) *big.Int {
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return new(big.Int)
+	}
+	return v
+}
+
+// a - b，结果不小于0
+func subFloorZero(a, b *big.Int) *big.Int {
+	r := new(big.Int).Sub(a, b)
+	if r.Sign() < 0 {
+		return new(big.Int)
+	}
+	return r
+}
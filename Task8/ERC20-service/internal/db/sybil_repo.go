@@ -0,0 +1,376 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"erc20-service/internal/sybil"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// 女巫聚类处理方式
+const (
+	SybilActionDownweight = "downweight" // 按配置的倍数降低积分
+	SybilActionExclude    = "exclude"    // 不计积分
+)
+
+// 单条INSERT写入的聚类成员数
+const sybilInsertChunk = 500
+
+// SybilCluster 链上的一个女巫聚类
+type SybilCluster struct {
+	ChainName  string    `json:"chain_name"`
+	ClusterID  string    `json:"cluster_id"`
+	Score      float64   `json:"score"`
+	Size       int       `json:"size"`
+	Funders    []string  `json:"funders"`
+	Funded     float64   `json:"funded"`   // 首笔资金来自共同来源的成员占比
+	Lockstep   float64   `json:"lockstep"` // 有同步操作的成员占比
+	Internal   float64   `json:"internal"` // 成员转账中两端均在聚类内的占比
+	AnalyzedAt time.Time `json:"analyzed_at"`
+	DetectedAt time.Time `json:"detected_at"` // 首次检测到该聚类的分析时间
+}
+
+// SybilAddress 聚类成员
+type SybilAddress struct {
+	ChainName  string     `json:"chain_name"`
+	Address    string     `json:"address"`
+	ClusterID  string     `json:"cluster_id"`
+	Score      float64    `json:"score"`
+	Reasons    []string   `json:"reasons"`
+	DetectedAt time.Time  `json:"detected_at"`          // 首次检测到地址在聚类中的分析时间，重新分析时保留
+	FlaggedAt  *time.Time `json:"flagged_at,omitempty"` // 所在聚类分数首次达到处理阈值的分析时间，未达到时为空
+}
+
+// SybilPenalty 地址所在聚类达到处理阈值时的积分权重，Weight为0表示不计积分
+// 只处理开始时间不早于FlaggedAt的周期：达到阈值前的周期（回填、延迟入账）不按之后的聚类结果追溯处理
+type SybilPenalty struct {
+	ClusterID string
+	Score     float64
+	Weight    *big.Rat
+	FlaggedAt time.Time
+}
+
+// ActiveAt 开始于periodStart的周期适用的处理，地址在周期开始后才达到处理阈值时返回nil
+func (p *SybilPenalty) ActiveAt(periodStart time.Time) *SybilPenalty {
+	if p == nil || periodStart.Before(p.FlaggedAt) {
+		return nil
+	}
+	return p
+}
+
+// String 积分明细规则说明中的写法，如 sybil:1a2b3c4d5e6f7a8b×0.2，不计积分时为×0
+func (p SybilPenalty) String() string {
+	return "sybil:" + p.ClusterID + "×" + strings.TrimRight(strings.TrimRight(p.Weight.FloatString(6), "0"), ".")
+}
+
+// GetTransferPairs 获取链上event_time不早于since的转账，按时间排序
+// 转账记录为同一交易中的 transfer_out 与紧随其后的同额 transfer_in（监听器按此顺序写入）
+func GetTransferPairs(chainName string, since time.Time) ([]sybil.Transfer, error) {
+	rows, err := ReadQuery(`
+        SELECT o.user_address, i.user_address, o.event_time
+        FROM balance_changes o
+        JOIN balance_changes i ON i.id = (
+            SELECT MIN(n.id) FROM balance_changes n
+            WHERE n.chain_name = o.chain_name AND n.tx_hash = o.tx_hash
+              AND n.event_type = 'transfer_in' AND n.id > o.id
+        )
+        WHERE o.chain_name = ? AND o.event_type = 'transfer_out' AND o.event_time >= ?
+          AND i.amount = o.amount AND i.block_number = o.block_number
+        ORDER BY o.event_time, o.id
+    `, chainName, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transfers []sybil.Transfer
+	for rows.Next() {
+		var t sybil.Transfer
+		if err := rows.Scan(&t.From, &t.To, &t.Time); err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, t)
+	}
+	return transfers, rows.Err()
+}
+
+// SaveSybilClusters 以一次分析的结果替换链上全部聚类
+// 首次检测时间跨分析保留：地址仍在聚类中时沿用其detected_at，聚类ID不变时沿用聚类的detected_at，
+// 新聚类取成员中最早的检测时间
+// 达到处理阈值的时间同样跨分析保留：所在聚类分数不低于阈值的地址沿用上次的flagged_at，
+// 本次新达到阈值时取本次分析时间，低于阈值时清空，之后再次达到阈值时重新计时
+func SaveSybilClusters(policy *Policy, chainName string, clusters []sybil.Cluster, analyzedAt time.Time) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	prevClusters, err := txDetectedAt(tx, "SELECT cluster_id, detected_at FROM sybil_clusters WHERE chain_name = ? FOR UPDATE", chainName)
+	if err != nil {
+		return err
+	}
+	prevAddrs, err := txDetectedAt(tx, "SELECT address, detected_at FROM sybil_addresses WHERE chain_name = ? FOR UPDATE", chainName)
+	if err != nil {
+		return err
+	}
+	prevFlagged, err := txDetectedAt(tx, "SELECT address, flagged_at FROM sybil_addresses WHERE chain_name = ? AND flagged_at IS NOT NULL", chainName)
+	if err != nil {
+		return err
+	}
+	threshold := policy.sybilThreshold()
+
+	if _, err := TxExec(tx, "DELETE FROM sybil_addresses WHERE chain_name = ?", chainName); err != nil {
+		return err
+	}
+	if _, err := TxExec(tx, "DELETE FROM sybil_clusters WHERE chain_name = ?", chainName); err != nil {
+		return err
+	}
+
+	var (
+		members []any
+		count   int
+	)
+	flush := func() error {
+		if count == 0 {
+			return nil
+		}
+		_, err := TxExec(tx, `
+            INSERT INTO sybil_addresses (chain_name, address, cluster_id, score, reasons, detected_at, flagged_at)
+            VALUES `+valuesList(count, "?, ?, ?, ?, ?, ?, ?"), members...)
+		members, count = members[:0], 0
+		return err
+	}
+	for _, c := range clusters {
+		detectedAt, ok := prevClusters[c.ID]
+		if !ok {
+			detectedAt = analyzedAt
+			for _, m := range c.Members {
+				if t, ok := prevAddrs[AddrKey(m.Address)]; ok && t.Before(detectedAt) {
+					detectedAt = t
+				}
+			}
+		}
+		funders, _ := json.Marshal(c.Funders)
+		_, err := TxExec(tx, `
+            INSERT INTO sybil_clusters (chain_name, cluster_id, score, size, funders, funded, lockstep, internal, analyzed_at, detected_at)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        `, chainName, c.ID, c.Score, len(c.Members), string(funders), c.Funded, c.Lockstep, c.Internal, analyzedAt, detectedAt)
+		if err != nil {
+			return err
+		}
+		for _, m := range c.Members {
+			memberAt, ok := prevAddrs[AddrKey(m.Address)]
+			if !ok {
+				memberAt = analyzedAt
+			}
+			var flaggedAt *time.Time
+			if c.Score >= threshold {
+				t, ok := prevFlagged[AddrKey(m.Address)]
+				if !ok {
+					t = analyzedAt
+				}
+				flaggedAt = &t
+			}
+			members = append(members, chainName, m.Address, c.ID, c.Score, strings.Join(m.Reasons, ","), memberAt, flaggedAt)
+			count++
+			if count == sybilInsertChunk {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// 查询上次分析记录的时间（键 → detected_at 或 flagged_at）
+func txDetectedAt(tx *sql.Tx, query, chainName string) (map[string]time.Time, error) {
+	rows, err := TxQuery(tx, query, chainName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	detected := make(map[string]time.Time)
+	for rows.Next() {
+		var (
+			key string
+			at  time.Time
+		)
+		if err := rows.Scan(&key, &at); err != nil {
+			return nil, err
+		}
+		detected[key] = at
+	}
+	return detected, rows.Err()
+}
+
+// ListSybilClusters 获取链上分数不低于minScore的聚类，按分数降序
+func ListSybilClusters(chainName string, minScore float64, limit int) ([]SybilCluster, error) {
+	rows, err := ReadQuery(`
+        SELECT chain_name, cluster_id, score, size, funders, funded, lockstep, internal, analyzed_at, detected_at
+        FROM sybil_clusters
+        WHERE chain_name = ? AND score >= ?
+        ORDER BY score DESC, size DESC, cluster_id
+        LIMIT ?
+    `, chainName, minScore, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []SybilCluster
+	for rows.Next() {
+		var (
+			c       SybilCluster
+			funders []byte
+		)
+		if err := rows.Scan(&c.ChainName, &c.ClusterID, &c.Score, &c.Size, &funders, &c.Funded, &c.Lockstep, &c.Internal, &c.AnalyzedAt, &c.DetectedAt); err != nil {
+			return nil, err
+		}
+		_ = json.Unmarshal(funders, &c.Funders)
+		list = append(list, c)
+	}
+	return list, rows.Err()
+}
+
+// GetSybilCluster 获取聚类及其成员，不存在时ok为false
+func GetSybilCluster(chainName, clusterID string) (SybilCluster, []SybilAddress, bool, error) {
+	c := SybilCluster{ChainName: chainName, ClusterID: clusterID}
+	var funders []byte
+	err := ReadQueryRow(`
+        SELECT score, size, funders, funded, lockstep, internal, analyzed_at, detected_at
+        FROM sybil_clusters WHERE chain_name = ? AND cluster_id = ?
+    `, chainName, clusterID).Scan(&c.Score, &c.Size, &funders, &c.Funded, &c.Lockstep, &c.Internal, &c.AnalyzedAt, &c.DetectedAt)
+	if err == sql.ErrNoRows {
+		return c, nil, false, nil
+	}
+	if err != nil {
+		return c, nil, false, err
+	}
+	_ = json.Unmarshal(funders, &c.Funders)
+
+	rows, err := ReadQuery(`
+        SELECT address, score, reasons, detected_at, flagged_at FROM sybil_addresses
+        WHERE chain_name = ? AND cluster_id = ?
+        ORDER BY address
+    `, chainName, clusterID)
+	if err != nil {
+		return c, nil, false, err
+	}
+	defer rows.Close()
+
+	var members []SybilAddress
+	for rows.Next() {
+		m := SybilAddress{ChainName: chainName, ClusterID: clusterID}
+		var reasons string
+		if err := rows.Scan(&m.Address, &m.Score, &reasons, &m.DetectedAt, &m.FlaggedAt); err != nil {
+			return c, nil, false, err
+		}
+		if reasons != "" {
+			m.Reasons = strings.Split(reasons, ",")
+		}
+		members = append(members, m)
+	}
+	return c, members, true, rows.Err()
+}
+
+// GetSybilAddress 获取地址所在的聚类，不在任何聚类中时ok为false
+func GetSybilAddress(chainName, addr string) (SybilAddress, bool, error) {
+	m := SybilAddress{ChainName: chainName, Address: AddrKey(addr)}
+	var reasons string
+	err := ReadQueryRow(`
+        SELECT cluster_id, score, reasons, detected_at, flagged_at FROM sybil_addresses
+        WHERE chain_name = ? AND address = ?
+    `, chainName, m.Address).Scan(&m.ClusterID, &m.Score, &reasons, &m.DetectedAt, &m.FlaggedAt)
+	if err == sql.ErrNoRows {
+		return m, false, nil
+	}
+	if reasons != "" {
+		m.Reasons = strings.Split(reasons, ",")
+	}
+	return m, err == nil, err
+}
+
+// GetSybilPenalty 获取开始于periodStart的周期适用的女巫处理权重
+// 未启用处理、地址所在聚类未达到阈值或在周期开始后才达到阈值时返回nil
+func GetSybilPenalty(policy *Policy, chainName, addr string, periodStart time.Time) (*SybilPenalty, error) {
+	threshold, weight, ok := policy.sybilPolicy()
+	if !ok {
+		return nil, nil
+	}
+	p := &SybilPenalty{Weight: weight}
+	err := QueryRow(`
+        SELECT cluster_id, score, flagged_at FROM sybil_addresses
+        WHERE chain_name = ? AND address = ? AND score >= ? AND flagged_at IS NOT NULL
+    `, chainName, AddrKey(addr), threshold).Scan(&p.ClusterID, &p.Score, &p.FlaggedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p.ActiveAt(periodStart), nil
+}
+
+// GetSybilPenalties 获取链上全部达到阈值的地址（小写）及其处理权重，未启用处理时返回空
+// 返回的处理带达到阈值的时间，按周期使用时经 ActiveAt 过滤
+func GetSybilPenalties(policy *Policy, chainName string) (map[string]*SybilPenalty, error) {
+	penalties := make(map[string]*SybilPenalty)
+	threshold, weight, ok := policy.sybilPolicy()
+	if !ok {
+		return penalties, nil
+	}
+	rows, err := Query(`
+        SELECT address, cluster_id, score, flagged_at FROM sybil_addresses
+        WHERE chain_name = ? AND score >= ? AND flagged_at IS NOT NULL
+    `, chainName, threshold)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var addr string
+		p := &SybilPenalty{Weight: weight}
+		if err := rows.Scan(&addr, &p.ClusterID, &p.Score, &p.FlaggedAt); err != nil {
+			return nil, err
+		}
+		penalties[addr] = p
+	}
+	return penalties, rows.Err()
+}
+
+// 获取指定地址中达到阈值的地址（小写）及其处理权重，未启用处理时返回空；按周期使用时经 ActiveAt 过滤
+func getSybilPenaltiesFor(policy *Policy, chainName string, addrs []string) (map[string]*SybilPenalty, error) {
+	penalties := make(map[string]*SybilPenalty)
+	threshold, weight, ok := policy.sybilPolicy()
+	if !ok || len(addrs) == 0 {
+		return penalties, nil
+	}
+	args := []any{chainName, threshold}
+	for _, a := range addrs {
+		args = append(args, AddrKey(a))
+	}
+	rows, err := Query(`
+        SELECT address, cluster_id, score, flagged_at FROM sybil_addresses
+        WHERE chain_name = ? AND score >= ? AND flagged_at IS NOT NULL AND address IN `+placeholders(len(addrs)),
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var addr string
+		p := &SybilPenalty{Weight: weight}
+		if err := rows.Scan(&addr, &p.ClusterID, &p.Score, &p.FlaggedAt); err != nil {
+			return nil, err
+		}
+		penalties[AddrKey(addr)] = p
+	}
+	return penalties, rows.Err()
+}
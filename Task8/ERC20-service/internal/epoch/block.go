@@ -0,0 +1,100 @@
+package epoch
+
+import (
+	"fmt"
+	"time"
+)
+
+// BlockPeriod 区块积分周期 [StartBlock, EndBlock)
+// Start、End 为起止区块的链上时间，由监听器记录，仅用于计算历史与规则选择，积分按区块数加权
+type BlockPeriod struct {
+	Index      int64
+	StartBlock uint64
+	EndBlock   uint64
+	Start      time.Time
+	End        time.Time
+}
+
+// Blocks 周期内的区块数
+func (p BlockPeriod) Blocks() uint64 {
+	return p.EndBlock - p.StartBlock
+}
+
+func (p BlockPeriod) String() string {
+	return fmt.Sprintf("#%d[%d - %d)", p.Index, p.StartBlock, p.EndBlock)
+}
+
+// BlockClock 区块周期划分：从start起每size个区块一个周期，end不为0时最后一个周期截断到end
+type BlockClock struct {
+	start uint64
+	end   uint64
+	size  uint64
+}
+
+// NewBlockClock 创建区块周期划分，size为0时按1处理
+func NewBlockClock(start, end, size uint64) BlockClock {
+	if size == 0 {
+		size = 1
+	}
+	return BlockClock{start: start, end: end, size: size}
+}
+
+// Period 按序号获取周期（不含链上时间），超出结束区块时ok为false
+func (c BlockClock) Period(index int64) (BlockPeriod, bool) {
+	if index < 0 {
+		return BlockPeriod{}, false
+	}
+	p := BlockPeriod{
+		Index:      index,
+		StartBlock: c.start + uint64(index)*c.size,
+	}
+	p.EndBlock = p.StartBlock + c.size
+	if c.end != 0 {
+		if p.StartBlock >= c.end {
+			return BlockPeriod{}, false
+		}
+		if p.EndBlock > c.end {
+			p.EndBlock = c.end
+		}
+	}
+	return p, true
+}
+
+// Boundaries 返回[from, to]内的周期边界区块（各周期起始区块与结束区块）
+func (c BlockClock) Boundaries(from, to uint64) []uint64 {
+	if from < c.start {
+		from = c.start
+	}
+	var blocks []uint64
+	for b := c.start + (from-c.start+c.size-1)/c.size*c.size; b <= to; b += c.size {
+		if c.end != 0 && b >= c.end {
+			break
+		}
+		blocks = append(blocks, b)
+	}
+	if c.end != 0 && c.end >= from && c.end <= to {
+		blocks = append(blocks, c.end)
+	}
+	return blocks
+}
+
+// Completed 按已记录链上时间的边界区块，依次返回已结束的周期，遇到未记录的边界即停止
+func (c BlockClock) Completed(boundaryTimes map[uint64]time.Time) []BlockPeriod {
+	var periods []BlockPeriod
+	for i := int64(0); ; i++ {
+		p, ok := c.Period(i)
+		if !ok {
+			return periods
+		}
+		start, ok := boundaryTimes[p.StartBlock]
+		if !ok {
+			return periods
+		}
+		end, ok := boundaryTimes[p.EndBlock]
+		if !ok {
+			return periods
+		}
+		p.Start, p.End = start, end
+		periods = append(periods, p)
+	}
+}
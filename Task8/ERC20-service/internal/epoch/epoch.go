@@ -0,0 +1,86 @@
+package epoch
+
+import (
+	"fmt"
+	"time"
+)
+
+// Epoch 积分周期：按UTC对齐到间隔整数倍的固定时间段 [Start, End)
+// ID 为自Unix纪元起的周期序号，同一间隔下恒定不变，相邻周期ID连续，可据此精确定位缺口
+type Epoch struct {
+	ID    int64
+	Start time.Time
+	End   time.Time
+}
+
+func (e Epoch) String() string {
+	return fmt.Sprintf("#%d[%s - %s)", e.ID, e.Start.Format(time.RFC3339), e.End.Format(time.RFC3339))
+}
+
+// Clock 周期时钟，间隔为整数秒
+// 间隔能整除一天时（5、15、60分钟等），周期恰好从每个UTC整点/整刻开始
+type Clock struct {
+	seconds int64
+}
+
+// NewClock 创建周期时钟，间隔不足1秒时按1秒处理
+func NewClock(interval time.Duration) Clock {
+	s := int64(interval / time.Second)
+	if s < 1 {
+		s = 1
+	}
+	return Clock{seconds: s}
+}
+
+// Interval 周期长度
+func (c Clock) Interval() time.Duration {
+	return time.Duration(c.seconds) * time.Second
+}
+
+// ID 时刻t所在周期的ID
+func (c Clock) ID(t time.Time) int64 {
+	u := t.Unix()
+	id := u / c.seconds
+	if u%c.seconds < 0 {
+		id--
+	}
+	return id
+}
+
+// Epoch 按ID获取周期
+func (c Clock) Epoch(id int64) Epoch {
+	start := time.Unix(id*c.seconds, 0).UTC()
+	return Epoch{ID: id, Start: start, End: start.Add(c.Interval())}
+}
+
+// At 时刻t所在的周期
+func (c Clock) At(t time.Time) Epoch {
+	return c.Epoch(c.ID(t))
+}
+
+// LastCompleted now之前最近一个已结束的周期
+func (c Clock) LastCompleted(now time.Time) Epoch {
+	return c.Epoch(c.ID(now) - 1)
+}
+
+// Next now之后的下一个周期边界
+func (c Clock) Next(now time.Time) time.Time {
+	return c.At(now).End
+}
+
+// Range 按ID获取[fromID, toID]内的全部周期
+func (c Clock) Range(fromID, toID int64) []Epoch {
+	var epochs []Epoch
+	for id := fromID; id <= toID; id++ {
+		epochs = append(epochs, c.Epoch(id))
+	}
+	return epochs
+}
+
+// Covering 与[start, end)相交的全部周期，首尾向外对齐到周期边界
+func (c Clock) Covering(start, end time.Time) []Epoch {
+	if !end.After(start) {
+		return nil
+	}
+	return c.Range(c.ID(start), c.ID(end.Add(-time.Second)))
+}
@@ -0,0 +1,112 @@
+package epoch
+
+import (
+	"testing"
+	"time"
+)
+
+func utc(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestClockAlignment(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		at       string
+		start    string
+		end      string
+	}{
+		{"5分钟", 5 * time.Minute, "2026-07-01T12:07:30Z", "2026-07-01T12:05:00Z", "2026-07-01T12:10:00Z"},
+		{"5分钟边界", 5 * time.Minute, "2026-07-01T12:10:00Z", "2026-07-01T12:10:00Z", "2026-07-01T12:15:00Z"},
+		{"15分钟", 15 * time.Minute, "2026-07-01T12:44:59Z", "2026-07-01T12:30:00Z", "2026-07-01T12:45:00Z"},
+		{"60分钟", time.Hour, "2026-07-01T23:59:59Z", "2026-07-01T23:00:00Z", "2026-07-02T00:00:00Z"},
+		{"1天", 24 * time.Hour, "2026-07-01T08:00:00+08:00", "2026-07-01T00:00:00Z", "2026-07-02T00:00:00Z"},
+		{"1天非UTC时区", 24 * time.Hour, "2026-07-01T07:59:59+08:00", "2026-06-30T00:00:00Z", "2026-07-01T00:00:00Z"},
+		// 7分钟不能整除一天，按Unix纪元对齐而不是按整点
+		{"7分钟", 7 * time.Minute, "2026-07-01T00:00:00Z", "2026-06-30T23:58:00Z", "2026-07-01T00:05:00Z"},
+		{"纪元之前", time.Hour, "1969-12-31T23:30:00Z", "1969-12-31T23:00:00Z", "1970-01-01T00:00:00Z"},
+		{"纪元之前的边界", time.Hour, "1969-12-31T23:00:00Z", "1969-12-31T23:00:00Z", "1970-01-01T00:00:00Z"},
+		{"不足1秒按1秒", 500 * time.Millisecond, "2026-07-01T12:00:00.7Z", "2026-07-01T12:00:00Z", "2026-07-01T12:00:01Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewClock(tt.interval)
+			e := c.At(utc(tt.at))
+			if !e.Start.Equal(utc(tt.start)) || !e.End.Equal(utc(tt.end)) {
+				t.Errorf("At(%s) = [%s, %s), want [%s, %s)", tt.at, e.Start, e.End, tt.start, tt.end)
+			}
+			if e.Start.Location() != time.UTC {
+				t.Errorf("周期开始时间应为UTC, got %s", e.Start.Location())
+			}
+			if got := c.Epoch(e.ID); got != e {
+				t.Errorf("Epoch(%d) = %s, want %s", e.ID, got, e)
+			}
+			if next := c.At(e.End); next.ID != e.ID+1 || !next.Start.Equal(e.End) {
+				t.Errorf("下一个周期 = %s, 应紧接 %s", next, e)
+			}
+		})
+	}
+}
+
+func TestClockLastCompletedAndNext(t *testing.T) {
+	c := NewClock(15 * time.Minute)
+	tests := []struct {
+		now       string
+		lastStart string
+		next      string
+	}{
+		{"2026-07-01T12:20:00Z", "2026-07-01T12:00:00Z", "2026-07-01T12:30:00Z"},
+		{"2026-07-01T12:15:00Z", "2026-07-01T12:00:00Z", "2026-07-01T12:30:00Z"},
+		{"2026-07-01T12:14:59Z", "2026-07-01T11:45:00Z", "2026-07-01T12:15:00Z"},
+	}
+	for _, tt := range tests {
+		now := utc(tt.now)
+		if got := c.LastCompleted(now); !got.Start.Equal(utc(tt.lastStart)) || got.End.After(now) {
+			t.Errorf("LastCompleted(%s) = %s, want start %s", tt.now, got, tt.lastStart)
+		}
+		if got := c.Next(now); !got.Equal(utc(tt.next)) {
+			t.Errorf("Next(%s) = %s, want %s", tt.now, got, tt.next)
+		}
+	}
+}
+
+func TestClockCovering(t *testing.T) {
+	c := NewClock(time.Hour)
+	tests := []struct {
+		name       string
+		start, end string
+		want       []string // 各周期开始时间
+	}{
+		{"空区间", "2026-07-01T12:00:00Z", "2026-07-01T12:00:00Z", nil},
+		{"倒置区间", "2026-07-01T13:00:00Z", "2026-07-01T12:00:00Z", nil},
+		{"恰好一个周期", "2026-07-01T12:00:00Z", "2026-07-01T13:00:00Z", []string{"2026-07-01T12:00:00Z"}},
+		{"周期内部", "2026-07-01T12:10:00Z", "2026-07-01T12:20:00Z", []string{"2026-07-01T12:00:00Z"}},
+		{"首尾向外对齐", "2026-07-01T12:30:00Z", "2026-07-01T14:00:01Z", []string{
+			"2026-07-01T12:00:00Z", "2026-07-01T13:00:00Z", "2026-07-01T14:00:00Z",
+		}},
+		{"结束于边界不含下一周期", "2026-07-01T12:30:00Z", "2026-07-01T14:00:00Z", []string{
+			"2026-07-01T12:00:00Z", "2026-07-01T13:00:00Z",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := c.Covering(utc(tt.start), utc(tt.end))
+			if len(got) != len(tt.want) {
+				t.Fatalf("Covering = %v, want %d 个周期", got, len(tt.want))
+			}
+			for i, w := range tt.want {
+				if !got[i].Start.Equal(utc(w)) {
+					t.Errorf("周期%d = %s, want start %s", i, got[i], w)
+				}
+				if i > 0 && got[i].ID != got[i-1].ID+1 {
+					t.Errorf("周期ID不连续: %d → %d", got[i-1].ID, got[i].ID)
+				}
+			}
+		})
+	}
+}
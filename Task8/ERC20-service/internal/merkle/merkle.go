@@ -0,0 +1,110 @@
+// Package merkle 构建与 OpenZeppelin StandardMerkleTree 兼容的 (address, uint256) 默克尔树
+//
+// 叶子 = keccak256(bytes.concat(keccak256(abi.encode(account, amount))))，双重哈希防止第二原像攻击；
+// 叶子按哈希排序后放入完全二叉树数组，父节点为两个子节点排序后拼接的 keccak256，
+// 生成的证明可直接用于合约中的 MerkleProof.verify。
+package merkle
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Leaf 叶子数据
+type Leaf struct {
+	Account common.Address
+	Amount  *big.Int
+}
+
+// Tree 默克尔树
+type Tree struct {
+	nodes   []common.Hash // 完全二叉树数组，根在下标0
+	indexOf map[int]int   // 叶子原始序号 → 树数组下标
+}
+
+// LeafHash 计算叶子哈希
+func LeafHash(l Leaf) common.Hash {
+	// abi.encode(address, uint256)：两个32字节左侧补零的字
+	encoded := make([]byte, 64)
+	copy(encoded[12:32], l.Account.Bytes())
+	l.Amount.FillBytes(encoded[32:64])
+	return crypto.Keccak256Hash(crypto.Keccak256(encoded))
+}
+
+// 子节点排序后拼接哈希
+func hashPair(a, b common.Hash) common.Hash {
+	if bytes.Compare(a[:], b[:]) > 0 {
+		a, b = b, a
+	}
+	return crypto.Keccak256Hash(a[:], b[:])
+}
+
+// Build 由叶子构建默克尔树，金额必须为非负且不超过uint256
+func Build(leaves []Leaf) (*Tree, error) {
+	if len(leaves) == 0 {
+		return nil, fmt.Errorf("叶子为空")
+	}
+	hashes := make([]common.Hash, len(leaves))
+	for i, l := range leaves {
+		if l.Amount == nil || l.Amount.Sign() < 0 || l.Amount.BitLen() > 256 {
+			return nil, fmt.Errorf("第%d个叶子金额无效", i)
+		}
+		hashes[i] = LeafHash(l)
+	}
+
+	// 按叶子哈希排序
+	order := make([]int, len(leaves))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return bytes.Compare(hashes[order[a]][:], hashes[order[b]][:]) < 0
+	})
+
+	// 叶子倒序放在数组末尾，自底向上计算父节点
+	n := 2*len(leaves) - 1
+	t := &Tree{nodes: make([]common.Hash, n), indexOf: make(map[int]int, len(leaves))}
+	for i, leafIdx := range order {
+		pos := n - 1 - i
+		t.nodes[pos] = hashes[leafIdx]
+		t.indexOf[leafIdx] = pos
+	}
+	for i := n - 1 - len(leaves); i >= 0; i-- {
+		t.nodes[i] = hashPair(t.nodes[2*i+1], t.nodes[2*i+2])
+	}
+	return t, nil
+}
+
+// Root 默克尔根
+func (t *Tree) Root() common.Hash {
+	return t.nodes[0]
+}
+
+// Proof 获取第i个叶子（原始序号）的证明
+func (t *Tree) Proof(i int) []common.Hash {
+	pos := t.indexOf[i]
+	var proof []common.Hash
+	for pos > 0 {
+		sibling := pos + 1
+		if pos%2 == 0 {
+			sibling = pos - 1
+		}
+		proof = append(proof, t.nodes[sibling])
+		pos = (pos - 1) / 2
+	}
+	return proof
+}
+
+// Verify 校验叶子与证明能否得到根，与 MerkleProof.verify 算法一致
+func Verify(root common.Hash, leaf Leaf, proof []common.Hash) bool {
+	computed := LeafHash(leaf)
+	for _, p := range proof {
+		computed = hashPair(computed, p)
+	}
+	return computed == root
+}
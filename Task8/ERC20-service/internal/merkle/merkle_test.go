@@ -0,0 +1,98 @@
+package merkle
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func amount(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("无效金额: " + s)
+	}
+	return n
+}
+
+// @openzeppelin/merkle-tree README 中的示例：
+// StandardMerkleTree.of([[0x1111..., "5000000000000000000"], [0x2222..., "2500000000000000000"]], ["address", "uint256"])
+func TestStandardMerkleTreeReference(t *testing.T) {
+	leaves := []Leaf{
+		{Account: common.HexToAddress("0x1111111111111111111111111111111111111111"), Amount: amount("5000000000000000000")},
+		{Account: common.HexToAddress("0x2222222222222222222222222222222222222222"), Amount: amount("2500000000000000000")},
+	}
+	tree, err := Build(leaves)
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if got, want := tree.Root().Hex(), "0xd4dee0beab2d53f2cc83e567171bd2820e49898130a22622b10ead383e90bd77"; got != want {
+		t.Errorf("Root = %s, want %s", got, want)
+	}
+
+	tests := []struct {
+		index int
+		proof []string
+	}{
+		{0, []string{"0xb92c48e9d7abe27fd8dfd6b5dfdbfb1c9a463f80c712b66f3a5180a090cccafc"}},
+		{1, []string{"0xeb02c421cfa48976e66dfb29120745909ea3a0f843456c263cf8f1253483e283"}},
+	}
+	for _, tt := range tests {
+		proof := tree.Proof(tt.index)
+		if len(proof) != len(tt.proof) {
+			t.Fatalf("Proof(%d) 长度 = %d, want %d", tt.index, len(proof), len(tt.proof))
+		}
+		for i, want := range tt.proof {
+			if proof[i].Hex() != want {
+				t.Errorf("Proof(%d)[%d] = %s, want %s", tt.index, i, proof[i].Hex(), want)
+			}
+		}
+		if !Verify(tree.Root(), leaves[tt.index], proof) {
+			t.Errorf("Verify(%d) = false", tt.index)
+		}
+	}
+}
+
+func TestProofsVerify(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 4, 5, 7, 8, 13} {
+		leaves := make([]Leaf, n)
+		for i := range leaves {
+			leaves[i] = Leaf{
+				Account: common.BigToAddress(big.NewInt(int64(i + 1))),
+				Amount:  new(big.Int).Mul(big.NewInt(int64(i+1)), amount("1000000000000000000")),
+			}
+		}
+		tree, err := Build(leaves)
+		if err != nil {
+			t.Fatalf("n=%d Build: %v", n, err)
+		}
+		for i, l := range leaves {
+			proof := tree.Proof(i)
+			if !Verify(tree.Root(), l, proof) {
+				t.Errorf("n=%d 叶子%d 证明校验失败", n, i)
+			}
+			tampered := Leaf{Account: l.Account, Amount: new(big.Int).Add(l.Amount, big.NewInt(1))}
+			if Verify(tree.Root(), tampered, proof) {
+				t.Errorf("n=%d 叶子%d 修改金额后证明仍然通过", n, i)
+			}
+		}
+	}
+}
+
+func TestBuildRejectsInvalidAmounts(t *testing.T) {
+	addr := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	tests := []struct {
+		name   string
+		leaves []Leaf
+	}{
+		{"无叶子", nil},
+		{"金额为nil", []Leaf{{Account: addr}}},
+		{"金额为负", []Leaf{{Account: addr, Amount: big.NewInt(-1)}}},
+		{"金额超过uint256", []Leaf{{Account: addr, Amount: new(big.Int).Lsh(big.NewInt(1), 256)}}},
+	}
+	for _, tt := range tests {
+		if _, err := Build(tt.leaves); err == nil {
+			t.Errorf("%s: Build 应返回错误", tt.name)
+		}
+	}
+}
@@ -1,11 +1,15 @@
 package mq
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"erc20-service/config"
+	"erc20-service/internal/epoch"
 	"erc20-service/pkg/logger"
 	"fmt"
 	"log/slog"
+	"strings"
 	"time"
 
 	"github.com/streadway/amqp"
@@ -17,6 +21,21 @@ type Connection struct {
 	log  *slog.Logger
 }
 
+// Delay 将消息原样发布到延迟队列（经默认交换机按队列名路由），到期后由队列死信转回任务队列
+func (c *Connection) Delay(queue string, msg amqp.Delivery) error {
+	err := c.ch.Publish("", queue, false, false, amqp.Publishing{
+		ContentType:  msg.ContentType,
+		Type:         msg.Type,
+		Headers:      msg.Headers,
+		Body:         msg.Body,
+		DeliveryMode: amqp.Persistent,
+	})
+	if err != nil {
+		return fmt.Errorf("发布到延迟队列失败: %v", err)
+	}
+	return nil
+}
+
 // Consume 包装通道的 Consume，避免暴露内部字段
 func (c *Connection) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
 	return c.ch.Consume(queue, consumer, autoAck, exclusive, noLocal, noWait, args)
@@ -43,10 +62,92 @@ func (c *Connection) Close() error {
 
 // PointsCalculationTask 积分计算任务
 type PointsCalculationTask struct {
+	TaskID      string    `json:"task_id"` // 由(链, 用户, 时间段)确定，重复投递时保持不变
 	ChainName   string    `json:"chain_name"`
 	UserAddress string    `json:"user_address"`
 	PeriodStart time.Time `json:"period_start"`
 	PeriodEnd   time.Time `json:"period_end"`
+	EpochID     int64     `json:"epoch_id,omitempty"` // 所属积分周期，旧版本任务为0
+	Season      string    `json:"season,omitempty"`   // 周期开始时刻所属的赛季，为空时由消费者按时间查询
+	// 区块周期 [BlockStart, BlockEnd)，时间周期为0；PeriodStart、PeriodEnd 为起止区块的链上时间
+	BlockStart uint64 `json:"block_start,omitempty"`
+	BlockEnd   uint64 `json:"block_end,omitempty"`
+}
+
+// IsBlockPeriod 是否为区块周期任务
+func (t PointsCalculationTask) IsBlockPeriod() bool {
+	return t.BlockEnd > 0
+}
+
+// NewBlockPeriodTask 创建区块周期计算任务，任务ID由(链, 用户, 区块区间)确定
+func NewBlockPeriodTask(chainName, userAddr string, p epoch.BlockPeriod) PointsCalculationTask {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("block|%s|%s|%d|%d",
+		chainName, strings.ToLower(userAddr), p.StartBlock, p.EndBlock)))
+	return PointsCalculationTask{
+		TaskID:      hex.EncodeToString(sum[:]),
+		ChainName:   chainName,
+		UserAddress: userAddr,
+		PeriodStart: p.Start.Truncate(time.Second),
+		PeriodEnd:   p.End.Truncate(time.Second),
+		BlockStart:  p.StartBlock,
+		BlockEnd:    p.EndBlock,
+	}
+}
+
+// NewEpochTask 创建覆盖整个积分周期的计算任务
+func NewEpochTask(chainName, userAddr string, e epoch.Epoch) PointsCalculationTask {
+	task := NewPointsCalculationTask(chainName, userAddr, e.Start, e.End)
+	task.EpochID = e.ID
+	return task
+}
+
+// NewPointsCalculationTask 创建积分计算任务
+// 时间段截断到秒，与数据库TIMESTAMP精度一致，保证同一时间段重新生成的任务ID相同
+func NewPointsCalculationTask(chainName, userAddr string, start, end time.Time) PointsCalculationTask {
+	start, end = start.Truncate(time.Second), end.Truncate(time.Second)
+	return PointsCalculationTask{
+		TaskID:      TaskID(chainName, userAddr, start, end),
+		ChainName:   chainName,
+		UserAddress: userAddr,
+		PeriodStart: start,
+		PeriodEnd:   end,
+	}
+}
+
+// TaskID 计算确定性任务ID：sha256(链|小写地址|起始秒|结束秒)
+func TaskID(chainName, userAddr string, start, end time.Time) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d|%d",
+		chainName, strings.ToLower(userAddr), start.Unix(), end.Unix())))
+	return hex.EncodeToString(sum[:])
+}
+
+// PointsBatchTask 分片批量积分计算任务：覆盖一条链一个周期内 CRC32(小写地址) % Shards == Shard 的全部用户
+type PointsBatchTask struct {
+	TaskID      string    `json:"task_id"`
+	ChainName   string    `json:"chain_name"`
+	Shard       int       `json:"shard"`
+	Shards      int       `json:"shards"`
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+	EpochID     int64     `json:"epoch_id,omitempty"`
+	Season      string    `json:"season,omitempty"`
+}
+
+// NewPointsBatchTask 创建一个积分周期的分片批量任务
+// 分片内每个用户的入账仍使用 TaskID(链, 用户, 时间段)，与逐用户任务相互幂等
+func NewPointsBatchTask(chainName string, shard, shards int, e epoch.Epoch) PointsBatchTask {
+	start, end := e.Start, e.End
+	sum := sha256.Sum256([]byte(fmt.Sprintf("batch|%s|%d/%d|%d|%d",
+		chainName, shard, shards, start.Unix(), end.Unix())))
+	return PointsBatchTask{
+		TaskID:      hex.EncodeToString(sum[:]),
+		ChainName:   chainName,
+		Shard:       shard,
+		Shards:      shards,
+		PeriodStart: start,
+		PeriodEnd:   end,
+		EpochID:     e.ID,
+	}
 }
 
 // PointsProducer 积分计算任务生产者
@@ -54,13 +155,53 @@ type PointsProducer struct {
 	ch         *amqp.Channel
 	exchange   string
 	routingKey string
+	confirms   chan amqp.Confirmation // 发布确认通道，仅确认模式下非空
+	returns    chan amqp.Return       // 无法路由的消息退回通道，仅确认模式下非空
 	log        *slog.Logger
 }
 
 // NewPointsProducer 创建积分任务生产者
 func NewPointsProducer(conn *Connection, cfg config.RabbitMQConfig) *PointsProducer {
+	if err := declareTopology(conn.ch, cfg); err != nil {
+		panic(err.Error())
+	}
+	return &PointsProducer{
+		ch:         conn.ch,
+		exchange:   cfg.Exchange,
+		routingKey: cfg.RoutingKey,
+		log:        logger.New("points-producer"),
+	}
+}
+
+// DialConfirmProducer 建立独立连接并创建开启发布确认的生产者
+// 发件箱中继使用独立连接，连接断开后可整体重建而不影响消费者
+func DialConfirmProducer(cfg config.RabbitMQConfig) (*Connection, *PointsProducer, error) {
+	conn, err := NewConnection(cfg.URL)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := declareTopology(conn.ch, cfg); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if err := conn.ch.Confirm(false); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("开启发布确认失败: %v", err)
+	}
+	return conn, &PointsProducer{
+		ch:         conn.ch,
+		exchange:   cfg.Exchange,
+		routingKey: cfg.RoutingKey,
+		confirms:   conn.ch.NotifyPublish(make(chan amqp.Confirmation, 1)),
+		returns:    conn.ch.NotifyReturn(make(chan amqp.Return, 1)),
+		log:        logger.New("points-producer"),
+	}, nil
+}
+
+// 声明交换机、队列并完成绑定
+func declareTopology(ch *amqp.Channel, cfg config.RabbitMQConfig) error {
 	//声明交换机
-	err := conn.ch.ExchangeDeclare(
+	err := ch.ExchangeDeclare(
 		cfg.Exchange, // 交换机名称
 		"direct",     // 类型
 		true,         // 持久化
@@ -70,10 +211,10 @@ func NewPointsProducer(conn *Connection, cfg config.RabbitMQConfig) *PointsProdu
 		nil,          // 参数
 	)
 	if err != nil {
-		panic(fmt.Sprintf("声明交换机失败: %v", err))
+		return fmt.Errorf("声明交换机失败: %v", err)
 	}
 	//声明队列
-	_, err = conn.ch.QueueDeclare(
+	_, err = ch.QueueDeclare(
 		cfg.Queue, // 队列名称
 		true,      // 持久化
 		false,     // 自动删除
@@ -82,10 +223,10 @@ func NewPointsProducer(conn *Connection, cfg config.RabbitMQConfig) *PointsProdu
 		nil,       // 参数
 	)
 	if err != nil {
-		panic(fmt.Sprintf("声明队列失败: %v", err))
+		return fmt.Errorf("声明队列失败: %v", err)
 	}
 	//绑定队列到交换机
-	err = conn.ch.QueueBind(
+	err = ch.QueueBind(
 		cfg.Queue,      // 队列名称
 		cfg.RoutingKey, // 路由键
 		cfg.Exchange,   // 交换机名称
@@ -93,14 +234,25 @@ func NewPointsProducer(conn *Connection, cfg config.RabbitMQConfig) *PointsProdu
 		nil,            // 参数
 	)
 	if err != nil {
-		panic(fmt.Sprintf("绑定队列到交换机失败: %v", err))
+		return fmt.Errorf("绑定队列到交换机失败: %v", err)
 	}
-	return &PointsProducer{
-		ch:         conn.ch,
-		exchange:   cfg.Exchange,
-		routingKey: cfg.RoutingKey,
-		log:        logger.New("points-producer"),
+	// 领域事件队列：未绑定时事件被Broker丢弃但仍会确认
+	if _, err := ch.QueueDeclare(cfg.EventQueue, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("声明事件队列失败: %v", err)
 	}
+	if err := ch.QueueBind(cfg.EventQueue, cfg.EventRoutingKey, cfg.Exchange, false, nil); err != nil {
+		return fmt.Errorf("绑定事件队列到交换机失败: %v", err)
+	}
+	// 延迟重试队列：不绑定交换机，消息过期后死信转回交换机，按任务路由键回到任务队列
+	_, err = ch.QueueDeclare(cfg.RetryQueue, true, false, false, false, amqp.Table{
+		"x-message-ttl":             int32(cfg.RetryDelaySeconds * 1000),
+		"x-dead-letter-exchange":    cfg.Exchange,
+		"x-dead-letter-routing-key": cfg.RoutingKey,
+	})
+	if err != nil {
+		return fmt.Errorf("声明延迟重试队列失败: %v", err)
+	}
+	return nil
 }
 
 // Publish 发布积分计算任务
@@ -109,23 +261,61 @@ func (p *PointsProducer) Publish(task PointsCalculationTask) error {
 	if err != nil {
 		return fmt.Errorf("序列化任务失败: %v", err)
 	}
-	return p.ch.Publish(
-		p.exchange,   // 交换机
-		p.routingKey, // 路由键
-		false,        // 强制的
-		false,        // 立即的
+	if err := p.PublishRaw(p.routingKey, "", data); err != nil {
+		return err
+	}
+	p.log.Debug("发布积分计算任务",
+		"chain", task.ChainName,
+		"user", task.UserAddress,
+	)
+	return nil
+}
+
+// PublishRaw 按路由键发布已序列化的消息，确认模式下等待Broker确认
+// 确认模式下以mandatory发布，没有队列接收的消息被退回并视为失败，避免Broker丢弃后仍确认
+// routingKey 为空时使用任务路由键
+func (p *PointsProducer) PublishRaw(routingKey, msgType string, body []byte) error {
+	if routingKey == "" {
+		routingKey = p.routingKey
+	}
+	err := p.ch.Publish(
+		p.exchange,        // 交换机
+		routingKey,        // 路由键
+		p.confirms != nil, // 强制的
+		false,             // 立即的
 		amqp.Publishing{
 			ContentType:  "application/json",
-			Body:         data,
+			Type:         msgType,
+			Body:         body,
 			DeliveryMode: amqp.Persistent, // 持久化消息
 		},
 	)
 	if err != nil {
 		return fmt.Errorf("发布任务失败: %v", err)
 	}
-	p.log.Debug("发布积分计算任务",
-		"chain", task.ChainName,
-		"user", task.UserAddress,
-	)
-	return nil
+	if p.confirms == nil {
+		return nil
+	}
+
+	select {
+	case confirm, ok := <-p.confirms:
+		if !ok {
+			return fmt.Errorf("发布确认通道已关闭")
+		}
+		if !confirm.Ack {
+			return fmt.Errorf("Broker拒绝消息: delivery_tag=%d", confirm.DeliveryTag)
+		}
+		// Broker在确认之前发送退回，频道按序分发，此时退回已在通道中
+		select {
+		case ret := <-p.returns:
+			return fmt.Errorf("消息无法路由: routing_key=%s reply=%d %s", ret.RoutingKey, ret.ReplyCode, ret.ReplyText)
+		default:
+		}
+		return nil
+	case <-time.After(publishConfirmTimeout):
+		return fmt.Errorf("等待发布确认超时")
+	}
 }
+
+// 发布确认等待时长
+const publishConfirmTimeout = 10 * time.Second
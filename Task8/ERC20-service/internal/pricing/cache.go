@@ -0,0 +1,51 @@
+package pricing
+
+import (
+	"sync"
+	"time"
+)
+
+// 缓存的最大时间段数，超过后清空重新缓存
+const cacheLimit = 1024
+
+// 按链与时间段缓存报价：同一周期的用户任务共用一次查询
+// 只缓存已结束时间段的成功结果，缺失或过期的报价不缓存，延迟重试时重新查询
+type cached struct {
+	src PriceSource
+
+	mu      sync.Mutex
+	entries map[cacheKey][]Quote
+}
+
+type cacheKey struct {
+	chain      string
+	start, end int64
+}
+
+func newCached(src PriceSource) *cached {
+	return &cached{src: src, entries: make(map[cacheKey][]Quote)}
+}
+
+func (c *cached) Quotes(chainName string, start, end time.Time) ([]Quote, error) {
+	key := cacheKey{chain: chainName, start: start.UnixNano(), end: end.UnixNano()}
+	c.mu.Lock()
+	quotes, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok {
+		return quotes, nil
+	}
+
+	quotes, err := c.src.Quotes(chainName, start, end)
+	if err != nil {
+		return nil, err
+	}
+	if !end.After(time.Now()) {
+		c.mu.Lock()
+		if len(c.entries) >= cacheLimit {
+			c.entries = make(map[cacheKey][]Quote)
+		}
+		c.entries[key] = quotes
+		c.mu.Unlock()
+	}
+	return quotes, nil
+}
@@ -0,0 +1,137 @@
+package pricing
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FileSource 本地价格文件，启动时整体载入内存
+//
+// CSV：每行为"chain,time,price"，time为RFC3339，首行为表头时自动跳过，#开头的行为注释
+// JSON：报价数组 [{"chain": "sepolia", "time": "2026-07-01T00:00:00Z", "price": "1.25"}]
+type FileSource struct {
+	quotes map[string][]Quote // 链 → 按时间升序的报价
+}
+
+// LoadFile 按扩展名（.csv/.json）载入价格文件
+func LoadFile(path string) (*FileSource, error) {
+	if path == "" {
+		return nil, fmt.Errorf("未配置价格文件")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("打开价格文件失败: %v", err)
+	}
+	defer f.Close()
+
+	source := "file:" + filepath.Base(path)
+	var quotes []Quote
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		quotes, err = readCSV(f, source)
+	case ".json":
+		quotes, err = readJSON(f, source)
+	default:
+		return nil, fmt.Errorf("价格文件只支持.csv或.json: %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("解析价格文件失败: %v", err)
+	}
+	return NewFileSource(quotes), nil
+}
+
+// NewFileSource 由报价列表创建价格源，同一条链同一时刻的重复报价以后出现的为准
+func NewFileSource(quotes []Quote) *FileSource {
+	s := &FileSource{quotes: make(map[string][]Quote)}
+	for _, q := range quotes {
+		s.quotes[q.Chain] = append(s.quotes[q.Chain], q)
+	}
+	for chain, qs := range s.quotes {
+		sort.SliceStable(qs, func(i, j int) bool { return qs[i].At.Before(qs[j].At) })
+		deduped := qs[:0]
+		for _, q := range qs {
+			if n := len(deduped); n > 0 && deduped[n-1].At.Equal(q.At) {
+				deduped[n-1] = q
+				continue
+			}
+			deduped = append(deduped, q)
+		}
+		s.quotes[chain] = deduped
+	}
+	return s
+}
+
+// Quotes 实现 PriceSource
+func (s *FileSource) Quotes(chainName string, start, end time.Time) ([]Quote, error) {
+	return window(s.quotes[chainName], start, end)
+}
+
+// Chains 价格文件中包含的链
+func (s *FileSource) Chains() []string {
+	chains := make([]string, 0, len(s.quotes))
+	for c := range s.quotes {
+		chains = append(chains, c)
+	}
+	sort.Strings(chains)
+	return chains
+}
+
+func readCSV(r io.Reader, source string) ([]Quote, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	var quotes []Quote
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return quotes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 3 {
+			return nil, fmt.Errorf("第%d行列数不足", line)
+		}
+		at, err := time.Parse(time.RFC3339, strings.TrimSpace(record[1]))
+		if err != nil {
+			if line == 1 {
+				continue // 表头
+			}
+			return nil, fmt.Errorf("第%d行时间无效: %v", line, err)
+		}
+		q, err := quoteJSON{
+			Chain: strings.TrimSpace(record[0]),
+			Time:  at,
+			Price: json.Number(strings.TrimSpace(record[2])),
+		}.quote(source)
+		if err != nil {
+			return nil, fmt.Errorf("第%d行: %v", line, err)
+		}
+		quotes = append(quotes, q)
+	}
+}
+
+func readJSON(r io.Reader, source string) ([]Quote, error) {
+	var raw []quoteJSON
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, err
+	}
+	quotes := make([]Quote, 0, len(raw))
+	for i, rq := range raw {
+		q, err := rq.quote(source)
+		if err != nil {
+			return nil, fmt.Errorf("第%d条报价: %v", i+1, err)
+		}
+		quotes = append(quotes, q)
+	}
+	return quotes, nil
+}
@@ -0,0 +1,108 @@
+package pricing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// HTTPSource HTTP价格服务
+//
+// 请求：GET <url>?chain=<链>&from=<RFC3339>&to=<RFC3339>
+// 响应：与JSON价格文件相同的报价数组，包含from时刻生效的报价及[from, to)内的报价；
+// 可指向 prices serve 启动的本地服务，或任何实现该接口的价格服务
+type HTTPSource struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPSource 创建HTTP价格源，超时为0时默认5秒
+func NewHTTPSource(rawURL string, timeout time.Duration) (*HTTPSource, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("价格服务地址无效: %q", rawURL)
+	}
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &HTTPSource{url: rawURL, client: &http.Client{Timeout: timeout}}, nil
+}
+
+// Quotes 实现 PriceSource
+func (s *HTTPSource) Quotes(chainName string, start, end time.Time) ([]Quote, error) {
+	u, _ := url.Parse(s.url)
+	q := u.Query()
+	q.Set("chain", chainName)
+	q.Set("from", start.UTC().Format(time.RFC3339))
+	q.Set("to", end.UTC().Format(time.RFC3339))
+	u.RawQuery = q.Encode()
+
+	resp, err := s.client.Get(u.String())
+	if err != nil {
+		return nil, fmt.Errorf("请求价格服务失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s %s", ErrNoQuote, chainName, start.UTC().Format(time.RFC3339))
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("价格服务返回 %s: %s", resp.Status, body)
+	}
+
+	var raw []quoteJSON
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("解析价格服务响应失败: %v", err)
+	}
+	quotes := make([]Quote, 0, len(raw))
+	for i, rq := range raw {
+		quote, err := rq.quote(s.url)
+		if err != nil {
+			return nil, fmt.Errorf("第%d条报价: %v", i+1, err)
+		}
+		if quote.Chain != chainName {
+			return nil, fmt.Errorf("价格服务返回了其他链的报价: %s", quote.Chain)
+		}
+		if i > 0 && !quote.At.After(quotes[i-1].At) {
+			return nil, fmt.Errorf("价格服务返回的报价未按时间升序")
+		}
+		quotes = append(quotes, quote)
+	}
+	return quotes, nil
+}
+
+// Handler 以HTTP接口提供价格源的报价，协议与 HTTPSource 一致
+func Handler(src PriceSource) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chain := r.URL.Query().Get("chain")
+		from, err := time.Parse(time.RFC3339, r.URL.Query().Get("from"))
+		if err != nil {
+			http.Error(w, "from应为RFC3339时间", http.StatusBadRequest)
+			return
+		}
+		to, err := time.Parse(time.RFC3339, r.URL.Query().Get("to"))
+		if err != nil || !to.After(from) {
+			http.Error(w, "to应为晚于from的RFC3339时间", http.StatusBadRequest)
+			return
+		}
+		quotes, err := src.Quotes(chain, from, to)
+		if errors.Is(err, ErrNoQuote) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		out := make([]quoteJSON, 0, len(quotes))
+		for _, q := range quotes {
+			out = append(out, toJSON(q))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	})
+}
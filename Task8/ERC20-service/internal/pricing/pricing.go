@@ -0,0 +1,160 @@
+// Package pricing 提供代币USD价格源，用于按持仓USD价值计算积分
+//
+// 价格按链区分（每条链追踪一个代币合约），报价从其时间起生效直到下一条报价；
+// 积分子段使用子段开始时刻生效的报价，报价随计算历史保存以便审计。
+package pricing
+
+import (
+	"encoding/json"
+	"erc20-service/config"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 价格源类型
+const (
+	SourceFile = "file" // 本地CSV/JSON价格文件
+	SourceHTTP = "http" // HTTP价格服务
+)
+
+var (
+	// ErrNoQuote 时间段开始时刻没有生效的报价
+	ErrNoQuote = errors.New("没有可用的价格报价")
+	// ErrStaleQuote 报价超过最大滞后时间
+	ErrStaleQuote = errors.New("价格报价已过期")
+)
+
+// Quote 单个代币的USD报价，自At起生效直到同一条链的下一条报价
+type Quote struct {
+	Chain  string
+	At     time.Time
+	Price  *big.Rat
+	Source string // 报价来源，如 file:prices.csv、http://127.0.0.1:8088/prices
+}
+
+// PriceString 价格按最多18位小数输出并去掉末尾的0
+func (q Quote) PriceString() string {
+	s := q.Price.FloatString(18)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
+
+// PriceSource 价格源
+type PriceSource interface {
+	// Quotes 返回链在[start, end)内生效的报价，按时间升序：
+	// 第一条为start时刻生效的报价（不晚于start的最后一条），其后为区间内的报价
+	// start时刻没有生效的报价时返回 ErrNoQuote
+	Quotes(chainName string, start, end time.Time) ([]Quote, error)
+}
+
+// New 按配置创建价格源，未配置source时返回nil（按代币数量计算积分）
+// 返回的价格源校验报价的滞后时间，区间内任一时刻使用的报价超过max_age_minutes时返回 ErrStaleQuote；
+// 校验通过的已结束时间段按链缓存
+func New(cfg config.PricingConfig) (PriceSource, error) {
+	var (
+		src PriceSource
+		err error
+	)
+	switch cfg.Source {
+	case "":
+		return nil, nil
+	case SourceFile:
+		src, err = LoadFile(cfg.File)
+	case SourceHTTP:
+		src, err = NewHTTPSource(cfg.URL, time.Duration(cfg.TimeoutSeconds)*time.Second)
+	default:
+		return nil, fmt.Errorf("不支持的价格源: %s", cfg.Source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newCached(freshness{src: src, maxAge: time.Duration(cfg.MaxAgeMinutes) * time.Minute}), nil
+}
+
+// 报价滞后校验
+type freshness struct {
+	src    PriceSource
+	maxAge time.Duration
+}
+
+func (f freshness) Quotes(chainName string, start, end time.Time) ([]Quote, error) {
+	quotes, err := f.src.Quotes(chainName, start, end)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckCoverage(quotes, start, end, f.maxAge); err != nil {
+		return nil, fmt.Errorf("链 %s: %w", chainName, err)
+	}
+	return quotes, nil
+}
+
+// CheckCoverage 校验报价覆盖[start, end)：首条报价不晚于start，且区间内任一时刻使用的报价滞后不超过maxAge
+func CheckCoverage(quotes []Quote, start, end time.Time, maxAge time.Duration) error {
+	if len(quotes) == 0 || quotes[0].At.After(start) {
+		return fmt.Errorf("%w: %s", ErrNoQuote, start.UTC().Format(time.RFC3339))
+	}
+	if maxAge <= 0 {
+		return nil
+	}
+	for i, q := range quotes {
+		until := end
+		if i+1 < len(quotes) && quotes[i+1].At.Before(end) {
+			until = quotes[i+1].At
+		}
+		if until.Sub(q.At) > maxAge {
+			return fmt.Errorf("%w: %s 的报价使用到 %s", ErrStaleQuote,
+				q.At.UTC().Format(time.RFC3339), until.UTC().Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// At 在按时间升序的报价中查找时刻t生效的报价
+func At(quotes []Quote, t time.Time) (Quote, bool) {
+	i := sort.Search(len(quotes), func(i int) bool { return quotes[i].At.After(t) })
+	if i == 0 {
+		return Quote{}, false
+	}
+	return quotes[i-1], true
+}
+
+// 从升序报价中截取[start, end)内生效的报价
+func window(quotes []Quote, start, end time.Time) ([]Quote, error) {
+	first := sort.Search(len(quotes), func(i int) bool { return quotes[i].At.After(start) })
+	if first == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoQuote, start.UTC().Format(time.RFC3339))
+	}
+	last := sort.Search(len(quotes), func(i int) bool { return !quotes[i].At.Before(end) })
+	if last < first {
+		last = first
+	}
+	return quotes[first-1 : last], nil
+}
+
+// 价格文件与HTTP接口共用的报价格式，价格可为字符串或数字
+type quoteJSON struct {
+	Chain string      `json:"chain"`
+	Time  time.Time   `json:"time"`
+	Price json.Number `json:"price"`
+}
+
+func (q quoteJSON) quote(source string) (Quote, error) {
+	price, ok := new(big.Rat).SetString(q.Price.String())
+	if !ok || price.Sign() < 0 {
+		return Quote{}, fmt.Errorf("价格无效: %q", q.Price)
+	}
+	if q.Chain == "" || q.Time.IsZero() {
+		return Quote{}, fmt.Errorf("报价缺少chain或time")
+	}
+	return Quote{Chain: q.Chain, At: q.Time.UTC(), Price: price, Source: source}, nil
+}
+
+func toJSON(q Quote) quoteJSON {
+	return quoteJSON{Chain: q.Chain, Time: q.At, Price: json.Number(q.PriceString())}
+}
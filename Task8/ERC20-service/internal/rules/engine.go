@@ -0,0 +1,345 @@
+package rules
+
+import (
+	"erc20-service/config"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultVersion 未配置规则集时使用的版本号
+const DefaultVersion = "default"
+
+// 每个代币的最小单位数量（18位精度）
+var weiPerToken = new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+
+// Segment 持有余额不变的时间段
+type Segment struct {
+	Start   time.Time
+	End     time.Time
+	Balance *big.Int // 余额（最小单位），含已申请解押、尚未领取的代币
+	Staked  *big.Int // 质押锁定余额（最小单位），可为nil
+}
+
+// RatedSegment 按规则定价后的时间段
+type RatedSegment struct {
+	Segment
+	Rate        *big.Rat // 生效比率（基础比率 × 各项倍数）
+	Staking     *big.Rat // 质押锁定余额的倍数
+	RuleVersion string   // 规则集版本
+	Rule        string   // 命中的规则描述，便于审计
+}
+
+// Engine 积分规则引擎
+type Engine struct {
+	sets []*ruleSet // 按生效时间升序
+}
+
+type ruleSet struct {
+	version       string
+	effectiveFrom time.Time
+	baseRate      *big.Rat
+	chainRates    map[string]*big.Rat
+	tiers         []tier // 按门槛降序
+	addresses     map[string]*big.Rat
+	campaigns     []campaign
+	staking       *big.Rat
+}
+
+type tier struct {
+	minWei     *big.Int
+	minBalance string
+	multiplier *big.Rat
+}
+
+type campaign struct {
+	name       string
+	start, end time.Time
+	multiplier *big.Rat
+	chains     map[string]bool
+}
+
+// NewEngine 根据积分配置构建规则引擎，未配置规则集时按points.rate生成默认规则集
+func NewEngine(cfg config.PointsConfig) (*Engine, error) {
+	e := &Engine{}
+	if len(cfg.RuleSets) == 0 {
+		e.sets = []*ruleSet{{
+			version:  DefaultVersion,
+			baseRate: RatFromFloat(cfg.Rate),
+			staking:  stakingMultiplier(cfg.StakingMultiplier),
+		}}
+		return e, nil
+	}
+
+	seen := make(map[string]bool)
+	for _, rc := range cfg.RuleSets {
+		if rc.Version == "" {
+			return nil, fmt.Errorf("规则集缺少version")
+		}
+		if seen[rc.Version] {
+			return nil, fmt.Errorf("规则集版本重复: %s", rc.Version)
+		}
+		seen[rc.Version] = true
+		rs, err := buildRuleSet(rc, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("规则集 %s 无效: %v", rc.Version, err)
+		}
+		e.sets = append(e.sets, rs)
+	}
+	sort.Slice(e.sets, func(i, j int) bool { return e.sets[i].effectiveFrom.Before(e.sets[j].effectiveFrom) })
+	return e, nil
+}
+
+func buildRuleSet(rc config.RuleSetConfig, cfg config.PointsConfig) (*ruleSet, error) {
+	// 未配置时沿用points中的设置，显式配置的0照常生效
+	baseRate := RatFromFloat(cfg.Rate)
+	if rc.BaseRate != nil {
+		if *rc.BaseRate < 0 {
+			return nil, fmt.Errorf("base_rate不能为负")
+		}
+		baseRate = RatFromFloat(*rc.BaseRate)
+	}
+	staking := stakingMultiplier(cfg.StakingMultiplier)
+	if rc.StakingMultiplier != nil {
+		if *rc.StakingMultiplier < 0 {
+			return nil, fmt.Errorf("staking_multiplier不能为负")
+		}
+		staking = RatFromFloat(*rc.StakingMultiplier)
+	}
+	rs := &ruleSet{
+		version:       rc.Version,
+		effectiveFrom: rc.EffectiveFrom,
+		baseRate:      baseRate,
+		chainRates:    make(map[string]*big.Rat),
+		addresses:     make(map[string]*big.Rat),
+		staking:       staking,
+	}
+	for chain, rate := range rc.ChainRates {
+		if rate < 0 {
+			return nil, fmt.Errorf("链 %s 比率不能为负", chain)
+		}
+		rs.chainRates[chain] = RatFromFloat(rate)
+	}
+	for _, t := range rc.Tiers {
+		if t.Multiplier < 0 || t.MinBalance < 0 {
+			return nil, fmt.Errorf("分级配置不能为负")
+		}
+		minTokens := RatFromFloat(t.MinBalance)
+		minWei := new(big.Rat).Mul(minTokens, new(big.Rat).SetInt(weiPerToken))
+		rs.tiers = append(rs.tiers, tier{
+			minWei:     new(big.Int).Quo(minWei.Num(), minWei.Denom()),
+			minBalance: strconv.FormatFloat(t.MinBalance, 'f', -1, 64),
+			multiplier: RatFromFloat(t.Multiplier),
+		})
+	}
+	sort.Slice(rs.tiers, func(i, j int) bool { return rs.tiers[i].minWei.Cmp(rs.tiers[j].minWei) > 0 })
+	for addr, m := range rc.AddressMultipliers {
+		if m < 0 {
+			return nil, fmt.Errorf("地址 %s 倍数不能为负", addr)
+		}
+		rs.addresses[strings.ToLower(addr)] = RatFromFloat(m)
+	}
+	for _, c := range rc.Campaigns {
+		if !c.End.After(c.Start) {
+			return nil, fmt.Errorf("活动 %s 结束时间必须晚于开始时间", c.Name)
+		}
+		if c.Multiplier < 0 {
+			return nil, fmt.Errorf("活动 %s 倍数不能为负", c.Name)
+		}
+		chains := make(map[string]bool)
+		for _, ch := range c.Chains {
+			chains[ch] = true
+		}
+		rs.campaigns = append(rs.campaigns, campaign{
+			name:       c.Name,
+			start:      c.Start,
+			end:        c.End,
+			multiplier: RatFromFloat(c.Multiplier),
+			chains:     chains,
+		})
+	}
+	return rs, nil
+}
+
+// Rate 对时间段定价：按规则集切换与活动起止时间拆分，每个子段给出生效比率与规则版本
+func (e *Engine) Rate(chainName, userAddr string, seg Segment) []RatedSegment {
+	if !seg.End.After(seg.Start) {
+		return nil
+	}
+	var out []RatedSegment
+	for _, piece := range splitAt(seg, e.boundaries(seg.Start, seg.End)) {
+		rs := e.active(piece.Start)
+		if rs == nil {
+			// 早于首个规则集生效时间，不计积分
+			out = append(out, RatedSegment{Segment: piece, Rate: new(big.Rat), Rule: "no active rule set"})
+			continue
+		}
+		rate, rule := rs.rate(chainName, userAddr, piece)
+		if piece.Staked != nil && piece.Staked.Sign() > 0 {
+			rule += " staked×" + ratString(rs.staking)
+		}
+		out = append(out, RatedSegment{Segment: piece, Rate: rate, Staking: rs.staking, RuleVersion: rs.version, Rule: rule})
+	}
+	return out
+}
+
+// Pinned 返回固定使用指定版本规则集的引擎，供绑定了规则集的赛季使用：
+// 赛季内的周期不再按生效时间切换规则集，活动仍按起止时间生效；版本不存在时返回错误
+func (e *Engine) Pinned(version string) (*Engine, error) {
+	for _, rs := range e.sets {
+		if rs.version == version {
+			pinned := *rs
+			pinned.effectiveFrom = time.Time{}
+			return &Engine{sets: []*ruleSet{&pinned}}, nil
+		}
+	}
+	return nil, fmt.Errorf("规则集版本不存在: %s", version)
+}
+
+// 时间段内的拆分点：规则集生效时间与活动起止时间
+func (e *Engine) boundaries(start, end time.Time) []time.Time {
+	var points []time.Time
+	add := func(t time.Time) {
+		if t.After(start) && t.Before(end) {
+			points = append(points, t)
+		}
+	}
+	for _, rs := range e.sets {
+		add(rs.effectiveFrom)
+		for _, c := range rs.campaigns {
+			add(c.start)
+			add(c.end)
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Before(points[j]) })
+	return points
+}
+
+func splitAt(seg Segment, points []time.Time) []Segment {
+	var out []Segment
+	cur := seg.Start
+	for _, p := range points {
+		if !p.After(cur) {
+			continue
+		}
+		out = append(out, Segment{Start: cur, End: p, Balance: seg.Balance, Staked: seg.Staked})
+		cur = p
+	}
+	return append(out, Segment{Start: cur, End: seg.End, Balance: seg.Balance, Staked: seg.Staked})
+}
+
+// 指定时刻生效的规则集
+func (e *Engine) active(at time.Time) *ruleSet {
+	var current *ruleSet
+	for _, rs := range e.sets {
+		if rs.effectiveFrom.After(at) {
+			break
+		}
+		current = rs
+	}
+	return current
+}
+
+// 计算生效比率：链比率（或基础比率）× 分级倍数 × 地址倍数 × 活动倍数
+// 分级门槛按持有余额与质押余额之和判断
+func (rs *ruleSet) rate(chainName, userAddr string, seg Segment) (*big.Rat, string) {
+	holding := seg.Holding()
+	rate := new(big.Rat).Set(rs.baseRate)
+	parts := []string{"base=" + rs.baseRate.FloatString(6)}
+	if cr, ok := rs.chainRates[chainName]; ok {
+		rate.Set(cr)
+		parts = []string{"chain:" + chainName + "=" + cr.FloatString(6)}
+	}
+	for _, t := range rs.tiers {
+		if holding.Cmp(t.minWei) >= 0 {
+			rate.Mul(rate, t.multiplier)
+			parts = append(parts, fmt.Sprintf("tier>=%s×%s", t.minBalance, ratString(t.multiplier)))
+			break
+		}
+	}
+	if m, ok := rs.addresses[strings.ToLower(userAddr)]; ok {
+		rate.Mul(rate, m)
+		parts = append(parts, "address×"+ratString(m))
+	}
+	for _, c := range rs.campaigns {
+		if seg.Start.Before(c.start) || !seg.Start.Before(c.end) {
+			continue
+		}
+		if len(c.chains) > 0 && !c.chains[chainName] {
+			continue
+		}
+		rate.Mul(rate, c.multiplier)
+		parts = append(parts, "campaign:"+c.name+"×"+ratString(c.multiplier))
+	}
+	return rate, strings.Join(parts, " ")
+}
+
+// Holding 持有余额与质押余额之和
+func (s Segment) Holding() *big.Int {
+	holding := new(big.Int).Set(s.Balance)
+	if s.Staked != nil {
+		holding.Add(holding, s.Staked)
+	}
+	return holding
+}
+
+// Weight 计息余额（最小单位，精确有理数）：持有余额 + 质押余额 × 质押倍数
+func (rs RatedSegment) Weight() *big.Rat {
+	w := new(big.Rat).SetInt(rs.Balance)
+	if rs.Staked != nil && rs.Staked.Sign() > 0 {
+		staked := new(big.Rat).SetInt(rs.Staked)
+		if rs.Staking != nil {
+			staked.Mul(staked, rs.Staking)
+		}
+		w.Add(w, staked)
+	}
+	return w
+}
+
+// WeightTokens 计息余额（代币单位）
+func (rs RatedSegment) WeightTokens() *big.Rat {
+	w := rs.Weight()
+	return w.Quo(w, new(big.Rat).SetInt(weiPerToken))
+}
+
+// Points 计算定价子段的积分（精确有理数）：计息余额(代币) × 比率 × 子段时长 / 总周期
+func Points(rs RatedSegment, totalDuration time.Duration) *big.Rat {
+	if totalDuration <= 0 {
+		return new(big.Rat)
+	}
+	return PointsForShare(rs, big.NewRat(int64(rs.End.Sub(rs.Start)), int64(totalDuration)))
+}
+
+// PointsForShare 按子段占周期的比例计算积分（精确有理数）：计息余额(代币) × 比率 × share
+// 区块周期的share为持有区块数 / 周期区块数
+func PointsForShare(rs RatedSegment, share *big.Rat) *big.Rat {
+	p := rs.Weight()
+	p.Mul(p, share)
+	p.Mul(p, rs.Rate)
+	return p.Quo(p, new(big.Rat).SetInt(weiPerToken))
+}
+
+// 质押倍数，未配置时为1
+func stakingMultiplier(f float64) *big.Rat {
+	if f == 0 {
+		return big.NewRat(1, 1)
+	}
+	return RatFromFloat(f)
+}
+
+// RatFromFloat 将配置中的浮点数按最短十进制表示转换为精确有理数（0.05 → 1/20）
+func RatFromFloat(f float64) *big.Rat {
+	r, ok := new(big.Rat).SetString(strconv.FormatFloat(f, 'f', -1, 64))
+	if !ok {
+		return new(big.Rat)
+	}
+	return r
+}
+
+func ratString(r *big.Rat) string {
+	f, _ := r.Float64()
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
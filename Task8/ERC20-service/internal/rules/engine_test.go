@@ -0,0 +1,132 @@
+package rules
+
+import (
+	"erc20-service/config"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func floatPtr(f float64) *float64 { return &f }
+
+// v1 自 t0 起生效，v2 自 t1 起生效
+var (
+	t0 = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	t1 = time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+)
+
+func testEngine(t *testing.T) *Engine {
+	t.Helper()
+	e, err := NewEngine(config.PointsConfig{
+		Rate:              0.05,
+		StakingMultiplier: 1,
+		RuleSets: []config.RuleSetConfig{
+			// 故意乱序，引擎按生效时间排序
+			{Version: "v2", EffectiveFrom: t1, BaseRate: floatPtr(0.2)},
+			{Version: "v1", EffectiveFrom: t0, BaseRate: floatPtr(0.1)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+	return e
+}
+
+func TestRateSelectsRuleSetAtEffectiveFrom(t *testing.T) {
+	e := testEngine(t)
+	balance := big.NewInt(1)
+	type piece struct {
+		start, end time.Time
+		version    string
+	}
+	tests := []struct {
+		name       string
+		start, end time.Time
+		want       []piece
+	}{
+		{
+			name:  "早于首个规则集",
+			start: t0.Add(-2 * time.Hour), end: t0.Add(-time.Hour),
+			want: []piece{{t0.Add(-2 * time.Hour), t0.Add(-time.Hour), ""}},
+		},
+		{
+			name:  "结束于首个规则集生效时刻",
+			start: t0.Add(-time.Hour), end: t0,
+			want: []piece{{t0.Add(-time.Hour), t0, ""}},
+		},
+		{
+			name:  "跨越首个规则集生效时刻",
+			start: t0.Add(-time.Hour), end: t0.Add(time.Hour),
+			want: []piece{{t0.Add(-time.Hour), t0, ""}, {t0, t0.Add(time.Hour), "v1"}},
+		},
+		{
+			name:  "开始于生效时刻",
+			start: t1, end: t1.Add(time.Hour),
+			want: []piece{{t1, t1.Add(time.Hour), "v2"}},
+		},
+		{
+			name:  "结束于下一个规则集生效时刻",
+			start: t1.Add(-time.Hour), end: t1,
+			want: []piece{{t1.Add(-time.Hour), t1, "v1"}},
+		},
+		{
+			name:  "跨越规则集切换",
+			start: t1.Add(-time.Hour), end: t1.Add(time.Hour),
+			want: []piece{{t1.Add(-time.Hour), t1, "v1"}, {t1, t1.Add(time.Hour), "v2"}},
+		},
+		{
+			name:  "生效时刻前一纳秒",
+			start: t1.Add(-time.Nanosecond), end: t1.Add(time.Nanosecond),
+			want: []piece{{t1.Add(-time.Nanosecond), t1, "v1"}, {t1, t1.Add(time.Nanosecond), "v2"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := e.Rate("sepolia", "0xabc", Segment{Start: tt.start, End: tt.end, Balance: balance})
+			if len(got) != len(tt.want) {
+				t.Fatalf("子段数 = %d, want %d", len(got), len(tt.want))
+			}
+			for i, w := range tt.want {
+				g := got[i]
+				if !g.Start.Equal(w.start) || !g.End.Equal(w.end) || g.RuleVersion != w.version {
+					t.Errorf("子段%d = [%s, %s) %q, want [%s, %s) %q", i, g.Start, g.End, g.RuleVersion, w.start, w.end, w.version)
+				}
+				if w.version == "" && g.Rate.Sign() != 0 {
+					t.Errorf("子段%d 无生效规则集时比率应为0, got %s", i, g.Rate.FloatString(6))
+				}
+			}
+		})
+	}
+}
+
+func TestRuleSetFallbacks(t *testing.T) {
+	tests := []struct {
+		name        string
+		rs          config.RuleSetConfig
+		wantRate    *big.Rat
+		wantStaking *big.Rat
+	}{
+		{"未配置时沿用points", config.RuleSetConfig{}, big.NewRat(1, 20), big.NewRat(3, 2)},
+		{"显式配置为0", config.RuleSetConfig{BaseRate: floatPtr(0), StakingMultiplier: floatPtr(0)}, new(big.Rat), new(big.Rat)},
+		{"显式配置", config.RuleSetConfig{BaseRate: floatPtr(0.08), StakingMultiplier: floatPtr(2)}, big.NewRat(2, 25), big.NewRat(2, 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rs.Version, tt.rs.EffectiveFrom = "v1", t0
+			e, err := NewEngine(config.PointsConfig{Rate: 0.05, StakingMultiplier: 1.5, RuleSets: []config.RuleSetConfig{tt.rs}})
+			if err != nil {
+				t.Fatalf("NewEngine: %v", err)
+			}
+			got := e.Rate("sepolia", "0xabc", Segment{Start: t0, End: t0.Add(time.Hour), Balance: big.NewInt(1)})
+			if len(got) != 1 {
+				t.Fatalf("子段数 = %d, want 1", len(got))
+			}
+			if got[0].Rate.Cmp(tt.wantRate) != 0 {
+				t.Errorf("比率 = %s, want %s", got[0].Rate.FloatString(6), tt.wantRate.FloatString(6))
+			}
+			if got[0].Staking.Cmp(tt.wantStaking) != 0 {
+				t.Errorf("质押倍数 = %s, want %s", got[0].Staking.FloatString(6), tt.wantStaking.FloatString(6))
+			}
+		})
+	}
+}
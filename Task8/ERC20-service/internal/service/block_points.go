@@ -0,0 +1,203 @@
+package service
+
+import (
+	"erc20-service/internal/db"
+	"erc20-service/internal/epoch"
+	"erc20-service/internal/mq"
+	"erc20-service/internal/rules"
+	"erc20-service/pkg/decimal"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// 计算区块周期任务
+// 区块周期首尾相接，与已入账时间段重叠只出现在切换积分模式时，此时整个周期跳过，不按时间拆分
+func (c *PointsConsumer) calculateBlockPeriod(task mq.PointsCalculationTask) error {
+	applied, err := db.GetAppliedPeriods(task.ChainName, task.UserAddress, task.PeriodStart, task.PeriodEnd)
+	if err != nil {
+		return fmt.Errorf("获取已入账时间段失败: %v", err)
+	}
+	if len(applied) > 0 {
+		if len(subtractPeriods(db.TimePeriod{Start: task.PeriodStart, End: task.PeriodEnd}, applied)) > 0 {
+			c.log.Warn("区块周期与已入账时间段部分重叠，跳过",
+				"task_id", task.TaskID,
+				"chain", task.ChainName,
+				"user", task.UserAddress,
+				"blocks", fmt.Sprintf("%d - %d", task.BlockStart, task.BlockEnd),
+			)
+			return nil
+		}
+		c.log.Info("区块周期已入账，跳过", "task_id", task.TaskID, "chain", task.ChainName, "user", task.UserAddress)
+		return nil
+	}
+
+	p := epoch.BlockPeriod{
+		StartBlock: task.BlockStart,
+		EndBlock:   task.BlockEnd,
+		Start:      task.PeriodStart,
+		End:        task.PeriodEnd,
+	}
+	holdings, err := LoadBlockPeriodHoldings(c.policy, task.ChainName, task.UserAddress, p)
+	if err != nil {
+		return err
+	}
+	if holdings.Prices, err = LoadPrices(c.prices, task.ChainName, task.PeriodStart, task.PeriodEnd); err != nil {
+		return err
+	}
+	engine, err := c.seasonEngine(task.Season)
+	if err != nil {
+		return err
+	}
+	points, ruleVersion, segments := ExplainBlockPeriodPoints(engine, task.ChainName, task.UserAddress, holdings, p)
+	return c.credit(task, db.PointsCalculation{
+		ChainName:    task.ChainName,
+		UserAddress:  task.UserAddress,
+		PeriodStart:  task.PeriodStart,
+		PeriodEnd:    task.PeriodEnd,
+		PointsAdded:  points,
+		RuleVersion:  ruleVersion,
+		TaskID:       task.TaskID,
+		BlockStart:   task.BlockStart,
+		BlockEnd:     task.BlockEnd,
+		Season:       task.Season,
+		CalculatedAt: time.Now(),
+	}, segments)
+}
+
+// LoadBlockPeriodHoldings 查询用户区块周期[StartBlock, EndBlock)的期初持仓与持仓变动，以及周期适用的女巫处理权重
+func LoadBlockPeriodHoldings(policy *db.Policy, chainName, userAddr string, p epoch.BlockPeriod) (PeriodHoldings, error) {
+	var h PeriodHoldings
+	var err error
+	from, to := p.StartBlock, p.EndBlock
+	if h.OpeningBalance, err = db.GetBalanceAtBlock(chainName, userAddr, from); err != nil {
+		return h, fmt.Errorf("获取期初余额失败: %v", err)
+	}
+	if h.Changes, err = db.GetBalanceChangesInBlocks(chainName, userAddr, from, to); err != nil {
+		return h, fmt.Errorf("获取余额变动失败: %v", err)
+	}
+	if h.OpeningStake, err = db.GetStakeAtBlock(chainName, userAddr, from); err != nil {
+		return h, fmt.Errorf("获取期初质押持仓失败: %v", err)
+	}
+	if h.StakeChanges, err = db.GetStakeChangesInBlocks(chainName, userAddr, from, to); err != nil {
+		return h, fmt.Errorf("获取质押变动失败: %v", err)
+	}
+	if h.Sybil, err = db.GetSybilPenalty(policy, chainName, userAddr, p.Start); err != nil {
+		return h, fmt.Errorf("获取女巫聚类失败: %v", err)
+	}
+	return h, nil
+}
+
+// ExplainBlockPeriodPoints 计算区块周期的积分与明细，不访问数据库
+// 积分 = Σ 计息余额(代币单位) × 生效比率 × (持有区块数/周期区块数)，变动所在区块起按新持仓计
+// 规则按子段的链上时间选择，子段时间由周期起止时间按区块号线性插值；
+// 规则在子段内切换时，子段的区块数按时长比例分配给各规则
+func ExplainBlockPeriodPoints(engine *rules.Engine, chainName, userAddr string, holdings PeriodHoldings, p epoch.BlockPeriod) (decimal.Decimal, string, []db.PointsSegment) {
+	if p.Blocks() == 0 {
+		return decimal.Zero(), "", nil
+	}
+
+	total := new(big.Rat)
+	var (
+		versions []string
+		segments []db.PointsSegment
+	)
+	for _, seg := range blockHoldingSegments(holdings, p) {
+		blockShare := big.NewRat(int64(seg.toBlock-seg.fromBlock), int64(p.Blocks()))
+		segDuration := seg.End.Sub(seg.Start)
+		for i, rated := range engine.Rate(chainName, userAddr, seg.Segment) {
+			share := blockShare
+			if segDuration > 0 {
+				share = new(big.Rat).Mul(blockShare, big.NewRat(int64(rated.End.Sub(rated.Start)), int64(segDuration)))
+			} else if i > 0 {
+				// 零时长子段（多个区块同一时间戳）整体归第一个规则
+				continue
+			}
+			points := rules.PointsForShare(rated, share)
+			segment := db.PointsSegment{
+				Start:       rated.Start,
+				End:         rated.End,
+				FromBlock:   seg.fromBlock,
+				ToBlock:     seg.toBlock,
+				Balance:     rated.Balance.String(),
+				Staked:      stakedString(rated.Staked),
+				Weight:      ratText(rated.WeightTokens(), 18),
+				Rate:        ratText(rated.Rate, 12),
+				RuleVersion: rated.RuleVersion,
+				Rule:        rated.Rule,
+			}
+			applyPrice(holdings.Prices, points, &segment)
+			applySybil(holdings.Sybil, points, &segment)
+			segment.Points = decimal.FromRat(points).String()
+			total.Add(total, points)
+			if rated.RuleVersion != "" && (len(versions) == 0 || versions[len(versions)-1] != rated.RuleVersion) {
+				versions = append(versions, rated.RuleVersion)
+			}
+			segments = append(segments, segment)
+		}
+	}
+	return decimal.FromRat(total), strings.Join(versions, "+"), segments
+}
+
+// 持仓不变的区块区间
+type blockSegment struct {
+	rules.Segment
+	fromBlock uint64
+	toBlock   uint64
+}
+
+// 按区块号合并余额变动与质押变动，切分为持仓不变的区块区间
+func blockHoldingSegments(h PeriodHoldings, p epoch.BlockPeriod) []blockSegment {
+	wallet := parseWei(h.OpeningBalance)
+	staked := parseWei(h.OpeningStake.Staked)
+	pending := parseWei(h.OpeningStake.Pending)
+
+	var segments []blockSegment
+	prev := p.StartBlock
+	// 记录截至区块b（不含）的持仓区间
+	flush := func(b uint64) {
+		if b <= prev {
+			return
+		}
+		segments = append(segments, blockSegment{
+			Segment: rules.Segment{
+				Start:   blockTime(p, prev),
+				End:     blockTime(p, b),
+				Balance: new(big.Int).Add(wallet, pending),
+				Staked:  staked,
+			},
+			fromBlock: prev,
+			toBlock:   b,
+		})
+		prev = b
+	}
+
+	i, j := 0, 0
+	for i < len(h.Changes) || j < len(h.StakeChanges) {
+		// 同一区块先处理余额变动
+		if j >= len(h.StakeChanges) || (i < len(h.Changes) && h.Changes[i].BlockNumber <= h.StakeChanges[j].BlockNumber) {
+			flush(h.Changes[i].BlockNumber)
+			wallet = parseWei(h.Changes[i].BalanceAfter)
+			i++
+			continue
+		}
+		flush(h.StakeChanges[j].BlockNumber)
+		staked = parseWei(h.StakeChanges[j].StakedAfter)
+		pending = parseWei(h.StakeChanges[j].PendingAfter)
+		j++
+	}
+
+	flush(p.EndBlock)
+	return segments
+}
+
+// 按区块号在周期起止时间之间线性插值区块时间
+func blockTime(p epoch.BlockPeriod, block uint64) time.Time {
+	if block >= p.EndBlock {
+		return p.End
+	}
+	offset := new(big.Int).Mul(big.NewInt(int64(p.End.Sub(p.Start))), new(big.Int).SetUint64(block-p.StartBlock))
+	offset.Quo(offset, new(big.Int).SetUint64(p.Blocks()))
+	return p.Start.Add(time.Duration(offset.Int64()))
+}
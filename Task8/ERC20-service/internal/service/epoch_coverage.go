@@ -0,0 +1,33 @@
+package service
+
+import (
+	"erc20-service/internal/db"
+	"erc20-service/internal/epoch"
+	"fmt"
+)
+
+// MissingEpochs 返回用户在[fromID, toID]内未完整覆盖的积分周期
+// 覆盖以已入账时间段判断（含积分为0的记录、已归档的日汇总与对齐前的旧历史），
+// 周期内任一时刻未被覆盖即视为缺失，由消费者只补算未覆盖的部分
+func MissingEpochs(clock epoch.Clock, chainName, userAddr string, fromID, toID int64) ([]epoch.Epoch, error) {
+	if toID < fromID {
+		return nil, nil
+	}
+	first, last := clock.Epoch(fromID), clock.Epoch(toID)
+	applied, err := db.GetAppliedPeriods(chainName, userAddr, first.Start, last.End)
+	if err != nil {
+		return nil, fmt.Errorf("获取已入账时间段失败: %v", err)
+	}
+
+	var missing []epoch.Epoch
+	for _, e := range clock.Range(fromID, toID) {
+		// applied按开始时间排序，跳过已在当前周期之前结束的时间段
+		for len(applied) > 0 && !applied[0].End.After(e.Start) {
+			applied = applied[1:]
+		}
+		if len(subtractPeriods(db.TimePeriod{Start: e.Start, End: e.End}, applied)) > 0 {
+			missing = append(missing, e)
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"erc20-service/config"
+	"erc20-service/internal/db"
+	"erc20-service/internal/mq"
+	"erc20-service/pkg/logger"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// 重试退避上限
+const outboxMaxBackoff = 5 * time.Minute
+
+// 领取消息的租约，需覆盖一批消息逐条等待发布确认的时长；中继中途退出时消息在租约到期后被其他中继领取
+const outboxClaimLease = 2 * time.Minute
+
+// OutboxRelay 发件箱中继：轮询outbox表并投递到MQ，投递成功后标记已发送
+// 先投递后标记，保证至少一次投递；MQ故障期间消息保留在表中等待重试
+// 消息按租约领取，多个daemon同时运行中继时不会重复投递同一条消息
+type OutboxRelay struct {
+	mqCfg     config.RabbitMQConfig
+	cfg       config.OutboxConfig
+	conn      *mq.Connection
+	producer  *mq.PointsProducer
+	lastPurge time.Time
+	log       *slog.Logger
+}
+
+// NewOutboxRelay 创建发件箱中继
+func NewOutboxRelay(mqCfg config.RabbitMQConfig, cfg config.OutboxConfig) *OutboxRelay {
+	return &OutboxRelay{
+		mqCfg: mqCfg,
+		cfg:   cfg,
+		log:   logger.New("outbox-relay"),
+	}
+}
+
+// Start 启动中继
+func (r *OutboxRelay) Start(ctx context.Context) {
+	r.log.Info("启动发件箱中继", "poll_interval", r.cfg.PollInterval, "batch_size", r.cfg.BatchSize)
+
+	ticker := time.NewTicker(time.Duration(r.cfg.PollInterval) * time.Second)
+	defer func() {
+		ticker.Stop()
+		r.disconnect()
+		r.log.Info("发件箱中继已停止")
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// 一批投递满时立即继续，尽快追平积压
+			for {
+				sent, err := r.relayBatch(ctx)
+				if err != nil {
+					r.log.Warn("投递发件箱消息失败", "error", err)
+					break
+				}
+				if sent < r.cfg.BatchSize {
+					break
+				}
+			}
+			r.purgeSent()
+		}
+	}
+}
+
+// 投递一批到期消息，返回成功条数
+func (r *OutboxRelay) relayBatch(ctx context.Context) (int, error) {
+	msgs, err := db.ClaimPendingOutbox(r.cfg.BatchSize, outboxClaimLease)
+	if err != nil {
+		return 0, fmt.Errorf("读取发件箱失败: %v", err)
+	}
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+
+	if err := r.connect(); err != nil {
+		// MQ不可用时整批延后重试
+		for _, m := range msgs {
+			r.markFailed(m, err)
+		}
+		return 0, err
+	}
+
+	sent := 0
+	for i, m := range msgs {
+		if ctx.Err() != nil {
+			r.release(msgs[i:])
+			return sent, nil
+		}
+		routingKey, msgType := r.route(m.Topic)
+		if err := r.producer.PublishRaw(routingKey, msgType, m.Payload); err != nil {
+			r.markFailed(m, err)
+			r.release(msgs[i+1:])
+			// 连接状态未知，丢弃后下一轮重连
+			r.disconnect()
+			return sent, err
+		}
+		if err := db.MarkOutboxSent(m.ID); err != nil {
+			// 已投递但未标记，租约到期后会重复投递，由消费端幂等处理
+			r.release(msgs[i+1:])
+			return sent, fmt.Errorf("标记发件箱消息失败: %v", err)
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// 根据主题确定路由键与消息类型
+func (r *OutboxRelay) route(topic string) (string, string) {
+	switch topic {
+	case db.OutboxTopicPointsTask:
+		return r.mqCfg.RoutingKey, ""
+	case db.OutboxTopicPointsBatch:
+		// 与逐用户任务共用队列，消费者按消息类型区分
+		return r.mqCfg.RoutingKey, topic
+	}
+	return r.mqCfg.EventRoutingKey, topic
+}
+
+// 释放本批未尝试投递的消息，下一轮重新领取
+func (r *OutboxRelay) release(msgs []db.OutboxMessage) {
+	if err := db.ReleaseOutbox(msgs); err != nil {
+		r.log.Warn("释放发件箱消息失败", "count", len(msgs), "error", err)
+	}
+}
+
+// 记录失败并按指数退避安排下次重试
+func (r *OutboxRelay) markFailed(m db.OutboxMessage, cause error) {
+	attempts := m.Attempts + 1
+	backoff := time.Second << min(attempts, 9)
+	if backoff > outboxMaxBackoff {
+		backoff = outboxMaxBackoff
+	}
+	if err := db.MarkOutboxFailed(m.ID, attempts, r.cfg.MaxAttempts, time.Now().Add(backoff), cause); err != nil {
+		r.log.Error("记录投递失败状态失败", "id", m.ID, "error", err)
+	}
+}
+
+// 建立MQ连接（已连接时复用）
+func (r *OutboxRelay) connect() error {
+	if r.producer != nil {
+		return nil
+	}
+	conn, producer, err := mq.DialConfirmProducer(r.mqCfg)
+	if err != nil {
+		return err
+	}
+	r.conn = conn
+	r.producer = producer
+	r.log.Info("发件箱中继已连接MQ")
+	return nil
+}
+
+// 断开MQ连接
+func (r *OutboxRelay) disconnect() {
+	if r.conn != nil {
+		r.conn.Close()
+	}
+	r.conn = nil
+	r.producer = nil
+}
+
+// 定期清理已投递消息
+func (r *OutboxRelay) purgeSent() {
+	if time.Since(r.lastPurge) < time.Hour {
+		return
+	}
+	r.lastPurge = time.Now()
+	before := time.Now().Add(-time.Duration(r.cfg.RetentionHours) * time.Hour)
+	n, err := db.PurgeSentOutbox(before)
+	if err != nil {
+		r.log.Warn("清理已投递消息失败", "error", err)
+		return
+	}
+	if n > 0 {
+		r.log.Info("清理已投递消息", "count", n)
+	}
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"erc20-service/internal/db"
+	"erc20-service/internal/mq"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 计算分片批量任务
+// 分片内全部用户的期初持仓、持仓变动与已入账时间段各用一条集合查询取回，在内存中逐用户计算，
+// 再按chunk_size分组批量入账；每个用户的入账仍使用确定性任务ID，与逐用户任务相互幂等
+//
+// 每个任务只计算一个积分周期，周期之间首尾相接；此前周期的缺口由调度器按用户生成回溯任务
+func (c *PointsConsumer) calculateBatch(task mq.PointsBatchTask) error {
+	started := time.Now()
+	shard := db.Shard{ChainName: task.ChainName, Shard: task.Shard, Shards: task.Shards}
+
+	// 1. 集合查询
+	users, err := db.GetShardUsers(shard)
+	if err != nil {
+		return fmt.Errorf("获取分片用户失败: %v", err)
+	}
+	if len(users) == 0 {
+		return nil
+	}
+	excluded, err := db.GetExcludedSet(c.policy, task.ChainName)
+	if err != nil {
+		return fmt.Errorf("获取排除地址失败: %v", err)
+	}
+	if task.Season == "" {
+		if task.Season, err = db.GetSeasonAt(task.ChainName, task.PeriodStart); err != nil {
+			return fmt.Errorf("获取赛季失败: %v", err)
+		}
+	}
+	openings, err := db.GetShardOpeningBalances(shard, task.PeriodStart)
+	if err != nil {
+		return fmt.Errorf("获取期初余额失败: %v", err)
+	}
+	changes, err := db.GetShardBalanceChanges(shard, task.PeriodStart, task.PeriodEnd)
+	if err != nil {
+		return fmt.Errorf("获取余额变动失败: %v", err)
+	}
+	openingStakes, err := db.GetShardOpeningStakes(shard, task.PeriodStart)
+	if err != nil {
+		return fmt.Errorf("获取期初质押持仓失败: %v", err)
+	}
+	stakeChanges, err := db.GetShardStakeChanges(shard, task.PeriodStart, task.PeriodEnd)
+	if err != nil {
+		return fmt.Errorf("获取质押变动失败: %v", err)
+	}
+	applied, err := db.GetShardAppliedPeriods(shard, task.PeriodStart, task.PeriodEnd)
+	if err != nil {
+		return fmt.Errorf("获取已入账时间段失败: %v", err)
+	}
+	prices, err := LoadPrices(c.prices, task.ChainName, task.PeriodStart, task.PeriodEnd)
+	if err != nil {
+		return err
+	}
+	penalties, err := db.GetSybilPenalties(c.policy, task.ChainName)
+	if err != nil {
+		return fmt.Errorf("获取女巫聚类失败: %v", err)
+	}
+	engine, err := c.seasonEngine(task.Season)
+	if err != nil {
+		return err
+	}
+
+	// 2. 逐用户计算
+	var (
+		calcs   []db.PointsCalculation
+		empty   []db.PointsCalculation // 无积分可加的时间段，只记录历史并推进上次计算时间
+		skipped int
+	)
+	for _, u := range users {
+		key := db.AddrKey(u.UserAddress)
+		if excluded[key] {
+			skipped++
+			continue
+		}
+
+		for _, period := range subtractPeriods(db.TimePeriod{Start: task.PeriodStart, End: task.PeriodEnd}, applied[key]) {
+			sub := mq.NewPointsCalculationTask(task.ChainName, u.UserAddress, period.Start, period.End)
+			if !sub.PeriodEnd.After(sub.PeriodStart) {
+				continue
+			}
+			holdings := holdingsInPeriod(openings[key], changes[key], openingStakes[key], stakeChanges[key], sub.PeriodStart, sub.PeriodEnd)
+			holdings.Prices = prices
+			holdings.Sybil = penalties[key].ActiveAt(sub.PeriodStart)
+			points, ruleVersion, segments := ExplainPeriodPoints(engine, task.ChainName, u.UserAddress, holdings, sub.PeriodStart, sub.PeriodEnd)
+			calc := db.PointsCalculation{
+				ChainName:    task.ChainName,
+				UserAddress:  u.UserAddress,
+				PeriodStart:  sub.PeriodStart,
+				PeriodEnd:    sub.PeriodEnd,
+				PointsAdded:  points,
+				RuleVersion:  ruleVersion,
+				TaskID:       sub.TaskID,
+				EpochID:      task.EpochID,
+				Season:       task.Season,
+				Prices:       pricesUsed(segments),
+				CalculatedAt: time.Now(),
+			}
+			if c.persistSegments {
+				calc.Segments = segments
+			}
+			if points.Sign() <= 0 {
+				empty = append(empty, calc)
+				continue
+			}
+			calcs = append(calcs, calc)
+		}
+	}
+
+	// 3. 分组批量入账
+	credited, duplicates := 0, 0
+	for i := 0; i < len(calcs); i += c.batch.ChunkSize {
+		chunk := calcs[i:min(i+c.batch.ChunkSize, len(calcs))]
+		ok, dup, err := c.applyChunk(chunk)
+		if err != nil {
+			return err
+		}
+		credited += ok
+		duplicates += dup
+	}
+	for i := 0; i < len(empty); i += c.batch.ChunkSize {
+		if err := db.RecordEmptyPeriods(empty[i:min(i+c.batch.ChunkSize, len(empty))]); err != nil {
+			return fmt.Errorf("记录无积分时间段失败: %v", err)
+		}
+	}
+
+	c.log.Info("分片积分计算完成",
+		"task_id", task.TaskID,
+		"chain", task.ChainName,
+		"shard", fmt.Sprintf("%d/%d", task.Shard, task.Shards),
+		"epoch", task.EpochID,
+		"period", fmt.Sprintf("%s - %s", task.PeriodStart, task.PeriodEnd),
+		"users", len(users),
+		"excluded", skipped,
+		"credited", credited,
+		"duplicates", duplicates,
+		"elapsed", time.Since(started),
+	)
+	return nil
+}
+
+// 批量入账一组计算结果，返回入账与跳过的条数
+// 批次内存在并发写入的任务ID冲突时退回逐条入账
+func (c *PointsConsumer) applyChunk(chunk []db.PointsCalculation) (int, int, error) {
+	applied, skipped, err := db.ApplyPointsBatch(c.policy, chunk)
+	if err == nil {
+		return len(applied), len(skipped), nil
+	}
+	if !errors.Is(err, db.ErrPeriodApplied) {
+		return 0, 0, fmt.Errorf("批量入账失败: %v", err)
+	}
+
+	c.log.Warn("批量入账存在已入账任务，退回逐条入账", "count", len(chunk))
+	ok, dup := 0, 0
+	for _, calc := range chunk {
+		_, err := db.UpdateUserPoints(c.policy, calc)
+		if errors.Is(err, db.ErrPeriodApplied) || errors.Is(err, db.ErrPeriodOverlap) {
+			dup++
+			continue
+		}
+		if err != nil {
+			return ok, dup, fmt.Errorf("更新积分失败: %v", err)
+		}
+		ok++
+	}
+	return ok, dup, nil
+}
+
+// 从窗口期初持仓与窗口内变动（均按时间排序）截取[start, end]的持仓数据
+// 边界与逐用户查询一致：期初取start之前最后一次变动，周期内变动含两端
+func holdingsInPeriod(opening string, changes []db.BalanceChange, openingStake db.StakePosition, stakeChanges []db.StakeChange, start, end time.Time) PeriodHoldings {
+	h := PeriodHoldings{OpeningBalance: opening, OpeningStake: openingStake}
+	if h.OpeningBalance == "" {
+		h.OpeningBalance = "0"
+	}
+	if h.OpeningStake.Staked == "" {
+		h.OpeningStake = db.StakePosition{Staked: "0", Pending: "0"}
+	}
+
+	i := 0
+	for ; i < len(changes) && changes[i].EventTime.Before(start); i++ {
+		h.OpeningBalance = changes[i].BalanceAfter
+	}
+	j := i
+	for j < len(changes) && !changes[j].EventTime.After(end) {
+		j++
+	}
+	h.Changes = changes[i:j]
+
+	i = 0
+	for ; i < len(stakeChanges) && stakeChanges[i].EventTime.Before(start); i++ {
+		h.OpeningStake = db.StakePosition{Staked: stakeChanges[i].StakedAfter, Pending: stakeChanges[i].PendingAfter}
+	}
+	j = i
+	for j < len(stakeChanges) && !stakeChanges[j].EventTime.After(end) {
+		j++
+	}
+	h.StakeChanges = stakeChanges[i:j]
+	return h
+}
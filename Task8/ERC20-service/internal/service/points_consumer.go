@@ -6,28 +6,52 @@ import (
 	"erc20-service/config"
 	"erc20-service/internal/db"
 	"erc20-service/internal/mq"
+	"erc20-service/internal/pricing"
+	"erc20-service/internal/rules"
+	"erc20-service/pkg/decimal"
 	"erc20-service/pkg/logger"
+	"errors"
 	"fmt"
 	"log/slog"
 	"math/big"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/streadway/amqp"
 )
 
 // PointsConsumer 积分计算任务消费者
 type PointsConsumer struct {
-	conn  *mq.Connection
-	queue string
-	rate  float64
-	log   *slog.Logger
+	conn   *mq.Connection
+	queue  string
+	engine *rules.Engine
+	prices pricing.PriceSource // 为nil时按代币数量计算
+	policy *db.Policy
+	// 报价缺失或过期的任务转入的延迟队列
+	retryQueue string
+	// 赛季ID → 赛季使用的规则引擎（赛季的规则集创建后不变）
+	seasonMu      sync.Mutex
+	seasonEngines map[string]*rules.Engine
+	batch         config.PointsBatchConfig
+	// 随计算历史保存积分明细
+	persistSegments bool
+	log             *slog.Logger
 }
 
 // NewPointsConsumer 创建消费者
-func NewPointsConsumer(conn *mq.Connection, cfg config.RabbitMQConfig, rate float64) *PointsConsumer {
+func NewPointsConsumer(conn *mq.Connection, cfg config.RabbitMQConfig, engine *rules.Engine, prices pricing.PriceSource, points config.PointsConfig, policy *db.Policy) *PointsConsumer {
 	return &PointsConsumer{
-		conn:  conn,
-		queue: cfg.Queue,
-		rate:  rate,
-		log:   logger.New("points-consumer"),
+		conn:            conn,
+		queue:           cfg.Queue,
+		retryQueue:      cfg.RetryQueue,
+		seasonEngines:   make(map[string]*rules.Engine),
+		engine:          engine,
+		prices:          prices,
+		policy:          policy,
+		batch:           points.Batch,
+		persistSegments: points.PersistSegments,
+		log:             logger.New("points-consumer"),
 	}
 }
 
@@ -59,6 +83,27 @@ func (c *PointsConsumer) Start(ctx context.Context) error {
 				return fmt.Errorf("消息通道已关闭")
 			}
 
+			// 分片批量任务
+			if msg.Type == db.OutboxTopicPointsBatch {
+				var task mq.PointsBatchTask
+				if err := json.Unmarshal(msg.Body, &task); err != nil {
+					c.log.Warn("解析批量任务失败", "error", err)
+					msg.Nack(false, false)
+					continue
+				}
+				if err := c.calculateBatch(task); err != nil {
+					c.log.Error("分片积分计算失败",
+						"chain", task.ChainName,
+						"shard", task.Shard,
+						"error", err,
+					)
+					c.retry(msg, err) // 已入账的用户按任务ID去重
+					continue
+				}
+				msg.Ack(false)
+				continue
+			}
+
 			// 解析任务
 			var task mq.PointsCalculationTask
 			if err := json.Unmarshal(msg.Body, &task); err != nil {
@@ -74,7 +119,7 @@ func (c *PointsConsumer) Start(ctx context.Context) error {
 					"user", task.UserAddress,
 					"error", err,
 				)
-				msg.Nack(false, true) // 重新入队
+				c.retry(msg, err)
 				continue
 			}
 
@@ -84,116 +129,430 @@ func (c *PointsConsumer) Start(ctx context.Context) error {
 	}
 }
 
+// 处理失败的任务：报价缺失或过期要等价格源更新，立即重新入队只会反复失败，转入延迟队列稍后重试；
+// 其他错误（数据库故障、死锁等）重新入队
+func (c *PointsConsumer) retry(msg amqp.Delivery, cause error) {
+	if errors.Is(cause, pricing.ErrNoQuote) || errors.Is(cause, pricing.ErrStaleQuote) {
+		if err := c.conn.Delay(c.retryQueue, msg); err != nil {
+			c.log.Error("转入延迟队列失败，重新入队", "queue", c.retryQueue, "error", err)
+		} else {
+			msg.Ack(false)
+			return
+		}
+	}
+	msg.Nack(false, true)
+}
+
+// 赛季使用的规则引擎，按赛季ID缓存
+func (c *PointsConsumer) seasonEngine(seasonID string) (*rules.Engine, error) {
+	if seasonID == "" {
+		return c.engine, nil
+	}
+	c.seasonMu.Lock()
+	defer c.seasonMu.Unlock()
+	if engine, ok := c.seasonEngines[seasonID]; ok {
+		return engine, nil
+	}
+	engine, err := SeasonEngine(c.engine, seasonID)
+	if err != nil {
+		return nil, err
+	}
+	c.seasonEngines[seasonID] = engine
+	return engine, nil
+}
+
 // 计算用户积分
+// 任务按确定性ID幂等入账：已入账的时间段跳过，与已入账时间段部分重叠时只计算未覆盖的子时间段
 func (c *PointsConsumer) calculatePoints(task mq.PointsCalculationTask) error {
+	if task.TaskID == "" {
+		// 兼容旧版本生产的任务
+		task = mq.NewPointsCalculationTask(task.ChainName, task.UserAddress, task.PeriodStart, task.PeriodEnd)
+	}
 	c.log.Info("开始计算积分",
+		"task_id", task.TaskID,
 		"chain", task.ChainName,
 		"user", task.UserAddress,
 		"period", fmt.Sprintf("%s - %s", task.PeriodStart, task.PeriodEnd),
 	)
 
-	// 1. 获取时间段内的余额变动
-	changes, err := db.GetBalanceChangesInPeriod(
-		task.ChainName,
-		task.UserAddress,
-		task.PeriodStart,
-		task.PeriodEnd,
-	)
+	// 1. 排除地址不计积分（任务可能在地址加入排除列表前已生成）
+	excluded, err := db.IsExcludedAddress(c.policy, task.ChainName, task.UserAddress)
 	if err != nil {
-		return fmt.Errorf("获取余额变动失败: %v", err)
+		return fmt.Errorf("检查排除地址失败: %v", err)
+	}
+	if excluded {
+		c.log.Info("排除地址，跳过", "task_id", task.TaskID, "chain", task.ChainName, "user", task.UserAddress)
+		return nil
 	}
 
-	// 2. 计算积分
-	points := c.calculateFromChanges(changes, task.PeriodStart, task.PeriodEnd)
-	if points <= 0 {
-		c.log.Info("无积分可加", "chain", task.ChainName, "user", task.UserAddress)
+	// 未标记赛季的任务（旧版本任务、回溯任务）按周期开始时间查询所属赛季
+	if task.Season == "" {
+		if task.Season, err = db.GetSeasonAt(task.ChainName, task.PeriodStart); err != nil {
+			return fmt.Errorf("获取赛季失败: %v", err)
+		}
+	}
+
+	// 区块周期按区块区间整体计算
+	if task.IsBlockPeriod() {
+		return c.calculateBlockPeriod(task)
+	}
+
+	// 2. 扣除已入账的时间段
+	applied, err := db.GetAppliedPeriods(task.ChainName, task.UserAddress, task.PeriodStart, task.PeriodEnd)
+	if err != nil {
+		return fmt.Errorf("获取已入账时间段失败: %v", err)
+	}
+	pending := subtractPeriods(db.TimePeriod{Start: task.PeriodStart, End: task.PeriodEnd}, applied)
+	if len(pending) == 0 {
+		c.log.Info("时间段已入账，跳过", "task_id", task.TaskID, "chain", task.ChainName, "user", task.UserAddress)
 		return nil
 	}
+	if len(applied) > 0 {
+		c.log.Warn("任务与已入账时间段重叠，拆分计算",
+			"task_id", task.TaskID,
+			"chain", task.ChainName,
+			"user", task.UserAddress,
+			"pending", len(pending),
+		)
+	}
 
-	// 3. 更新用户总积分
-	currentTotal, err := db.GetUserTotalPoints(task.ChainName, task.UserAddress)
+	// 3. 逐个子时间段计算并入账
+	for _, period := range pending {
+		sub := task
+		if len(applied) > 0 {
+			sub = mq.NewPointsCalculationTask(task.ChainName, task.UserAddress, period.Start, period.End)
+			sub.EpochID = task.EpochID
+			sub.Season = task.Season
+		}
+		if err := c.applyPeriod(sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 计算单个时间段的积分并入账
+func (c *PointsConsumer) applyPeriod(task mq.PointsCalculationTask) error {
+	// 1. 获取期初持仓与时间段内的余额、质押变动
+	holdings, err := LoadPeriodHoldings(c.policy, task.ChainName, task.UserAddress, task.PeriodStart, task.PeriodEnd)
+	if err != nil {
+		return err
+	}
+	if holdings.Prices, err = LoadPrices(c.prices, task.ChainName, task.PeriodStart, task.PeriodEnd); err != nil {
+		return err
+	}
+
+	// 2. 计算积分
+	engine, err := c.seasonEngine(task.Season)
 	if err != nil {
-		return fmt.Errorf("获取当前积分失败: %v", err)
+		return err
 	}
+	points, ruleVersion, segments := ExplainPeriodPoints(engine, task.ChainName, task.UserAddress, holdings, task.PeriodStart, task.PeriodEnd)
 
-	newTotal := currentTotal + points
-	calc := db.PointsCalculation{
+	// 3. 入账
+	return c.credit(task, db.PointsCalculation{
 		ChainName:    task.ChainName,
 		UserAddress:  task.UserAddress,
 		PeriodStart:  task.PeriodStart,
 		PeriodEnd:    task.PeriodEnd,
 		PointsAdded:  points,
-		TotalPoints:  newTotal,
+		RuleVersion:  ruleVersion,
+		TaskID:       task.TaskID,
+		EpochID:      task.EpochID,
+		Season:       task.Season,
 		CalculatedAt: time.Now(),
+	}, segments)
+}
+
+// 入账单个时间段的计算结果（事务内累加总积分，重复或重叠的时间段被拒绝），积分为0时只记录历史
+func (c *PointsConsumer) credit(task mq.PointsCalculationTask, calc db.PointsCalculation, segments []db.PointsSegment) error {
+	calc.Prices = pricesUsed(segments)
+	if c.persistSegments {
+		calc.Segments = segments
+	}
+	points := calc.PointsAdded
+	if points.Sign() <= 0 {
+		// 记录积分为0的历史，周期覆盖检测不会将其视为缺口
+		if err := db.RecordEmptyPeriods([]db.PointsCalculation{calc}); err != nil {
+			return fmt.Errorf("记录无积分时间段失败: %v", err)
+		}
+		c.log.Info("无积分可加", "chain", task.ChainName, "user", task.UserAddress, "epoch", task.EpochID)
+		return nil
 	}
 
-	if err := db.UpdateUserPoints(calc); err != nil {
+	newTotal, err := db.UpdateUserPoints(c.policy, calc)
+	if errors.Is(err, db.ErrPeriodApplied) || errors.Is(err, db.ErrPeriodOverlap) {
+		// 并发消费者已入账，视为成功
+		c.log.Info("时间段已被其他任务入账，跳过",
+			"task_id", task.TaskID,
+			"chain", task.ChainName,
+			"user", task.UserAddress,
+			"reason", err,
+		)
+		return nil
+	}
+	if err != nil {
 		return fmt.Errorf("更新积分失败: %v", err)
 	}
 
 	c.log.Info("积分计算完成",
+		"task_id", task.TaskID,
 		"chain", task.ChainName,
 		"user", task.UserAddress,
-		"added", points,
-		"total", newTotal,
+		"added", points.String(),
+		"total", newTotal.String(),
+		"rule_version", calc.RuleVersion,
 	)
 	return nil
 }
 
-// 根据余额变动计算积分
-func (c *PointsConsumer) calculateFromChanges(changes []db.BalanceChange, start, end time.Time) float64 {
-	if len(changes) == 0 {
-		return 0
+// 从时间段中扣除已入账的时间段（按开始时间排序），返回剩余的子时间段
+func subtractPeriods(period db.TimePeriod, applied []db.TimePeriod) []db.TimePeriod {
+	var remaining []db.TimePeriod
+	cursor := period.Start
+	for _, a := range applied {
+		if a.Start.After(cursor) {
+			end := a.Start
+			if end.After(period.End) {
+				end = period.End
+			}
+			if end.After(cursor) {
+				remaining = append(remaining, db.TimePeriod{Start: cursor, End: end})
+			}
+		}
+		if a.End.After(cursor) {
+			cursor = a.End
+		}
+		if !cursor.Before(period.End) {
+			return remaining
+		}
 	}
+	return append(remaining, db.TimePeriod{Start: cursor, End: period.End})
+}
 
-	totalPoints := 0.0
-	prevTime := start
-	prevBalance := big.NewInt(0) // 初始余额
+// PeriodHoldings 单个周期的持仓数据：期初钱包余额与余额变动、期初质押持仓与质押变动
+// Prices 非空时按持仓USD价值计算，每个子段使用子段开始时刻生效的报价
+// Sybil 非空时地址所在的女巫聚类达到处理阈值，各子段积分乘以处理权重（0为不计积分）
+type PeriodHoldings struct {
+	OpeningBalance string
+	Changes        []db.BalanceChange
+	OpeningStake   db.StakePosition
+	StakeChanges   []db.StakeChange
+	Prices         []pricing.Quote
+	Sybil          *db.SybilPenalty
+}
 
-	totalDuration := end.Sub(start).Hours()
+// CalculatePeriodPoints 根据期初持仓与持仓变动计算单个周期的积分，不访问数据库，消费者与模拟共用
+// 周期内无变动时按期初持仓持有整个周期计算
+// 每个持仓不变的时间段由规则引擎定价（可能按规则集切换、活动起止再拆分），
+// 积分 = Σ 计息余额(代币单位) × 生效比率 × (持续时间/总周期)，全程使用 big.Rat 精确计算，最终按银行家舍入保留6位小数
+// 计息余额 = 钱包余额 + 待领取解押余额 + 质押锁定余额 × 质押倍数；按USD价值计算时再乘以子段开始时刻的代币价格
+// 返回积分与使用的规则集版本（多个版本以"+"连接）
+func CalculatePeriodPoints(engine *rules.Engine, chainName, userAddr string, holdings PeriodHoldings, start, end time.Time) (decimal.Decimal, string) {
+	points, ruleVersion, _ := ExplainPeriodPoints(engine, chainName, userAddr, holdings, start, end)
+	return points, ruleVersion
+}
+
+// ExplainPeriodPoints 与 CalculatePeriodPoints 相同，同时返回每个定价子段的积分明细
+// 子段积分各自舍入到6位小数，周期积分由精确值求和后舍入，两者之和可能相差舍入误差
+func ExplainPeriodPoints(engine *rules.Engine, chainName, userAddr string, holdings PeriodHoldings, start, end time.Time) (decimal.Decimal, string, []db.PointsSegment) {
+	totalDuration := end.Sub(start)
 	if totalDuration <= 0 {
-		return 0
+		return decimal.Zero(), "", nil
+	}
+
+	total := new(big.Rat)
+	var (
+		versions []string
+		segments []db.PointsSegment
+	)
+	for _, seg := range holdingSegments(holdings, start, end) {
+		for _, rated := range engine.Rate(chainName, userAddr, seg) {
+			points := rules.Points(rated, totalDuration)
+			segment := db.PointsSegment{
+				Start:       rated.Start,
+				End:         rated.End,
+				Balance:     rated.Balance.String(),
+				Staked:      stakedString(rated.Staked),
+				Weight:      ratText(rated.WeightTokens(), 18),
+				Rate:        ratText(rated.Rate, 12),
+				RuleVersion: rated.RuleVersion,
+				Rule:        rated.Rule,
+			}
+			applyPrice(holdings.Prices, points, &segment)
+			applySybil(holdings.Sybil, points, &segment)
+			segment.Points = decimal.FromRat(points).String()
+			total.Add(total, points)
+			if rated.RuleVersion != "" && (len(versions) == 0 || versions[len(versions)-1] != rated.RuleVersion) {
+				versions = append(versions, rated.RuleVersion)
+			}
+			segments = append(segments, segment)
+		}
+	}
+	return decimal.FromRat(total), strings.Join(versions, "+"), segments
+}
+
+// LoadPeriodHoldings 查询用户[start, end]的期初持仓与持仓变动，以及周期适用的女巫处理权重
+func LoadPeriodHoldings(policy *db.Policy, chainName, userAddr string, start, end time.Time) (PeriodHoldings, error) {
+	var h PeriodHoldings
+	var err error
+	if h.OpeningBalance, err = db.GetBalanceAt(chainName, userAddr, start); err != nil {
+		return h, fmt.Errorf("获取期初余额失败: %v", err)
+	}
+	if h.Changes, err = db.GetBalanceChangesInPeriod(chainName, userAddr, start, end); err != nil {
+		return h, fmt.Errorf("获取余额变动失败: %v", err)
+	}
+	if h.OpeningStake, err = db.GetStakeAt(chainName, userAddr, start); err != nil {
+		return h, fmt.Errorf("获取期初质押持仓失败: %v", err)
+	}
+	if h.StakeChanges, err = db.GetStakeChangesInPeriod(chainName, userAddr, start, end); err != nil {
+		return h, fmt.Errorf("获取质押变动失败: %v", err)
+	}
+	if h.Sybil, err = db.GetSybilPenalty(policy, chainName, userAddr, start); err != nil {
+		return h, fmt.Errorf("获取女巫聚类失败: %v", err)
+	}
+	return h, nil
+}
+
+// SeasonEngine 赛季绑定了规则集时返回固定使用该规则集的引擎，否则返回原引擎
+func SeasonEngine(engine *rules.Engine, seasonID string) (*rules.Engine, error) {
+	if seasonID == "" {
+		return engine, nil
+	}
+	s, err := db.GetSeason(seasonID)
+	if err != nil {
+		return nil, fmt.Errorf("获取赛季 %s 失败: %w", seasonID, err)
+	}
+	if s.RuleSet == "" {
+		return engine, nil
+	}
+	pinned, err := engine.Pinned(s.RuleSet)
+	if err != nil {
+		return nil, fmt.Errorf("赛季 %s: %v", seasonID, err)
 	}
+	return pinned, nil
+}
 
-	for _, change := range changes {
-		// 解析当前余额
-		currentBalance := new(big.Int)
-		currentBalance.SetString(change.BalanceAfter, 10)
+// LoadPrices 查询链在[start, end)内生效的报价，未配置价格源时返回nil（按代币数量计算）
+func LoadPrices(src pricing.PriceSource, chainName string, start, end time.Time) ([]pricing.Quote, error) {
+	if src == nil {
+		return nil, nil
+	}
+	quotes, err := src.Quotes(chainName, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("获取价格失败: %w", err)
+	}
+	return quotes, nil
+}
 
-		// 计算当前余额的持续时间
-		duration := change.EventTime.Sub(prevTime)
-		if duration <= 0 {
-			prevTime = change.EventTime
-			prevBalance = currentBalance
+// 按子段开始时刻生效的报价将积分换算为USD价值积分，并在明细中记录使用的报价
+// 未启用价格源时积分不变；报价已由 LoadPrices 校验覆盖整个周期，查不到时按价格0计
+func applyPrice(prices []pricing.Quote, points *big.Rat, segment *db.PointsSegment) {
+	if len(prices) == 0 {
+		return
+	}
+	q, ok := pricing.At(prices, segment.Start)
+	if !ok {
+		points.SetInt64(0)
+		segment.Price = "0"
+		return
+	}
+	points.Mul(points, q.Price)
+	segment.Price = q.PriceString()
+	segment.PriceAt = q.At
+	segment.PriceSource = q.Source
+}
+
+// 地址所在女巫聚类达到处理阈值时按处理权重降低子段积分，并在规则说明中注明
+func applySybil(penalty *db.SybilPenalty, points *big.Rat, segment *db.PointsSegment) {
+	if penalty == nil {
+		return
+	}
+	points.Mul(points, penalty.Weight)
+	segment.Rule = strings.TrimSpace(segment.Rule + " " + penalty.String())
+}
+
+// 汇总积分明细中使用的报价（按报价时间去重），随计算历史保存
+func pricesUsed(segments []db.PointsSegment) []db.PriceQuote {
+	var (
+		used []db.PriceQuote
+		seen = make(map[time.Time]bool)
+	)
+	for _, s := range segments {
+		if s.PriceAt.IsZero() || seen[s.PriceAt] {
 			continue
 		}
+		seen[s.PriceAt] = true
+		used = append(used, db.PriceQuote{At: s.PriceAt, Price: s.Price, Source: s.PriceSource})
+	}
+	return used
+}
 
-		// 计算积分：余额 × 0.05 × (持续时间/总周期)
-		// 限制余额精度避免溢出
-		balanceFloat := new(big.Float).SetInt(prevBalance)
-		balanceFloat.Quo(balanceFloat, big.NewFloat(1e18)) // 转换为 ETH 单位
-		balance, _ := balanceFloat.Float64()
+// 按时间合并余额变动与质押变动，切分为持仓不变的时间段
+// 质押的代币已从钱包转入质押合约，这里按质押持仓归属回质押者
+func holdingSegments(h PeriodHoldings, start, end time.Time) []rules.Segment {
+	wallet := parseWei(h.OpeningBalance)
+	staked := parseWei(h.OpeningStake.Staked)
+	pending := parseWei(h.OpeningStake.Pending)
 
-		periodRatio := duration.Hours() / totalDuration
-		points := balance * c.rate * periodRatio
-		totalPoints += points
+	var segments []rules.Segment
+	prevTime := start
+	// 记录截至t的持仓时间段
+	flush := func(t time.Time) {
+		if !t.After(prevTime) {
+			return
+		}
+		segments = append(segments, rules.Segment{
+			Start:   prevTime,
+			End:     t,
+			Balance: new(big.Int).Add(wallet, pending),
+			Staked:  staked,
+		})
+		prevTime = t
+	}
 
-		// 更新状态
-		prevTime = change.EventTime
-		prevBalance = currentBalance
+	i, j := 0, 0
+	for i < len(h.Changes) || j < len(h.StakeChanges) {
+		// 同一时刻先处理余额变动
+		if j >= len(h.StakeChanges) || (i < len(h.Changes) && !h.Changes[i].EventTime.After(h.StakeChanges[j].EventTime)) {
+			flush(h.Changes[i].EventTime)
+			wallet = parseWei(h.Changes[i].BalanceAfter)
+			i++
+			continue
+		}
+		flush(h.StakeChanges[j].EventTime)
+		staked = parseWei(h.StakeChanges[j].StakedAfter)
+		pending = parseWei(h.StakeChanges[j].PendingAfter)
+		j++
 	}
 
 	// 处理最后一段周期
-	lastDuration := end.Sub(prevTime).Hours()
-	if lastDuration > 0 {
-		balanceFloat := new(big.Float).SetInt(prevBalance)
-		balanceFloat.Quo(balanceFloat, big.NewFloat(1e18)) // 转换为 ETH 单位
-		balance, _ := balanceFloat.Float64()
+	flush(end)
+	return segments
+}
+
+// 质押余额为空或0时不输出
+func stakedString(staked *big.Int) string {
+	if staked == nil || staked.Sign() == 0 {
+		return ""
+	}
+	return staked.String()
+}
 
-		periodRatio := lastDuration / totalDuration
-		points := balance * c.rate * periodRatio
-		totalPoints += points
+// 有理数按prec位小数输出并去掉末尾的0
+func ratText(r *big.Rat, prec int) string {
+	s := r.FloatString(prec)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
 	}
+	return s
+}
 
-	return totalPoints
+// 解析最小单位数量，无效时为0
+func parseWei(s string) *big.Int {
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return big.NewInt(0)
+	}
+	return v
 }
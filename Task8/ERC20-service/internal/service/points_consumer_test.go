@@ -0,0 +1,236 @@
+package service
+
+import (
+	"erc20-service/config"
+	"erc20-service/internal/db"
+	"erc20-service/internal/rules"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// 测试周期为 [p0, p0+60分钟)
+var p0 = time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC)
+
+// 周期开始后第min分钟
+func at(min int) time.Time {
+	return p0.Add(time.Duration(min) * time.Minute)
+}
+
+// n个代币的最小单位数量
+func tokens(n int64) string {
+	wei := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	return wei.Mul(wei, big.NewInt(n)).String()
+}
+
+func testEngine(t *testing.T) *rules.Engine {
+	t.Helper()
+	e, err := rules.NewEngine(config.PointsConfig{Rate: 0.1, StakingMultiplier: 2})
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+	return e
+}
+
+func TestExplainPeriodPoints(t *testing.T) {
+	engine := testEngine(t)
+	tests := []struct {
+		name     string
+		opening  int64
+		changes  []db.BalanceChange
+		want     string
+		segments int
+	}{
+		{"周期内无变动按期初余额持有整个周期", 10, nil, "1.000000", 1},
+		{"期初余额为0且无变动", 0, nil, "0.000000", 1},
+		{
+			name:    "变动恰在周期开始时刻",
+			opening: 10,
+			changes: []db.BalanceChange{{EventTime: at(0), BalanceAfter: tokens(20)}},
+			want:    "2.000000", segments: 1,
+		},
+		{
+			name:    "变动恰在周期结束时刻",
+			opening: 10,
+			changes: []db.BalanceChange{{EventTime: at(60), BalanceAfter: tokens(20)}},
+			want:    "1.000000", segments: 1,
+		},
+		{
+			// 10 × 0.1 × 1/4 + 30 × 0.1 × 3/4
+			name:    "周期内变动按持有时长加权",
+			opening: 10,
+			changes: []db.BalanceChange{{EventTime: at(15), BalanceAfter: tokens(30)}},
+			want:    "2.500000", segments: 2,
+		},
+		{
+			// 10 × 0.1 × 1/2 + 0 + 5 × 0.1 × 1/4
+			name:    "多次变动",
+			opening: 10,
+			changes: []db.BalanceChange{
+				{EventTime: at(30), BalanceAfter: "0"},
+				{EventTime: at(45), BalanceAfter: tokens(5)},
+			},
+			want: "0.625000", segments: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := PeriodHoldings{OpeningBalance: tokens(tt.opening), Changes: tt.changes}
+			points, version, segments := ExplainPeriodPoints(engine, "sepolia", "0xabc", h, at(0), at(60))
+			if points.String() != tt.want {
+				t.Errorf("积分 = %s, want %s", points, tt.want)
+			}
+			if version != rules.DefaultVersion {
+				t.Errorf("规则集版本 = %q, want %q", version, rules.DefaultVersion)
+			}
+			if len(segments) != tt.segments {
+				t.Fatalf("明细子段数 = %d, want %d", len(segments), tt.segments)
+			}
+			// 子段首尾相接覆盖整个周期
+			cursor := at(0)
+			for i, s := range segments {
+				if !s.Start.Equal(cursor) || !s.End.After(s.Start) {
+					t.Errorf("子段%d = [%s, %s), 应从 %s 开始", i, s.Start, s.End, cursor)
+				}
+				cursor = s.End
+			}
+			if !cursor.Equal(at(60)) {
+				t.Errorf("子段结束于 %s, want %s", cursor, at(60))
+			}
+			if got, _ := CalculatePeriodPoints(engine, "sepolia", "0xabc", h, at(0), at(60)); got.Cmp(points) != 0 {
+				t.Errorf("CalculatePeriodPoints = %s, want %s", got, points)
+			}
+		})
+	}
+}
+
+func TestExplainPeriodPointsEmptyPeriod(t *testing.T) {
+	points, version, segments := ExplainPeriodPoints(testEngine(t), "sepolia", "0xabc",
+		PeriodHoldings{OpeningBalance: tokens(10)}, at(60), at(60))
+	if points.Sign() != 0 || version != "" || segments != nil {
+		t.Errorf("空周期 = %s %q %v, want 0", points, version, segments)
+	}
+}
+
+func TestSubtractPeriods(t *testing.T) {
+	span := func(start, end int) db.TimePeriod { return db.TimePeriod{Start: at(start), End: at(end)} }
+	tests := []struct {
+		name    string
+		applied []db.TimePeriod
+		want    []db.TimePeriod
+	}{
+		{"无已入账时间段", nil, []db.TimePeriod{span(0, 60)}},
+		{"整个周期已入账", []db.TimePeriod{span(0, 60)}, nil},
+		{"已入账时间段覆盖周期之外", []db.TimePeriod{span(-30, 90)}, nil},
+		{"已入账时间段恰在周期开始", []db.TimePeriod{span(0, 20)}, []db.TimePeriod{span(20, 60)}},
+		{"已入账时间段恰在周期结束", []db.TimePeriod{span(40, 60)}, []db.TimePeriod{span(0, 40)}},
+		{"周期中间已入账", []db.TimePeriod{span(20, 40)}, []db.TimePeriod{span(0, 20), span(40, 60)}},
+		{"首尾相接的已入账时间段", []db.TimePeriod{span(10, 20), span(20, 30)}, []db.TimePeriod{span(0, 10), span(30, 60)}},
+		{"重叠的已入账时间段", []db.TimePeriod{span(10, 30), span(20, 40)}, []db.TimePeriod{span(0, 10), span(40, 60)}},
+		{"被包含的已入账时间段", []db.TimePeriod{span(10, 50), span(20, 30)}, []db.TimePeriod{span(0, 10), span(50, 60)}},
+		{"已入账时间段在周期之前", []db.TimePeriod{span(-20, -10)}, []db.TimePeriod{span(0, 60)}},
+		{"已入账时间段在周期之后", []db.TimePeriod{span(70, 80)}, []db.TimePeriod{span(0, 60)}},
+		{"跨越周期开始与结束", []db.TimePeriod{span(-10, 10), span(50, 70)}, []db.TimePeriod{span(10, 50)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := subtractPeriods(span(0, 60), tt.applied)
+			if len(got) != len(tt.want) {
+				t.Fatalf("剩余时间段 = %v, want %v", got, tt.want)
+			}
+			for i, w := range tt.want {
+				if !got[i].Start.Equal(w.Start) || !got[i].End.Equal(w.End) {
+					t.Errorf("时间段%d = [%s, %s), want [%s, %s)", i, got[i].Start, got[i].End, w.Start, w.End)
+				}
+			}
+		})
+	}
+}
+
+func TestHoldingSegments(t *testing.T) {
+	type seg struct {
+		start, end      int
+		balance, staked int64
+	}
+	tests := []struct {
+		name string
+		h    PeriodHoldings
+		want []seg
+	}{
+		{
+			name: "无变动",
+			h:    PeriodHoldings{OpeningBalance: tokens(10), OpeningStake: db.StakePosition{Staked: tokens(5)}},
+			want: []seg{{0, 60, 10, 5}},
+		},
+		{
+			name: "质押变动恰在周期开始",
+			h: PeriodHoldings{
+				OpeningBalance: tokens(10),
+				StakeChanges:   []db.StakeChange{{EventTime: at(0), StakedAfter: tokens(5), PendingAfter: "0"}},
+			},
+			want: []seg{{0, 60, 10, 5}},
+		},
+		{
+			name: "质押变动恰在周期结束",
+			h: PeriodHoldings{
+				OpeningBalance: tokens(10),
+				StakeChanges:   []db.StakeChange{{EventTime: at(60), StakedAfter: tokens(5), PendingAfter: "0"}},
+			},
+			want: []seg{{0, 60, 10, 0}},
+		},
+		{
+			// 质押时代币从钱包转入质押合约，两条变动同一时刻，先处理余额变动
+			name: "周期中间质押",
+			h: PeriodHoldings{
+				OpeningBalance: tokens(10),
+				Changes:        []db.BalanceChange{{EventTime: at(20), BalanceAfter: tokens(4)}},
+				StakeChanges:   []db.StakeChange{{EventTime: at(20), StakedAfter: tokens(6), PendingAfter: "0"}},
+			},
+			want: []seg{{0, 20, 10, 0}, {20, 60, 4, 6}},
+		},
+		{
+			// 申请解押后待领取余额计入余额，领取后回到钱包
+			name: "周期中间申请解押并领取",
+			h: PeriodHoldings{
+				OpeningStake: db.StakePosition{Staked: tokens(8), Pending: "0"},
+				Changes:      []db.BalanceChange{{EventTime: at(40), BalanceAfter: tokens(8)}},
+				StakeChanges: []db.StakeChange{
+					{EventTime: at(15), StakedAfter: tokens(3), PendingAfter: tokens(5)},
+					{EventTime: at(40), StakedAfter: tokens(3), PendingAfter: "0"},
+				},
+			},
+			want: []seg{{0, 15, 0, 8}, {15, 40, 5, 3}, {40, 60, 8, 3}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := holdingSegments(tt.h, at(0), at(60))
+			if len(got) != len(tt.want) {
+				t.Fatalf("持仓时间段数 = %d, want %d", len(got), len(tt.want))
+			}
+			for i, w := range tt.want {
+				g := got[i]
+				if !g.Start.Equal(at(w.start)) || !g.End.Equal(at(w.end)) ||
+					g.Balance.String() != tokens(w.balance) || g.Staked.String() != tokens(w.staked) {
+					t.Errorf("时间段%d = [%s, %s) 余额%s 质押%s, want [%s, %s) 余额%s 质押%s", i,
+						g.Start, g.End, g.Balance, g.Staked, at(w.start), at(w.end), tokens(w.balance), tokens(w.staked))
+				}
+			}
+		})
+	}
+}
+
+func TestExplainPeriodPointsStaking(t *testing.T) {
+	// 质押倍数2：8 × 2 × 0.1 × 1/4 + (5 + 3 × 2) × 0.1 × 3/4
+	h := PeriodHoldings{
+		OpeningStake: db.StakePosition{Staked: tokens(8), Pending: "0"},
+		StakeChanges: []db.StakeChange{{EventTime: at(15), StakedAfter: tokens(3), PendingAfter: tokens(5)}},
+	}
+	points, _, segments := ExplainPeriodPoints(testEngine(t), "sepolia", "0xabc", h, at(0), at(60))
+	if points.String() != "1.225000" {
+		t.Errorf("积分 = %s, want 1.225000", points)
+	}
+	if len(segments) != 2 || segments[0].Staked != tokens(8) || segments[1].Staked != tokens(3) {
+		t.Errorf("明细 = %+v, 质押余额应为 %s、%s", segments, tokens(8), tokens(3))
+	}
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"erc20-service/config"
+	"erc20-service/internal/db"
+	"erc20-service/internal/rules"
+	"erc20-service/pkg/logger"
+	"log/slog"
+	"math/big"
+	"time"
+)
+
+// 策略执行间隔，到期与衰减均以天、月为粒度，无需每轮调度执行
+const policyRunInterval = time.Hour
+
+// PointsPolicy 积分到期与衰减策略执行器
+// 到期：批次获得时间早于有效期的剩余积分写入expire流水；
+// 衰减：超过inactive_days未获得积分的账户每个自然月（UTC）按比例写入一次decay流水
+type PointsPolicy struct {
+	cfg     config.PointsPolicyConfig
+	points  *db.Policy
+	decay   *big.Rat
+	lastRun time.Time
+	log     *slog.Logger
+}
+
+// NewPointsPolicy 创建策略执行器
+func NewPointsPolicy(cfg config.PointsPolicyConfig, points *db.Policy) *PointsPolicy {
+	return &PointsPolicy{
+		cfg:    cfg,
+		points: points,
+		decay:  rules.RatFromFloat(cfg.DecayPercent),
+		log:    logger.New("points-policy"),
+	}
+}
+
+// Apply 执行到期与衰减，距上次执行不足一小时时跳过
+func (p *PointsPolicy) Apply(now time.Time) {
+	if p.cfg.ExpiryDays <= 0 && p.cfg.DecayPercent <= 0 {
+		return
+	}
+	if now.Sub(p.lastRun) < policyRunInterval {
+		return
+	}
+	p.lastRun = now
+
+	if p.cfg.ExpiryDays > 0 {
+		p.expire(now)
+	}
+	if p.cfg.DecayPercent > 0 {
+		p.applyDecay(now)
+	}
+}
+
+// 积分到期
+func (p *PointsPolicy) expire(now time.Time) {
+	cutoff := now.AddDate(0, 0, -p.cfg.ExpiryDays)
+	users, err := db.GetUsersWithExpiredLots(cutoff)
+	if err != nil {
+		p.log.Error("查询到期积分失败", "error", err)
+		return
+	}
+	for _, u := range users {
+		expired, err := db.ExpirePoints(p.points, u.ChainName, u.UserAddress, cutoff)
+		if err != nil {
+			p.log.Error("积分到期处理失败", "chain", u.ChainName, "user", u.UserAddress, "error", err)
+			continue
+		}
+		if expired.Sign() > 0 {
+			p.log.Info("积分已到期", "chain", u.ChainName, "user", u.UserAddress, "expired", expired.String())
+		}
+	}
+}
+
+// 不活跃账户按月衰减
+func (p *PointsPolicy) applyDecay(now time.Time) {
+	reference := "decay:" + now.UTC().Format("2006-01")
+	activeSince := now.AddDate(0, 0, -p.cfg.InactiveDays)
+	users, err := db.GetDecayCandidates(activeSince, reference)
+	if err != nil {
+		p.log.Error("查询不活跃账户失败", "error", err)
+		return
+	}
+	for _, u := range users {
+		decayed, err := db.DecayPoints(p.points, u.ChainName, u.UserAddress, p.decay, reference)
+		if err != nil {
+			p.log.Error("积分衰减失败", "chain", u.ChainName, "user", u.UserAddress, "error", err)
+			continue
+		}
+		if decayed.Sign() > 0 {
+			p.log.Info("不活跃账户积分已衰减", "chain", u.ChainName, "user", u.UserAddress, "decayed", decayed.String(), "period", reference)
+		}
+	}
+}
@@ -2,7 +2,9 @@ package service
 
 import (
 	"context"
+	"erc20-service/config"
 	"erc20-service/internal/db"
+	"erc20-service/internal/epoch"
 	"erc20-service/internal/mq"
 	"erc20-service/pkg/logger"
 	"fmt"
@@ -11,41 +13,61 @@ import (
 )
 
 // Scheduler 积分计算定时调度器
+// 任务写入发件箱表，由OutboxRelay投递到MQ，避免MQ故障时任务丢失
+// 积分周期按UTC对齐到调度间隔的整数倍，每个周期结束后调度该周期，重启或重复调度生成相同的任务
+// 区块模式的链按区块区间划分周期，边界区块的链上时间记录后即可调度
 type Scheduler struct {
-	interval int // 调度间隔（分钟）
-	chains   []string
-	producer *mq.PointsProducer
-	log      *slog.Logger
+	interval    int // 调度间隔（分钟）
+	clock       epoch.Clock
+	chains      []string
+	blockClocks map[string]epoch.BlockClock // 区块模式链的周期划分
+	batch       config.PointsBatchConfig
+	points      *db.Policy // 排除地址等积分策略
+	policy      *PointsPolicy
+	sybil       *SybilDetector
+	log         *slog.Logger
 }
 
 // NewScheduler 创建调度器
-func NewScheduler(interval int, chains []string, producer *mq.PointsProducer) *Scheduler {
-	return &Scheduler{
-		interval: interval,
-		chains:   chains,
-		producer: producer,
-		log:      logger.New("scheduler"),
+func NewScheduler(cfg config.PointsConfig, chains []config.ChainConfig, points *db.Policy) *Scheduler {
+	s := &Scheduler{
+		interval:    cfg.Interval,
+		clock:       epoch.NewClock(time.Duration(cfg.Interval) * time.Minute),
+		blockClocks: make(map[string]epoch.BlockClock),
+		batch:       cfg.Batch,
+		points:      points,
+		policy:      NewPointsPolicy(cfg.Policy, points),
+		log:         logger.New("scheduler"),
 	}
+	for _, c := range chains {
+		s.chains = append(s.chains, c.Name)
+		if c.BlockMode() {
+			s.blockClocks[c.Name] = epoch.NewBlockClock(c.Points.StartBlock, c.Points.EndBlock, c.Points.BlocksPerPeriod)
+		}
+	}
+	s.sybil = NewSybilDetector(cfg.Sybil, points, s.chains)
+	return s
 }
 
 // Start 启动调度器
 func (s *Scheduler) Start(ctx context.Context) {
-	s.log.Info("启动积分计算调度器", "interval", s.interval, "unit", "minutes")
+	s.log.Info("启动积分计算调度器", "interval", s.interval, "unit", "minutes", "batch", s.batch.Enabled)
 
-	// 立即执行一次
+	// 立即执行一次（调度最近一个已结束的周期）
 	s.scheduleAllChains()
 
-	// 定时执行
-	ticker := time.NewTicker(time.Duration(s.interval) * time.Minute)
-	defer ticker.Stop()
+	// 在每个周期边界执行
+	timer := time.NewTimer(time.Until(s.clock.Next(time.Now())))
+	defer timer.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			s.log.Info("调度器已停止")
 			return
-		case <-ticker.C:
+		case <-timer.C:
 			s.scheduleAllChains()
+			timer.Reset(time.Until(s.clock.Next(time.Now())))
 		}
 	}
 }
@@ -55,28 +77,55 @@ func (s *Scheduler) scheduleAllChains() {
 	s.log.Info("开始调度积分计算任务")
 
 	for _, chain := range s.chains {
-		if err := s.scheduleChain(chain); err != nil {
+		schedule := s.scheduleChain
+		if _, ok := s.blockClocks[chain]; ok {
+			schedule = s.scheduleChainBlocks
+		} else if s.batch.Enabled {
+			schedule = s.scheduleChainBatch
+		}
+		if err := schedule(chain); err != nil {
 			s.log.Error("调度链积分任务失败", "chain", chain, "error", err)
 		}
 	}
+
+	s.policy.Apply(time.Now())
+	s.sybil.Apply(time.Now())
+	s.refreshLeaderboards()
+}
+
+// 刷新各链榜单与跨链总榜
+// 本轮任务由消费者异步入账，榜单反映截至上一轮已入账的积分
+func (s *Scheduler) refreshLeaderboards() {
+	now := time.Now()
+	boards := append(append([]string{}, s.chains...), db.LeaderboardGlobal)
+	for _, board := range boards {
+		n, err := db.RefreshLeaderboard(board, now)
+		if err != nil {
+			s.log.Error("刷新排行榜失败", "board", board, "error", err)
+			continue
+		}
+		s.log.Debug("排行榜已刷新", "board", board, "ranked", n)
+	}
 }
 
 // 为单个链调度积分计算任务
 func (s *Scheduler) scheduleChain(chainName string) error {
-	// 获取链上所有用户
-	users, err := db.GetUsersByChain(chainName)
+	// 获取链上参与积分的用户（剔除配置与数据库中的排除地址）
+	users, err := db.GetEligibleUsersByChain(s.points, chainName)
 	if err != nil {
 		return fmt.Errorf("获取用户列表失败: %v", err)
 	}
 
-	s.log.Info("调度积分计算任务", "chain", chainName, "user_count", len(users))
-
-	// 计算周期
-	now := time.Now()
-	periodEnd := now
-	periodStart := now.Add(-time.Duration(s.interval) * time.Minute)
+	// 最近一个已结束的周期
+	current := s.clock.LastCompleted(time.Now())
+	s.log.Info("调度积分计算任务", "chain", chainName, "user_count", len(users), "epoch", current.String())
+	seasons, err := db.ListSeasons()
+	if err != nil {
+		return fmt.Errorf("获取赛季失败: %v", err)
+	}
 
 	// 为每个用户创建任务
+	var tasks []any
 	for _, user := range users {
 		// 获取用户上次计算时间
 		lastCalc, err := db.GetUserLastCalculatedTime(chainName, user)
@@ -84,129 +133,159 @@ func (s *Scheduler) scheduleChain(chainName string) error {
 			s.log.Warn("获取用户上次计算时间失败", "user", user, "error", err)
 			continue
 		}
-
-		// 检查是否需要回溯计算
-		hoursSinceLastCalc := now.Sub(lastCalc).Hours()
-
-		// 智能检测积分缺失：
-		// 1. 如果上次计算时间早于当前周期开始时间，说明有积分缺失
-		// 2. 如果超过正常计算间隔的2倍时间未计算，也认为有缺失
-		normalInterval := time.Duration(s.interval) * time.Minute
-		expectedLastCalc := now.Add(-normalInterval)
-
-		needsBackfill := lastCalc.Before(periodStart) || lastCalc.Before(expectedLastCalc)
-
-		if needsBackfill {
-			s.log.Warn("检测到积分计算缺失，启动回溯",
-				"user", user,
-				"last_calc", lastCalc,
-				"period_start", periodStart,
-				"expected_last_calc", expectedLastCalc,
-				"hours_behind", hoursSinceLastCalc)
-
-			// 执行回溯计算（从上次计算时间到当前周期开始）
-			backfillEnd := periodStart
-			if err := s.backfillUserPoints(chainName, user, lastCalc, backfillEnd); err != nil {
-				s.log.Error("回溯计算失败", "user", user, "error", err)
-				// 即使回溯失败，也继续正常计算当前周期
-			}
+		if !lastCalc.Before(current.End) {
+			continue
 		}
 
-		// 确定实际计算周期
-		actualStart := lastCalc
-		if actualStart.Before(periodStart) {
-			actualStart = periodStart
+		// 上次计算时间早于当前周期：检查其间各周期的覆盖情况，补齐缺失的周期
+		if lastCalc.Before(current.Start) {
+			tasks = append(tasks, s.backfillUserEpochs(chainName, user, s.clock.ID(lastCalc), current.ID-1, seasons)...)
 		}
 
-		// 发布任务
-		task := mq.PointsCalculationTask{
-			ChainName:   chainName,
-			UserAddress: user,
-			PeriodStart: actualStart,
-			PeriodEnd:   periodEnd,
-		}
+		task := mq.NewEpochTask(chainName, user, current)
+		task.Season = db.SeasonAt(seasons, chainName, current.Start)
+		tasks = append(tasks, task)
+	}
 
-		if err := s.producer.Publish(task); err != nil {
-			s.log.Warn("发布任务失败", "user", user, "error", err)
-		}
+	// 本轮任务在同一事务中写入发件箱
+	if err := db.EnqueueOutbox(db.OutboxTopicPointsTask, tasks...); err != nil {
+		return fmt.Errorf("写入发件箱失败: %v", err)
 	}
+	s.log.Info("积分任务已写入发件箱", "chain", chainName, "epoch", current.ID, "task_count", len(tasks))
 
 	return nil
 }
 
-// 回溯计算用户积分
-func (s *Scheduler) backfillUserPoints(chainName, userAddr string, startTime, endTime time.Time) error {
-	s.log.Info("开始回溯计算用户积分", "chain", chainName, "user", userAddr, "start", startTime, "end", endTime)
-
-	// 根据时间跨度选择合适的分割粒度
-	duration := endTime.Sub(startTime)
-	var splitDuration time.Duration
-
-	if duration <= time.Hour {
-		// 小于1小时，按15分钟分割
-		splitDuration = 15 * time.Minute
-	} else if duration <= 24*time.Hour {
-		// 小于24小时，按1小时分割
-		splitDuration = time.Hour
-	} else {
-		// 大于24小时，按6小时分割
-		splitDuration = 6 * time.Hour
+// 按区块周期调度单个链：为每个用户生成上次计算之后已结束的区块周期任务
+// 已有积分记录的用户从上次计算时间之后的周期开始，新用户从首次余额变动所在的周期开始
+func (s *Scheduler) scheduleChainBlocks(chainName string) error {
+	clock := s.blockClocks[chainName]
+	boundaries, err := db.GetBlockBoundaries(chainName, 0)
+	if err != nil {
+		return fmt.Errorf("获取区块周期边界失败: %v", err)
+	}
+	periods := clock.Completed(boundaries)
+	if len(periods) == 0 {
+		s.log.Info("暂无已结束的区块周期", "chain", chainName)
+		return nil
 	}
 
-	periods := s.splitTimeRange(startTime, endTime, splitDuration)
-	taskCount := 0
+	users, err := db.GetEligibleUsersByChain(s.points, chainName)
+	if err != nil {
+		return fmt.Errorf("获取用户列表失败: %v", err)
+	}
+	lastCalc, err := db.GetLastCalculatedTimes(chainName)
+	if err != nil {
+		return fmt.Errorf("获取上次计算时间失败: %v", err)
+	}
+	firstBlocks, err := db.GetFirstChangeBlocks(chainName)
+	if err != nil {
+		return fmt.Errorf("获取首次变动区块失败: %v", err)
+	}
+	seasons, err := db.ListSeasons()
+	if err != nil {
+		return fmt.Errorf("获取赛季失败: %v", err)
+	}
 
-	for _, period := range periods {
-		// 检查该时间段是否已经计算过
-		hasCalculated, err := db.HasPointsCalculated(chainName, userAddr, period.Start, period.End)
-		if err != nil {
-			s.log.Warn("检查计算状态失败", "user", userAddr, "period", period, "error", err)
-			continue
+	var tasks []any
+	for _, user := range users {
+		key := db.AddrKey(user)
+		last, calculated := lastCalc[key]
+		first, held := firstBlocks[key]
+		for _, p := range periods {
+			if calculated && !p.End.After(last) {
+				continue
+			}
+			if !calculated && (!held || p.EndBlock <= first) {
+				continue
+			}
+			task := mq.NewBlockPeriodTask(chainName, user, p)
+			task.Season = db.SeasonAt(seasons, chainName, p.Start)
+			tasks = append(tasks, task)
 		}
+	}
 
-		if hasCalculated {
-			s.log.Debug("时间段已计算", "user", userAddr, "period", period)
-			continue
-		}
+	if err := db.EnqueueOutbox(db.OutboxTopicPointsTask, tasks...); err != nil {
+		return fmt.Errorf("写入发件箱失败: %v", err)
+	}
+	s.log.Info("区块周期积分任务已写入发件箱",
+		"chain", chainName,
+		"user_count", len(users),
+		"period", periods[len(periods)-1].String(),
+		"task_count", len(tasks))
+	return nil
+}
 
-		// 发布计算任务
-		task := mq.PointsCalculationTask{
-			ChainName:   chainName,
-			UserAddress: userAddr,
-			PeriodStart: period.Start,
-			PeriodEnd:   period.End,
-		}
+// 以分片批量任务调度单个链：每个周期只写入shards条任务，不逐用户查询上次计算时间
+// 上次计算时间早于前一个周期的用户（如消费者长时间停止）仍按用户生成回溯任务，正常情况下为空
+func (s *Scheduler) scheduleChainBatch(chainName string) error {
+	current := s.clock.LastCompleted(time.Now())
 
-		if err := s.producer.Publish(task); err != nil {
-			s.log.Warn("发布回溯任务失败", "user", userAddr, "period", period, "error", err)
+	// 前一个周期的分片任务可能仍在处理，留出一个周期再判定滞后
+	lagging, err := db.GetLaggingUsers(chainName, s.clock.Epoch(current.ID-1).Start)
+	if err != nil {
+		return fmt.Errorf("获取滞后用户失败: %v", err)
+	}
+	excluded, err := db.GetExcludedSet(s.points, chainName)
+	if err != nil {
+		return fmt.Errorf("获取排除地址失败: %v", err)
+	}
+	seasons, err := db.ListSeasons()
+	if err != nil {
+		return fmt.Errorf("获取赛季失败: %v", err)
+	}
+	var backfill []any
+	for _, u := range lagging {
+		if excluded[db.AddrKey(u.UserAddress)] {
 			continue
 		}
-
-		taskCount++
+		backfill = append(backfill, s.backfillUserEpochs(chainName, u.UserAddress, s.clock.ID(u.LastCalculatedAt.Time), current.ID-1, seasons)...)
+	}
+	if err := db.EnqueueOutbox(db.OutboxTopicPointsTask, backfill...); err != nil {
+		return fmt.Errorf("写入发件箱失败: %v", err)
 	}
 
-	s.log.Info("回溯任务发布完成", "user", userAddr, "task_count", taskCount, "duration", duration)
+	tasks := make([]any, 0, s.batch.Shards)
+	season := db.SeasonAt(seasons, chainName, current.Start)
+	for shard := 0; shard < s.batch.Shards; shard++ {
+		task := mq.NewPointsBatchTask(chainName, shard, s.batch.Shards, current)
+		task.Season = season
+		tasks = append(tasks, task)
+	}
+	if err := db.EnqueueOutbox(db.OutboxTopicPointsBatch, tasks...); err != nil {
+		return fmt.Errorf("写入发件箱失败: %v", err)
+	}
+	s.log.Info("分片积分任务已写入发件箱",
+		"chain", chainName,
+		"epoch", current.ID,
+		"shards", len(tasks),
+		"backfill_tasks", len(backfill))
 	return nil
 }
 
-// 分割时间范围
-func (s *Scheduler) splitTimeRange(start, end time.Time, duration time.Duration) []db.TimePeriod {
-	var periods []db.TimePeriod
-
-	current := start
-	for current.Before(end) {
-		periodEnd := current.Add(duration)
-		if periodEnd.After(end) {
-			periodEnd = end
-		}
-
-		periods = append(periods, db.TimePeriod{
-			Start: current,
-			End:   periodEnd,
-		})
-
-		current = periodEnd
+// 为用户在[fromID, toID]内缺失的周期生成回溯任务，任务按周期开始时间标记赛季
+func (s *Scheduler) backfillUserEpochs(chainName, userAddr string, fromID, toID int64, seasons []db.Season) []any {
+	missing, err := MissingEpochs(s.clock, chainName, userAddr, fromID, toID)
+	if err != nil {
+		s.log.Warn("检查周期覆盖失败", "chain", chainName, "user", userAddr, "error", err)
+		return nil
+	}
+	if len(missing) == 0 {
+		return nil
 	}
 
-	return periods
+	s.log.Warn("检测到积分周期缺失，启动回溯",
+		"chain", chainName,
+		"user", userAddr,
+		"missing", len(missing),
+		"first", missing[0].String(),
+		"last", missing[len(missing)-1].String())
+
+	tasks := make([]any, 0, len(missing))
+	for _, e := range missing {
+		task := mq.NewEpochTask(chainName, userAddr, e)
+		task.Season = db.SeasonAt(seasons, chainName, e.Start)
+		tasks = append(tasks, task)
+	}
+	return tasks
 }
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"erc20-service/config"
+	"erc20-service/internal/db"
+	"erc20-service/internal/sybil"
+	"erc20-service/pkg/logger"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// SybilDetector 女巫聚类定时分析，由调度器每轮调用，按interval_hours节流
+type SybilDetector struct {
+	cfg     config.SybilConfig
+	policy  *db.Policy
+	chains  []string
+	lastRun time.Time
+	log     *slog.Logger
+}
+
+// NewSybilDetector 创建女巫聚类分析器
+func NewSybilDetector(cfg config.SybilConfig, policy *db.Policy, chains []string) *SybilDetector {
+	return &SybilDetector{cfg: cfg, policy: policy, chains: chains, log: logger.New("sybil")}
+}
+
+// Apply 分析各链转账图并替换聚类结果，未配置interval_hours或距上次执行不足间隔时跳过
+func (d *SybilDetector) Apply(now time.Time) {
+	if d.cfg.IntervalHours <= 0 {
+		return
+	}
+	if now.Sub(d.lastRun) < time.Duration(d.cfg.IntervalHours)*time.Hour {
+		return
+	}
+	d.lastRun = now
+
+	for _, chain := range d.chains {
+		clusters, transfers, err := DetectSybil(d.policy, chain, d.cfg, now)
+		if err != nil {
+			d.log.Error("女巫聚类分析失败", "chain", chain, "error", err)
+			continue
+		}
+		if err := db.SaveSybilClusters(d.policy, chain, clusters, now); err != nil {
+			d.log.Error("保存女巫聚类失败", "chain", chain, "error", err)
+			continue
+		}
+		d.log.Info("女巫聚类分析完成", "chain", chain, "transfers", transfers, "clusters", len(clusters),
+			"flagged", CountFlagged(clusters, d.cfg.Threshold))
+	}
+}
+
+// DetectSybil 分析链上最近window_days天的转账，返回聚类与参与分析的转账数，不写入数据库
+// 排除地址（交易所、LP池、质押合约等）不参与分析，避免以其为中心把无关用户连成聚类
+func DetectSybil(policy *db.Policy, chainName string, cfg config.SybilConfig, now time.Time) ([]sybil.Cluster, int, error) {
+	transfers, err := db.GetTransferPairs(chainName, now.AddDate(0, 0, -cfg.WindowDays))
+	if err != nil {
+		return nil, 0, fmt.Errorf("获取转账记录失败: %v", err)
+	}
+	excluded, err := db.GetExcludedSet(policy, chainName)
+	if err != nil {
+		return nil, 0, fmt.Errorf("获取排除地址失败: %v", err)
+	}
+	clusters := sybil.Analyze(transfers, sybil.Params{
+		MinFanout:         cfg.MinFanout,
+		LockstepWindow:    time.Duration(cfg.LockstepMinutes) * time.Minute,
+		LockstepMinShared: cfg.LockstepMinShared,
+		MaxBucketSize:     cfg.MaxBucketSize,
+		MinClusterSize:    cfg.MinClusterSize,
+		Ignore:            excluded,
+	})
+	return clusters, len(transfers), nil
+}
+
+// CountFlagged 分数达到阈值的聚类数
+func CountFlagged(clusters []sybil.Cluster, threshold float64) int {
+	n := 0
+	for _, c := range clusters {
+		if c.Score >= threshold {
+			n++
+		}
+	}
+	return n
+}
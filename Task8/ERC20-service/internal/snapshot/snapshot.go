@@ -0,0 +1,516 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"erc20-service/internal/db"
+	"erc20-service/pkg/logger"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 快照格式
+const (
+	FormatName    = "erc20-tracker-snapshot"
+	FormatVersion = 1
+
+	manifestEntry = "manifest.json"
+	insertBatch   = 500
+)
+
+var log = logger.New("snapshot")
+
+// TableSpec 快照包含的表；Where 非空时只导出近期数据，其中每个占位符都绑定历史起点
+type TableSpec struct {
+	Name  string
+	Where string
+}
+
+// Tables 快照表清单，按恢复顺序排列
+var Tables = []TableSpec{
+	{Name: "chain_status"},
+	{Name: "block_boundaries"},
+	{Name: "excluded_addresses"},
+	{Name: "referrals"},
+	{Name: "sybil_clusters"},
+	{Name: "sybil_addresses"},
+	{Name: "seasons"},
+	{Name: "season_points"},
+	{Name: "season_snapshots"},
+	{Name: "user_balances"},
+	{Name: "user_stakes"},
+	{Name: "user_points"},
+	{Name: "points_ledger"},
+	{Name: "points_lots"},
+	{Name: "points_lot_usage"},
+	{Name: "user_points_aggregate"},
+	{Name: "points_history_daily"},
+	// 近期余额变动，另带每个用户在窗口之前的最后一条作为期初余额锚点
+	{Name: "balance_changes", Where: `event_time >= ? OR id IN (
+        SELECT anchor_id FROM (
+            SELECT MAX(id) AS anchor_id FROM balance_changes
+            WHERE event_time < ?
+            GROUP BY chain_name, user_address
+        ) anchors)`},
+	// 近期质押变动，同样带期初锚点
+	{Name: "stake_changes", Where: `event_time >= ? OR id IN (
+        SELECT anchor_id FROM (
+            SELECT MAX(id) AS anchor_id FROM stake_changes
+            WHERE event_time < ?
+            GROUP BY chain_name, user_address
+        ) anchors)`},
+	{Name: "points_calculation_history", Where: "period_end >= ?"},
+}
+
+// Manifest 快照清单
+type Manifest struct {
+	Format       string            `json:"format"`
+	Version      int               `json:"version"`
+	CreatedAt    time.Time         `json:"created_at"`
+	HistorySince time.Time         `json:"history_since"`
+	Checkpoints  map[string]uint64 `json:"checkpoints"` // 各链最后处理区块
+	Tables       []TableManifest   `json:"tables"`
+}
+
+// TableManifest 表清单
+type TableManifest struct {
+	Name    string          `json:"name"`
+	Entry   string          `json:"entry"`
+	Columns []db.ColumnInfo `json:"columns"`
+	Rows    int64           `json:"rows"`
+	SHA256  string          `json:"sha256"`
+}
+
+// Create 导出快照到 path（tar.gz：manifest.json + tables/<table>.jsonl）
+func Create(path string, historySince time.Time) (*Manifest, error) {
+	tmpDir, err := os.MkdirTemp("", "erc20-snapshot-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	manifest := &Manifest{
+		Format:       FormatName,
+		Version:      FormatVersion,
+		CreatedAt:    time.Now().UTC(),
+		HistorySince: historySince.UTC(),
+		Checkpoints:  make(map[string]uint64),
+	}
+
+	// 可重复读只读事务保证各表数据来自同一时间点，守护进程运行中也可导出
+	tx, err := db.DB.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// 先逐表导出到临时文件并计算校验和
+	for _, spec := range Tables {
+		tm, err := dumpTable(tx, tmpDir, spec, historySince)
+		if err != nil {
+			return nil, fmt.Errorf("导出表 %s 失败: %v", spec.Name, err)
+		}
+		manifest.Tables = append(manifest.Tables, *tm)
+		log.Info("导出表完成", "table", spec.Name, "rows", tm.Rows)
+	}
+
+	if err := loadCheckpoints(filepath.Join(tmpDir, "chain_status.jsonl"), manifest); err != nil {
+		return nil, err
+	}
+
+	if err := writeArchive(path, tmpDir, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// 导出单表为JSON Lines，每行为字段值数组
+func dumpTable(tx *sql.Tx, dir string, spec TableSpec, historySince time.Time) (*TableManifest, error) {
+	f, err := os.Create(filepath.Join(dir, spec.Name+".jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	w := bufio.NewWriter(io.MultiWriter(f, hash))
+	enc := json.NewEncoder(w)
+
+	tm := &TableManifest{Name: spec.Name, Entry: "tables/" + spec.Name + ".jsonl"}
+	var args []any
+	for range strings.Count(spec.Where, "?") {
+		args = append(args, historySince)
+	}
+	err = db.TxStreamTable(tx, spec.Name, spec.Where, args, func(cols []db.ColumnInfo, row []any) error {
+		if tm.Columns == nil {
+			tm.Columns = cols
+		}
+		tm.Rows++
+		return enc.Encode(encodeRow(row))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	tm.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return tm, nil
+}
+
+// 从导出的 chain_status 中提取各链检查点
+func loadCheckpoints(path string, manifest *Manifest) error {
+	var cols []db.ColumnInfo
+	for _, t := range manifest.Tables {
+		if t.Name == "chain_status" {
+			cols = t.Columns
+		}
+	}
+	nameIdx, blockIdx := columnIndex(cols, "chain_name"), columnIndex(cols, "last_processed_block")
+	if nameIdx < 0 || blockIdx < 0 {
+		return nil
+	}
+	return readRows(path, func(row []*string) error {
+		if row[nameIdx] == nil || row[blockIdx] == nil {
+			return nil
+		}
+		block, err := strconv.ParseUint(*row[blockIdx], 10, 64)
+		if err != nil {
+			return err
+		}
+		manifest.Checkpoints[*row[nameIdx]] = block
+		return nil
+	})
+}
+
+// 打包：manifest 在前，便于恢复时先校验格式
+func writeArchive(path, dir string, manifest *Manifest) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := writeTarEntry(tw, manifestEntry, int64(len(data)), strings.NewReader(string(data))); err != nil {
+		f.Close()
+		return err
+	}
+	for _, t := range manifest.Tables {
+		if err := writeTarFile(tw, t.Entry, filepath.Join(dir, t.Name+".jsonl")); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func writeTarFile(tw *tar.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return writeTarEntry(tw, name, info.Size(), f)
+}
+
+func writeTarEntry(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    size,
+		ModTime: time.Now(),
+	}); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, r)
+	return err
+}
+
+// Verify 读取快照并校验格式与每个表的校验和
+func Verify(path string) (*Manifest, error) {
+	var manifest *Manifest
+	seen := make(map[string]bool)
+	err := walkArchive(path, func(m *Manifest) error {
+		manifest = m
+		return nil
+	}, func(t TableManifest, r io.Reader) error {
+		hash := sha256.New()
+		if _, err := io.Copy(hash, r); err != nil {
+			return err
+		}
+		if sum := hex.EncodeToString(hash.Sum(nil)); sum != t.SHA256 {
+			return fmt.Errorf("表 %s 校验和不匹配: 期望 %s, 实际 %s", t.Name, t.SHA256, sum)
+		}
+		seen[t.Name] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range manifest.Tables {
+		if !seen[t.Name] {
+			return nil, fmt.Errorf("快照缺少表 %s", t.Name)
+		}
+	}
+	return manifest, nil
+}
+
+// Restore 校验后将快照导入空数据库，全部表在同一事务中导入
+func Restore(path string) (*Manifest, error) {
+	manifest, err := Verify(path)
+	if err != nil {
+		return nil, fmt.Errorf("快照校验失败: %v", err)
+	}
+
+	for _, t := range manifest.Tables {
+		n, err := db.CountTableRows(t.Name)
+		if err != nil {
+			return nil, fmt.Errorf("检查表 %s 失败: %v", t.Name, err)
+		}
+		if n > 0 {
+			return nil, fmt.Errorf("表 %s 非空（%d 行），只能恢复到空数据库", t.Name, n)
+		}
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = walkArchive(path, nil, func(t TableManifest, r io.Reader) error {
+		n, err := restoreTable(tx, t, r)
+		if err != nil {
+			return fmt.Errorf("恢复表 %s 失败: %v", t.Name, err)
+		}
+		log.Info("恢复表完成", "table", t.Name, "rows", n)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return manifest, tx.Commit()
+}
+
+// 导入单表：按清单字段类型还原时间值，分批插入
+func restoreTable(tx *sql.Tx, t TableManifest, r io.Reader) (int64, error) {
+	cols := make([]string, len(t.Columns))
+	for i, c := range t.Columns {
+		cols[i] = c.Name
+	}
+
+	var (
+		batch [][]any
+		total int64
+	)
+	err := decodeRows(r, func(row []*string) error {
+		values, err := decodeRow(t.Columns, row)
+		if err != nil {
+			return err
+		}
+		batch = append(batch, values)
+		if len(batch) >= insertBatch {
+			if err := db.TxInsertRows(tx, t.Name, cols, batch); err != nil {
+				return err
+			}
+			total += int64(len(batch))
+			batch = batch[:0]
+		}
+		return nil
+	})
+	if err != nil {
+		return total, err
+	}
+	if err := db.TxInsertRows(tx, t.Name, cols, batch); err != nil {
+		return total, err
+	}
+	total += int64(len(batch))
+	if total != t.Rows {
+		return total, fmt.Errorf("行数不匹配: 清单 %d, 实际 %d", t.Rows, total)
+	}
+	return total, nil
+}
+
+// 遍历快照：先回调清单，再按顺序回调各表数据
+func walkArchive(path string, onManifest func(*Manifest) error, onTable func(TableManifest, io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("不是有效的快照文件: %v", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	var manifest *Manifest
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if hdr.Name == manifestEntry {
+			manifest = &Manifest{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return fmt.Errorf("解析快照清单失败: %v", err)
+			}
+			if manifest.Format != FormatName {
+				return fmt.Errorf("未知快照格式: %s", manifest.Format)
+			}
+			if manifest.Version > FormatVersion {
+				return fmt.Errorf("快照版本 %d 高于当前支持的版本 %d", manifest.Version, FormatVersion)
+			}
+			if onManifest != nil {
+				if err := onManifest(manifest); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if manifest == nil {
+			return fmt.Errorf("快照清单缺失或不在首位")
+		}
+		var table *TableManifest
+		for i := range manifest.Tables {
+			if manifest.Tables[i].Entry == hdr.Name {
+				table = &manifest.Tables[i]
+			}
+		}
+		if table == nil {
+			return fmt.Errorf("快照包含未登记的文件: %s", hdr.Name)
+		}
+		if err := onTable(*table, tr); err != nil {
+			return err
+		}
+	}
+	if manifest == nil {
+		return fmt.Errorf("快照清单缺失")
+	}
+	return nil
+}
+
+// 编码行：时间统一为RFC3339Nano（UTC），其余值转为字符串，NULL保留为null
+func encodeRow(row []any) []*string {
+	out := make([]*string, len(row))
+	for i, v := range row {
+		var s string
+		switch val := v.(type) {
+		case nil:
+			continue
+		case []byte:
+			s = string(val)
+		case time.Time:
+			s = val.UTC().Format(time.RFC3339Nano)
+		default:
+			s = fmt.Sprint(val)
+		}
+		out[i] = &s
+	}
+	return out
+}
+
+// 解码行：时间类型字段解析回 time.Time，由驱动按连接时区写入
+func decodeRow(cols []db.ColumnInfo, row []*string) ([]any, error) {
+	if len(row) != len(cols) {
+		return nil, fmt.Errorf("字段数不匹配: 期望 %d, 实际 %d", len(cols), len(row))
+	}
+	values := make([]any, len(row))
+	for i, v := range row {
+		if v == nil {
+			continue
+		}
+		switch cols[i].Type {
+		case "TIMESTAMP", "DATETIME", "DATE":
+			t, err := time.Parse(time.RFC3339Nano, *v)
+			if err != nil {
+				return nil, fmt.Errorf("字段 %s 时间格式错误: %v", cols[i].Name, err)
+			}
+			values[i] = t
+		default:
+			values[i] = *v
+		}
+	}
+	return values, nil
+}
+
+// 逐行读取JSON Lines表文件
+func readRows(path string, fn func([]*string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return decodeRows(f, fn)
+}
+
+func decodeRows(r io.Reader, fn func([]*string) error) error {
+	dec := json.NewDecoder(r)
+	for {
+		var row []*string
+		if err := dec.Decode(&row); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+}
+
+func columnIndex(cols []db.ColumnInfo, name string) int {
+	for i, c := range cols {
+		if c.Name == name {
+			return i
+		}
+	}
+	return -1
+}
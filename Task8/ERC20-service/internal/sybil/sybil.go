@@ -0,0 +1,285 @@
+// Package sybil 在转账图上识别疑似同一主体控制的地址聚类
+//
+// 两类信号在地址之间连边，连通分量即为聚类：
+//   - 共同资金来源：地址在窗口内的首笔转入视为其资金来源，同一来源资助的地址数不少于MinFanout时，
+//     来源与被资助地址连边（交易所、跨链桥等排除地址不作为来源）
+//   - 同步操作：按LockstepWindow将转账时间分桶，两个地址同时有转账的桶数不少于LockstepMinShared，
+//     且占较少一方活跃桶数的一半以上时连边（参与地址过多的桶视为市场整体行为，不计入）
+//
+// 聚类分数（0-1）= 规模系数 × (0.4×资金同源占比 + 0.3×同步操作占比 + 0.3×内部转账占比)，
+// 规模系数 = 1 - 1/成员数，成员越多、证据越集中分数越高。
+package sybil
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 聚类成员的判定原因
+const (
+	ReasonFunder   = "funder"   // 资助了聚类内多个地址
+	ReasonFunded   = "funded"   // 首笔资金来自聚类内的共同来源
+	ReasonLockstep = "lockstep" // 与聚类内地址同步操作
+)
+
+// Transfer 一笔转账（由balance_changes的转出、转入记录配对得到）
+type Transfer struct {
+	From string
+	To   string
+	Time time.Time
+}
+
+// Params 检测参数
+type Params struct {
+	MinFanout         int             // 共同来源至少资助的地址数
+	LockstepWindow    time.Duration   // 同步操作的时间分桶
+	LockstepMinShared int             // 同步操作至少共同活跃的桶数
+	MaxBucketSize     int             // 单个桶参与地址数超过该值时不计入同步操作
+	MinClusterSize    int             // 最小聚类规模
+	Ignore            map[string]bool // 不参与分析的地址（小写），如排除列表中的交易所、LP池
+}
+
+// Member 聚类成员
+type Member struct {
+	Address string   // 小写地址
+	Reasons []string // 判定原因
+}
+
+// Cluster 地址聚类
+type Cluster struct {
+	ID       string // 由成员地址确定，成员不变时ID不变
+	Members  []Member
+	Funders  []string // 聚类内的共同资金来源
+	Score    float64
+	Funded   float64 // 首笔资金来自共同来源的成员占比
+	Lockstep float64 // 有同步操作的成员占比
+	Internal float64 // 成员转账中两端均在聚类内的占比
+}
+
+// Analyze 对转账集合聚类并打分，结果按分数降序
+func Analyze(transfers []Transfer, p Params) []Cluster {
+	if p.MinClusterSize < 2 {
+		p.MinClusterSize = 2
+	}
+	sorted := make([]Transfer, 0, len(transfers))
+	for _, t := range transfers {
+		t.From, t.To = strings.ToLower(t.From), strings.ToLower(t.To)
+		if t.From == t.To || p.Ignore[t.From] && p.Ignore[t.To] {
+			continue
+		}
+		sorted = append(sorted, t)
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+
+	uf := newUnionFind()
+	reasons := make(map[string]map[string]bool)
+	mark := func(addr, reason string) {
+		if reasons[addr] == nil {
+			reasons[addr] = make(map[string]bool)
+		}
+		reasons[addr][reason] = true
+	}
+
+	// 1. 共同资金来源
+	firstFunder := make(map[string]string)
+	fanout := make(map[string][]string)
+	for _, t := range sorted {
+		if p.Ignore[t.To] || p.Ignore[t.From] {
+			continue
+		}
+		if _, ok := firstFunder[t.To]; ok {
+			continue
+		}
+		firstFunder[t.To] = t.From
+		fanout[t.From] = append(fanout[t.From], t.To)
+	}
+	funders := make(map[string]bool)
+	for funder, funded := range fanout {
+		if len(funded) < p.MinFanout {
+			continue
+		}
+		funders[funder] = true
+		mark(funder, ReasonFunder)
+		for _, addr := range funded {
+			uf.union(funder, addr)
+			mark(addr, ReasonFunded)
+		}
+	}
+
+	// 2. 同步操作
+	if p.LockstepWindow > 0 && p.LockstepMinShared > 0 {
+		window := int64(p.LockstepWindow / time.Second)
+		if window < 1 {
+			window = 1
+		}
+		buckets := make(map[int64]map[string]bool)
+		active := make(map[string]int)
+		touch := func(addr string, b int64) {
+			if p.Ignore[addr] {
+				return
+			}
+			if buckets[b] == nil {
+				buckets[b] = make(map[string]bool)
+			}
+			if !buckets[b][addr] {
+				buckets[b][addr] = true
+				active[addr]++
+			}
+		}
+		for _, t := range sorted {
+			b := t.Time.Unix() / window
+			touch(t.From, b)
+			touch(t.To, b)
+		}
+		type pair struct{ a, b string }
+		shared := make(map[pair]int)
+		for _, addrs := range buckets {
+			if len(addrs) < 2 || (p.MaxBucketSize > 0 && len(addrs) > p.MaxBucketSize) {
+				continue
+			}
+			list := make([]string, 0, len(addrs))
+			for a := range addrs {
+				list = append(list, a)
+			}
+			sort.Strings(list)
+			for i := range list {
+				for j := i + 1; j < len(list); j++ {
+					shared[pair{list[i], list[j]}]++
+				}
+			}
+		}
+		for pr, n := range shared {
+			if n < p.LockstepMinShared {
+				continue
+			}
+			if 2*n <= min(active[pr.a], active[pr.b]) {
+				continue
+			}
+			uf.union(pr.a, pr.b)
+			mark(pr.a, ReasonLockstep)
+			mark(pr.b, ReasonLockstep)
+		}
+	}
+
+	// 3. 连通分量
+	groups := make(map[string][]string)
+	for addr := range uf.parent {
+		root := uf.find(addr)
+		groups[root] = append(groups[root], addr)
+	}
+	var clusters []Cluster
+	for _, members := range groups {
+		if len(members) < p.MinClusterSize {
+			continue
+		}
+		sort.Strings(members)
+		clusters = append(clusters, score(members, sorted, firstFunder, funders, reasons))
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		if clusters[i].Score != clusters[j].Score {
+			return clusters[i].Score > clusters[j].Score
+		}
+		return clusters[i].ID < clusters[j].ID
+	})
+	return clusters
+}
+
+// 计算聚类的各项信号与分数
+func score(members []string, transfers []Transfer, firstFunder map[string]string, funders map[string]bool, reasons map[string]map[string]bool) Cluster {
+	in := make(map[string]bool, len(members))
+	for _, m := range members {
+		in[m] = true
+	}
+	c := Cluster{ID: clusterID(members)}
+
+	var funded, lockstep int
+	for _, m := range members {
+		r := reasons[m]
+		if f, ok := firstFunder[m]; ok && in[f] && funders[f] {
+			funded++
+		}
+		if r[ReasonLockstep] {
+			lockstep++
+		}
+		if funders[m] {
+			c.Funders = append(c.Funders, m)
+		}
+		member := Member{Address: m}
+		for _, reason := range []string{ReasonFunder, ReasonFunded, ReasonLockstep} {
+			if r[reason] {
+				member.Reasons = append(member.Reasons, reason)
+			}
+		}
+		c.Members = append(c.Members, member)
+	}
+
+	var touching, internal int
+	for _, t := range transfers {
+		if !in[t.From] && !in[t.To] {
+			continue
+		}
+		touching++
+		if in[t.From] && in[t.To] {
+			internal++
+		}
+	}
+
+	n := float64(len(members))
+	c.Funded = round4(float64(funded) / n)
+	c.Lockstep = round4(float64(lockstep) / n)
+	if touching > 0 {
+		c.Internal = round4(float64(internal) / float64(touching))
+	}
+	evidence := 0.4*c.Funded + 0.3*c.Lockstep + 0.3*c.Internal
+	c.Score = round4((1 - 1/n) * evidence)
+	return c
+}
+
+// 聚类ID：排序后成员地址的sha256前16位十六进制
+func clusterID(members []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(members, ",")))
+	return hex.EncodeToString(sum[:8])
+}
+
+func round4(f float64) float64 {
+	return math.Round(f*10000) / 10000
+}
+
+// 并查集
+type unionFind struct {
+	parent map[string]string
+}
+
+func newUnionFind() *unionFind {
+	return &unionFind{parent: make(map[string]string)}
+}
+
+func (u *unionFind) find(x string) string {
+	p, ok := u.parent[x]
+	if !ok {
+		u.parent[x] = x
+		return x
+	}
+	if p == x {
+		return x
+	}
+	root := u.find(p)
+	u.parent[x] = root
+	return root
+}
+
+func (u *unionFind) union(a, b string) {
+	ra, rb := u.find(a), u.find(b)
+	if ra == rb {
+		return
+	}
+	// 较小的地址作为根，结果与遍历顺序无关
+	if rb < ra {
+		ra, rb = rb, ra
+	}
+	u.parent[rb] = ra
+}
@@ -0,0 +1,201 @@
+package sybil
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var base = time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+
+// 第i小时的一笔转账，每笔落在不同的同步操作桶中
+func tx(from, to string, hour int) Transfer {
+	return Transfer{From: from, To: to, Time: base.Add(time.Duration(hour) * time.Hour)}
+}
+
+// 资助：funder在第start小时起依次转给各地址
+func fund(funder string, start int, addrs ...string) []Transfer {
+	var out []Transfer
+	for i, a := range addrs {
+		out = append(out, tx(funder, a, start+i))
+	}
+	return out
+}
+
+func memberAddrs(c Cluster) []string {
+	var addrs []string
+	for _, m := range c.Members {
+		addrs = append(addrs, m.Address)
+	}
+	return addrs
+}
+
+func TestAnalyzeFanout(t *testing.T) {
+	params := Params{MinFanout: 3, MinClusterSize: 3}
+	tests := []struct {
+		name      string
+		transfers []Transfer
+		ignore    []string
+		want      [][]string // 各聚类的成员（按分数降序）
+	}{
+		{
+			name:      "资助地址数低于阈值",
+			transfers: fund("0xf", 0, "0xa1", "0xa2"),
+		},
+		{
+			name:      "资助地址数达到阈值",
+			transfers: fund("0xf", 0, "0xa1", "0xa2", "0xa3"),
+			want:      [][]string{{"0xa1", "0xa2", "0xa3", "0xf"}},
+		},
+		{
+			name:      "地址大小写归一",
+			transfers: fund("0xF", 0, "0xA1", "0xa2", "0xA3"),
+			want:      [][]string{{"0xa1", "0xa2", "0xa3", "0xf"}},
+		},
+		{
+			name:      "只按首笔转入认定资金来源",
+			transfers: append(fund("0xg", 0, "0xa3"), fund("0xf", 10, "0xa1", "0xa2", "0xa3")...),
+		},
+		{
+			name: "同一来源重复转账不重复计数",
+			transfers: append(fund("0xf", 0, "0xa1", "0xa2"),
+				tx("0xf", "0xa1", 20), tx("0xf", "0xa2", 21)),
+		},
+		{
+			name:      "排除地址不作为来源",
+			transfers: fund("0xf", 0, "0xa1", "0xa2", "0xa3"),
+			ignore:    []string{"0xF"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := params
+			p.Ignore = make(map[string]bool)
+			for _, a := range tt.ignore {
+				p.Ignore[strings.ToLower(a)] = true
+			}
+			clusters := Analyze(tt.transfers, p)
+			var got [][]string
+			for _, c := range clusters {
+				got = append(got, memberAddrs(c))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("聚类 = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnalyzeFanoutSignals(t *testing.T) {
+	clusters := Analyze(fund("0xf", 0, "0xa1", "0xa2", "0xa3"), Params{MinFanout: 3, MinClusterSize: 3})
+	if len(clusters) != 1 {
+		t.Fatalf("聚类数 = %d, want 1", len(clusters))
+	}
+	c := clusters[0]
+	if !reflect.DeepEqual(c.Funders, []string{"0xf"}) {
+		t.Errorf("Funders = %v, want [0xf]", c.Funders)
+	}
+	// 3/4成员资金同源，全部转账在聚类内：分数 = (1-1/4) × (0.4×0.75 + 0.3×0 + 0.3×1)
+	if c.Funded != 0.75 || c.Lockstep != 0 || c.Internal != 1 || c.Score != 0.45 {
+		t.Errorf("funded=%v lockstep=%v internal=%v score=%v, want 0.75 0 1 0.45", c.Funded, c.Lockstep, c.Internal, c.Score)
+	}
+	for _, m := range c.Members {
+		want := []string{ReasonFunded}
+		if m.Address == "0xf" {
+			want = []string{ReasonFunder}
+		}
+		if !reflect.DeepEqual(m.Reasons, want) {
+			t.Errorf("%s 原因 = %v, want %v", m.Address, m.Reasons, want)
+		}
+	}
+}
+
+func TestAnalyzeLockstep(t *testing.T) {
+	params := Params{
+		MinFanout:         100, // 只测同步操作
+		LockstepWindow:    10 * time.Minute,
+		LockstepMinShared: 3,
+		MinClusterSize:    2,
+	}
+	// a、b在前n个桶中同时转账
+	lockstep := func(n int) []Transfer {
+		var out []Transfer
+		for i := 0; i < n; i++ {
+			out = append(out, tx("0xa", "0xb", i))
+		}
+		return out
+	}
+	// 地址在第start小时起的n个桶中单独转账（每个桶的对手方不同，不会与其共同活跃达到阈值）
+	solo := func(addr string, start, n int) []Transfer {
+		var out []Transfer
+		for i := 0; i < n; i++ {
+			out = append(out, tx(addr, "0xsink"+strings.Repeat("x", start+i), start+i))
+		}
+		return out
+	}
+	// 在前n个桶中加入另一对同步转账的地址，使桶内参与地址增加
+	crowd := func(n int) []Transfer {
+		var out []Transfer
+		for i := 0; i < n; i++ {
+			out = append(out, Transfer{From: "0xc", To: "0xd", Time: base.Add(time.Duration(i)*time.Hour + time.Minute)})
+		}
+		return out
+	}
+	concat := func(parts ...[]Transfer) []Transfer {
+		var out []Transfer
+		for _, p := range parts {
+			out = append(out, p...)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name          string
+		transfers     []Transfer
+		maxBucketSize int
+		want          [][]string
+	}{
+		{"共同活跃桶数低于阈值", lockstep(2), 0, nil},
+		{"共同活跃桶数达到阈值", lockstep(3), 0, [][]string{{"0xa", "0xb"}}},
+		{
+			// a、b各活跃6个桶，共同3个，不超过较少一方的一半
+			name:      "共同活跃占比不超过一半",
+			transfers: concat(lockstep(3), solo("0xa", 100, 3), solo("0xb", 200, 3)),
+		},
+		{
+			// b活跃4个桶，共同3个超过一半
+			name:      "共同活跃占比超过较少一方的一半",
+			transfers: concat(lockstep(3), solo("0xa", 100, 10), solo("0xb", 200, 1)),
+			want:      [][]string{{"0xa", "0xb"}},
+		},
+		{"桶内地址数不限时四个地址连成一个聚类", concat(lockstep(3), crowd(3)), 0, [][]string{{"0xa", "0xb", "0xc", "0xd"}}},
+		{"桶内地址数超过上限时不计入", concat(lockstep(3), crowd(3)), 3, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := params
+			p.MaxBucketSize = tt.maxBucketSize
+			var got [][]string
+			for _, c := range Analyze(tt.transfers, p) {
+				got = append(got, memberAddrs(c))
+				for _, m := range c.Members {
+					if !reflect.DeepEqual(m.Reasons, []string{ReasonLockstep}) {
+						t.Errorf("%s 原因 = %v, want [lockstep]", m.Address, m.Reasons)
+					}
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("聚类 = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClusterIDStable(t *testing.T) {
+	a := Analyze(fund("0xf", 0, "0xa1", "0xa2", "0xa3"), Params{MinFanout: 3})
+	b := Analyze(fund("0xF", 50, "0xA3", "0xA2", "0xA1"), Params{MinFanout: 3})
+	if len(a) != 1 || len(b) != 1 || a[0].ID != b[0].ID {
+		t.Fatalf("成员相同的聚类ID应相同: %v %v", a, b)
+	}
+}
@@ -2,9 +2,16 @@ package main
 
 import (
 	"erc20-service/cmd"
+	_ "erc20-service/cmd/archive"
 	_ "erc20-service/cmd/backfill"
 	_ "erc20-service/cmd/daemon"
+	_ "erc20-service/cmd/export"
 	_ "erc20-service/cmd/health"
+	_ "erc20-service/cmd/leaderboard"
+	_ "erc20-service/cmd/points"
+	_ "erc20-service/cmd/prices"
+	_ "erc20-service/cmd/snapshot"
+	_ "erc20-service/cmd/sybil"
 )
 
 func main() {
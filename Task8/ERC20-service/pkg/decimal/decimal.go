@@ -0,0 +1,198 @@
+// Package decimal 提供积分使用的定点小数类型
+//
+// Decimal 固定保留 6 位小数，与数据库 DECIMAL(30,6) 字段一致，内部以放大 10^6 倍的整数存储，
+// 加减运算无精度损失。中间计算使用 big.Rat 精确有理数，仅在转换为 Decimal 时舍入一次，
+// 舍入模式为银行家舍入（四舍六入五成双，round half to even），长期累加不产生系统性偏差。
+package decimal
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Scale 小数位数
+const Scale = 6
+
+var scaleFactor = new(big.Int).Exp(big.NewInt(10), big.NewInt(Scale), nil)
+
+// Decimal 定点小数，零值表示0
+type Decimal struct {
+	v *big.Int // 放大 10^Scale 后的整数
+}
+
+// Zero 返回0
+func Zero() Decimal {
+	return Decimal{}
+}
+
+// FromInt 由整数构造
+func FromInt(n int64) Decimal {
+	return Decimal{v: new(big.Int).Mul(big.NewInt(n), scaleFactor)}
+}
+
+// FromRat 由有理数构造，按银行家舍入保留 Scale 位小数
+func FromRat(r *big.Rat) Decimal {
+	scaled := new(big.Rat).Mul(r, new(big.Rat).SetInt(scaleFactor))
+	return Decimal{v: roundHalfEven(scaled)}
+}
+
+// Parse 解析十进制字符串，超过 Scale 位的小数按银行家舍入
+func Parse(s string) (Decimal, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Decimal{}, fmt.Errorf("空的小数字符串")
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return Decimal{}, fmt.Errorf("无效的小数: %q", s)
+	}
+	return FromRat(r), nil
+}
+
+// MustParse 解析十进制字符串，失败时panic，仅用于常量
+func MustParse(s string) Decimal {
+	d, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// 四舍六入五成双
+func roundHalfEven(r *big.Rat) *big.Int {
+	num, den := r.Num(), r.Denom()
+	q, m := new(big.Int).QuoRem(num, den, new(big.Int)) // 向零截断
+	if m.Sign() == 0 {
+		return q
+	}
+	// 比较 2|余数| 与 分母
+	twice := new(big.Int).Abs(m)
+	twice.Lsh(twice, 1)
+	cmp := twice.Cmp(den)
+	if cmp > 0 || (cmp == 0 && q.Bit(0) == 1) {
+		if num.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q
+}
+
+func (d Decimal) int() *big.Int {
+	if d.v == nil {
+		return new(big.Int)
+	}
+	return d.v
+}
+
+// Add 加法
+func (d Decimal) Add(o Decimal) Decimal {
+	return Decimal{v: new(big.Int).Add(d.int(), o.int())}
+}
+
+// Sub 减法
+func (d Decimal) Sub(o Decimal) Decimal {
+	return Decimal{v: new(big.Int).Sub(d.int(), o.int())}
+}
+
+// Neg 取反
+func (d Decimal) Neg() Decimal {
+	return Decimal{v: new(big.Int).Neg(d.int())}
+}
+
+// MulRat 乘以有理数并按银行家舍入
+func (d Decimal) MulRat(r *big.Rat) Decimal {
+	return FromRat(new(big.Rat).Mul(d.Rat(), r))
+}
+
+// Cmp 比较大小
+func (d Decimal) Cmp(o Decimal) int {
+	return d.int().Cmp(o.int())
+}
+
+// Sign 符号
+func (d Decimal) Sign() int {
+	return d.int().Sign()
+}
+
+// IsZero 是否为0
+func (d Decimal) IsZero() bool {
+	return d.Sign() == 0
+}
+
+// Rat 转为精确有理数
+func (d Decimal) Rat() *big.Rat {
+	return new(big.Rat).SetFrac(d.int(), scaleFactor)
+}
+
+// Float64 转为浮点数，仅用于展示与指标
+func (d Decimal) Float64() float64 {
+	f, _ := d.Rat().Float64()
+	return f
+}
+
+// String 输出固定 Scale 位小数
+func (d Decimal) String() string {
+	n := d.int()
+	sign := ""
+	if n.Sign() < 0 {
+		sign = "-"
+		n = new(big.Int).Neg(n)
+	}
+	q, m := new(big.Int).QuoRem(n, scaleFactor, new(big.Int))
+	return fmt.Sprintf("%s%s.%0*s", sign, q.String(), Scale, m.String())
+}
+
+// Value 实现 driver.Valuer，以字符串写入DECIMAL字段
+func (d Decimal) Value() (driver.Value, error) {
+	return d.String(), nil
+}
+
+// Scan 实现 sql.Scanner
+func (d *Decimal) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*d = Decimal{}
+		return nil
+	case []byte:
+		return d.parseInto(string(v))
+	case string:
+		return d.parseInto(v)
+	case int64:
+		*d = FromInt(v)
+		return nil
+	case float64:
+		// 仅兼容非DECIMAL来源，按最短十进制表示解析
+		return d.parseInto(fmt.Sprintf("%v", v))
+	default:
+		return fmt.Errorf("无法将 %T 转换为Decimal", src)
+	}
+}
+
+func (d *Decimal) parseInto(s string) error {
+	parsed, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// MarshalJSON 以字符串输出，避免JSON数字精度损失
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON 兼容字符串与数字
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "null" {
+		*d = Decimal{}
+		return nil
+	}
+	return d.parseInto(s)
+}
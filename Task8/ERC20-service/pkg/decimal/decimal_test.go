@@ -0,0 +1,111 @@
+package decimal
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestParseRoundHalfEven(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"1", "1.000000"},
+		{"0.1234565", "0.123456"},     // 恰好一半，舍去到偶数
+		{"0.1234575", "0.123458"},     // 恰好一半，进位到偶数
+		{"0.12345650001", "0.123457"}, // 超过一半进位
+		{"0.12345649999", "0.123456"}, // 不足一半舍去
+		{"0.0000005", "0.000000"},
+		{"0.0000015", "0.000002"},
+		{"-0.1234565", "-0.123456"},
+		{"-0.1234575", "-0.123458"},
+		{"-0.0000005", "0.000000"},
+		{"2.5e-6", "0.000002"},
+		{"999999.9999995", "1000000.000000"},
+		{"  42.5  ", "42.500000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			d, err := Parse(tt.in)
+			if err != nil {
+				t.Fatalf("Parse(%q) error: %v", tt.in, err)
+			}
+			if got := d.String(); got != tt.want {
+				t.Errorf("Parse(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, in := range []string{"", "   ", "abc", "1.2.3"} {
+		if _, err := Parse(in); err == nil {
+			t.Errorf("Parse(%q) 应返回错误", in)
+		}
+	}
+}
+
+func TestFromRatRoundHalfEven(t *testing.T) {
+	tests := []struct {
+		name     string
+		num, den int64
+		want     string
+	}{
+		{"三分之一", 1, 3, "0.333333"},
+		{"三分之二", 2, 3, "0.666667"},
+		{"正半数舍偶", 1, 2000000, "0.000000"},
+		{"正半数进偶", 3, 2000000, "0.000002"},
+		{"负半数舍偶", -1, 2000000, "0.000000"},
+		{"负半数进偶", -3, 2000000, "-0.000002"},
+		{"负数超过一半", -2, 3, "-0.666667"},
+		{"整除", 10, 4, "2.500000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FromRat(big.NewRat(tt.num, tt.den)).String(); got != tt.want {
+				t.Errorf("FromRat(%d/%d) = %s, want %s", tt.num, tt.den, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMulRatRoundsOnce(t *testing.T) {
+	tests := []struct {
+		d        string
+		num, den int64
+		want     string
+	}{
+		{"0.000001", 1, 2, "0.000000"},
+		{"0.000003", 1, 2, "0.000002"},
+		{"0.000005", 1, 2, "0.000002"},
+		{"0.000007", 1, 2, "0.000004"},
+		{"10.000000", 1, 3, "3.333333"},
+		{"-0.000003", 1, 2, "-0.000002"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.d, func(t *testing.T) {
+			got := MustParse(tt.d).MulRat(big.NewRat(tt.num, tt.den)).String()
+			if got != tt.want {
+				t.Errorf("%s × %d/%d = %s, want %s", tt.d, tt.num, tt.den, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStringSign(t *testing.T) {
+	tests := []struct {
+		d    Decimal
+		want string
+	}{
+		{Zero(), "0.000000"},
+		{Decimal{}, "0.000000"},
+		{FromInt(-3), "-3.000000"},
+		{MustParse("-0.5"), "-0.500000"},
+		{MustParse("0.000001").Neg(), "-0.000001"},
+	}
+	for _, tt := range tests {
+		if got := tt.d.String(); got != tt.want {
+			t.Errorf("String() = %s, want %s", got, tt.want)
+		}
+	}
+}
@@ -2,3 +2,283 @@
 ALTER TABLE user_points MODIFY COLUMN total_points DECIMAL(30,6) NOT NULL DEFAULT 0;
 ALTER TABLE points_calculation_history MODIFY COLUMN points_added DECIMAL(30,6) NOT NULL;
 ALTER TABLE points_calculation_history MODIFY COLUMN total_points DECIMAL(30,6) NOT NULL;
+
+-- 归档与保留策略：按时间范围扫描所需索引
+ALTER TABLE balance_changes ADD INDEX idx_event_time (event_time);
+ALTER TABLE points_calculation_history ADD INDEX idx_period_end (period_end);
+
+-- 积分规则引擎：记录每条计算历史使用的规则集版本
+ALTER TABLE points_calculation_history ADD COLUMN rule_version VARCHAR(64) NOT NULL DEFAULT '' AFTER total_points;
+
+-- 积分任务幂等：确定性任务ID唯一约束（历史数据为NULL，不受约束）
+ALTER TABLE points_calculation_history ADD COLUMN task_id CHAR(64) NULL AFTER rule_version;
+ALTER TABLE points_calculation_history ADD UNIQUE KEY uniq_task_id (task_id);
+
+-- 积分流水：以现有累计积分作为期初流水，此后总积分由流水汇总
+CREATE TABLE IF NOT EXISTS points_ledger (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    chain_name VARCHAR(50) NOT NULL,
+    user_address VARCHAR(42) NOT NULL,
+    amount DECIMAL(30,6) NOT NULL,
+    entry_type VARCHAR(20) NOT NULL,
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    actor VARCHAR(64) NOT NULL,
+    reference VARCHAR(128) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    KEY idx_chain_user (chain_name, user_address, id),
+    KEY idx_reference (reference)
+);
+INSERT INTO points_ledger (chain_name, user_address, amount, entry_type, reason, actor)
+SELECT chain_name, user_address, total_points, 'opening', '迁移前累计积分', 'migration'
+FROM user_points WHERE total_points <> 0;
+
+-- 跨链汇总积分：按默认权重1初始化，配置链权重后执行 points rebuild-totals 重算
+CREATE TABLE IF NOT EXISTS user_points_aggregate (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_address VARCHAR(42) NOT NULL,
+    total_points DECIMAL(30,6) NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY uniq_user (user_address)
+);
+INSERT INTO user_points_aggregate (user_address, total_points)
+SELECT user_address, SUM(total_points) FROM user_points GROUP BY user_address;
+
+-- 排行榜：排名表与每日快照
+CREATE TABLE IF NOT EXISTS leaderboard_ranks (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    board VARCHAR(50) NOT NULL,
+    user_address VARCHAR(42) NOT NULL,
+    total_points DECIMAL(30,6) NOT NULL,
+    rank_no INT NOT NULL,
+    refreshed_at TIMESTAMP NOT NULL,
+    UNIQUE KEY uniq_board_user (board, user_address),
+    KEY idx_board_rank (board, rank_no)
+);
+CREATE TABLE IF NOT EXISTS leaderboard_snapshots (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    board VARCHAR(50) NOT NULL,
+    day DATE NOT NULL,
+    user_address VARCHAR(42) NOT NULL,
+    total_points DECIMAL(30,6) NOT NULL,
+    rank_no INT NOT NULL,
+    UNIQUE KEY uniq_board_day_user (board, day, user_address),
+    KEY idx_day (day)
+);
+
+-- 积分批次：现有积分按迁移时刻作为一个批次，不会被追溯到期
+CREATE TABLE IF NOT EXISTS points_lots (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    chain_name VARCHAR(50) NOT NULL,
+    user_address VARCHAR(42) NOT NULL,
+    ledger_id BIGINT NOT NULL,
+    earned_at TIMESTAMP NOT NULL,
+    amount DECIMAL(30,6) NOT NULL,
+    remaining DECIMAL(30,6) NOT NULL,
+    KEY idx_chain_user_earned (chain_name, user_address, earned_at),
+    KEY idx_earned (earned_at)
+);
+CREATE TABLE IF NOT EXISTS points_lot_usage (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    lot_id BIGINT NOT NULL,
+    ledger_id BIGINT NOT NULL,
+    amount DECIMAL(30,6) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    KEY idx_lot (lot_id),
+    KEY idx_ledger (ledger_id)
+);
+INSERT INTO points_lots (chain_name, user_address, ledger_id, earned_at, amount, remaining)
+SELECT p.chain_name, p.user_address, COALESCE(MAX(l.id), 0), NOW(), p.total_points, p.total_points
+FROM user_points p
+LEFT JOIN points_ledger l ON l.chain_name = p.chain_name AND l.user_address = p.user_address
+WHERE p.total_points > 0
+GROUP BY p.chain_name, p.user_address, p.total_points;
+
+-- 质押归属：StakeSystem质押事件，质押期间的代币仍按质押者计算积分
+CREATE TABLE IF NOT EXISTS stake_changes (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    chain_name VARCHAR(50) NOT NULL,
+    user_address VARCHAR(42) NOT NULL,
+    pool_id BIGINT UNSIGNED NOT NULL,
+    event_type VARCHAR(20) NOT NULL,          -- stake / unstake_request / unstake_claim
+    amount VARCHAR(100) NOT NULL,
+    staked_after VARCHAR(100) NOT NULL,       -- 变动后质押锁定余额（所有追踪资金池合计）
+    pending_after VARCHAR(100) NOT NULL,      -- 变动后已申请解押、尚未领取的余额
+    block_number BIGINT NOT NULL,
+    event_time TIMESTAMP NOT NULL,
+    tx_hash VARCHAR(66) NOT NULL,
+    log_index INT UNSIGNED NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uniq_chain_log (chain_name, tx_hash, log_index),
+    KEY idx_chain_addr_time (chain_name, user_address, event_time)
+);
+
+-- 排除地址：通过 points exclusions 命令维护
+CREATE TABLE IF NOT EXISTS excluded_addresses (
+    chain_name VARCHAR(50) NOT NULL,
+    address VARCHAR(42) NOT NULL,
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    actor VARCHAR(64) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (chain_name, address)
+);
+
+-- 积分周期对齐：计算历史记录所属周期ID（对齐前的历史数据为NULL）
+ALTER TABLE points_calculation_history ADD COLUMN epoch_id BIGINT NULL AFTER task_id;
+ALTER TABLE points_calculation_history ADD INDEX idx_chain_addr_epoch (chain_name, user_address, epoch_id);
+
+-- 积分明细：随计算历史保存各定价子段（points.persist_segments开启时写入）
+ALTER TABLE points_calculation_history ADD COLUMN segments JSON NULL AFTER epoch_id;
+
+-- 区块周期：按区块区间计算积分的链
+CREATE TABLE IF NOT EXISTS block_boundaries (
+    chain_name VARCHAR(50) NOT NULL,
+    block_number BIGINT NOT NULL,
+    block_time TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (chain_name, block_number)
+);
+ALTER TABLE balance_changes ADD INDEX idx_chain_addr_block (chain_name, user_address, block_number);
+ALTER TABLE stake_changes ADD INDEX idx_chain_addr_block (chain_name, user_address, block_number);
+ALTER TABLE points_calculation_history ADD COLUMN block_start BIGINT NULL AFTER epoch_id;
+ALTER TABLE points_calculation_history ADD COLUMN block_end BIGINT NULL AFTER block_start;
+
+-- 推荐奖励：推荐关系通过 points referrals import 或推荐注册合约事件写入
+CREATE TABLE IF NOT EXISTS referrals (
+    chain_name VARCHAR(50) NOT NULL,
+    referee_address VARCHAR(42) NOT NULL,     -- 被推荐人，每条链至多一个推荐人
+    referrer_address VARCHAR(42) NOT NULL,
+    source VARCHAR(20) NOT NULL,              -- import/chain
+    actor VARCHAR(64) NOT NULL DEFAULT '',    -- 导入操作人，链上注册为空
+    tx_hash VARCHAR(66) NOT NULL DEFAULT '',  -- 链上注册交易
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (chain_name, referee_address),
+    KEY idx_chain_referrer (chain_name, referrer_address)
+);
+
+-- 赛季：通过 points seasons open/close/finalize 管理，赛季积分与终身积分分开累计
+-- 赛季表：[start_at, end_at) 内开始的积分周期计入赛季积分，同一条链上的赛季时间不重叠 (MySQL)
+CREATE TABLE IF NOT EXISTS seasons (
+    id VARCHAR(32) PRIMARY KEY,
+    name VARCHAR(100) NOT NULL DEFAULT '',
+    start_at TIMESTAMP NOT NULL,
+    end_at TIMESTAMP NULL,                    -- 为NULL表示尚未结束
+    chains JSON NULL,                         -- 参与的链，为NULL表示所有链
+    multiplier DECIMAL(20,6) NOT NULL DEFAULT 1, -- 持有积分与推荐奖励计入赛季时的倍数
+    status VARCHAR(20) NOT NULL,              -- open/closed/finalized
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finalized_at TIMESTAMP NULL
+);
+
+-- 赛季积分表：随带赛季标记的流水增量累加，终身积分仍在 user_points (MySQL)
+CREATE TABLE IF NOT EXISTS season_points (
+    season_id VARCHAR(32) NOT NULL,
+    chain_name VARCHAR(50) NOT NULL,
+    user_address VARCHAR(42) NOT NULL,
+    points DECIMAL(30,6) NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (season_id, chain_name, user_address),
+    KEY idx_chain_user (chain_name, user_address)
+);
+
+-- 赛季最终快照：结算时按链排名写入，结算后不再变化 (MySQL)
+CREATE TABLE IF NOT EXISTS season_snapshots (
+    season_id VARCHAR(32) NOT NULL,
+    chain_name VARCHAR(50) NOT NULL,
+    user_address VARCHAR(42) NOT NULL,
+    points DECIMAL(30,6) NOT NULL,
+    rank_no INT NOT NULL,
+    PRIMARY KEY (season_id, chain_name, user_address),
+    KEY idx_season_rank (season_id, chain_name, rank_no)
+);
+ALTER TABLE points_calculation_history ADD COLUMN season_id VARCHAR(32) NULL AFTER segments;
+ALTER TABLE points_ledger ADD COLUMN season_id VARCHAR(32) NULL AFTER reference;
+
+-- USD价值积分：points.pricing 启用后记录每次计算使用的报价
+ALTER TABLE points_calculation_history ADD COLUMN prices JSON NULL AFTER season_id;
+
+-- 女巫聚类检测：按交易哈希配对转出、转入记录，聚类结果按链整体替换
+ALTER TABLE balance_changes ADD KEY idx_chain_tx (chain_name, tx_hash);
+
+CREATE TABLE IF NOT EXISTS sybil_clusters (
+    chain_name VARCHAR(50) NOT NULL,
+    cluster_id VARCHAR(16) NOT NULL,
+    score DECIMAL(6,4) NOT NULL,
+    size INT NOT NULL,
+    funders JSON NOT NULL,
+    funded DECIMAL(6,4) NOT NULL,
+    lockstep DECIMAL(6,4) NOT NULL,
+    internal DECIMAL(6,4) NOT NULL,
+    analyzed_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (chain_name, cluster_id),
+    KEY idx_chain_score (chain_name, score)
+);
+
+CREATE TABLE IF NOT EXISTS sybil_addresses (
+    chain_name VARCHAR(50) NOT NULL,
+    address VARCHAR(42) NOT NULL,
+    cluster_id VARCHAR(16) NOT NULL,
+    score DECIMAL(6,4) NOT NULL,
+    reasons VARCHAR(100) NOT NULL DEFAULT '',
+    PRIMARY KEY (chain_name, address),
+    KEY idx_chain_cluster (chain_name, cluster_id)
+);
+
+-- 积分历史日汇总：每行只覆盖一段首尾相接的已计算时间，同一天有缺口时分为多行，日期按UTC计
+-- 此前按天整体汇总的行可能掩盖当天的缺口，需核对后按归档文件重建
+ALTER TABLE points_history_daily
+    DROP INDEX uniq_chain_user_day,
+    ADD UNIQUE KEY uniq_chain_user_start (chain_name, user_address, period_start),
+    ADD KEY idx_chain_user_day (chain_name, user_address, day);
+
+-- 女巫聚类首次检测时间：重新分析时保留，积分只处理检测之后开始的周期，回填与延迟入账的早期周期不追溯处理
+-- 已有聚类以上次分析时间作为检测时间
+ALTER TABLE sybil_clusters ADD COLUMN detected_at TIMESTAMP NULL AFTER analyzed_at;
+UPDATE sybil_clusters SET detected_at = analyzed_at;
+ALTER TABLE sybil_clusters MODIFY detected_at TIMESTAMP NOT NULL;
+ALTER TABLE sybil_addresses ADD COLUMN detected_at TIMESTAMP NULL AFTER reasons;
+UPDATE sybil_addresses a JOIN sybil_clusters c ON c.chain_name = a.chain_name AND c.cluster_id = a.cluster_id
+SET a.detected_at = c.analyzed_at;
+ALTER TABLE sybil_addresses MODIFY detected_at TIMESTAMP NOT NULL;
+
+-- 赛季绑定规则集：赛季内的周期固定按该版本的规则集计算
+ALTER TABLE seasons ADD COLUMN rule_set VARCHAR(64) NULL AFTER multiplier;
+
+-- 流水获得时间：空投导出按获得时间截止，截止前的周期延迟补算或回填的积分同样计入
+-- 已有流水取记账时间，形成批次的正数流水取批次的获得时间（周期结束时间）
+ALTER TABLE points_ledger
+    ADD COLUMN earned_at TIMESTAMP NULL AFTER created_at,
+    ADD KEY idx_chain_earned (chain_name, earned_at);
+UPDATE points_ledger SET earned_at = created_at;
+UPDATE points_ledger l JOIN points_lots p ON p.ledger_id = l.id SET l.earned_at = p.earned_at;
+ALTER TABLE points_ledger MODIFY earned_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+-- 排行榜增量刷新：只写入有变化的排名并删除落榜用户，最近刷新时间记录在榜单状态表；
+-- 快照改为跨日后首次刷新时写入前一日的最终排名，每天只写一次
+CREATE TABLE IF NOT EXISTS leaderboard_boards (
+    board VARCHAR(50) PRIMARY KEY,
+    refreshed_at TIMESTAMP NULL
+);
+INSERT IGNORE INTO leaderboard_boards (board, refreshed_at)
+SELECT board, MAX(refreshed_at) FROM leaderboard_ranks GROUP BY board;
+
+-- 用户最新质押持仓：批量计算取期初质押持仓时不再对全部质押历史做窗口排序
+CREATE TABLE IF NOT EXISTS user_stakes (
+    chain_name VARCHAR(50) NOT NULL,
+    user_address VARCHAR(42) NOT NULL,
+    staked VARCHAR(100) NOT NULL DEFAULT '0',
+    pending VARCHAR(100) NOT NULL DEFAULT '0',
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (chain_name, user_address)
+);
+INSERT INTO user_stakes (chain_name, user_address, staked, pending)
+SELECT chain_name, user_address, staked_after, pending_after FROM (
+    SELECT chain_name, user_address, staked_after, pending_after,
+           ROW_NUMBER() OVER (PARTITION BY chain_name, user_address ORDER BY event_time DESC, id DESC) AS rn
+    FROM stake_changes
+) t WHERE rn = 1
+ON DUPLICATE KEY UPDATE staked = VALUES(staked), pending = VALUES(pending);
+
+-- 女巫处理从地址所在聚类首次达到处理阈值时起算，而不是首次出现在聚类中时
+-- 已有成员中分数达到阈值的以检测时间作为达到阈值的时间（0.7为points.sybil.threshold，按实际配置替换）
+ALTER TABLE sybil_addresses ADD COLUMN flagged_at TIMESTAMP NULL AFTER detected_at;
+UPDATE sybil_addresses SET flagged_at = detected_at WHERE score >= 0.7;